@@ -0,0 +1,106 @@
+package ca
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	cfsslConfig "github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/signer"
+
+	"github.com/letsencrypt/boulder/cmd"
+)
+
+// reservedExtensionArcs are the OID arcs of extensions the CA sets itself or
+// that have a standard meaning, which additional extensions may not use: the
+// X.509 certificate extensions [RFC5280 4.2], the PKIX private extensions
+// [RFC5280 4.2.2], and Certificate Transparency's [RFC6962 3.1, 3.3].
+var reservedExtensionArcs = []asn1.ObjectIdentifier{
+	{2, 5, 29},
+	{1, 3, 6, 1, 5, 5, 7, 1},
+	{1, 3, 6, 1, 4, 1, 11129, 2, 4},
+	oidRegistrationID,
+}
+
+// parseOID parses an object identifier in dotted decimal form.
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// inOIDArc returns true if oid is arc or lies beneath it.
+func inOIDArc(oid, arc asn1.ObjectIdentifier) bool {
+	return len(oid) >= len(arc) && oid[:len(arc)].Equal(arc)
+}
+
+// parseAdditionalExtensions returns the extensions described by configs, for
+// inclusion in every certificate issued. It returns an error if any is
+// critical, uses a reserved OID, is given more than once, or doesn't have
+// exactly one of a DER value and a UTF8String.
+func parseAdditionalExtensions(configs []cmd.CAExtensionConfig) ([]signer.Extension, error) {
+	var extensions []signer.Extension
+	seen := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		oid, err := parseOID(config.OID)
+		if err != nil {
+			return nil, fmt.Errorf("additional extension: %s", err)
+		}
+		if config.Critical {
+			return nil, fmt.Errorf("additional extension %s must not be critical", oid)
+		}
+		for _, arc := range reservedExtensionArcs {
+			if inOIDArc(oid, arc) {
+				return nil, fmt.Errorf("additional extension %s uses an OID reserved for standard extensions", oid)
+			}
+		}
+		if seen[oid.String()] {
+			return nil, fmt.Errorf("additional extension %s is given more than once", oid)
+		}
+		seen[oid.String()] = true
+
+		var value []byte
+		switch {
+		case config.Value != "" && config.UTF8String != "":
+			return nil, fmt.Errorf("additional extension %s has both a value and a UTF8String", oid)
+		case config.Value != "":
+			value, err = hex.DecodeString(config.Value)
+			if err != nil {
+				return nil, fmt.Errorf("additional extension %s has an invalid value: %s", oid, err)
+			}
+			var raw asn1.RawValue
+			if rest, err := asn1.Unmarshal(value, &raw); err != nil || len(rest) > 0 {
+				return nil, fmt.Errorf("additional extension %s has a value that isn't a single DER element", oid)
+			}
+		case config.UTF8String != "":
+			if !utf8.ValidString(config.UTF8String) {
+				return nil, fmt.Errorf("additional extension %s has an invalid UTF8String", oid)
+			}
+			value, err = asn1.Marshal(asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(config.UTF8String)})
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("additional extension %s has no value", oid)
+		}
+		extensions = append(extensions, signer.Extension{
+			ID:       cfsslConfig.OID(oid),
+			Critical: false,
+			Value:    hex.EncodeToString(value),
+		})
+	}
+	return extensions, nil
+}
@@ -4,26 +4,37 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
+	"net/url"
+	"reflect"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	cfsslConfig "github.com/cloudflare/cfssl/config"
 	cferr "github.com/cloudflare/cfssl/errors"
-	"github.com/cloudflare/cfssl/ocsp"
+	cfocsp "github.com/cloudflare/cfssl/ocsp"
 	"github.com/cloudflare/cfssl/signer"
-	"github.com/cloudflare/cfssl/signer/local"
 	"github.com/jmhodges/clock"
 	"github.com/miekg/pkcs11"
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/crypto/pkcs12"
 	"golang.org/x/net/context"
 
 	"github.com/letsencrypt/boulder/cmd"
@@ -34,6 +45,7 @@ import (
 	"github.com/letsencrypt/boulder/goodkey"
 	blog "github.com/letsencrypt/boulder/log"
 	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/revocation"
 )
 
 // Miscellaneous PKIX OIDs that we need to refer to
@@ -46,14 +58,34 @@ var (
 	oidCrlDistributionPoints  = asn1.ObjectIdentifier{2, 5, 29, 31}
 	oidExtKeyUsage            = asn1.ObjectIdentifier{2, 5, 29, 37}
 	oidKeyUsage               = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidOCSPNoCheck            = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
 	oidSubjectAltName         = asn1.ObjectIdentifier{2, 5, 29, 17}
 	oidSubjectKeyIdentifier   = asn1.ObjectIdentifier{2, 5, 29, 14}
 	oidTLSFeature             = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
 
+	// Private extension carrying the requester's registration ID as an
+	// INTEGER, under ISRG's private enterprise arc. Only used for internal
+	// PKI profiles.
+	oidRegistrationID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 44947, 2, 1}
+
 	// CSR attribute requesting extensions
 	oidExtensionRequest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 14}
+
+	// Subject attributes
+	oidCommonName = asn1.ObjectIdentifier{2, 5, 4, 3}
 )
 
+// subjectAttributeNames maps the OIDs of the subject attributes that can be
+// listed in PermittedSubjectAttributes to their short names.
+var subjectAttributeNames = map[string]string{
+	"2.5.4.5":  "serialNumber",
+	"2.5.4.6":  "C",
+	"2.5.4.7":  "L",
+	"2.5.4.8":  "ST",
+	"2.5.4.10": "O",
+	"2.5.4.11": "OU",
+}
+
 // OID and fixed value for the "must staple" variant of the TLS Feature
 // extension:
 //
@@ -64,15 +96,87 @@ var (
 //  30 03 - SEQUENCE (3 octets)
 //  |-- 02 01 - INTEGER (1 octet)
 //  |   |-- 05 - 5
-var (
-	mustStapleFeatureValue = []byte{0x30, 0x03, 0x02, 0x01, 0x05}
-	mustStapleExtension    = signer.Extension{
-		ID:       cfsslConfig.OID(oidTLSFeature),
-		Critical: false,
-		Value:    hex.EncodeToString(mustStapleFeatureValue),
-	}
+var mustStapleFeatureValue = []byte{0x30, 0x03, 0x02, 0x01, 0x05}
+
+// TLS extension types which may appear in the TLS Feature extension.
+const (
+	tlsFeatureStatusRequest   = 5  // [RFC6066]
+	tlsFeatureStatusRequestV2 = 17 // [RFC6961]
 )
 
+// tlsFeatureRequires is the set of TLS features the CA will include in a TLS
+// Feature extension, each mapped to the features that must be requested along
+// with it. The CA's OCSP responses are stapled the same way under either
+// status_request or status_request_v2, but status_request_v2 alone would
+// break clients that only support status_request.
+var tlsFeatureRequires = map[int][]int{
+	tlsFeatureStatusRequest:   nil,
+	tlsFeatureStatusRequestV2: {tlsFeatureStatusRequest},
+}
+
+// checkTLSFeatures returns an error if the DER encoded TLS Feature extension
+// value isn't a coherent set of features the CA supports. Otherwise it returns
+// the requested features.
+func checkTLSFeatures(value []byte) ([]int, error) {
+	var features []int
+	rest, err := asn1.Unmarshal(value, &features)
+	if err != nil || len(rest) != 0 || len(features) == 0 {
+		return nil, errors.New("not a non-empty sequence of integers")
+	}
+	requested := make(map[int]bool, len(features))
+	for _, feature := range features {
+		if _, ok := tlsFeatureRequires[feature]; !ok {
+			return nil, fmt.Errorf("feature %d is not supported", feature)
+		}
+		if requested[feature] {
+			return nil, fmt.Errorf("feature %d is requested more than once", feature)
+		}
+		requested[feature] = true
+	}
+	for _, feature := range features {
+		for _, required := range tlsFeatureRequires[feature] {
+			if !requested[required] {
+				return nil, fmt.Errorf("feature %d requires feature %d", feature, required)
+			}
+		}
+	}
+	return features, nil
+}
+
+// keyUsagesByKeyType contains the key usages that can be performed with each
+// type of subject public key. RSA keys can't be used for key agreement, and
+// ECDSA keys can't be used to encipher keys or data.
+var keyUsagesByKeyType = map[reflect.Type]x509.KeyUsage{
+	reflect.TypeOf(&rsa.PublicKey{}): x509.KeyUsageDigitalSignature |
+		x509.KeyUsageContentCommitment |
+		x509.KeyUsageKeyEncipherment |
+		x509.KeyUsageDataEncipherment |
+		x509.KeyUsageCertSign |
+		x509.KeyUsageCRLSign,
+	reflect.TypeOf(&ecdsa.PublicKey{}): x509.KeyUsageDigitalSignature |
+		x509.KeyUsageContentCommitment |
+		x509.KeyUsageKeyAgreement |
+		x509.KeyUsageCertSign |
+		x509.KeyUsageCRLSign |
+		x509.KeyUsageEncipherOnly |
+		x509.KeyUsageDecipherOnly,
+}
+
+// ocspRevocationReasons contains the revocation reasons which the Baseline
+// Requirements permit in OCSP responses for subscriber certificates.
+var ocspRevocationReasons = map[revocation.Reason]bool{
+	revocation.Unspecified:          true,
+	revocation.KeyCompromise:        true,
+	revocation.AffiliationChanged:   true,
+	revocation.Superseded:           true,
+	revocation.CessationOfOperation: true,
+	revocation.PrivilegeWithdrawn:   true,
+}
+
+// issuerExpiryWarningInterval is the least time between warnings that an
+// issuer's expiry is cutting certificates short.
+const issuerExpiryWarningInterval = time.Hour
+
 // Metrics for CA statistics
 const (
 	// Increments when CA observes an HSM or signing error
@@ -95,10 +199,91 @@ const (
 	// Increments when CA handles a CSR requesting an extension other than those
 	// listed above
 	metricCSRExtensionOther = "CSRExtensions.Other"
+
+	// Increments, suffixed with the curve's name, when CA rejects a CSR whose
+	// ECDSA key is on a curve the key policy doesn't allow
+	metricCSRKeyCurveRejected = "CSRKeys.CurveRejected"
+
+	// Increments when CA recovers from a panic while processing a CSR
+	metricCSRPanics = "CSRProcessing.Panics"
+
+	// Increment for every certificate issued, by the type of its key
+	metricIssuancesRSA   = "Issuances.RSA"
+	metricIssuancesECDSA = "Issuances.ECDSA"
+
+	// Increment for every certificate issued with a CN, by whether the CN was
+	// requested in the CSR or taken from one of its SANs
+	metricIssuancesExplicitCN      = "Issuances.ExplicitCN"
+	metricIssuancesForcedCNFromSAN = "Issuances.ForcedCNFromSAN"
+
+	// Increment for every certificate issued whose notAfter was brought
+	// forward to fit within its issuer's
+	metricIssuancesClampedByIssuer = "Issuances.ClampedByIssuer"
+
+	// Increment for every certificate submitted to CT by the publish workers,
+	// every retried submission, every certificate whose submissions all
+	// failed, every certificate that couldn't be queued for submission, and
+	// every certificate given up on when draining the workers timed out
+	metricPublishSubmitted = "Publish.Submitted"
+	metricPublishRetries   = "Publish.Retries"
+	metricPublishFailed    = "Publish.Failed"
+	metricPublishDropped   = "Publish.Dropped"
+	metricPublishAbandoned = "Publish.Abandoned"
+
+	// Increment for every issued certificate the IssuanceObserver failed to
+	// be notified of
+	metricIssuanceObserverFailed = "IssuanceObserver.Failed"
+
+	// Gauge of signing operations currently holding a signing slot. Only
+	// reported when MaxConcurrentSignings is configured.
+	metricSigningInProgress = "Signatures.InProgress"
+
+	// Increment for every cross-certificate signed
+	metricSignaturesCrossCertificate = "Signatures.CrossCertificate"
+
+	// Increment for every OCSP response that could have been served from the
+	// OCSP response cache, by whether it was. Only reported when
+	// OCSPCacheSize is configured.
+	metricOCSPCacheHits   = "OCSPCache.Hits"
+	metricOCSPCacheMisses = "OCSPCache.Misses"
 )
 
+// issuanceEvent is audit logged as JSON for every certificate issuance
+// decision. It must only contain identifiers and digests, never key material
+// or full certificates.
+type issuanceEvent struct {
+	SerialNumber string   `json:",omitempty"`
+	Issuer       string   `json:",omitempty"`
+	Requester    int64    `json:",omitempty"`
+	Names        []string `json:",omitempty"`
+	Profile      string   `json:",omitempty"`
+	CSRDigest    string   `json:",omitempty"`
+	CertDigest   string   `json:",omitempty"`
+	Error        string   `json:",omitempty"`
+}
+
+// ocspSigningEvent is audit logged as JSON for every OCSP signing decision.
+type ocspSigningEvent struct {
+	SerialNumber string            `json:",omitempty"`
+	Issuer       string            `json:",omitempty"`
+	Status       string            `json:",omitempty"`
+	Reason       revocation.Reason `json:",omitempty"`
+	Error        string            `json:",omitempty"`
+}
+
 type certificateStorage interface {
 	AddCertificate(context.Context, []byte, int64, []byte) (string, error)
+	GetCertificate(context.Context, string) (core.Certificate, error)
+	GetCertificateStatus(context.Context, string) (core.CertificateStatus, error)
+}
+
+// IssuanceObserver is notified of every certificate the CA issues, e.g. to
+// pass issuance events on to other systems.
+type IssuanceObserver interface {
+	// OnIssued is called synchronously once cert has been signed and stored
+	// by the SA, so it should return quickly. An error is logged but doesn't
+	// fail the issuance.
+	OnIssued(cert core.Certificate) error
 }
 
 // CertificateAuthorityImpl represents a CA that signs certificates, CRLs, and
@@ -106,12 +291,24 @@ type certificateStorage interface {
 type CertificateAuthorityImpl struct {
 	rsaProfile   string
 	ecdsaProfile string
-	// A map from issuer cert common name to an internalIssuer struct
+	// A map from issuer cert common name to an internalIssuer struct. Where
+	// issuers share a name, it holds the one used for issuance.
 	issuers map[string]*internalIssuer
+	// issuersByID maps the key and name of every issuer to the issuer, to
+	// find the issuer of a certificate by its authority key ID.
+	issuersByID map[issuerID]*internalIssuer
+	// allIssuers holds every issuer, in the order they were configured
+	allIssuers []*internalIssuer
+	// The issuers that aren't OCSP-only, in order of preference for issuance
+	issuanceOrder []*internalIssuer
+	// issuerKeys maps the SHA-256 hash of every issuer's SubjectPublicKeyInfo
+	// to the issuer's common name
+	issuerKeys map[[sha256.Size]byte]string
 	// The common name of the default issuer cert
 	defaultIssuer    *internalIssuer
 	SA               certificateStorage
 	PA               core.PolicyAuthority
+	NameTransform    NameTransform // nil leaves names as requested
 	Publisher        core.Publisher
 	keyPolicy        goodkey.KeyPolicy
 	clk              clock.Clock
@@ -122,62 +319,417 @@ type CertificateAuthorityImpl struct {
 	maxNames         int
 	forceCNFromSAN   bool
 	enableMustStaple bool
+	// shuttingDown is set by Shutdown, after which new issuances are
+	// rejected. inFlight counts the issuances and CT submissions Shutdown
+	// waits for. shutdownMu guards shuttingDown and additions to inFlight
+	// from new issuances.
+	shutdownMu   sync.Mutex
+	shuttingDown bool
+	inFlight     sync.WaitGroup
+	// publishQueue, if non-nil, holds certificates waiting to be submitted to
+	// the Publisher by the publish workers.
+	publishQueue *publishQueue
+	// signingSlots is a semaphore bounding the number of concurrent signing
+	// operations. It is nil if no bound is configured.
+	signingSlots chan struct{}
+	// deterministic fixes certificate validity to the CA's clock, and allows
+	// callers to supply serials, so that identical inputs produce identical
+	// certificates.
+	deterministic bool
+	// serialRand is the source of randomness for serial numbers.
+	serialRand io.Reader
+	// issuerExpiryWarningMu protects issuerExpiryWarnings, the time the last
+	// warning was logged for each issuer that it expires too soon to issue
+	// full-length certificates.
+	issuerExpiryWarningMu sync.Mutex
+	issuerExpiryWarnings  map[issuerID]time.Time
+	// profileConfigs holds Boulder-specific settings for signing profiles,
+	// keyed by profile name.
+	profileConfigs map[string]cmd.CAProfileConfig
+	// ocspPrefixes, if non-nil, is the set of serial prefixes for which this CA
+	// will sign OCSP responses.
+	ocspPrefixes map[byte]bool
+	// ocspStatusFromSA makes GenerateOCSP use the SA's record of a
+	// certificate's status in preference to the requested one.
+	ocspStatusFromSA bool
+	// lifespanOCSP is how long OCSP responses are valid for.
+	lifespanOCSP time.Duration
+	// ocspNextUpdateStrategy and ocspNextUpdateFraction determine how long
+	// OCSP responses are valid for within the limit of lifespanOCSP.
+	ocspNextUpdateStrategy ocspNextUpdateStrategy
+	ocspNextUpdateFraction float64
+	// subjectKeyIDMethod determines how issued certificates' subject key
+	// identifiers are derived.
+	subjectKeyIDMethod subjectKeyIDMethod
+	// ocspCache, if non-nil, holds recently signed OCSP responses without
+	// nonces, which are served again in place of identical responses.
+	ocspCache *ocspCache
+	// minSCTs is the fewest SCTs a final certificate may be issued with.
+	minSCTs int
+	// crossSigning enables CrossSign, which issues cross-certificates valid
+	// for crossSignValidity.
+	crossSigning      bool
+	crossSignValidity time.Duration
+	// rejectCSRBasicConstraints rejects CSRs asking for cA or a
+	// pathLenConstraint, and double checks that issued leaves carry neither.
+	rejectCSRBasicConstraints bool
+	// minRequestedValidity is the shortest per-request validity period
+	// IssueCertificateWithValidity accepts.
+	minRequestedValidity time.Duration
+	// minCertificateValidity is the shortest validity period of any
+	// certificate the CA issues.
+	minCertificateValidity time.Duration
+	// cnStrategy selects the DNS name hoisted into an empty subject CN.
+	cnStrategy csrlib.CNStrategy
+	// omitLongCN leaves the CN empty, rather than rejecting the CSR, if every
+	// DNS name is too long to be hoisted into it.
+	omitLongCN bool
+	// maxIssuancesPerReg, if non-zero, caps the number of certificates issued
+	// to one registration within issuancesPerRegWindow.
+	maxIssuancesPerReg    int
+	issuancesPerRegWindow time.Duration
+	// IssuanceCounter tracks issuances per registration when
+	// maxIssuancesPerReg is set. It defaults to an in-memory counter.
+	IssuanceCounter IssuanceCounter
+	// maxIssuancesPerKey, if non-zero, caps the number of certificates issued
+	// for one subject public key.
+	maxIssuancesPerKey int
+	// KeyIssuanceCounter tracks issuances per key when maxIssuancesPerKey is
+	// set. There's no default: issuance fails until one is supplied.
+	KeyIssuanceCounter KeyIssuanceCounter
+	// IssuanceObserver, if non-nil, is notified of every certificate issued.
+	IssuanceObserver IssuanceObserver
+	// checkedLog, if non-nil, is used for the audit log entries that must be
+	// written before a certificate is issued.
+	checkedLog blog.CheckedLogger
+	// logDroppedCSRExtensions logs the OIDs of unsupported CSR extensions.
+	logDroppedCSRExtensions bool
+	// rejectUnknownExtensions rejects CSRs requesting unsupported extensions
+	// that the signing profile doesn't allow, instead of dropping them.
+	rejectUnknownExtensions bool
+	// fallbackProfile signs keys without a type-specific profile.
+	fallbackProfile string
+	// useDefaultProfile causes keys with neither a type-specific profile nor
+	// a fallback profile to be signed with CFSSL's Default signing profile,
+	// selected by the empty profile name.
+	useDefaultProfile bool
+	// rejectDisallowedSubjectAttributes rejects CSRs requesting subject
+	// attributes other than the CN and those in permittedSubjectAttributes.
+	rejectDisallowedSubjectAttributes bool
+	permittedSubjectAttributes        map[string]bool
+	// rejectMalformedWildcards rejects CSRs with malformed wildcard names or
+	// a wildcard CN missing from their DNS names.
+	rejectMalformedWildcards bool
+	// enforceNameConstraints rejects CSRs with names outside the DNS name
+	// constraints of the issuer that would sign them.
+	enforceNameConstraints bool
+	// requireCNInSANs rejects CSRs whose CN isn't among their DNS names even
+	// when forceCNFromSAN is set.
+	requireCNInSANs bool
+	// maxCSRBytes and maxCSRExtensions, if non-zero, limit the size of CSRs.
+	maxCSRBytes      int
+	maxCSRExtensions int
+	// additionalExtensions are included in every certificate issued.
+	additionalExtensions []signer.Extension
 }
 
 // Issuer represents a single issuer certificate, along with its key.
 type Issuer struct {
 	Signer crypto.Signer
 	Cert   *x509.Certificate
+	// OCSPOnly issuers are never used to issue certificates. They are loaded
+	// only to sign OCSP responses for certificates they issued before being
+	// retired, and may share their name with the issuer that replaced them.
+	OCSPOnly bool
+	// OCSPURL and CRLURL, if set, replace the signing profiles' OCSP responder
+	// and CRL URLs in certificates issued by this issuer. Profiles without an
+	// OCSP or CRL URL are left without one.
+	OCSPURL string
+	CRLURL  string
+	// ActiveFrom, if non-empty, restricts issuance by this issuer to keys of
+	// the listed types ("RSA" or "ECDSA"), each from the given time onwards.
+	// Certificates are issued by the first issuer active for their key type,
+	// so during a rotation a new issuer can take over issuance for one key
+	// type while another stays with the issuer that follows it.
+	ActiveFrom map[string]time.Time
+	// OCSPResponderSigner and OCSPResponderCert, if set, are a delegated OCSP
+	// responder [RFC6960 4.2.2.2] that signs this issuer's OCSP responses in
+	// place of the issuer's own key. The responder certificate must be issued
+	// by this issuer for id-kp-OCSPSigning, and is included in the responses.
+	OCSPResponderSigner crypto.Signer
+	OCSPResponderCert   *x509.Certificate
+}
+
+// issuerKeyTypes are the key types that issuers' ActiveFrom may name.
+var issuerKeyTypes = map[string]bool{"RSA": true, "ECDSA": true}
+
+// NewIssuerFromPKCS12 loads an Issuer from a PKCS#12 bundle containing both the
+// issuer certificate and its private key, decrypted using password.
+func NewIssuerFromPKCS12(pfxData []byte, password string) (Issuer, error) {
+	key, cert, err := pkcs12.Decode(pfxData, password)
+	if err == pkcs12.ErrIncorrectPassword {
+		return Issuer{}, errors.New("incorrect password for PKCS#12 issuer bundle")
+	} else if err != nil {
+		return Issuer{}, fmt.Errorf("failed to parse PKCS#12 issuer bundle: %s", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return Issuer{}, fmt.Errorf("unsupported key type %T in PKCS#12 issuer bundle", key)
+	}
+	if !core.KeyDigestEquals(signer.Public(), cert.PublicKey) {
+		return Issuer{}, errors.New("key in PKCS#12 issuer bundle does not match its certificate")
+	}
+	return Issuer{Signer: signer, Cert: cert}, nil
 }
 
 // internalIssuer represents the fully initialized internal state for a single
-// issuer, including the cfssl signer and OCSP signer objects.
+// issuer, including the signer object.
 type internalIssuer struct {
-	cert       *x509.Certificate
-	eeSigner   signer.Signer
-	ocspSigner ocsp.Signer
+	cert     *x509.Certificate
+	key      crypto.Signer
+	policy   *cfsslConfig.Signing
+	eeSigner certSigner
+	// newSigner creates signers like eeSigner for adjusted policies.
+	newSigner certSignerFactory
+	ocspOnly  bool
+	// activeFrom maps key types to the time this issuer starts issuing
+	// certificates for them. If it is empty it issues for every key type.
+	activeFrom map[string]time.Time
+	// ocspKey and ocspCert sign this issuer's OCSP responses. They are the
+	// issuer's own key and cert unless a delegated responder is configured.
+	ocspKey  crypto.Signer
+	ocspCert *x509.Certificate
+	// ocspSigAlg is the algorithm OCSP responses are signed with, or zero for
+	// the default of ocspKey's type.
+	ocspSigAlg x509.SignatureAlgorithm
+	// previewKey stands in for key when previewing certificates. It's
+	// generated the first time it's needed and then reused, since generating
+	// RSA keys is slow.
+	previewMu  sync.Mutex
+	previewKey crypto.Signer
+}
+
+// issuerID identifies an issuer by its key, as its subject key ID, and its
+// name. Issuers may share a name with an issuer whose key was retired, or a
+// key with an issuer under another name.
+type issuerID struct {
+	keyID string
+	cn    string
+}
+
+func (ii *internalIssuer) id() issuerID {
+	return issuerID{keyID: string(ii.cert.SubjectKeyId), cn: ii.cert.Subject.CommonName}
+}
+
+// ocspDelegated returns true if this issuer's OCSP responses are signed by a
+// delegated responder.
+func (ii *internalIssuer) ocspDelegated() bool {
+	return ii.ocspCert != ii.cert
+}
+
+// signingProfile returns the cfssl profile with the given name, falling back
+// to the default profile for unknown names the same way cfssl does.
+func (ii *internalIssuer) signingProfile(name string) *cfsslConfig.SigningProfile {
+	if p := ii.policy.Profiles[name]; p != nil {
+		return p
+	}
+	return ii.policy.Default
+}
+
+// profileValidity returns the validity period of certificates issued using
+// the named profile.
+func (ii *internalIssuer) profileValidity(name string) time.Duration {
+	if expiry := ii.signingProfile(name).Expiry; expiry != 0 {
+		return expiry
+	}
+	return ii.policy.Default.Expiry
+}
+
+// profileBackdate returns how long before the time of issuance the notBefore
+// of certificates issued using the named profile is, mirroring cfssl's default
+// backdate without its rounding.
+func (ii *internalIssuer) profileBackdate(name string) time.Duration {
+	if backdate := ii.signingProfile(name).Backdate; backdate != 0 {
+		return backdate
+	}
+	return 5 * time.Minute
+}
+
+// eeSignerWithProfile returns a signer for this issuer in which the named
+// profile has been replaced by a copy passed through modify. The issuer's
+// shared policy is not changed, so this is safe to use for per-request
+// adjustments.
+func (ii *internalIssuer) eeSignerWithProfile(name string, modify func(*cfsslConfig.SigningProfile)) (certSigner, error) {
+	return ii.newSigner(ii.key, ii.cert, ii.policyWithProfile(name, modify))
+}
+
+// policyWithProfile returns a copy of this issuer's policy in which the named
+// profile has been replaced by a copy passed through modify.
+func (ii *internalIssuer) policyWithProfile(name string, modify func(*cfsslConfig.SigningProfile)) *cfsslConfig.Signing {
+	policy := *ii.policy
+	policy.Profiles = make(map[string]*cfsslConfig.SigningProfile, len(ii.policy.Profiles)+1)
+	for n, p := range ii.policy.Profiles {
+		policy.Profiles[n] = p
+	}
+	profile := *ii.signingProfile(name)
+	// cfssl falls back to the default profile's revocation pointers when a
+	// profile has none. Resolve that up front and clear them from the copied
+	// default, so that modify is able to remove them entirely.
+	if profile.OCSP == "" {
+		profile.OCSP = policy.Default.OCSP
+	}
+	if profile.CRL == "" {
+		profile.CRL = policy.Default.CRL
+	}
+	defaultProfile := *policy.Default
+	defaultProfile.OCSP = ""
+	defaultProfile.CRL = ""
+	policy.Default = &defaultProfile
+	modify(&profile)
+	if name == "" {
+		// cfssl signs with the default profile when none is named
+		policy.Default = &profile
+	} else {
+		policy.Profiles[name] = &profile
+	}
+	return &policy
+}
+
+// withRevocationURLs returns a copy of policy in which every profile's
+// non-empty OCSP and CRL URLs are replaced by ocspURL and crlURL, unless those
+// are empty. Profiles that inherit the default profile's URLs inherit the
+// replacements.
+func withRevocationURLs(policy *cfsslConfig.Signing, ocspURL, crlURL string) *cfsslConfig.Signing {
+	replace := func(p cfsslConfig.SigningProfile) *cfsslConfig.SigningProfile {
+		if ocspURL != "" && p.OCSP != "" {
+			p.OCSP = ocspURL
+		}
+		if crlURL != "" && p.CRL != "" {
+			p.CRL = crlURL
+		}
+		return &p
+	}
+	copied := *policy
+	copied.Profiles = make(map[string]*cfsslConfig.SigningProfile, len(policy.Profiles))
+	for name, p := range policy.Profiles {
+		copied.Profiles[name] = replace(*p)
+	}
+	if policy.Default != nil {
+		copied.Default = replace(*policy.Default)
+	}
+	return &copied
 }
 
 func makeInternalIssuers(
 	issuers []Issuer,
 	policy *cfsslConfig.Signing,
-	lifespanOCSP time.Duration,
-) (map[string]*internalIssuer, error) {
+	newSigner certSignerFactory,
+) ([]*internalIssuer, error) {
 	if len(issuers) == 0 {
 		return nil, errors.New("No issuers specified.")
 	}
-	internalIssuers := make(map[string]*internalIssuer)
+	var internalIssuers []*internalIssuer
+	seen := make(map[issuerID]bool, len(issuers))
 	for _, iss := range issuers {
 		if iss.Cert == nil || iss.Signer == nil {
 			return nil, errors.New("Issuer with nil cert or signer specified.")
 		}
-		eeSigner, err := local.NewSigner(iss.Signer, iss.Cert, x509.SHA256WithRSA, policy)
+		// A signer paired with the wrong cert would otherwise only be noticed
+		// when the signatures it produces fail to verify.
+		signerKey, err := x509.MarshalPKIXPublicKey(iss.Signer.Public())
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Failed to marshal public key of signer for issuer %q: %s",
+				iss.Cert.Subject.CommonName, err)
 		}
-
-		// Set up our OCSP signer. Note this calls for both the issuer cert and the
-		// OCSP signing cert, which are the same in our case.
-		ocspSigner, err := ocsp.NewSigner(iss.Cert, iss.Cert, iss.Signer, lifespanOCSP)
-		if err != nil {
-			return nil, err
+		if !bytes.Equal(signerKey, iss.Cert.RawSubjectPublicKeyInfo) {
+			return nil, fmt.Errorf("Signer's public key doesn't match the cert for issuer %q",
+				iss.Cert.Subject.CommonName)
+		}
+		issuerPolicy := policy
+		if iss.OCSPURL != "" || iss.CRLURL != "" {
+			issuerPolicy = withRevocationURLs(policy, iss.OCSPURL, iss.CRLURL)
+		}
+		var eeSigner certSigner
+		if !iss.OCSPOnly {
+			eeSigner, err = newSigner(iss.Signer, iss.Cert, issuerPolicy)
+			if err != nil {
+				return nil, err
+			}
 		}
 		cn := iss.Cert.Subject.CommonName
-		if internalIssuers[cn] != nil {
-			return nil, errors.New("Multiple issuer certs with the same CommonName are not supported")
+		// Issuers sharing a name are told apart by their keys
+		id := issuerID{keyID: string(iss.Cert.SubjectKeyId), cn: cn}
+		if seen[id] {
+			return nil, fmt.Errorf("Multiple issuer certs with CommonName %q and the same subject key ID are not supported", cn)
+		}
+		seen[id] = true
+		if len(iss.ActiveFrom) > 0 && iss.OCSPOnly {
+			return nil, fmt.Errorf("OCSP-only issuer %q can't be active for issuance", cn)
 		}
-		internalIssuers[cn] = &internalIssuer{
-			cert:       iss.Cert,
-			eeSigner:   eeSigner,
-			ocspSigner: ocspSigner,
+		for keyType := range iss.ActiveFrom {
+			if !issuerKeyTypes[keyType] {
+				return nil, fmt.Errorf("unknown key type %q in activeFrom for issuer %q", keyType, cn)
+			}
+		}
+		ocspKey, ocspCert := iss.Signer, iss.Cert
+		if iss.OCSPResponderSigner != nil || iss.OCSPResponderCert != nil {
+			if err := checkOCSPResponder(iss); err != nil {
+				return nil, err
+			}
+			ocspKey, ocspCert = iss.OCSPResponderSigner, iss.OCSPResponderCert
 		}
+		internalIssuers = append(internalIssuers, &internalIssuer{
+			cert:      iss.Cert,
+			key:       iss.Signer,
+			policy:    issuerPolicy,
+			eeSigner:  eeSigner,
+			newSigner: newSigner,
+			ocspOnly:  iss.OCSPOnly,
+
+			activeFrom: iss.ActiveFrom,
+			ocspKey:    ocspKey,
+			ocspCert:   ocspCert,
+		})
 	}
 	return internalIssuers, nil
 }
 
+// checkOCSPResponder returns an error unless iss has a delegated OCSP
+// responder whose certificate matches its signer, was issued by iss, is for
+// OCSP signing, and carries id-pkix-ocsp-nocheck. Without nocheck relying
+// parties would have to check the responder's own revocation status
+// [RFC6960 4.2.2.2.1], which we don't serve.
+func checkOCSPResponder(iss Issuer) error {
+	cn := iss.Cert.Subject.CommonName
+	if iss.OCSPResponderSigner == nil || iss.OCSPResponderCert == nil {
+		return fmt.Errorf("OCSP responder for issuer %q needs both a cert and a signer", cn)
+	}
+	if !core.KeyDigestEquals(iss.OCSPResponderSigner.Public(), iss.OCSPResponderCert.PublicKey) {
+		return fmt.Errorf("OCSP responder signer's public key doesn't match its cert for issuer %q", cn)
+	}
+	if err := iss.OCSPResponderCert.CheckSignatureFrom(iss.Cert); err != nil {
+		return fmt.Errorf("OCSP responder cert wasn't issued by issuer %q: %s", cn, err)
+	}
+	ocspSigning := false
+	for _, eku := range iss.OCSPResponderCert.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageOCSPSigning {
+			ocspSigning = true
+		}
+	}
+	if !ocspSigning {
+		return fmt.Errorf("OCSP responder cert for issuer %q isn't for OCSP signing", cn)
+	}
+	for _, ext := range iss.OCSPResponderCert.Extensions {
+		if ext.Id.Equal(oidOCSPNoCheck) {
+			return nil
+		}
+	}
+	return fmt.Errorf("OCSP responder cert for issuer %q lacks id-pkix-ocsp-nocheck", cn)
+}
+
 // NewCertificateAuthorityImpl creates a CA instance that can sign certificates
-// from a single issuer (the first first in the issuers slice), and can sign OCSP
-// for any of the issuer certificates provided.
+// from a single issuer (the first in the issuers slice that isn't OCSP-only),
+// and can sign OCSP for any of the issuer certificates provided.
 func NewCertificateAuthorityImpl(
 	config cmd.CAConfig,
 	clk clock.Clock,
@@ -193,6 +745,23 @@ func NewCertificateAuthorityImpl(
 		err = errors.New("Must have a positive non-zero serial prefix less than 256 for CA.")
 		return nil, err
 	}
+	if len(config.AllowedSerialPrefixes) > 0 {
+		allowed := false
+		for _, prefix := range config.AllowedSerialPrefixes {
+			if prefix == config.SerialPrefix {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("serial prefix %d is not one of the allowed serial prefixes %v",
+				config.SerialPrefix, config.AllowedSerialPrefixes)
+		}
+	}
+
+	if err := checkProfileExpiryStrings(config.CFSSL.Signing); err != nil {
+		return nil, err
+	}
 
 	// CFSSL requires processing JSON configs through its own LoadConfig, so we
 	// serialize and then deserialize.
@@ -204,30 +773,103 @@ func NewCertificateAuthorityImpl(
 	if err != nil {
 		return nil, err
 	}
+	if config.MaxProfiles > 0 && cfsslConfigObj.Signing != nil &&
+		len(cfsslConfigObj.Signing.Profiles) > config.MaxProfiles {
+		return nil, fmt.Errorf("CFSSL config has %d signing profiles, more than the maximum of %d",
+			len(cfsslConfigObj.Signing.Profiles), config.MaxProfiles)
+	}
 
 	if config.LifespanOCSP.Duration == 0 {
 		return nil, errors.New("Config must specify an OCSP lifespan period.")
 	}
 
+	newSigner, err := parseSignerBackend(config.SignerBackend)
+	if err != nil {
+		return nil, err
+	}
 	internalIssuers, err := makeInternalIssuers(
 		issuers,
 		cfsslConfigObj.Signing,
-		config.LifespanOCSP.Duration)
+		newSigner)
+	if err != nil {
+		return nil, err
+	}
+	ocspHash, err := parseOCSPSignatureHash(config.OCSPSignatureHash)
 	if err != nil {
 		return nil, err
 	}
-	defaultIssuer := internalIssuers[issuers[0].Cert.Subject.CommonName]
+	for _, iss := range internalIssuers {
+		iss.ocspSigAlg, err = ocspSignatureAlgorithm(iss.ocspKey.Public(), ocspHash)
+		if err != nil {
+			return nil, fmt.Errorf("OCSP signing key for issuer %q: %s", iss.cert.Subject.CommonName, err)
+		}
+	}
+	issuersByName := make(map[string]*internalIssuer, len(internalIssuers))
+	issuersByID := make(map[issuerID]*internalIssuer, len(internalIssuers))
+	var defaultIssuer *internalIssuer
+	var issuanceOrder []*internalIssuer
+	for _, iss := range internalIssuers {
+		// A name shared with OCSP-only issuers refers to the one used for
+		// issuance, and otherwise to the first listed.
+		cn := iss.cert.Subject.CommonName
+		if prev := issuersByName[cn]; prev == nil || (prev.ocspOnly && !iss.ocspOnly) {
+			issuersByName[cn] = iss
+		}
+		issuersByID[iss.id()] = iss
+		if !iss.ocspOnly {
+			issuanceOrder = append(issuanceOrder, iss)
+		}
+	}
+	if len(issuanceOrder) > 0 {
+		defaultIssuer = issuanceOrder[0]
+	}
+	if defaultIssuer == nil {
+		return nil, errors.New("at least one issuer must not be OCSP-only")
+	}
 
 	rsaProfile := config.RSAProfile
 	ecdsaProfile := config.ECDSAProfile
 
-	if rsaProfile == "" || ecdsaProfile == "" {
-		return nil, errors.New("must specify rsaProfile and ecdsaProfile")
+	if (rsaProfile == "" || ecdsaProfile == "") && config.FallbackProfile == "" {
+		if !config.UseDefaultProfile {
+			return nil, errors.New("must specify rsaProfile and ecdsaProfile, fallbackProfile, or useDefaultProfile")
+		}
+		if err := checkDefaultProfile(cfsslConfigObj.Signing.Default); err != nil {
+			return nil, err
+		}
+	}
+	for _, profile := range []string{rsaProfile, ecdsaProfile, config.FallbackProfile} {
+		if config.Profiles[profile].EmailProfile {
+			return nil, fmt.Errorf("profile %q is an email profile and can't be selected by key type", profile)
+		}
+	}
+	for name, profileConfig := range config.Profiles {
+		if profileConfig.Issuer != "" {
+			issuer := issuersByName[profileConfig.Issuer]
+			if issuer == nil {
+				return nil, fmt.Errorf("profile %q is mapped to unknown issuer %q", name, profileConfig.Issuer)
+			}
+			if issuer.ocspOnly {
+				return nil, fmt.Errorf("profile %q is mapped to OCSP-only issuer %q", name, profileConfig.Issuer)
+			}
+		}
+		for _, usage := range profileConfig.AllowedExtKeyUsages {
+			eku, ok := cfsslConfig.ExtKeyUsage[usage]
+			if !ok {
+				return nil, fmt.Errorf("profile %q allows unknown extended key usage %q", name, usage)
+			}
+			if eku == x509.ExtKeyUsageAny {
+				return nil, fmt.Errorf("profile %q may not allow anyExtendedKeyUsage", name)
+			}
+		}
 	}
 
 	ca = &CertificateAuthorityImpl{
-		issuers:          internalIssuers,
+		issuers:          issuersByName,
+		issuersByID:      issuersByID,
+		allIssuers:       internalIssuers,
 		defaultIssuer:    defaultIssuer,
+		issuanceOrder:    issuanceOrder,
 		rsaProfile:       rsaProfile,
 		ecdsaProfile:     ecdsaProfile,
 		prefix:           config.SerialPrefix,
@@ -237,6 +879,86 @@ func NewCertificateAuthorityImpl(
 		keyPolicy:        keyPolicy,
 		forceCNFromSAN:   !config.DoNotForceCN, // Note the inversion here
 		enableMustStaple: config.EnableMustStaple,
+		deterministic:    config.DeterministicIssuance,
+		ocspStatusFromSA: config.OCSPStatusFromSA,
+		lifespanOCSP:     config.LifespanOCSP.Duration,
+		serialRand:       rand.Reader,
+		profileConfigs:   config.Profiles,
+
+		issuerExpiryWarnings: make(map[issuerID]time.Time),
+	}
+
+	if ca.deterministic {
+		for _, iss := range internalIssuers {
+			if _, ok := iss.cert.PublicKey.(*ecdsa.PublicKey); ok && !iss.ocspOnly {
+				return nil, fmt.Errorf("DeterministicIssuance can't be used with ECDSA issuer %q, whose signatures are randomized",
+					iss.cert.Subject.CommonName)
+			}
+		}
+	}
+
+	ca.rejectCSRBasicConstraints = config.RejectCSRBasicConstraints
+	ca.minRequestedValidity = config.MinRequestedValidity.Duration
+	ca.minCertificateValidity = config.MinCertificateValidity.Duration
+	ca.logDroppedCSRExtensions = config.LogDroppedCSRExtensions
+	ca.rejectUnknownExtensions = config.RejectUnknownExtensions
+	ca.fallbackProfile = config.FallbackProfile
+	ca.useDefaultProfile = config.UseDefaultProfile
+	ca.rejectDisallowedSubjectAttributes = config.RejectDisallowedSubjectAttributes
+	ca.rejectMalformedWildcards = config.RejectMalformedWildcards
+	ca.requireCNInSANs = config.RequireCNInSANs
+	ca.enforceNameConstraints = config.EnforceIssuerNameConstraints
+	if err := ca.validateProfiles(); err != nil {
+		return nil, err
+	}
+	ca.maxCSRBytes = config.MaxCSRBytes
+	ca.maxCSRExtensions = config.MaxCSRExtensions
+	ca.additionalExtensions, err = parseAdditionalExtensions(config.AdditionalExtensions)
+	if err != nil {
+		return nil, err
+	}
+	ca.permittedSubjectAttributes = make(map[string]bool)
+	for _, name := range config.PermittedSubjectAttributes {
+		known := false
+		for _, n := range subjectAttributeNames {
+			if n == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown subject attribute %q in permittedSubjectAttributes", name)
+		}
+		ca.permittedSubjectAttributes[name] = true
+	}
+	ca.cnStrategy, err = csrlib.ParseCNStrategy(config.CNStrategy)
+	if err != nil {
+		return nil, err
+	}
+	ca.omitLongCN = config.OmitLongCN
+	ca.subjectKeyIDMethod, err = parseSubjectKeyIDMethod(config.SubjectKeyIDMethod)
+	if err != nil {
+		return nil, err
+	}
+	ca.ocspNextUpdateStrategy, err = parseOCSPNextUpdateStrategy(config.OCSPNextUpdateStrategy)
+	if err != nil {
+		return nil, err
+	}
+	if ca.ocspNextUpdateStrategy == ocspNextUpdateFractional {
+		if config.OCSPNextUpdateFraction <= 0 || config.OCSPNextUpdateFraction > 1 {
+			return nil, fmt.Errorf("ocspNextUpdateFraction must be greater than 0 and at most 1, not %g",
+				config.OCSPNextUpdateFraction)
+		}
+		ca.ocspNextUpdateFraction = config.OCSPNextUpdateFraction
+	}
+	if config.OCSPCacheSize < 0 {
+		return nil, errors.New("OCSPCacheSize must not be negative")
+	}
+	if config.OCSPCacheSize > 0 {
+		if config.OCSPCacheFreshness.Duration <= 0 {
+			return nil, errors.New("OCSPCacheFreshness must be positive when OCSPCacheSize is set")
+		}
+		ca.ocspCache = newOCSPCache(config.OCSPCacheSize, config.OCSPCacheFreshness.Duration)
 	}
 
 	if config.Expiry == "" {
@@ -249,211 +971,1743 @@ func NewCertificateAuthorityImpl(
 
 	ca.maxNames = config.MaxNames
 
-	return ca, nil
-}
-
-// noteSignError is called after operations that may cause a CFSSL
-// or PKCS11 signing error.
-func (ca *CertificateAuthorityImpl) noteSignError(err error) {
-	if err != nil {
-		if _, ok := err.(*pkcs11.Error); ok {
-			ca.stats.Inc(metricHSMError, 1)
-		} else if cfErr, ok := err.(*cferr.Error); ok {
-			ca.stats.Inc(fmt.Sprintf("%s.%d", metricSigningError, cfErr.ErrorCode), 1)
+	if len(config.OCSPSerialPrefixes) > 0 {
+		ca.ocspPrefixes = map[byte]bool{byte(config.SerialPrefix): true}
+		for _, prefix := range config.OCSPSerialPrefixes {
+			if prefix <= 0 || prefix >= 256 {
+				return nil, fmt.Errorf("OCSP serial prefix %d is not a positive non-zero value less than 256", prefix)
+			}
+			ca.ocspPrefixes[byte(prefix)] = true
 		}
 	}
-	return
-}
 
-// Extract supported extensions from a CSR.  The following extensions are
-// currently supported:
-//
-// * 1.3.6.1.5.5.7.1.24 - TLS Feature [RFC7633], with the "must staple" value.
-//                        Any other value will result in an error.
-//
-// Other requested extensions are silently ignored.
-func (ca *CertificateAuthorityImpl) extensionsFromCSR(csr *x509.CertificateRequest) ([]signer.Extension, error) {
-	extensions := []signer.Extension{}
-
-	extensionSeen := map[string]bool{}
-	hasBasic := false
-	hasOther := false
+	if config.RequireAuditLog {
+		checkedLog, ok := logger.(blog.CheckedLogger)
+		if !ok {
+			return nil, errors.New("RequireAuditLog is set but the logger can't report audit log failures")
+		}
+		ca.checkedLog = checkedLog
+	}
 
-	for _, attr := range csr.Attributes {
-		if !attr.Type.Equal(oidExtensionRequest) {
-			continue
+	if config.MaxIssuancesPerRegistration < 0 {
+		return nil, errors.New("MaxIssuancesPerRegistration must not be negative")
+	}
+	if config.MaxIssuancesPerRegistration > 0 {
+		if config.IssuancesPerRegistrationWindow.Duration <= 0 {
+			return nil, errors.New("IssuancesPerRegistrationWindow must be positive when MaxIssuancesPerRegistration is set")
 		}
+		ca.maxIssuancesPerReg = config.MaxIssuancesPerRegistration
+		ca.issuancesPerRegWindow = config.IssuancesPerRegistrationWindow.Duration
+		ca.IssuanceCounter = NewMemoryIssuanceCounter()
+	}
+	if config.MaxIssuancesPerKey < 0 {
+		return nil, errors.New("MaxIssuancesPerKey must not be negative")
+	}
+	// There's no default KeyIssuanceCounter, since one local to this instance
+	// wouldn't enforce the limit across instances or restarts
+	ca.maxIssuancesPerKey = config.MaxIssuancesPerKey
 
-		for _, extList := range attr.Value {
-			for _, ext := range extList {
-				if extensionSeen[ext.Type.String()] {
-					// Ignore duplicate certificate extensions
-					continue
-				}
-				extensionSeen[ext.Type.String()] = true
+	ca.issuerKeys = make(map[[sha256.Size]byte]string, len(internalIssuers))
+	for _, issuer := range internalIssuers {
+		ca.issuerKeys[sha256.Sum256(issuer.cert.RawSubjectPublicKeyInfo)] = issuer.cert.Subject.CommonName
+	}
 
-				switch {
-				case ext.Type.Equal(oidTLSFeature):
-					ca.stats.Inc(metricCSRExtensionTLSFeature, 1)
-					value, ok := ext.Value.([]byte)
-					if !ok {
-						return nil, berrors.MalformedError("malformed extension with OID %v", ext.Type)
-					} else if !bytes.Equal(value, mustStapleFeatureValue) {
-						ca.stats.Inc(metricCSRExtensionTLSFeatureInvalid, 1)
-						return nil, berrors.MalformedError("unsupported value for extension with OID %v", ext.Type)
-					}
+	if config.MinSCTs < 0 {
+		return nil, errors.New("MinSCTs must not be negative")
+	}
+	ca.minSCTs = config.MinSCTs
 
-					if ca.enableMustStaple {
-						extensions = append(extensions, mustStapleExtension)
-					}
-				case ext.Type.Equal(oidAuthorityInfoAccess),
-					ext.Type.Equal(oidAuthorityKeyIdentifier),
-					ext.Type.Equal(oidBasicConstraints),
-					ext.Type.Equal(oidCertificatePolicies),
-					ext.Type.Equal(oidCrlDistributionPoints),
-					ext.Type.Equal(oidExtKeyUsage),
-					ext.Type.Equal(oidKeyUsage),
-					ext.Type.Equal(oidSubjectAltName),
-					ext.Type.Equal(oidSubjectKeyIdentifier):
-					hasBasic = true
-				default:
-					hasOther = true
-				}
-			}
+	if config.CrossSigning {
+		if config.CrossSignValidity.Duration <= 0 {
+			return nil, errors.New("CrossSignValidity must be positive when CrossSigning is set")
 		}
+		ca.crossSigning = true
+		ca.crossSignValidity = config.CrossSignValidity.Duration
 	}
 
-	if hasBasic {
-		ca.stats.Inc(metricCSRExtensionBasic, 1)
+	if config.MaxConcurrentSignings < 0 {
+		return nil, errors.New("MaxConcurrentSignings must not be negative")
 	}
-
-	if hasOther {
-		ca.stats.Inc(metricCSRExtensionOther, 1)
+	if config.MaxConcurrentSignings > 0 {
+		ca.signingSlots = make(chan struct{}, config.MaxConcurrentSignings)
 	}
 
-	return extensions, nil
-}
-
-// GenerateOCSP produces a new OCSP response and returns it
-func (ca *CertificateAuthorityImpl) GenerateOCSP(ctx context.Context, xferObj core.OCSPSigningRequest) ([]byte, error) {
-	cert, err := x509.ParseCertificate(xferObj.CertDER)
-	if err != nil {
-		ca.log.AuditErr(err.Error())
-		return nil, err
+	if config.PublisherWorkers < 0 || config.PublisherQueueSize < 0 || config.PublisherMaxAttempts < 0 {
+		return nil, errors.New("PublisherWorkers, PublisherQueueSize and PublisherMaxAttempts must not be negative")
 	}
 
-	signRequest := ocsp.SignRequest{
-		Certificate: cert,
-		Status:      xferObj.Status,
-		Reason:      int(xferObj.Reason),
-		RevokedAt:   xferObj.RevokedAt,
+	if config.StartupSelfTest {
+		if err := ca.selfTest(); err != nil {
+			return nil, err
+		}
 	}
 
-	cn := cert.Issuer.CommonName
-	issuer := ca.issuers[cn]
-	if issuer == nil {
-		return nil, fmt.Errorf("This CA doesn't have an issuer cert with CommonName %q", cn)
+	// Serials from two instances can only collide if they share a prefix, so
+	// record which one this instance uses
+	logger.AuditInfo(fmt.Sprintf("CA created with serial prefix %#02x", ca.prefix))
+
+	// Started last, so that no workers are left behind if the CA isn't
+	// created
+	if config.PublisherWorkers > 0 {
+		ca.startPublishWorkers(config.PublisherWorkers, config.PublisherQueueSize,
+			config.PublisherMaxAttempts, config.PublisherRetryBackoff.Duration,
+			config.PublisherMaxRetryBackoff.Duration, config.PublisherAttemptTimeout.Duration)
 	}
 
-	err = cert.CheckSignatureFrom(issuer.cert)
-	if err != nil {
-		return nil, fmt.Errorf("GenerateOCSP was asked to sign OCSP for cert "+
-			"%s from %q, but the cert's signature was not valid: %s.",
-			core.SerialToString(cert.SerialNumber), cn, err)
+	return ca, nil
+}
+
+// acquireSigningSlot blocks until a signing slot is available or the context
+// is done. Callers must call releaseSigningSlot once their signing operation
+// has finished if acquireSigningSlot returns no error.
+func (ca *CertificateAuthorityImpl) acquireSigningSlot(ctx context.Context) error {
+	if ca.signingSlots == nil {
+		return nil
 	}
+	select {
+	case ca.signingSlots <- struct{}{}:
+		ca.stats.GaugeDelta(metricSigningInProgress, 1)
+		return nil
+	case <-ctx.Done():
+		return berrors.InternalServerError("timed out waiting for a signing slot: %s", ctx.Err())
+	}
+}
 
-	ocspResponse, err := issuer.ocspSigner.Sign(signRequest)
-	ca.noteSignError(err)
-	if err == nil {
-		ca.stats.Inc("Signatures.OCSP", 1)
+// releaseSigningSlot returns a slot taken by acquireSigningSlot.
+func (ca *CertificateAuthorityImpl) releaseSigningSlot() {
+	if ca.signingSlots == nil {
+		return
 	}
-	return ocspResponse, err
+	<-ca.signingSlots
+	ca.stats.GaugeDelta(metricSigningInProgress, -1)
 }
 
-// IssueCertificate attempts to convert a CSR into a signed Certificate, while
-// enforcing all policies. Names (domains) in the CertificateRequest will be
-// lowercased before storage.
-// Currently it will always sign with the defaultIssuer.
-func (ca *CertificateAuthorityImpl) IssueCertificate(ctx context.Context, csr x509.CertificateRequest, regID int64) (core.Certificate, error) {
-	emptyCert := core.Certificate{}
+// noteSignError is called after operations that may cause a CFSSL
+// or PKCS11 signing error.
+func (ca *CertificateAuthorityImpl) noteSignError(err error) {
+	if err != nil {
+		if _, ok := err.(*pkcs11.Error); ok {
+			ca.stats.Inc(metricHSMError, 1)
+		} else if cfErr, ok := err.(*cferr.Error); ok {
+			ca.stats.Inc(fmt.Sprintf("%s.%d", metricSigningError, cfErr.ErrorCode), 1)
+		}
+	}
+	return
+}
+
+// auditInfoRequired writes msg to the audit log. If the CA is configured to
+// require audit logging, it returns an error if msg could not be written.
+func (ca *CertificateAuthorityImpl) auditInfoRequired(msg string) error {
+	if ca.checkedLog == nil {
+		ca.log.AuditInfo(msg)
+		return nil
+	}
+	if err := ca.checkedLog.CheckedAuditInfo(msg); err != nil {
+		return berrors.InternalServerError("failed to write audit log: %s", err)
+	}
+	return nil
+}
+
+// reserveIssuance reserves one of the issuances regID may have within the
+// configured window, returning a RateLimit error if they've all been used.
+// It returns a function that releases the reservation, for an issuance that
+// then fails.
+func (ca *CertificateAuthorityImpl) reserveIssuance(ctx context.Context, regID int64) (func(), error) {
+	if ca.maxIssuancesPerReg <= 0 {
+		return func() {}, nil
+	}
+	now := ca.clk.Now()
+	reserved, err := ca.IssuanceCounter.Reserve(ctx, regID, now.Add(-ca.issuancesPerRegWindow), now, ca.maxIssuancesPerReg)
+	if err != nil {
+		return nil, berrors.InternalServerError("failed to count issuances for registration %d: %s", regID, err)
+	}
+	if !reserved {
+		return nil, berrors.RateLimitError(
+			"registration %d has been issued %d certificates in the last %s",
+			regID, ca.maxIssuancesPerReg, ca.issuancesPerRegWindow)
+	}
+	return func() {
+		if err := ca.IssuanceCounter.Release(ctx, regID, now); err != nil {
+			ca.log.AuditErr(fmt.Sprintf("Failed to release issuance reservation: regID=[%d] err=[%v]", regID, err))
+		}
+	}, nil
+}
+
+// reserveKeyIssuance reserves one of the issuances key may have, returning a
+// RateLimit error if they've all been used. It returns a function that
+// releases the reservation, for an issuance that then fails.
+func (ca *CertificateAuthorityImpl) reserveKeyIssuance(ctx context.Context, key crypto.PublicKey) (func(), error) {
+	if ca.maxIssuancesPerKey <= 0 {
+		return func() {}, nil
+	}
+	if ca.KeyIssuanceCounter == nil {
+		return nil, berrors.InternalServerError("MaxIssuancesPerKey is set but the CA has no KeyIssuanceCounter")
+	}
+	keyDigest, err := core.KeyDigest(key)
+	if err != nil {
+		return nil, berrors.InternalServerError("failed to compute key digest: %s", err)
+	}
+	reserved, err := ca.KeyIssuanceCounter.Reserve(ctx, keyDigest, ca.maxIssuancesPerKey)
+	if err != nil {
+		return nil, berrors.InternalServerError("failed to count issuances for key %s: %s", keyDigest, err)
+	}
+	if !reserved {
+		return nil, berrors.RateLimitError(
+			"%d certificates have already been issued for key %s", ca.maxIssuancesPerKey, keyDigest)
+	}
+	return func() {
+		if err := ca.KeyIssuanceCounter.Release(ctx, keyDigest); err != nil {
+			ca.log.AuditErr(fmt.Sprintf("Failed to release issuance reservation for key: digest=[%s] err=[%v]",
+				keyDigest, err))
+		}
+	}, nil
+}
+
+// healthCheckData is signed by each issuer key during a health check.
+var healthCheckData = []byte("boulder CA health check")
+
+// Health reports whether the CA is ready to serve requests. It checks that the
+// SA, PA, and Publisher are set and that every issuer key can produce a valid
+// signature, returning an error describing the first component that fails.
+// The test signatures are over fixed data rather than a certificate, so no
+// serial number is consumed.
+func (ca *CertificateAuthorityImpl) Health(ctx context.Context) error {
+	if ca.SA == nil {
+		return errors.New("CA has no SA configured")
+	}
+	if ca.PA == nil {
+		return errors.New("CA has no PA configured")
+	}
+	if ca.Publisher == nil {
+		return errors.New("CA has no Publisher configured")
+	}
+	if ca.maxIssuancesPerKey > 0 && ca.KeyIssuanceCounter == nil {
+		return errors.New("CA has MaxIssuancesPerKey set but no KeyIssuanceCounter configured")
+	}
+
+	for _, issuer := range ca.allIssuers {
+		if err := ca.checkIssuerKey(ctx, issuer); err != nil {
+			return fmt.Errorf("issuer %q failed health check: %s", issuer.cert.Subject.CommonName, err)
+		}
+	}
+	return nil
+}
+
+// checkIssuerKey makes a test signature with the issuer's key and verifies it
+// against the issuer certificate.
+func (ca *CertificateAuthorityImpl) checkIssuerKey(ctx context.Context, issuer *internalIssuer) error {
+	var sigAlg x509.SignatureAlgorithm
+	switch issuer.cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		sigAlg = x509.SHA256WithRSA
+	case *ecdsa.PublicKey:
+		sigAlg = x509.ECDSAWithSHA256
+	default:
+		return fmt.Errorf("unsupported issuer key type %T", issuer.cert.PublicKey)
+	}
+
+	digest := sha256.Sum256(healthCheckData)
+	if err := ca.acquireSigningSlot(ctx); err != nil {
+		return err
+	}
+	sig, err := issuer.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	ca.releaseSigningSlot()
+	ca.noteSignError(err)
+	if err != nil {
+		return fmt.Errorf("signing failed: %s", err)
+	}
+	if err := issuer.cert.CheckSignature(sigAlg, healthCheckData, sig); err != nil {
+		return fmt.Errorf("signature did not verify: %s", err)
+	}
+	return nil
+}
+
+// selfTestName is the only name in the certificates issued by selfTest.
+const selfTestName = "self-test.boulder.invalid"
+
+// selfTestSerial is the serial of the certificates issued by selfTest. Real
+// serials start with the CA's non-zero prefix byte, so can never collide with
+// it.
+var selfTestSerial = big.NewInt(1)
+
+// selfTest issues a throwaway certificate for a freshly generated key from
+// each issuer that can issue, and checks that it verifies against the issuer
+// certificate. The certificates are neither stored nor published, and don't
+// consume serials from the CA's serial source.
+func (ca *CertificateAuthorityImpl) selfTest() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate self-test key: %s", err)
+	}
+	profile, err := ca.profileForKey(key.Public())
+	if err != nil {
+		return err
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: selfTestName},
+		DNSNames: []string{selfTestName},
+	}, key)
+	if err != nil {
+		return fmt.Errorf("failed to create self-test CSR: %s", err)
+	}
+	req := signer.SignRequest{
+		Request: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})),
+		Profile: profile,
+		Hosts:   []string{selfTestName},
+		Subject: &signer.Subject{CN: selfTestName},
+		Serial:  selfTestSerial,
+	}
+
+	for _, issuer := range ca.issuanceOrder {
+		name := issuer.cert.Subject.CommonName
+		certPEM, err := issuer.eeSigner.Sign(req)
+		if err != nil {
+			return fmt.Errorf("issuer %q failed self-test: signing failed: %s", name, err)
+		}
+		block, _ := pem.Decode(certPEM)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return fmt.Errorf("issuer %q failed self-test: invalid certificate returned", name)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("issuer %q failed self-test: %s", name, err)
+		}
+		if err := cert.CheckSignatureFrom(issuer.cert); err != nil {
+			return fmt.Errorf("issuer %q failed self-test: certificate did not verify: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// Extract supported extensions from a CSR.  The following extensions are
+// currently supported:
+//
+// * 1.3.6.1.5.5.7.1.24 - TLS Feature [RFC7633], with the features allowed by
+//                        tlsFeatureRequires. Any other value will result in an error.
+//
+// The TLS Feature extension is only included in the certificate if must staple
+// is enabled for the CA or for profile. Other requested extensions are silently
+// ignored, unless rejectUnknownExtensions is set and they aren't allowed by
+// issuer's signing profile, in which case they result in an error.
+//
+// An extension requested more than once is only considered once if every copy
+// is identical, and results in an error otherwise.
+func (ca *CertificateAuthorityImpl) extensionsFromCSR(csr *x509.CertificateRequest, issuer *internalIssuer, profile string) ([]signer.Extension, error) {
+	if err := checkConflictingExtensions(csr.Attributes); err != nil {
+		return nil, err
+	}
+
+	// A leaf CSR has no business asking for a CA certificate, so this is
+	// flagged as well as rejected. Cross-certificates are requested through
+	// CrossSign instead. csr.Extensions is used since, unlike the raw
+	// attributes, it is parsed correctly whether or not the extension is
+	// marked critical.
+	for _, ext := range csr.Extensions {
+		if ext.Id.Equal(oidBasicConstraints) && requestsCA(ext.Value) {
+			ca.log.AuditErr(fmt.Sprintf("Possible attack: CSR requests a CA certificate: csr=[%s]",
+				core.Fingerprint256(csr.Raw)))
+			return nil, berrors.MalformedError("CSR requests a CA certificate")
+		}
+	}
+
+	extensions := []signer.Extension{}
+
+	extensionSeen := map[string]bool{}
+	hasBasic := false
+	var dropped, rejected []string
+	allowed := issuer.signingProfile(profile).ExtensionWhitelist
+
+	for _, attr := range csr.Attributes {
+		if !attr.Type.Equal(oidExtensionRequest) {
+			continue
+		}
+
+		for _, extList := range attr.Value {
+			for _, ext := range extList {
+				if extensionSeen[ext.Type.String()] {
+					// Ignore duplicate certificate extensions
+					continue
+				}
+				extensionSeen[ext.Type.String()] = true
+
+				switch {
+				case ext.Type.Equal(oidTLSFeature):
+					ca.stats.Inc(metricCSRExtensionTLSFeature, 1)
+					value, ok := ext.Value.([]byte)
+					if !ok {
+						return nil, berrors.MalformedError("malformed extension with OID %v", ext.Type)
+					}
+					features, err := checkTLSFeatures(value)
+					if err != nil {
+						ca.stats.Inc(metricCSRExtensionTLSFeatureInvalid, 1)
+						return nil, berrors.MalformedError("unsupported value for extension with OID %v: %s", ext.Type, err)
+					}
+
+					if ca.enableMustStaple || ca.profileConfigs[profile].EnableMustStaple {
+						// Re-encode the features so that only what was checked
+						// ends up in the certificate
+						featuresDER, err := asn1.Marshal(features)
+						if err != nil {
+							return nil, berrors.InternalServerError("failed to encode TLS features: %s", err)
+						}
+						extensions = append(extensions, signer.Extension{
+							ID:       cfsslConfig.OID(oidTLSFeature),
+							Critical: false,
+							Value:    hex.EncodeToString(featuresDER),
+						})
+					}
+				case ext.Type.Equal(oidBasicConstraints):
+					// cfssl copies a requested basicConstraints into the
+					// certificate template, so don't rely on the profile
+					// alone to keep CA constraints out of leaves.
+					hasBasic = true
+					if ca.rejectCSRBasicConstraints {
+						if err := checkLeafBasicConstraints(ext.Value); err != nil {
+							return nil, err
+						}
+					}
+				case ext.Type.Equal(oidAuthorityInfoAccess),
+					ext.Type.Equal(oidAuthorityKeyIdentifier),
+					ext.Type.Equal(oidCertificatePolicies),
+					ext.Type.Equal(oidCrlDistributionPoints),
+					ext.Type.Equal(oidExtKeyUsage),
+					ext.Type.Equal(oidKeyUsage),
+					ext.Type.Equal(oidSubjectAltName),
+					ext.Type.Equal(oidSubjectKeyIdentifier):
+					hasBasic = true
+				default:
+					dropped = append(dropped, ext.Type.String())
+					if ca.rejectUnknownExtensions && !allowed[ext.Type.String()] {
+						rejected = append(rejected, ext.Type.String())
+					}
+				}
+			}
+		}
+	}
+
+	if hasBasic {
+		ca.stats.Inc(metricCSRExtensionBasic, 1)
+	}
+
+	if len(dropped) > 0 {
+		ca.stats.Inc(metricCSRExtensionOther, 1)
+		if len(rejected) > 0 {
+			return nil, berrors.MalformedError("unsupported extensions requested: %s", strings.Join(rejected, ", "))
+		}
+		if ca.logDroppedCSRExtensions {
+			ca.log.Debug(fmt.Sprintf("Dropped unsupported CSR extensions: %s", strings.Join(dropped, ", ")))
+		}
+	}
+
+	return extensions, nil
+}
+
+// checkConflictingExtensions returns a Malformed error if any extension is
+// requested by attrs more than once with differing values.
+func checkConflictingExtensions(attrs []pkix.AttributeTypeAndValueSET) error {
+	requested := map[string]interface{}{}
+	for _, attr := range attrs {
+		if !attr.Type.Equal(oidExtensionRequest) {
+			continue
+		}
+		for _, extList := range attr.Value {
+			for _, ext := range extList {
+				prev, ok := requested[ext.Type.String()]
+				if !ok {
+					requested[ext.Type.String()] = ext.Value
+					continue
+				}
+				if !reflect.DeepEqual(prev, ext.Value) {
+					return berrors.MalformedError("conflicting values for extension with OID %v", ext.Type)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// basicConstraints mirrors the ASN.1 structure of the X.509 basicConstraints
+// extension (RFC 5280, 4.2.1.9).
+type basicConstraints struct {
+	IsCA       bool `asn1:"optional"`
+	MaxPathLen int  `asn1:"optional,default:-1"`
+}
+
+// checkLeafBasicConstraints returns an error if the requested basicConstraints
+// extension value asks for a CA certificate or a pathLenConstraint.
+func checkLeafBasicConstraints(extValue interface{}) error {
+	value, ok := extValue.([]byte)
+	if !ok {
+		return berrors.MalformedError("malformed extension with OID %v", oidBasicConstraints)
+	}
+	var constraints basicConstraints
+	rest, err := asn1.Unmarshal(value, &constraints)
+	if err != nil || len(rest) != 0 {
+		return berrors.MalformedError("malformed extension with OID %v", oidBasicConstraints)
+	}
+	if constraints.IsCA {
+		return berrors.MalformedError("CSR requests a CA certificate")
+	}
+	if constraints.MaxPathLen != -1 {
+		return berrors.MalformedError("CSR requests a pathLenConstraint")
+	}
+	return nil
+}
+
+// requestsCA returns true if value is a well-formed basicConstraints
+// extension value with cA set.
+func requestsCA(value []byte) bool {
+	var constraints basicConstraints
+	rest, err := asn1.Unmarshal(value, &constraints)
+	return err == nil && len(rest) == 0 && constraints.IsCA
+}
+
+// checkIssuerValidity returns an error if a certificate issued at issuedAt with
+// the given validity period would expire after the issuer certificate does.
+func (ca *CertificateAuthorityImpl) checkIssuerValidity(issuer *internalIssuer, issuedAt time.Time, validity time.Duration) error {
+	notAfter := issuedAt.Add(validity)
+	if issuer.cert.NotAfter.Before(notAfter) {
+		return berrors.InternalServerError(
+			"cannot issue a certificate that expires after the issuer certificate %q",
+			issuer.cert.Subject.CommonName)
+	}
+	return nil
+}
+
+// alignNotAfter rounds notAfter down to a multiple of boundary, so that the
+// certificate expires on a fixed wall-clock boundary without exceeding its
+// maximum validity. If that would still outlive the issuer, the last boundary
+// before the issuer expires is used instead, and clamped is true.
+func alignNotAfter(notBefore, notAfter, issuerNotAfter time.Time, boundary time.Duration) (aligned time.Time, clamped bool, err error) {
+	notAfter = notAfter.Truncate(boundary)
+	if notAfter.After(issuerNotAfter) {
+		notAfter = issuerNotAfter.Truncate(boundary)
+		clamped = true
+	}
+	if !notAfter.After(notBefore) {
+		return time.Time{}, false, berrors.InternalServerError(
+			"no %s expiry boundary between %s and the issuer's expiry", boundary, notBefore)
+	}
+	return notAfter, clamped, nil
+}
+
+// warnIssuerExpiry logs a warning that issuer expires before a certificate
+// issued at issuedAt using the named profile would, so it can no longer issue
+// full-length certificates. Each issuer is warned about at most once per
+// issuerExpiryWarningInterval.
+func (ca *CertificateAuthorityImpl) warnIssuerExpiry(issuer *internalIssuer, profile string, issuedAt time.Time) {
+	id := issuer.id()
+	ca.issuerExpiryWarningMu.Lock()
+	last, warned := ca.issuerExpiryWarnings[id]
+	if warned && issuedAt.Sub(last) < issuerExpiryWarningInterval {
+		ca.issuerExpiryWarningMu.Unlock()
+		return
+	}
+	ca.issuerExpiryWarnings[id] = issuedAt
+	ca.issuerExpiryWarningMu.Unlock()
+
+	ca.log.Warning(fmt.Sprintf(
+		"Issuer %q expires at %s, too soon to issue full-length certificates with profile %q",
+		issuer.cert.Subject.CommonName, issuer.cert.NotAfter, profile))
+}
+
+// checkProfileExtKeyUsages checks the extended key usages the named profile
+// will put in certificates, after any anyExtendedKeyUsage is stripped, against
+// the profile's configured allowlist. Without an allowlist every usage is
+// accepted.
+func (ca *CertificateAuthorityImpl) checkProfileExtKeyUsages(issuer *internalIssuer, profile string) error {
+	profileConfig := ca.profileConfigs[profile]
+	if len(profileConfig.AllowedExtKeyUsages) == 0 {
+		return nil
+	}
+	allowed := make(map[x509.ExtKeyUsage]bool, len(profileConfig.AllowedExtKeyUsages))
+	for _, usage := range profileConfig.AllowedExtKeyUsages {
+		allowed[cfsslConfig.ExtKeyUsage[usage]] = true
+	}
+	_, ekus, _ := issuer.signingProfile(profile).Usages()
+	var remaining int
+	for _, eku := range ekus {
+		if eku == x509.ExtKeyUsageAny && profileConfig.StripAnyExtKeyUsage {
+			continue
+		}
+		if !allowed[eku] {
+			return berrors.InternalServerError(
+				"profile %q requests an extended key usage it doesn't allow", profile)
+		}
+		remaining++
+	}
+	if remaining == 0 {
+		return berrors.InternalServerError("profile %q requests no extended key usages", profile)
+	}
+	return nil
+}
+
+// extKeyUsagesAllowed reports whether cert has at least one extended key
+// usage, all of them named in allowed and none unknown.
+func extKeyUsagesAllowed(cert *x509.Certificate, allowed []string) bool {
+	if len(cert.ExtKeyUsage) == 0 || len(cert.UnknownExtKeyUsage) > 0 {
+		return false
+	}
+	permitted := make(map[x509.ExtKeyUsage]bool, len(allowed))
+	for _, usage := range allowed {
+		permitted[cfsslConfig.ExtKeyUsage[usage]] = true
+	}
+	for _, eku := range cert.ExtKeyUsage {
+		if !permitted[eku] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkProfileUsages returns an error if the named profile requests usages the
+// issuer certificate can't grant. The issuer must be permitted to sign
+// certificates, and if it is restricted to particular extended key usages the
+// profile's extended key usages must be among them.
+func (ca *CertificateAuthorityImpl) checkProfileUsages(issuer *internalIssuer, profile string) error {
+	_, ekus, unknown := issuer.signingProfile(profile).Usages()
+	if len(unknown) > 0 {
+		return berrors.InternalServerError(
+			"profile %q has unknown usages: %s", profile, strings.Join(unknown, ", "))
+	}
+
+	issuerCert := issuer.cert
+	if issuerCert.KeyUsage != 0 && issuerCert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return berrors.InternalServerError(
+			"issuer certificate %q is not permitted to sign certificates",
+			issuerCert.Subject.CommonName)
+	}
+	if len(issuerCert.ExtKeyUsage) == 0 {
+		return nil
+	}
+	permitted := make(map[x509.ExtKeyUsage]bool, len(issuerCert.ExtKeyUsage))
+	for _, eku := range issuerCert.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageAny {
+			return nil
+		}
+		permitted[eku] = true
+	}
+	for _, eku := range ekus {
+		if !permitted[eku] {
+			return berrors.InternalServerError(
+				"profile %q requests an extended key usage not permitted by issuer certificate %q",
+				profile, issuerCert.Subject.CommonName)
+		}
+	}
+	return nil
+}
+
+// checkProfileOCSPNoCheck returns an error unless the named profile includes
+// the id-pkix-ocsp-nocheck extension exactly when it issues delegated OCSP
+// responder certificates. Responder certificates must carry it so that relying
+// parties don't need to check their revocation status [RFC6960 4.2.2.2.1], and
+// it must never appear in server certificates, whose revocation status must
+// always be checked.
+func (ca *CertificateAuthorityImpl) checkProfileOCSPNoCheck(issuer *internalIssuer, profile string) error {
+	signingProfile := issuer.signingProfile(profile)
+	_, ekus, _ := signingProfile.Usages()
+	var ocspSigning, serverAuth bool
+	for _, eku := range ekus {
+		switch eku {
+		case x509.ExtKeyUsageOCSPSigning:
+			ocspSigning = true
+		case x509.ExtKeyUsageServerAuth:
+			serverAuth = true
+		}
+	}
+	if ocspSigning && serverAuth {
+		return berrors.InternalServerError(
+			"profile %q issues certificates for both OCSP signing and server authentication", profile)
+	}
+	if ocspSigning && !signingProfile.OCSPNoCheck {
+		return berrors.InternalServerError(
+			"profile %q issues OCSP responder certificates without the OCSP no check extension", profile)
+	}
+	if !ocspSigning && signingProfile.OCSPNoCheck {
+		return berrors.InternalServerError(
+			"profile %q includes the OCSP no check extension in certificates that can't sign OCSP", profile)
+	}
+	return nil
+}
+
+// checkKeyUsageCompatibility returns an error if the named profile has key
+// usages that can't be performed with key.
+func (ca *CertificateAuthorityImpl) checkKeyUsageCompatibility(issuer *internalIssuer, profile string, key crypto.PublicKey) error {
+	usages, _, _ := issuer.signingProfile(profile).Usages()
+	supported, ok := keyUsagesByKeyType[reflect.TypeOf(key)]
+	if !ok {
+		return berrors.InternalServerError("unsupported key type %T", key)
+	}
+	if unsupported := usages &^ supported; unsupported != 0 {
+		return berrors.InternalServerError(
+			"profile %q has key usages (%#x) that a %T can't perform", profile, unsupported, key)
+	}
+	return nil
+}
+
+// checkProfilePolicies returns an error if the named profile's certificate
+// policies contain a malformed OID or a CPS qualifier that isn't an absolute
+// HTTP(S) URI.
+func (ca *CertificateAuthorityImpl) checkProfilePolicies(issuer *internalIssuer, profile string) error {
+	for _, policy := range issuer.signingProfile(profile).Policies {
+		oid := asn1.ObjectIdentifier(policy.ID)
+		if !validOID(oid) {
+			return berrors.InternalServerError("profile %q has malformed policy OID %q", profile, oid)
+		}
+		for _, qualifier := range policy.Qualifiers {
+			switch qualifier.Type {
+			case "id-qt-cps":
+				if !validHTTPURL(qualifier.Value) {
+					return berrors.InternalServerError(
+						"profile %q has invalid CPS URI %q for policy %s", profile, qualifier.Value, oid)
+				}
+				for _, r := range qualifier.Value {
+					if r > unicode.MaxASCII {
+						return berrors.InternalServerError(
+							"profile %q has non-ASCII CPS URI %q for policy %s", profile, qualifier.Value, oid)
+					}
+				}
+			case "id-qt-unotice":
+			default:
+				return berrors.InternalServerError(
+					"profile %q has unknown qualifier type %q for policy %s", profile, qualifier.Type, oid)
+			}
+		}
+	}
+	return nil
+}
+
+// validHTTPURL returns true if s is an absolute HTTP(S) URL with a host.
+func validHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs() && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// checkProfileExpiryStrings returns an error naming the first signing profile
+// whose expiry is missing or can't be parsed. The default profile is named
+// "". cfssl's LoadConfig rejects these too, but without saying which profile
+// is at fault.
+func checkProfileExpiryStrings(signing *cfsslConfig.Signing) error {
+	if signing == nil {
+		return nil
+	}
+	names := []string{""}
+	for name := range signing.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := signing.Profiles[name]
+		if name == "" {
+			p = signing.Default
+		}
+		if p == nil || p.RemoteName != "" || p.AuthRemote.RemoteName != "" {
+			continue
+		}
+		if p.ExpiryString == "" {
+			return fmt.Errorf("profile %q has no expiry", name)
+		}
+		if _, err := time.ParseDuration(p.ExpiryString); err != nil {
+			return fmt.Errorf("profile %q has invalid expiry %q: %s", name, p.ExpiryString, err)
+		}
+	}
+	return nil
+}
+
+// validateProfiles returns an error naming the first misconfigured signing
+// profile, so that mistakes are caught at startup rather than when the
+// profile is first used for issuance. The profiles selected by key type must
+// exist, and every profile of every issuer that can issue must have valid
+// certificate policies and revocation URLs and a validity period that fits
+// within the issuer certificate's own.
+func (ca *CertificateAuthorityImpl) validateProfiles() error {
+	for _, profile := range []string{ca.rsaProfile, ca.ecdsaProfile, ca.fallbackProfile} {
+		if profile != "" && ca.defaultIssuer.policy.Profiles[profile] == nil {
+			return fmt.Errorf("profile %q is selected by key type but isn't a CFSSL signing profile", profile)
+		}
+	}
+	for _, issuer := range ca.issuanceOrder {
+		names := []string{""}
+		for name := range issuer.policy.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		issuerLifetime := issuer.cert.NotAfter.Sub(issuer.cert.NotBefore)
+		for _, name := range names {
+			if err := ca.checkProfilePolicies(issuer, name); err != nil {
+				return err
+			}
+			p := issuer.signingProfile(name)
+			urls := append([]string{p.OCSP, p.CRL}, p.IssuerURL...)
+			for _, u := range urls {
+				if u != "" && !validHTTPURL(u) {
+					return fmt.Errorf("profile %q has invalid URL %q", name, u)
+				}
+			}
+			if validity := issuer.profileValidity(name); validity > issuerLifetime {
+				return fmt.Errorf("profile %q has a validity period of %s, longer than that of issuer %q",
+					name, validity, issuer.cert.Subject.CommonName)
+			}
+		}
+	}
+	return nil
+}
+
+// validOID returns true if oid can be DER encoded per X.690: it has at least
+// two arcs, the first arc is 0, 1, or 2, the second arc is below 40 unless
+// the first is 2, and no arc is negative.
+func validOID(oid asn1.ObjectIdentifier) bool {
+	if len(oid) < 2 || oid[0] < 0 || oid[0] > 2 || (oid[0] < 2 && oid[1] >= 40) {
+		return false
+	}
+	for _, arc := range oid {
+		if arc < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// GenerateOCSP produces a new OCSP response and returns it
+func (ca *CertificateAuthorityImpl) GenerateOCSP(ctx context.Context, xferObj core.OCSPSigningRequest) (ocspResponse []byte, err error) {
+	logEvent := ocspSigningEvent{
+		Status: xferObj.Status,
+		Reason: xferObj.Reason,
+	}
+	// No matter what, log the decision
+	defer func() {
+		result := "signed"
+		if err != nil {
+			result = "error"
+			logEvent.Error = err.Error()
+		}
+		ca.log.AuditObject(fmt.Sprintf("OCSP signing - %s", result), logEvent)
+	}()
+
+	switch core.OCSPStatus(xferObj.Status) {
+	case core.OCSPStatusGood, core.OCSPStatusUnknown:
+		if xferObj.Reason != revocation.Unspecified {
+			return nil, berrors.MalformedError(
+				"revocation reason %d is not allowed for status %q", xferObj.Reason, xferObj.Status)
+		}
+	case core.OCSPStatusRevoked:
+		if !ocspRevocationReasons[xferObj.Reason] {
+			return nil, berrors.MalformedError("revocation reason %d is not permitted", xferObj.Reason)
+		}
+	}
+
+	if xferObj.Nonce != nil && (len(xferObj.Nonce) == 0 || len(xferObj.Nonce) > maxOCSPNonceLength) {
+		return nil, berrors.MalformedError(
+			"OCSP nonce must be between 1 and %d bytes, not %d", maxOCSPNonceLength, len(xferObj.Nonce))
+	}
+
+	if !xferObj.ProducedAt.IsZero() && xferObj.ProducedAt.After(ca.clk.Now()) {
+		return nil, berrors.MalformedError("requested producedAt %s is in the future", xferObj.ProducedAt)
+	}
+
+	if core.OCSPStatus(xferObj.Status) == core.OCSPStatusUnknown {
+		return ca.generateUnknownOCSP(ctx, xferObj, &logEvent)
+	}
+
+	cert, err := x509.ParseCertificate(xferObj.CertDER)
+	if err != nil {
+		ca.log.AuditErr(err.Error())
+		return nil, err
+	}
+	logEvent.SerialNumber = core.SerialToString(cert.SerialNumber)
+	logEvent.Issuer = cert.Issuer.CommonName
+
+	if ca.ocspPrefixes != nil {
+		serialBytes := cert.SerialNumber.Bytes()
+		if len(serialBytes) == 0 || !ca.ocspPrefixes[serialBytes[0]] {
+			return nil, berrors.MalformedError(
+				"serial %s does not have a prefix this CA signs OCSP for",
+				logEvent.SerialNumber)
+		}
+	}
+
+	if ca.ocspStatusFromSA {
+		status, err := ca.SA.GetCertificateStatus(ctx, logEvent.SerialNumber)
+		if err != nil {
+			return nil, berrors.InternalServerError(
+				"failed to look up status for serial %s: %s", logEvent.SerialNumber, err)
+		}
+		// An empty status means the SA has no record of this certificate yet,
+		// which is expected when signing the initial OCSP response at issuance.
+		if status.Status == core.OCSPStatusRevoked {
+			xferObj.Status = string(status.Status)
+			xferObj.Reason = status.RevokedReason
+			xferObj.RevokedAt = status.RevokedDate
+			logEvent.Status = xferObj.Status
+			logEvent.Reason = xferObj.Reason
+		}
+	}
+
+	cn := cert.Issuer.CommonName
+	issuer := ca.issuerFor(cert)
+	if issuer == nil && len(cert.AuthorityKeyId) > 0 {
+		return nil, fmt.Errorf("This CA doesn't have an issuer cert with CommonName %q "+
+			"and a subject key ID matching the authority key ID %x", cn, cert.AuthorityKeyId)
+	}
+	if issuer == nil {
+		return nil, fmt.Errorf("This CA doesn't have an issuer cert with CommonName %q", cn)
+	}
+
+	err = cert.CheckSignatureFrom(issuer.cert)
+	if err != nil {
+		return nil, fmt.Errorf("GenerateOCSP was asked to sign OCSP for cert "+
+			"%s from %q, but the cert's signature was not valid: %s.",
+			core.SerialToString(cert.SerialNumber), cn, err)
+	}
+
+	status, ok := cfocsp.StatusCode[xferObj.Status]
+	if !ok {
+		return nil, cferr.New(cferr.OCSPError, cferr.InvalidStatus)
+	}
+	// Match cfssl's rounding of thisUpdate to the hour
+	thisUpdate := ca.ocspProducedAt(xferObj).Truncate(time.Hour)
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: cert.SerialNumber,
+		ThisUpdate:   thisUpdate,
+		NextUpdate:   ca.ocspNextUpdate(thisUpdate, cert.NotAfter),
+		ProducedAt:   xferObj.ProducedAt,
+	}
+	if status == ocsp.Revoked {
+		template.RevokedAt = xferObj.RevokedAt
+		template.RevocationReason = int(xferObj.Reason)
+	}
+
+	// Responses with a nonce or an explicit producedAt are specific to one
+	// request, so only others can be shared.
+	if ca.ocspCache == nil || xferObj.Nonce != nil || !xferObj.ProducedAt.IsZero() {
+		return ca.signOCSP(ctx, issuer, template, xferObj.Nonce)
+	}
+	key := ocspCacheKey{
+		issuer:    issuer.id(),
+		serial:    logEvent.SerialNumber,
+		status:    xferObj.Status,
+		reason:    xferObj.Reason,
+		revokedAt: xferObj.RevokedAt,
+	}
+	if cached := ca.ocspCache.get(key, ca.clk.Now()); cached != nil {
+		ca.stats.Inc(metricOCSPCacheHits, 1)
+		return cached, nil
+	}
+	ca.stats.Inc(metricOCSPCacheMisses, 1)
+	ocspResponse, err = ca.signOCSP(ctx, issuer, template, nil)
+	if err != nil {
+		return nil, err
+	}
+	ca.ocspCache.add(key, ocspResponse, ca.clk.Now())
+	return ocspResponse, nil
+}
+
+// issuerFor returns the issuer of cert, or nil if the CA doesn't have it. The
+// issuer is found by cert's authority key ID and issuer name, so that
+// certificates from a retired key get the issuer with that key even if
+// another has taken over its name. Only certificates without an authority key
+// ID fall back to the issuer with the name.
+func (ca *CertificateAuthorityImpl) issuerFor(cert *x509.Certificate) *internalIssuer {
+	if len(cert.AuthorityKeyId) > 0 {
+		return ca.issuersByID[issuerID{keyID: string(cert.AuthorityKeyId), cn: cert.Issuer.CommonName}]
+	}
+	return ca.issuers[cert.Issuer.CommonName]
+}
+
+// issuerByID returns the issuer with the given common name and subject key
+// ID, or a Malformed error if the CA doesn't have it.
+func (ca *CertificateAuthorityImpl) issuerByID(name string, keyID []byte) (*internalIssuer, error) {
+	issuer := ca.issuersByID[issuerID{keyID: string(keyID), cn: name}]
+	if issuer == nil {
+		return nil, berrors.MalformedError("this CA has no issuer cert with CommonName %q and subject key ID %x", name, keyID)
+	}
+	return issuer, nil
+}
+
+// generateUnknownOCSP signs an OCSP response with status unknown [RFC6960
+// 2.2], for a certificate the CA has no record of. The certificate, if given,
+// only supplies the serial and isn't required to have been issued by this CA.
+// The response is signed by the certificate's issuer if the CA has it, and by
+// the default issuer otherwise.
+func (ca *CertificateAuthorityImpl) generateUnknownOCSP(ctx context.Context, xferObj core.OCSPSigningRequest, logEvent *ocspSigningEvent) ([]byte, error) {
+	serial := xferObj.Serial
+	issuer := ca.defaultIssuer
+	if len(xferObj.CertDER) > 0 {
+		cert, err := x509.ParseCertificate(xferObj.CertDER)
+		if err != nil {
+			ca.log.AuditErr(err.Error())
+			return nil, err
+		}
+		serial = cert.SerialNumber
+		if known := ca.issuerFor(cert); known != nil {
+			issuer = known
+		}
+	}
+	if serial == nil {
+		return nil, berrors.MalformedError("OCSP signing request has neither a certificate nor a serial")
+	}
+	logEvent.SerialNumber = core.SerialToString(serial)
+	logEvent.Issuer = issuer.cert.Subject.CommonName
+
+	// There's no certificate lifetime to take a fraction of, so the
+	// nextUpdate is always the fixed lifespan.
+	thisUpdate := ca.ocspProducedAt(xferObj).Truncate(time.Hour)
+	return ca.signOCSP(ctx, issuer, ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: serial,
+		ThisUpdate:   thisUpdate,
+		NextUpdate:   thisUpdate.Add(ca.lifespanOCSP),
+		ProducedAt:   xferObj.ProducedAt,
+	}, xferObj.Nonce)
+}
+
+// ocspProducedAt returns the time the OCSP response requested by xferObj is
+// produced at, from which its thisUpdate and nextUpdate are computed:
+// xferObj.ProducedAt if it is set, or the current time.
+func (ca *CertificateAuthorityImpl) ocspProducedAt(xferObj core.OCSPSigningRequest) time.Time {
+	if !xferObj.ProducedAt.IsZero() {
+		return xferObj.ProducedAt
+	}
+	return ca.clk.Now()
+}
+
+// signOCSP signs the OCSP response described by template with issuer's key,
+// adding nonce to it if non-nil. The response is produced at
+// template.ProducedAt if that is set, and at the current time otherwise.
+func (ca *CertificateAuthorityImpl) signOCSP(ctx context.Context, issuer *internalIssuer, template ocsp.Response, nonce []byte) ([]byte, error) {
+	if err := ca.acquireSigningSlot(ctx); err != nil {
+		return nil, err
+	}
+	if issuer.ocspDelegated() {
+		template.Certificate = issuer.ocspCert
+	}
+	template.SignatureAlgorithm = issuer.ocspSigAlg
+	ocspResponse, err := ocsp.CreateResponse(issuer.cert, issuer.ocspCert, template, issuer.ocspKey)
+	if err == nil && (nonce != nil || !template.ProducedAt.IsZero()) {
+		ocspResponse, err = amendOCSPResponse(ocspResponse, template.ProducedAt, nonce, issuer.ocspKey)
+	}
+	ca.releaseSigningSlot()
+	ca.noteSignError(err)
+	if err == nil {
+		ca.stats.Inc("Signatures.OCSP", 1)
+	}
+	return ocspResponse, err
+}
+
+// RegenerateOCSPBatch signs fresh OCSP responses for the certificates with
+// the given serials, fetching each certificate and its current status from
+// the SA, so that callers like the OCSP updater needn't look them up first.
+// Each response is passed to emit as soon as it has been signed. Serials the
+// SA has no certificate for are skipped and returned. Any other error, or an
+// error returned by emit, stops the batch and is returned.
+func (ca *CertificateAuthorityImpl) RegenerateOCSPBatch(
+	ctx context.Context,
+	serials []string,
+	emit func(serial string, response []byte) error,
+) (notFound []string, err error) {
+	for _, serial := range serials {
+		cert, err := ca.SA.GetCertificate(ctx, serial)
+		if berrors.Is(err, berrors.NotFound) {
+			notFound = append(notFound, serial)
+			continue
+		}
+		if err != nil {
+			return notFound, berrors.InternalServerError(
+				"failed to look up certificate for serial %s: %s", serial, err)
+		}
+		status, err := ca.SA.GetCertificateStatus(ctx, serial)
+		if err != nil {
+			return notFound, berrors.InternalServerError(
+				"failed to look up status for serial %s: %s", serial, err)
+		}
+		req := core.OCSPSigningRequest{
+			CertDER: cert.DER,
+			Status:  string(core.OCSPStatusGood),
+		}
+		if status.Status == core.OCSPStatusRevoked {
+			req.Status = string(status.Status)
+			req.Reason = status.RevokedReason
+			req.RevokedAt = status.RevokedDate
+		}
+		response, err := ca.GenerateOCSP(ctx, req)
+		if err != nil {
+			return notFound, err
+		}
+		if err := emit(serial, response); err != nil {
+			return notFound, err
+		}
+	}
+	return notFound, nil
+}
+
+// GenerateRevokedOCSPBatch signs revoked OCSP responses with the same reason
+// and revocation time for many serials at once, for use during a mass
+// revocation. The serials must all have been issued by the issuer with the
+// given common name and subject key ID, which tells apart a retired key from
+// its successor under the same name. Unlike GenerateOCSP it works from serials alone, so the
+// certificates needn't be fetched first. Each response is passed to emit as
+// soon as it has been signed. If emit returns an error, no further responses
+// are signed and that error is returned.
+func (ca *CertificateAuthorityImpl) GenerateRevokedOCSPBatch(
+	ctx context.Context,
+	issuerName string,
+	issuerKeyID []byte,
+	serials []*big.Int,
+	reason revocation.Reason,
+	revokedAt time.Time,
+	emit func(serial *big.Int, response []byte) error,
+) error {
+	if !ocspRevocationReasons[reason] {
+		return berrors.MalformedError("revocation reason %d is not permitted", reason)
+	}
+	issuer, err := ca.issuerByID(issuerName, issuerKeyID)
+	if err != nil {
+		return err
+	}
+	for _, serial := range serials {
+		if ca.ocspPrefixes != nil {
+			serialBytes := serial.Bytes()
+			if len(serialBytes) == 0 || !ca.ocspPrefixes[serialBytes[0]] {
+				return berrors.MalformedError(
+					"serial %s does not have a prefix this CA signs OCSP for",
+					core.SerialToString(serial))
+			}
+		}
+	}
+
+	ca.log.AuditInfo(fmt.Sprintf("Batch OCSP revocation: issuer=[%s] count=[%d] reason=[%d] revokedAt=[%s]",
+		issuerName, len(serials), reason, revokedAt))
+
+	// Match cfssl's rounding of thisUpdate to the hour
+	thisUpdate := ca.clk.Now().Truncate(time.Hour)
+	template := ocsp.Response{
+		Status:           ocsp.Revoked,
+		RevokedAt:        revokedAt,
+		RevocationReason: int(reason),
+		ThisUpdate:       thisUpdate,
+		NextUpdate:       thisUpdate.Add(ca.lifespanOCSP),
+	}
+	if issuer.ocspDelegated() {
+		template.Certificate = issuer.ocspCert
+	}
+	template.SignatureAlgorithm = issuer.ocspSigAlg
+	for _, serial := range serials {
+		if err := ctx.Err(); err != nil {
+			return berrors.InternalServerError("batch OCSP revocation interrupted: %s", err)
+		}
+		if err := ca.acquireSigningSlot(ctx); err != nil {
+			return err
+		}
+		template.SerialNumber = serial
+		response, err := ocsp.CreateResponse(issuer.cert, issuer.ocspCert, template, issuer.ocspKey)
+		ca.releaseSigningSlot()
+		ca.noteSignError(err)
+		if err != nil {
+			return berrors.InternalServerError(
+				"failed to sign OCSP for serial %s: %s", core.SerialToString(serial), err)
+		}
+		ca.stats.Inc("Signatures.OCSP", 1)
+		if err := emit(serial, response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// issuancePlan holds the decisions made about a CSR before it is signed.
+type issuancePlan struct {
+	issuer     *internalIssuer
+	profile    string
+	extensions []signer.Extension
+	validity   time.Duration
+	// names are the names the certificate is issued to: the CSR's DNS names,
+	// or its email addresses for an email profile.
+	names []string
+	// explicitCN is true if the CSR requested a CN itself, rather than having
+	// one taken from its SANs or going without.
+	explicitCN bool
+	// notBefore and notAfter are set when the CA fixes the certificate's
+	// validity itself rather than leaving it to cfssl.
+	notBefore time.Time
+	notAfter  time.Time
+	// clampedByIssuer is true if notAfter was brought forward to fit within
+	// the issuer's validity.
+	clampedByIssuer bool
+	// sctList, if non-nil, is the SCT list extension to embed in a final
+	// certificate.
+	sctList *signer.Extension
+}
+
+// checkCSRSize returns a Malformed error if csr is larger, or requests more
+// extensions, than the CA's configured limits. CSRs arriving over gRPC have
+// their size checked before they're parsed as well.
+func (ca *CertificateAuthorityImpl) checkCSRSize(csr *x509.CertificateRequest) error {
+	if ca.maxCSRBytes > 0 && len(csr.Raw) > ca.maxCSRBytes {
+		return berrors.WithReason(
+			berrors.MalformedError("CSR is %d bytes, more than the maximum of %d", len(csr.Raw), ca.maxCSRBytes),
+			berrors.CSRTooLarge)
+	}
+	if ca.maxCSRExtensions > 0 {
+		count := 0
+		for _, attr := range csr.Attributes {
+			if !attr.Type.Equal(oidExtensionRequest) {
+				continue
+			}
+			for _, extList := range attr.Value {
+				count += len(extList)
+			}
+		}
+		if count > ca.maxCSRExtensions {
+			return berrors.WithReason(
+				berrors.MalformedError("CSR requests %d extensions, more than the maximum of %d", count, ca.maxCSRExtensions),
+				berrors.CSRTooLarge)
+		}
+	}
+	return nil
+}
+
+// checkSubjectAttributes returns a Malformed error if subject requests any
+// attribute other than the CN and those permitted by the CA's configuration.
+// Attributes the CA has no short name for are never permitted.
+func (ca *CertificateAuthorityImpl) checkSubjectAttributes(subject pkix.Name) error {
+	for _, atv := range subject.Names {
+		if atv.Type.Equal(oidCommonName) {
+			continue
+		}
+		name, ok := subjectAttributeNames[atv.Type.String()]
+		if !ok {
+			name = atv.Type.String()
+		}
+		if !ca.permittedSubjectAttributes[name] {
+			return berrors.WithReason(
+				berrors.MalformedError("CSR requests disallowed subject attribute %s", name),
+				berrors.CSRSubjectAttribute)
+		}
+	}
+	return nil
+}
+
+// profileForKey selects the CFSSL profile used to sign a certificate for key,
+// using the fallback profile when no profile is configured for its type. If
+// there is no fallback profile either and useDefaultProfile is set, it returns
+// the empty name, which selects CFSSL's Default profile. CFSSL can only sign
+// certificates for RSA and ECDSA keys, so no profile, fallback or otherwise,
+// can handle any other key type.
+func (ca *CertificateAuthorityImpl) profileForKey(key crypto.PublicKey) (string, error) {
+	var profile string
+	switch key.(type) {
+	case *rsa.PublicKey:
+		profile = ca.rsaProfile
+	case *ecdsa.PublicKey:
+		profile = ca.ecdsaProfile
+	default:
+		return "", berrors.WithReason(
+			berrors.MalformedError("no signing profile supports key type %T", key),
+			berrors.BadCSRPublicKey)
+	}
+	if profile == "" {
+		profile = ca.fallbackProfile
+	}
+	if profile == "" && !ca.useDefaultProfile {
+		return "", berrors.WithReason(
+			berrors.MalformedError("no signing profile configured for key type %T", key),
+			berrors.BadCSRPublicKey)
+	}
+	return profile, nil
+}
+
+// checkDefaultProfile returns an error unless p, CFSSL's Default signing
+// profile, is safe to sign keys without a named profile with: it must not be a
+// CA profile, must sign with the CA's serial numbers and only what it
+// whitelists from the CSR, and must request at least one specific extended
+// key usage and no certificate or CRL signing.
+func checkDefaultProfile(p *cfsslConfig.SigningProfile) error {
+	if p == nil {
+		return errors.New("useDefaultProfile requires a default signing profile")
+	}
+	if p.CAConstraint.IsCA {
+		return errors.New("default signing profile issues CA certificates")
+	}
+	if !p.ClientProvidesSerialNumbers {
+		return errors.New("default signing profile doesn't use the CA's serial numbers")
+	}
+	if p.CSRWhitelist == nil {
+		return errors.New("default signing profile has no CSR whitelist")
+	}
+	ku, ekus, unknown := p.Usages()
+	if len(unknown) > 0 {
+		return fmt.Errorf("default signing profile has unknown usages: %s", strings.Join(unknown, ", "))
+	}
+	if ku&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+		return errors.New("default signing profile requests cert sign or CRL sign key usage")
+	}
+	if len(ekus) == 0 {
+		return errors.New("default signing profile requests no extended key usages")
+	}
+	for _, eku := range ekus {
+		if eku == x509.ExtKeyUsageAny {
+			return errors.New("default signing profile requests anyExtendedKeyUsage")
+		}
+	}
+	return nil
+}
+
+// IssueOptions adjusts how IssueCertificateWithOptions issues a certificate.
+// The zero value issues certificates exactly as IssueCertificate does.
+type IssueOptions struct {
+	// Validity, if non-zero, requests a certificate valid for that long
+	// instead of for the full length of the profile.
+	Validity time.Duration
+	// Profile, if non-empty, names the profile to sign with instead of
+	// selecting one by the CSR's key type.
+	Profile string
+	// NotBefore, if non-zero, is used as the time of issuance instead of the
+	// CA's clock, e.g. to reissue certificates after an outage or to produce
+	// deterministic test vectors. The certificate is backdated from it as
+	// usual. It must not be in the future, and the certificate must still not
+	// outlive the issuer.
+	NotBefore time.Time
+	// Serial, if non-nil, is used as the certificate's serial instead of a
+	// randomly generated one, e.g. to reproduce a certificate. It is only
+	// accepted if the CA is configured for deterministic issuance, and must be
+	// positive, at most 20 octets long, and start with the CA's serial prefix.
+	Serial *big.Int
+	// SCTs, if non-nil, makes the certificate the final certificate for a
+	// precertificate that was submitted to CT logs, and holds the serialized
+	// SCTs the logs returned, which are embedded in it. At least MinSCTs must
+	// be given.
+	SCTs [][]byte
+}
+
+// issuerForKey returns the first issuer, in order of preference, that is
+// active for issuing certificates for key's type at the current time.
+func (ca *CertificateAuthorityImpl) issuerForKey(key crypto.PublicKey) (*internalIssuer, error) {
+	var keyType string
+	switch key.(type) {
+	case *rsa.PublicKey:
+		keyType = "RSA"
+	case *ecdsa.PublicKey:
+		keyType = "ECDSA"
+	}
+	now := ca.clk.Now()
+	for _, issuer := range ca.issuanceOrder {
+		if len(issuer.activeFrom) == 0 {
+			return issuer, nil
+		}
+		if from, ok := issuer.activeFrom[keyType]; ok && !now.Before(from) {
+			return issuer, nil
+		}
+	}
+	return nil, berrors.InternalServerError("no issuer is active for %T keys", key)
+}
+
+// checkProfileForKey returns a Malformed error unless profile is one the CA
+// could select for key: its type-specific profile or the fallback profile.
+// Email profiles are never selected by key type, and are accepted for any key
+// type that some profile supports.
+func (ca *CertificateAuthorityImpl) checkProfileForKey(profile string, key crypto.PublicKey) error {
+	selected, err := ca.profileForKey(key)
+	if err != nil {
+		return err
+	}
+	if profile != selected && profile != ca.fallbackProfile && !ca.profileConfigs[profile].EmailProfile {
+		return berrors.WithReason(
+			berrors.MalformedError("signing profile %q is not compatible with key type %T", profile, key),
+			berrors.IncompatibleProfile)
+	}
+	return nil
+}
+
+// planIssuanceSafely calls planIssuance, recovering from any panic while the
+// CSR is checked and its extensions processed. Since the CSR is attacker
+// controlled, a panic is reported and the CSR rejected as malformed rather
+// than letting one request crash the CA.
+func (ca *CertificateAuthorityImpl) planIssuanceSafely(csr *x509.CertificateRequest, regID int64, opts IssueOptions) (plan issuancePlan, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ca.stats.Inc(metricCSRPanics, 1)
+			ca.log.AuditErr(fmt.Sprintf("Panic while processing CSR: csr=[%s] panic=[%v] stack=[%s]",
+				core.Fingerprint256(csr.Raw), r, debug.Stack()))
+			plan = issuancePlan{issuer: ca.defaultIssuer}
+			err = berrors.MalformedError("unable to process CSR")
+		}
+	}()
+	return ca.planIssuance(csr, regID, opts)
+}
+
+// planIssuance makes every check on csr that can be made without signing
+// anything or consuming a serial number, normalizing csr in the process. It
+// returns the plan for issuing it, which is partially filled in on error. A
+// non-zero opts.Validity shortens the profile's validity period, and a
+// non-empty opts.Profile is used instead of selecting one by key type. If
+// opts.Profile is an email profile, csr must request email addresses instead
+// of DNS names.
+func (ca *CertificateAuthorityImpl) planIssuance(csr *x509.CertificateRequest, regID int64, opts IssueOptions) (issuancePlan, error) {
+	plan := issuancePlan{issuer: ca.defaultIssuer}
+	plan.explicitCN = csr.Subject.CommonName != ""
+	email := opts.Profile != "" && ca.profileConfigs[opts.Profile].EmailProfile
+
+	issuedAt := ca.clk.Now()
+	if !opts.NotBefore.IsZero() {
+		if opts.NotBefore.After(issuedAt) {
+			err := berrors.WithReason(
+				berrors.MalformedError("requested NotBefore %s is in the future", opts.NotBefore),
+				berrors.InvalidValidity)
+			ca.log.AuditErr(err.Error())
+			return plan, err
+		}
+		issuedAt = opts.NotBefore
+	}
+	if opts.Serial != nil {
+		if err := ca.checkRequestedSerial(opts.Serial); err != nil {
+			ca.log.AuditErr(err.Error())
+			return plan, err
+		}
+	}
+	if opts.SCTs != nil {
+		var err error
+		plan.sctList, err = ca.sctListExtension(opts.SCTs)
+		if err != nil {
+			ca.log.AuditErr(err.Error())
+			return plan, err
+		}
+	}
+
+	if err := ca.checkCSRSize(csr); err != nil {
+		ca.log.AuditErr(err.Error())
+		return plan, err
+	}
+
+	// Issuing a leaf for an issuer's own key would be a serious mis-issuance,
+	// whatever else the CSR contains
+	if cn, ok := ca.issuerKeys[sha256.Sum256(csr.RawSubjectPublicKeyInfo)]; ok {
+		err := berrors.WithReason(
+			berrors.MalformedError("CSR public key is the key of issuer %q", cn),
+			berrors.BadCSRPublicKey)
+		ca.log.AuditErr(err.Error())
+		return plan, err
+	}
+
+	if err := ca.transformNames(csr); err != nil {
+		ca.log.AuditErr(err.Error())
+		return plan, err
+	}
+
+	if ca.rejectDisallowedSubjectAttributes {
+		if err := ca.checkSubjectAttributes(csr.Subject); err != nil {
+			ca.log.AuditErr(err.Error())
+			return plan, err
+		}
+	}
+
+	// Wildcards are checked before a wildcard CN can be added to the SANs.
+	if ca.rejectMalformedWildcards && !email {
+		if err := csrlib.CheckWildcards(csr); err != nil {
+			ca.log.AuditErr(err.Error())
+			return plan, err
+		}
+	}
+
+	// A CN that isn't among the SANs would otherwise be silently added to
+	// them. Both are lowercased before they're compared, and the SANs are
+	// deduplicated afterwards, so names differing only in case are the same.
+	if (!ca.forceCNFromSAN || ca.requireCNInSANs) && !email {
+		if err := csrlib.CheckCNInSANs(csr); err != nil {
+			ca.log.AuditErr(err.Error())
+			return plan, berrors.WithReason(berrors.MalformedError("%s", err), berrors.ReasonOf(err))
+		}
+	}
+
+	// VerifyCSR rejects keys on curves the key policy doesn't allow, but they
+	// are counted per curve here so we can see which curves clients try.
+	if key, ok := csr.PublicKey.(*ecdsa.PublicKey); ok && !ca.keyPolicy.AllowedCurve(key.Curve) {
+		ca.stats.Inc(fmt.Sprintf("%s.%s", metricCSRKeyCurveRejected, goodkey.CurveName(key.Curve)), 1)
+	}
 
-	if err := csrlib.VerifyCSR(
-		&csr,
-		ca.maxNames,
-		&ca.keyPolicy,
-		ca.PA,
-		ca.forceCNFromSAN,
-		regID,
-	); err != nil {
+	var err error
+	if email {
+		err = csrlib.VerifyEmailCSR(csr, ca.maxNames, &ca.keyPolicy, ca.forceCNFromSAN)
+		plan.names = csr.EmailAddresses
+	} else {
+		err = csrlib.VerifyCSR(
+			csr,
+			ca.maxNames,
+			&ca.keyPolicy,
+			ca.PA,
+			ca.forceCNFromSAN,
+			ca.cnStrategy,
+			ca.omitLongCN,
+			regID,
+		)
+		plan.names = csr.DNSNames
+	}
+	if err != nil {
 		ca.log.AuditErr(err.Error())
-		return emptyCert, berrors.MalformedError(err.Error())
+		return plan, berrors.WithReason(berrors.MalformedError("%s", err), berrors.ReasonOf(err))
 	}
 
-	requestedExtensions, err := ca.extensionsFromCSR(&csr)
+	if opts.Profile != "" {
+		err = ca.checkProfileForKey(opts.Profile, csr.PublicKey)
+		plan.profile = opts.Profile
+	} else {
+		plan.profile, err = ca.profileForKey(csr.PublicKey)
+	}
 	if err != nil {
-		return emptyCert, err
+		ca.log.AuditErr(err.Error())
+		return plan, err
 	}
 
-	issuer := ca.defaultIssuer
-	notAfter := ca.clk.Now().Add(ca.validityPeriod)
+	// A profile mapped to an issuer is always signed by it, and any other by
+	// the first issuer active for the key's type.
+	issuer := ca.issuers[ca.profileConfigs[plan.profile].Issuer]
+	if issuer == nil {
+		issuer, err = ca.issuerForKey(csr.PublicKey)
+		if err != nil {
+			ca.log.AuditErr(err.Error())
+			return plan, err
+		}
+	}
+	plan.issuer = issuer
 
-	if issuer.cert.NotAfter.Before(notAfter) {
-		err = berrors.InternalServerError("cannot issue a certificate that expires after the issuer certificate")
+	// IP addresses are never issued, so only the DNS name constraints apply
+	if ca.enforceNameConstraints && !email {
+		if err := checkNameConstraints(issuer.cert, plan.names); err != nil {
+			ca.log.AuditErr(err.Error())
+			return plan, err
+		}
+	}
+
+	plan.extensions, err = ca.extensionsFromCSR(csr, plan.issuer, plan.profile)
+	if err != nil {
+		if berrors.Is(err, berrors.Malformed) {
+			err = berrors.WithReason(err, berrors.CSRExtension)
+		}
+		return plan, err
+	}
+	issuer, profile := plan.issuer, plan.profile
+
+	// The certificate's validity comes from the selected profile, and it must
+	// not outlive the issuer that will actually sign it. Profiles with a
+	// NotAfter boundary are instead cut short to fit within the issuer.
+	profileConfig := ca.profileConfigs[profile]
+	plan.validity = issuer.profileValidity(profile)
+	if issuer.cert.NotAfter.Sub(issuedAt) < plan.validity {
+		ca.warnIssuerExpiry(issuer, profile, issuedAt)
+	}
+	shortened := false
+	if opts.Validity != 0 {
+		if opts.Validity <= 0 || opts.Validity < ca.minRequestedValidity {
+			err = berrors.WithReason(berrors.MalformedError(
+				"requested validity period %s is shorter than the minimum of %s",
+				opts.Validity, ca.minRequestedValidity), berrors.InvalidValidity)
+			ca.log.AuditErr(err.Error())
+			return plan, err
+		}
+		if opts.Validity < plan.validity {
+			plan.validity = opts.Validity
+			shortened = true
+		}
+	}
+	boundary := profileConfig.NotAfterBoundary.Duration
+	if boundary == 0 {
+		if err := ca.checkIssuerValidity(issuer, issuedAt, plan.validity); err != nil {
+			ca.log.AuditErr(err.Error())
+			return plan, err
+		}
+	}
+	if err := ca.checkProfileUsages(issuer, profile); err != nil {
 		ca.log.AuditErr(err.Error())
-		return emptyCert, err
+		return plan, err
+	}
+	if err := ca.checkProfileExtKeyUsages(issuer, profile); err != nil {
+		ca.log.AuditErr(err.Error())
+		return plan, err
+	}
+	if err := ca.checkProfileOCSPNoCheck(issuer, profile); err != nil {
+		ca.log.AuditErr(err.Error())
+		return plan, err
+	}
+	if err := ca.checkProfilePolicies(issuer, profile); err != nil {
+		ca.log.AuditErr(err.Error())
+		return plan, err
+	}
+	if profileConfig.CheckKeyUsageCompatibility {
+		if err := ca.checkKeyUsageCompatibility(issuer, profile, csr.PublicKey); err != nil {
+			ca.log.AuditErr(err.Error())
+			return plan, err
+		}
 	}
 
-	// Convert the CSR to PEM
-	csrPEM := string(pem.EncodeToMemory(&pem.Block{
-		Type:  "CERTIFICATE REQUEST",
-		Bytes: csr.Raw,
-	}))
+	backdate := issuer.profileBackdate(profile)
+	if ca.deterministic || boundary > 0 || shortened || !opts.NotBefore.IsZero() {
+		plan.notBefore = issuedAt.UTC().Truncate(time.Second).Add(-backdate)
+		plan.notAfter = plan.notBefore.Add(plan.validity)
+		if boundary > 0 {
+			plan.notAfter, plan.clampedByIssuer, err = alignNotAfter(plan.notBefore, plan.notAfter, issuer.cert.NotAfter, boundary)
+			if err != nil {
+				ca.log.AuditErr(err.Error())
+				return plan, err
+			}
+			plan.validity = plan.notAfter.Sub(plan.notBefore)
+		}
+	}
+	if err := ca.checkValidityPeriod(plan.validity, backdate); err != nil {
+		ca.log.AuditErr(err.Error())
+		return plan, err
+	}
+	return plan, nil
+}
 
-	// We want 136 bits of random number, plus an 8-bit instance id prefix.
-	const randBits = 136
-	serialBytes := make([]byte, randBits/8+1)
-	serialBytes[0] = byte(ca.prefix)
-	_, err = rand.Read(serialBytes[1:])
+// checkValidityPeriod returns an error if a certificate valid for validity,
+// with a notBefore backdate before the time of issuance, would be shorter
+// than the CA's minimum validity period or expire by the time it is issued.
+func (ca *CertificateAuthorityImpl) checkValidityPeriod(validity, backdate time.Duration) error {
+	if validity <= backdate {
+		return berrors.InternalServerError(
+			"certificate validity period %s doesn't extend past its backdate of %s", validity, backdate)
+	}
+	if validity < ca.minCertificateValidity {
+		return berrors.InternalServerError(
+			"certificate validity period %s is shorter than the minimum of %s", validity, ca.minCertificateValidity)
+	}
+	return nil
+}
+
+// ValidateCSR makes the same checks on csr that IssueCertificate would, and
+// returns the same error IssueCertificate would if it rejected it. It doesn't
+// sign anything, consume a serial number, or store a certificate.
+func (ca *CertificateAuthorityImpl) ValidateCSR(ctx context.Context, csr x509.CertificateRequest, regID int64) error {
+	_, err := ca.planIssuance(&csr, regID, IssueOptions{})
+	return err
+}
+
+// IssueCertificate attempts to convert a CSR into a signed Certificate, while
+// enforcing all policies. Names (domains) in the CertificateRequest will be
+// lowercased before storage. The returned Certificate carries the DER and its
+// digest, in the form the SA stores it.
+// It signs with the first issuer active for the CSR's key type, which unless
+// issuers are restricted with ActiveFrom is the defaultIssuer.
+func (ca *CertificateAuthorityImpl) IssueCertificate(ctx context.Context, csr x509.CertificateRequest, regID int64) (core.Certificate, error) {
+	return ca.IssueCertificateWithOptions(ctx, csr, regID, IssueOptions{})
+}
+
+// IssueCertificateWithValidity is like IssueCertificate, but a non-zero
+// validity requests a certificate valid for that long instead of for the full
+// length of the profile. The request is rejected if validity is below the
+// configured minimum, and cut down to the profile's expiry if above it.
+func (ca *CertificateAuthorityImpl) IssueCertificateWithValidity(ctx context.Context, csr x509.CertificateRequest, regID int64, validity time.Duration) (core.Certificate, error) {
+	return ca.IssueCertificateWithOptions(ctx, csr, regID, IssueOptions{Validity: validity})
+}
+
+// IssueCertificateWithProfile is like IssueCertificate, but signs with the
+// named profile, already chosen by the caller, instead of selecting one by the
+// CSR's key type. The request is rejected if the profile isn't one the CA
+// could have selected for the key type itself.
+func (ca *CertificateAuthorityImpl) IssueCertificateWithProfile(ctx context.Context, csr x509.CertificateRequest, regID int64, profile string) (core.Certificate, error) {
+	return ca.IssueCertificateWithOptions(ctx, csr, regID, IssueOptions{Profile: profile})
+}
+
+// IssueCertificateWithOptions is like IssueCertificate, adjusted by opts.
+func (ca *CertificateAuthorityImpl) IssueCertificateWithOptions(ctx context.Context, csr x509.CertificateRequest, regID int64, opts IssueOptions) (cert core.Certificate, err error) {
+	emptyCert := core.Certificate{}
+
+	logEvent := issuanceEvent{
+		Requester: regID,
+		CSRDigest: core.Fingerprint256(csr.Raw),
+	}
+	var plan issuancePlan
+	// No matter what, log the decision
+	defer func() {
+		logEvent.Names = plan.names
+		if logEvent.Names == nil {
+			logEvent.Names = csr.DNSNames
+		}
+		result := "issued"
+		if err != nil {
+			result = "error"
+			logEvent.Error = err.Error()
+		}
+		ca.log.AuditObject(fmt.Sprintf("Certificate issuance - %s", result), logEvent)
+	}()
+
+	if err := ca.beginIssuance(); err != nil {
+		return emptyCert, err
+	}
+	defer ca.endIssuance()
+
+	logEvent.Issuer = ca.defaultIssuer.cert.Subject.CommonName
+	plan, err = ca.planIssuanceSafely(&csr, regID, opts)
+	logEvent.Profile = plan.profile
+	logEvent.Issuer = plan.issuer.cert.Subject.CommonName
 	if err != nil {
-		err = berrors.InternalServerError("failed to generate serial: %s", err)
-		ca.log.AuditErr(fmt.Sprintf("Serial randomness failed, err=[%v]", err))
 		return emptyCert, err
 	}
-	serialBigInt := big.NewInt(0)
-	serialBigInt = serialBigInt.SetBytes(serialBytes)
-	serialHex := core.SerialToString(serialBigInt)
+	issuer := plan.issuer
+	profile := plan.profile
 
-	var profile string
-	switch csr.PublicKey.(type) {
-	case *rsa.PublicKey:
-		profile = ca.rsaProfile
-	case *ecdsa.PublicKey:
-		profile = ca.ecdsaProfile
-	default:
-		err = berrors.InternalServerError("unsupported key type %T", csr.PublicKey)
+	// Issuances are counted as soon as they're reserved, so that concurrent
+	// requests can't together exceed the limits. Reservations are released
+	// if the certificate isn't signed after all.
+	releaseReg, err := ca.reserveIssuance(ctx, regID)
+	if err != nil {
+		ca.log.AuditErr(err.Error())
+		return emptyCert, err
+	}
+	releaseKey, err := ca.reserveKeyIssuance(ctx, csr.PublicKey)
+	if err != nil {
 		ca.log.AuditErr(err.Error())
+		releaseReg()
 		return emptyCert, err
 	}
+	counted := false
+	defer func() {
+		if !counted {
+			releaseReg()
+			releaseKey()
+		}
+	}()
+
+	serialBigInt := opts.Serial
+	if serialBigInt == nil {
+		serialBigInt, err = ca.generateSerial()
+		if err != nil {
+			ca.log.AuditErr(fmt.Sprintf("Serial randomness failed, err=[%v]", err))
+			return emptyCert, err
+		}
+	}
+	serialHex := core.SerialToString(serialBigInt)
+	logEvent.SerialNumber = serialHex
 
 	// Send the cert off for signing
-	req := signer.SignRequest{
-		Request: csrPEM,
-		Profile: profile,
-		Hosts:   csr.DNSNames,
-		Subject: &signer.Subject{
-			CN: csr.Subject.CommonName,
-		},
-		Serial:     serialBigInt,
-		Extensions: requestedExtensions,
+	req, adjust, err := ca.signRequest(&csr, regID, plan, serialBigInt)
+	if err != nil {
+		ca.log.AuditErr(fmt.Sprintf("Signing failed: serial=[%s] err=[%v]", serialHex, err))
+		return emptyCert, err
 	}
-	if !ca.forceCNFromSAN {
-		req.Subject.SerialNumber = serialHex
+
+	err = ca.auditInfoRequired(fmt.Sprintf("Signing: serial=[%s] names=[%s] csr=[%s]",
+		serialHex, strings.Join(plan.names, ", "), hex.EncodeToString(csr.Raw)))
+	if err != nil {
+		return emptyCert, err
 	}
 
-	ca.log.AuditInfo(fmt.Sprintf("Signing: serial=[%s] names=[%s] csr=[%s]",
-		serialHex, strings.Join(csr.DNSNames, ", "), hex.EncodeToString(csr.Raw)))
+	eeSigner := issuer.eeSigner
+	if adjust != nil {
+		eeSigner, err = issuer.eeSignerWithProfile(profile, adjust)
+		if err != nil {
+			err = berrors.InternalServerError("failed to create signer: %s", err)
+			ca.log.AuditErr(fmt.Sprintf("Signing failed: serial=[%s] err=[%v]", serialHex, err))
+			return emptyCert, err
+		}
+	}
 
-	certPEM, err := issuer.eeSigner.Sign(req)
+	if err := ca.acquireSigningSlot(ctx); err != nil {
+		ca.log.AuditErr(fmt.Sprintf("Signing failed: serial=[%s] err=[%v]", serialHex, err))
+		return emptyCert, err
+	}
+	certPEM, err := eeSigner.Sign(req)
+	ca.releaseSigningSlot()
 	ca.noteSignError(err)
 	if err != nil {
 		err = berrors.InternalServerError("failed to sign certificate: %s", err)
@@ -476,14 +2730,52 @@ func (ca *CertificateAuthorityImpl) IssueCertificate(ctx context.Context, csr x5
 		return emptyCert, err
 	}
 	certDER := block.Bytes
+	digest := core.Fingerprint256(certDER)
+	logEvent.CertDigest = digest
+
+	allowedEKUs := ca.profileConfigs[plan.profile].AllowedExtKeyUsages
+	if ca.rejectCSRBasicConstraints || len(allowedEKUs) > 0 {
+		parsedCert, err := x509.ParseCertificate(certDER)
+		if err != nil {
+			err = berrors.InternalServerError("failed to parse issued certificate: %s", err)
+			ca.log.AuditErr(fmt.Sprintf("Signing failed: serial=[%s] err=[%v]", serialHex, err))
+			return emptyCert, err
+		}
+		if ca.rejectCSRBasicConstraints &&
+			(parsedCert.IsCA || parsedCert.MaxPathLen > 0 || parsedCert.MaxPathLenZero) {
+			err = berrors.InternalServerError("issued certificate has CA basic constraints")
+			ca.log.AuditErr(fmt.Sprintf("Signing failed: serial=[%s] cert=[%s] err=[%v]",
+				serialHex, hex.EncodeToString(certDER), err))
+			return emptyCert, err
+		}
+		if len(allowedEKUs) > 0 && !extKeyUsagesAllowed(parsedCert, allowedEKUs) {
+			err = berrors.InternalServerError("issued certificate has an extended key usage its profile doesn't allow")
+			ca.log.AuditErr(fmt.Sprintf("Signing failed: serial=[%s] cert=[%s] err=[%v]",
+				serialHex, hex.EncodeToString(certDER), err))
+			return emptyCert, err
+		}
+	}
 
-	cert := core.Certificate{
-		DER: certDER,
+	cert = core.Certificate{
+		RegistrationID: regID,
+		Serial:         serialHex,
+		DER:            certDER,
+		Digest:         digest,
 	}
 
-	ca.log.AuditInfo(fmt.Sprintf("Signing success: serial=[%s] names=[%s] csr=[%s] cert=[%s]",
-		serialHex, strings.Join(csr.DNSNames, ", "), hex.EncodeToString(csr.Raw),
+	err = ca.auditInfoRequired(fmt.Sprintf("Signing success: serial=[%s] names=[%s] csr=[%s] cert=[%s]",
+		serialHex, strings.Join(plan.names, ", "), hex.EncodeToString(csr.Raw),
 		hex.EncodeToString(certDER)))
+	if err != nil {
+		// Without a record of the certificate it must not be stored or
+		// returned
+		ca.log.AuditErr(fmt.Sprintf("Discarding unlogged certificate: serial=[%s] err=[%v]", serialHex, err))
+		return emptyCert, err
+	}
+
+	// The certificate has been issued, so it counts towards the limits even
+	// if something below fails
+	counted = true
 
 	var ocspResp []byte
 	if features.Enabled(features.GenerateOCSPEarly) {
@@ -501,7 +2793,7 @@ func (ca *CertificateAuthorityImpl) IssueCertificate(ctx context.Context, csr x5
 	}
 
 	// Store the cert with the certificate authority, if provided
-	_, err = ca.SA.AddCertificate(ctx, certDER, regID, ocspResp)
+	storedDigest, err := ca.SA.AddCertificate(ctx, certDER, regID, ocspResp)
 	if err != nil {
 		err = berrors.InternalServerError(err.Error())
 		// Note: This log line is parsed by cmd/orphan-finder. If you make any
@@ -515,15 +2807,187 @@ func (ca *CertificateAuthorityImpl) IssueCertificate(ctx context.Context, csr x5
 		))
 		return emptyCert, err
 	}
+	// The SA computes the digest it stores itself, so a mismatch means the
+	// certificate it stored isn't the one that was issued.
+	if storedDigest != digest {
+		ca.log.AuditErr(fmt.Sprintf("SA stored certificate with unexpected digest: serial=[%s] digest=[%s] stored=[%s]",
+			serialHex, digest, storedDigest))
+	}
+
+	switch csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		ca.stats.Inc(metricIssuancesRSA, 1)
+	case *ecdsa.PublicKey:
+		ca.stats.Inc(metricIssuancesECDSA, 1)
+	}
+	if plan.explicitCN {
+		ca.stats.Inc(metricIssuancesExplicitCN, 1)
+	} else if csr.Subject.CommonName != "" {
+		ca.stats.Inc(metricIssuancesForcedCNFromSAN, 1)
+	}
+	if plan.clampedByIssuer {
+		ca.stats.Inc(metricIssuancesClampedByIssuer, 1)
+	}
+
+	if ca.IssuanceObserver != nil {
+		if err := ca.IssuanceObserver.OnIssued(cert); err != nil {
+			ca.stats.Inc(metricIssuanceObserverFailed, 1)
+			ca.log.Warning(fmt.Sprintf("Failed to notify issuance observer: serial=[%s] err=[%s]", serialHex, err))
+		}
+	}
 
-	// Submit the certificate to any configured CT logs
-	if ca.Publisher != nil {
-		go func() {
-			// since we don't want this method to be canceled if the parent context
-			// expires pass a background context to it
-			_ = ca.Publisher.SubmitToCT(context.Background(), certDER)
-		}()
+	// Submit the certificate to any configured CT logs, unless it can't be
+	// used by TLS servers, as with S/MIME certificates
+	if serverAuthCertificate(certDER) {
+		ca.publish(certDER)
 	}
 
 	return cert, nil
 }
+
+// generateSerial returns a new random serial number, prefixed with the CA's
+// instance id.
+func (ca *CertificateAuthorityImpl) generateSerial() (*big.Int, error) {
+	// We want 136 bits of random number, plus an 8-bit instance id prefix.
+	const randBits = 136
+	serialBytes := make([]byte, randBits/8+1)
+	serialBytes[0] = byte(ca.prefix)
+	_, err := io.ReadFull(ca.serialRand, serialBytes[1:])
+	if err != nil {
+		return nil, berrors.InternalServerError("failed to generate serial: %s", err)
+	}
+	return big.NewInt(0).SetBytes(serialBytes), nil
+}
+
+// checkRequestedSerial returns a Malformed error if serial can't be used as a
+// caller-supplied serial: the CA must be configured for deterministic
+// issuance, and serial must be positive, at most 20 octets long [RFC5280
+// 4.1.2.2], and start with the CA's serial prefix like the serials it
+// generates, so that it can't collide with another instance's.
+func (ca *CertificateAuthorityImpl) checkRequestedSerial(serial *big.Int) error {
+	if !ca.deterministic {
+		return berrors.MalformedError("serials can only be requested when the CA issues deterministically")
+	}
+	if serial.Sign() <= 0 || serial.BitLen() > 159 {
+		return berrors.MalformedError("requested serial %s is not positive and at most 20 octets long", serial)
+	}
+	if serial.Bytes()[0] != byte(ca.prefix) {
+		return berrors.MalformedError("requested serial %s doesn't start with this CA's serial prefix %#02x",
+			core.SerialToString(serial), ca.prefix)
+	}
+	return nil
+}
+
+// whitelistExtension returns a signing profile adjustment allowing an
+// extension with the given OID to be copied from the request, since cfssl
+// only copies whitelisted extensions.
+func whitelistExtension(oid asn1.ObjectIdentifier) func(*cfsslConfig.SigningProfile) {
+	return func(p *cfsslConfig.SigningProfile) {
+		whitelist := make(map[string]bool, len(p.ExtensionWhitelist)+1)
+		for id, allowed := range p.ExtensionWhitelist {
+			whitelist[id] = allowed
+		}
+		whitelist[oid.String()] = true
+		p.ExtensionWhitelist = whitelist
+	}
+}
+
+// signRequest returns the cfssl request to sign a certificate for csr, as
+// planned, with the given serial. If the certificate needs any per-request
+// changes to the signing profile, it also returns a function making them.
+func (ca *CertificateAuthorityImpl) signRequest(csr *x509.CertificateRequest, regID int64, plan issuancePlan, serial *big.Int) (signer.SignRequest, func(*cfsslConfig.SigningProfile), error) {
+	profileConfig := ca.profileConfigs[plan.profile]
+
+	// Convert the CSR to PEM
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE REQUEST",
+		Bytes: csr.Raw,
+	}))
+
+	req := signer.SignRequest{
+		Request: csrPEM,
+		Profile: plan.profile,
+		Hosts:   plan.names,
+		Subject: &signer.Subject{
+			CN: csr.Subject.CommonName,
+		},
+		Serial:     serial,
+		Extensions: plan.extensions,
+	}
+	if !ca.forceCNFromSAN {
+		req.Subject.SerialNumber = core.SerialToString(serial)
+	}
+
+	// Collect any per-request changes to the signing profile
+	var adjustments []func(*cfsslConfig.SigningProfile)
+	if !plan.notAfter.IsZero() {
+		adjustments = append(adjustments, func(p *cfsslConfig.SigningProfile) {
+			p.NotBefore = plan.notBefore
+			p.NotAfter = plan.notAfter
+		})
+	}
+	if profileConfig.IncludeRegistrationID {
+		regIDValue, err := asn1.Marshal(regID)
+		if err != nil {
+			return req, nil, berrors.InternalServerError("failed to encode registration ID: %s", err)
+		}
+		req.Extensions = append(req.Extensions, signer.Extension{
+			ID:       cfsslConfig.OID(oidRegistrationID),
+			Critical: false,
+			Value:    hex.EncodeToString(regIDValue),
+		})
+		adjustments = append(adjustments, whitelistExtension(oidRegistrationID))
+	}
+	// cfssl always derives the subject key identifier with SHA-1, so any
+	// other is supplied as an extension, which takes the place of its own.
+	if ca.subjectKeyIDMethod != subjectKeyIDSHA1 {
+		ski, err := subjectKeyID(ca.subjectKeyIDMethod, csr.PublicKey)
+		if err != nil {
+			return req, nil, berrors.InternalServerError("failed to compute subject key identifier: %s", err)
+		}
+		skiValue, err := asn1.Marshal(ski)
+		if err != nil {
+			return req, nil, berrors.InternalServerError("failed to encode subject key identifier: %s", err)
+		}
+		req.Extensions = append(req.Extensions, signer.Extension{
+			ID:       cfsslConfig.OID(oidSubjectKeyIdentifier),
+			Critical: false,
+			Value:    hex.EncodeToString(skiValue),
+		})
+		adjustments = append(adjustments, whitelistExtension(oidSubjectKeyIdentifier))
+	}
+	for _, ext := range ca.additionalExtensions {
+		req.Extensions = append(req.Extensions, ext)
+		adjustments = append(adjustments, whitelistExtension(asn1.ObjectIdentifier(ext.ID)))
+	}
+	if plan.sctList != nil {
+		req.Extensions = append(req.Extensions, *plan.sctList)
+		adjustments = append(adjustments, whitelistExtension(oidSCTList))
+	}
+	if profileConfig.StripAnyExtKeyUsage {
+		adjustments = append(adjustments, func(p *cfsslConfig.SigningProfile) {
+			var usages []string
+			for _, usage := range p.Usage {
+				if eku, ok := cfsslConfig.ExtKeyUsage[usage]; !ok || eku != x509.ExtKeyUsageAny {
+					usages = append(usages, usage)
+				}
+			}
+			p.Usage = usages
+		})
+	}
+	if threshold := profileConfig.OmitRevocationPointersBelow.Duration; plan.validity < threshold {
+		adjustments = append(adjustments, func(p *cfsslConfig.SigningProfile) {
+			p.OCSP = ""
+			p.CRL = ""
+		})
+	}
+
+	if len(adjustments) == 0 {
+		return req, nil, nil
+	}
+	return req, func(p *cfsslConfig.SigningProfile) {
+		for _, adjust := range adjustments {
+			adjust(p)
+		}
+	}, nil
+}
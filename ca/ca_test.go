@@ -3,11 +3,27 @@ package ca
 import (
 	"bytes"
 	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -22,11 +38,13 @@ import (
 	"github.com/letsencrypt/boulder/cmd"
 	"github.com/letsencrypt/boulder/core"
 	berrors "github.com/letsencrypt/boulder/errors"
+	"github.com/letsencrypt/boulder/features"
 	"github.com/letsencrypt/boulder/goodkey"
 	blog "github.com/letsencrypt/boulder/log"
 	"github.com/letsencrypt/boulder/metrics"
 	"github.com/letsencrypt/boulder/mocks"
 	"github.com/letsencrypt/boulder/policy"
+	"github.com/letsencrypt/boulder/revocation"
 	"github.com/letsencrypt/boulder/test"
 )
 
@@ -50,6 +68,12 @@ var (
 	// * DNSNames = [none]
 	NoNameCSR = mustRead("./testdata/no_name.der.csr")
 
+	// CSR generated by Go:
+	// * Random public key
+	// * CN = not-example.com
+	// * DNSNames = [none]
+	NoSANCSR = mustRead("./testdata/no_san.der.csr")
+
 	// CSR generated by Go:
 	// * Random public key
 	// * CN = [none]
@@ -68,6 +92,12 @@ var (
 	// * DNSNames = moreCAPs.com, morecaps.com, evenMOREcaps.com, Capitalizedletters.COM
 	CapitalizedCSR = mustRead("./testdata/capitalized_cn_and_san.der.csr")
 
+	// CSR generated by Go:
+	// * Random public key
+	// * CN = not-example.com
+	// * DNSNames = not-example.com, exactblacklist.letsencrypt.org
+	ForbiddenSANCSR = mustRead("./testdata/forbidden_san.der.csr")
+
 	// CSR generated by OpenSSL:
 	// Edited signature to become invalid.
 	WrongSignatureCSR = mustRead("./testdata/invalid_signature.der.csr")
@@ -84,6 +114,14 @@ var (
 	// * Includes extensionRequest attributes for *two* must-staple extensions
 	DuplicateMustStapleCSR = mustRead("./testdata/duplicate_must_staple.der.csr")
 
+	// CSR generated by Go:
+	// * Random public key
+	// * CN = not-example.com
+	// * Includes extensionRequest attributes for two TLS Feature extensions,
+	//   one for status_request and one for status_request and
+	//   status_request_v2
+	ConflictingTLSFeatureCSR = mustRead("./testdata/conflicting_tls_feature.der.csr")
+
 	// CSR generated by Go:
 	// * Random public key
 	// * CN = not-example.com
@@ -114,6 +152,58 @@ var (
 	// * DNSNames = [none]
 	LongCNCSR = mustRead("./testdata/long_cn.der.csr")
 
+	// CSR generated by Go:
+	// * Random RSA public key.
+	// * CN = [none]
+	// * DNSNames = aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa.not-example.com
+	LongSANCSR = mustRead("./testdata/long_san.der.csr")
+
+	// CSR generated by Go:
+	// * Random RSA public key.
+	// * CN = not-example.com
+	// * DNSNames = not-example.com
+	// * Signed with SHA1WithRSA
+	SHA1SignatureCSR = mustRead("./testdata/sha1_signature.der.csr")
+
+	// CSR generated by Go:
+	// * Random public key
+	// * CN = not-example.com
+	// * DNSNames = not-example.com
+	// * Basic Constraints = cA: false, pathLenConstraint: 3
+	PathLenCSR = mustRead("./testdata/path_len.der.csr")
+
+	// CSR generated by Go:
+	// * Random public key
+	// * CN = not-example.com
+	// * DNSNames = not-example.com
+	// * Basic Constraints (critical) = cA: true
+	CATrueCSR = mustRead("./testdata/ca_true.der.csr")
+
+	// CSR generated by Go:
+	// * Random public key
+	// * CN = not-example.com
+	// * DNSNames = www.not-example.com
+	CNNotInSANCSR = mustRead("./testdata/cn_not_in_san.der.csr")
+
+	// CSR generated by Go:
+	// * Random public key
+	// * CN = not-example.com
+	// * O = Internet Widgets
+	// * DNSNames = not-example.com
+	OrganizationCSR = mustRead("./testdata/organization.der.csr")
+
+	// CSR generated by Go:
+	// * Random public key
+	// * CN = alice@not-example.com
+	// * EmailAddresses = alice@not-example.com
+	EmailCSR = mustRead("./testdata/email.der.csr")
+
+	// CSR generated by Go:
+	// * Random public key
+	// * No CN
+	// * EmailAddresses = alice@not-example.com, bob@not-example.com
+	TwoEmailsCSR = mustRead("./testdata/two_emails.der.csr")
+
 	log = blog.UseMock()
 )
 
@@ -143,11 +233,40 @@ type testCtx struct {
 
 type mockSA struct {
 	certificate core.Certificate
+	// certificates holds every certificate added, returned by GetCertificate,
+	// keyed by serial.
+	certificates map[string]core.Certificate
+	// statuses holds the certificate statuses returned by
+	// GetCertificateStatus, keyed by serial.
+	statuses map[string]core.CertificateStatus
 }
 
-func (m *mockSA) AddCertificate(ctx context.Context, der []byte, _ int64, _ []byte) (string, error) {
+func (m *mockSA) AddCertificate(ctx context.Context, der []byte, regID int64, _ []byte) (string, error) {
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		return "", err
+	}
+	m.certificate.RegistrationID = regID
+	m.certificate.Serial = core.SerialToString(parsed.SerialNumber)
 	m.certificate.DER = der
-	return "", nil
+	m.certificate.Digest = core.Fingerprint256(der)
+	if m.certificates == nil {
+		m.certificates = make(map[string]core.Certificate)
+	}
+	m.certificates[m.certificate.Serial] = m.certificate
+	return m.certificate.Digest, nil
+}
+
+func (m *mockSA) GetCertificate(_ context.Context, serial string) (core.Certificate, error) {
+	cert, ok := m.certificates[serial]
+	if !ok {
+		return core.Certificate{}, berrors.NotFoundError("no certificate with serial %s", serial)
+	}
+	return cert, nil
+}
+
+func (m *mockSA) GetCertificateStatus(_ context.Context, serial string) (core.CertificateStatus, error) {
+	return m.statuses[serial], nil
 }
 
 var caKey crypto.Signer
@@ -166,14 +285,18 @@ func init() {
 	}
 }
 
-func setup(t *testing.T) *testCtx {
+func setup(t testing.TB) *testCtx {
 	fc := clock.NewFake()
 	fc.Add(1 * time.Hour)
 
 	pa, err := policy.New(nil)
-	test.AssertNotError(t, err, "Couldn't create PA")
+	if err != nil {
+		t.Fatalf("Couldn't create PA: %s", err)
+	}
 	err = pa.SetHostnamePolicyFile("../test/hostname-policy.json")
-	test.AssertNotError(t, err, "Couldn't set hostname policy")
+	if err != nil {
+		t.Fatalf("Couldn't set hostname policy: %s", err)
+	}
 
 	// Create a CA
 	caConfig := cmd.CAConfig{
@@ -237,7 +360,7 @@ func setup(t *testing.T) *testCtx {
 		},
 	}
 
-	issuers := []Issuer{{caKey, caCert}}
+	issuers := []Issuer{{Signer: caKey, Cert: caCert}}
 
 	keyPolicy := goodkey.KeyPolicy{
 		AllowRSA:           true,
@@ -258,6 +381,26 @@ func setup(t *testing.T) *testCtx {
 	}
 }
 
+// newCA creates a CA from testCtx's config and issuers, with a mock publisher
+// and SA. Tests adjust testCtx before calling it, and replace the CA's
+// dependencies afterwards.
+func (c *testCtx) newCA(t testing.TB) *CertificateAuthorityImpl {
+	ca, err := NewCertificateAuthorityImpl(
+		c.caConfig,
+		c.fc,
+		c.stats,
+		c.issuers,
+		c.keyPolicy,
+		c.logger)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %s", err)
+	}
+	ca.Publisher = &mocks.Publisher{}
+	ca.PA = c.pa
+	ca.SA = &mockSA{}
+	return ca
+}
+
 func TestFailNoSerial(t *testing.T) {
 	testCtx := setup(t)
 
@@ -272,19 +415,131 @@ func TestFailNoSerial(t *testing.T) {
 	test.AssertError(t, err, "CA should have failed with no SerialPrefix")
 }
 
-func TestIssueCertificate(t *testing.T) {
+func TestAllowedSerialPrefixes(t *testing.T) {
 	testCtx := setup(t)
-	ca, err := NewCertificateAuthorityImpl(
+	testCtx.caConfig.SerialPrefix = 17
+	testCtx.caConfig.AllowedSerialPrefixes = []int{16, 18}
+	_, err := NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created a CA with a serial prefix that isn't allowed")
+
+	testCtx.caConfig.AllowedSerialPrefixes = []int{16, 17, 18}
+	mockLog := testCtx.logger.(*blog.Mock)
+	mockLog.Clear()
+	_ = testCtx.newCA(t)
+	test.AssertEquals(t, len(mockLog.GetAllMatching(`CA created with serial prefix 0x11$`)), 1)
+}
+
+func TestMaxProfiles(t *testing.T) {
+	testCtx := setup(t)
+	test.AssertEquals(t, len(testCtx.caConfig.CFSSL.Signing.Profiles), 2)
+
+	testCtx.caConfig.MaxProfiles = 1
+	_, err := NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "CA should have failed with more profiles than MaxProfiles")
+
+	testCtx.caConfig.MaxProfiles = 2
+	_ = testCtx.newCA(t)
+}
+
+func TestCNStrategy(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "Failed to generate key")
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames:           []string{"www.not-example.com", "not-example.com"},
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}, key)
+	test.AssertNotError(t, err, "Failed to create CSR")
+
+	for strategy, expectedCN := range map[string]string{
+		"":                  "www.not-example.com",
+		"first":             "www.not-example.com",
+		"shortest":          "not-example.com",
+		"registered-domain": "not-example.com",
+	} {
+		testCtx := setup(t)
+		testCtx.caConfig.CNStrategy = strategy
+		ca := testCtx.newCA(t)
+
+		csr, err := x509.ParseCertificateRequest(csrDER)
+		test.AssertNotError(t, err, "Failed to parse CSR")
+		issued, err := ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to issue certificate")
+		cert, err := x509.ParseCertificate(issued.DER)
+		test.AssertNotError(t, err, "Failed to parse certificate")
+		test.AssertEquals(t, cert.Subject.CommonName, expectedCN)
+	}
+
+	testCtx := setup(t)
+	testCtx.caConfig.CNStrategy = "longest"
+	_, err = NewCertificateAuthorityImpl(
 		testCtx.caConfig,
 		testCtx.fc,
 		testCtx.stats,
 		testCtx.issuers,
 		testCtx.keyPolicy,
 		testCtx.logger)
-	test.AssertNotError(t, err, "Failed to create CA")
+	test.AssertError(t, err, "CA accepted an unknown CN strategy")
+}
+
+func TestCanonicalNameOrder(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.MaxNames = 3
+	ca := testCtx.newCA(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "Failed to generate key")
+	sanExtension := func(cn string, names []string) []byte {
+		csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:            pkix.Name{CommonName: cn},
+			DNSNames:           names,
+			SignatureAlgorithm: x509.SHA256WithRSA,
+		}, key)
+		test.AssertNotError(t, err, "Failed to create CSR")
+		csr, err := x509.ParseCertificateRequest(csrDER)
+		test.AssertNotError(t, err, "Failed to parse CSR")
+		issued, err := ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to issue certificate")
+		cert, err := x509.ParseCertificate(issued.DER)
+		test.AssertNotError(t, err, "Failed to parse certificate")
+		test.AssertDeepEquals(t, cert.DNSNames, []string{"a.not-example.com", "b.not-example.com", "c.not-example.com"})
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(oidSubjectAltName) {
+				return ext.Value
+			}
+		}
+		t.Fatal("Certificate has no subjectAltName extension")
+		return nil
+	}
+
+	expected := sanExtension("", []string{"a.not-example.com", "b.not-example.com", "c.not-example.com"})
+	for _, c := range []struct {
+		cn    string
+		names []string
+	}{
+		{"", []string{"c.not-example.com", "a.not-example.com", "b.not-example.com"}},
+		{"", []string{"B.not-example.com", "c.not-example.com", "b.not-example.com", "A.NOT-EXAMPLE.COM"}},
+		{"C.not-example.com", []string{"b.not-example.com", "a.not-example.com"}},
+	} {
+		test.AssertByteEquals(t, sanExtension(c.cn, c.names), expected)
+	}
+}
+
+func TestIssueCertificate(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
 	ca.forceCNFromSAN = false
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
 	sa := &mockSA{}
 	ca.SA = sa
 
@@ -318,6 +573,275 @@ func TestIssueCertificate(t *testing.T) {
 		t.Errorf("SerialNumber: want %#v, got %#v", serialString, cert.Subject.SerialNumber)
 	}
 	test.Assert(t, bytes.Equal(issuedCert.DER, sa.certificate.DER), "Retrieved cert not equal to issued cert.")
+
+	// The digest is returned and matches what the SA stored
+	sum := sha256.Sum256(issuedCert.DER)
+	test.AssertEquals(t, issuedCert.Digest, base64.RawURLEncoding.EncodeToString(sum[:]))
+	test.AssertEquals(t, sa.certificate.Digest, issuedCert.Digest)
+
+	// The certificate's serial is linked to the requesting registration, both
+	// in what the SA stored and in what is returned
+	test.AssertEquals(t, sa.certificate.Serial, serialString)
+	test.AssertEquals(t, sa.certificate.RegistrationID, int64(1001))
+	test.AssertEquals(t, issuedCert.Serial, serialString)
+	test.AssertEquals(t, issuedCert.RegistrationID, int64(1001))
+}
+
+func TestOCSPOnlyIssuer(t *testing.T) {
+	testCtx := setup(t)
+	newCA := func(issuers []Issuer) *CertificateAuthorityImpl {
+		c := *testCtx
+		c.issuers = issuers
+		return c.newCA(t)
+	}
+
+	// Issue a certificate from caCert before it is retired
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	oldCert, err := newCA(testCtx.issuers).IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+
+	// caCert is now OCSP-only, so newIssuerCert is used for issuance despite
+	// coming second.
+	newIssuerCert, err := core.LoadCert("../test/test-ca2.pem")
+	test.AssertNotError(t, err, "Failed to load new cert")
+	ca := newCA([]Issuer{
+		{Signer: caKey, Cert: caCert, OCSPOnly: true},
+		{Signer: caKey, Cert: newIssuerCert},
+	})
+	newCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	parsedNewCert, err := x509.ParseCertificate(newCert.DER)
+	test.AssertNotError(t, err, "Failed to parse cert")
+	test.AssertEquals(t, parsedNewCert.Issuer.CommonName, newIssuerCert.Subject.CommonName)
+
+	// Certificates from the retired issuer still get OCSP responses
+	ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: oldCert.DER,
+		Status:  string(core.OCSPStatusGood),
+	})
+	test.AssertNotError(t, err, "Failed to generate OCSP for a retired issuer")
+	parsed, err := ocsp.ParseResponse(ocspResp, caCert)
+	test.AssertNotError(t, err, "Failed to parse / validate OCSP")
+	test.AssertEquals(t, parsed.Status, ocsp.Good)
+
+	// At least one issuer must be usable for issuance
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		[]Issuer{{Signer: caKey, Cert: caCert, OCSPOnly: true}},
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created a CA with only OCSP-only issuers")
+
+	// An issuer with the right name but a different key doesn't match
+	otherKey, otherCert := issuerSharingName(t)
+	ca = newCA([]Issuer{
+		{Signer: otherKey, Cert: otherCert, OCSPOnly: true},
+		{Signer: caKey, Cert: newIssuerCert},
+	})
+	_, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: oldCert.DER,
+		Status:  string(core.OCSPStatusGood),
+	})
+	test.AssertError(t, err, "Generated OCSP with an issuer whose key ID doesn't match")
+	test.AssertContains(t, err.Error(), "authority key ID")
+}
+
+// issuerSharingName returns a new self-signed issuer certificate, and its key,
+// with the same name as caCert but a different key and key ID, as when an
+// issuer's key is rotated.
+func issuerSharingName(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "Failed to generate key")
+	template := *caCert
+	template.SubjectKeyId = []byte{1, 2, 3, 4}
+	template.PublicKey = key.Public()
+	// Allow the new issuer to cross-sign
+	template.MaxPathLen = 1
+	template.MaxPathLenZero = false
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	test.AssertNotError(t, err, "Failed to create issuer certificate")
+	cert, err := x509.ParseCertificate(der)
+	test.AssertNotError(t, err, "Failed to parse issuer certificate")
+	test.AssertEquals(t, cert.Subject.CommonName, caCert.Subject.CommonName)
+	return key, cert
+}
+
+func TestOCSPOnlyIssuerSharingName(t *testing.T) {
+	testCtx := setup(t)
+	newCA := func(issuers []Issuer) *CertificateAuthorityImpl {
+		c := *testCtx
+		c.issuers = issuers
+		return c.newCA(t)
+	}
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	oldCert, err := newCA(testCtx.issuers).IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+
+	// The issuer is rotated to a new key, keeping its name, and the old key
+	// is kept only to sign OCSP
+	newKey, newIssuerCert := issuerSharingName(t)
+	ca := newCA([]Issuer{
+		{Signer: caKey, Cert: caCert, OCSPOnly: true},
+		{Signer: newKey, Cert: newIssuerCert},
+	})
+
+	newCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	parsedNewCert, err := x509.ParseCertificate(newCert.DER)
+	test.AssertNotError(t, err, "Failed to parse cert")
+	test.AssertNotError(t, parsedNewCert.CheckSignatureFrom(newIssuerCert), "Certificate wasn't issued with the new key")
+
+	// Each certificate's OCSP is signed by the key that issued it
+	for _, tc := range []struct {
+		cert   core.Certificate
+		issuer *x509.Certificate
+	}{
+		{oldCert, caCert},
+		{newCert, newIssuerCert},
+	} {
+		ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+			CertDER: tc.cert.DER,
+			Status:  string(core.OCSPStatusGood),
+		})
+		test.AssertNotError(t, err, "Failed to generate OCSP")
+		parsed, err := ocsp.ParseResponse(ocspResp, tc.issuer)
+		test.AssertNotError(t, err, "Failed to parse / validate OCSP")
+		test.AssertEquals(t, parsed.Status, ocsp.Good)
+
+		// As are unknown responses and batch revocations
+		unknownResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+			CertDER: tc.cert.DER,
+			Status:  string(core.OCSPStatusUnknown),
+		})
+		test.AssertNotError(t, err, "Failed to generate unknown OCSP")
+		_, err = ocsp.ParseResponse(unknownResp, tc.issuer)
+		test.AssertNotError(t, err, "Failed to parse / validate unknown OCSP")
+		cert, err := x509.ParseCertificate(tc.cert.DER)
+		test.AssertNotError(t, err, "Failed to parse cert")
+		err = ca.GenerateRevokedOCSPBatch(ctx, tc.issuer.Subject.CommonName, tc.issuer.SubjectKeyId,
+			[]*big.Int{cert.SerialNumber}, revocation.KeyCompromise, testCtx.fc.Now(),
+			func(_ *big.Int, response []byte) error {
+				_, err := ocsp.ParseResponse(response, tc.issuer)
+				return err
+			})
+		test.AssertNotError(t, err, "Failed to generate revoked OCSP batch")
+
+		// And the chain ends in the issuer that signed the certificate
+		chain, err := ca.CertificateChainPEM(tc.cert)
+		test.AssertNotError(t, err, "Failed to build certificate chain")
+		test.AssertByteEquals(t, chain[len(CertificatePEM(tc.cert)):],
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tc.issuer.Raw}))
+	}
+
+	// Cross-signing picks the issuer by key too, and the old key is OCSP-only
+	testCtx.caConfig.CrossSigning = true
+	testCtx.caConfig.CrossSignValidity = cmd.ConfigDuration{Duration: 365 * 24 * time.Hour}
+	crossCA := newCA([]Issuer{
+		{Signer: caKey, Cert: caCert, OCSPOnly: true},
+		{Signer: newKey, Cert: newIssuerCert},
+	})
+	rootCert, err := core.LoadCert("../test/test-root.pem")
+	test.AssertNotError(t, err, "Failed to load root cert")
+	crossKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "Failed to generate key")
+	crossCSRDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		RawSubject: rootCert.RawSubject,
+	}, crossKey)
+	test.AssertNotError(t, err, "Failed to create CSR")
+	crossCSR, err := x509.ParseCertificateRequest(crossCSRDER)
+	test.AssertNotError(t, err, "Failed to parse CSR")
+	_, err = crossCA.CrossSign(ctx, *crossCSR, caCert.Subject.CommonName, caCert.SubjectKeyId, 0)
+	test.AssertError(t, err, "Cross-signed with an OCSP-only issuer")
+	crossCert, err := crossCA.CrossSign(ctx, *crossCSR, caCert.Subject.CommonName, newIssuerCert.SubjectKeyId, 0)
+	test.AssertNotError(t, err, "Failed to cross-sign")
+	parsedCrossCert, err := x509.ParseCertificate(crossCert.DER)
+	test.AssertNotError(t, err, "Failed to parse cross-certificate")
+	test.AssertNotError(t, parsedCrossCert.CheckSignatureFrom(newIssuerCert), "Cross-certificate wasn't signed with the new key")
+
+	// Issuers may only share a name if their keys differ
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		[]Issuer{
+			{Signer: caKey, Cert: caCert, OCSPOnly: true},
+			{Signer: caKey, Cert: caCert},
+		},
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created a CA with duplicate issuers")
+}
+
+func TestIssuerRevocationURLs(t *testing.T) {
+	testCtx := setup(t)
+	newCA := func(issuers []Issuer) *CertificateAuthorityImpl {
+		c := *testCtx
+		c.issuers = issuers
+		return c.newCA(t)
+	}
+	issue := func(ca *CertificateAuthorityImpl) *x509.Certificate {
+		csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+		coreCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to issue")
+		cert, err := x509.ParseCertificate(coreCert.DER)
+		test.AssertNotError(t, err, "Failed to parse cert")
+		return cert
+	}
+
+	cert := issue(newCA([]Issuer{{
+		Signer:  caKey,
+		Cert:    caCert,
+		OCSPURL: "http://tenant.not-example.com/ocsp",
+		CRLURL:  "http://tenant.not-example.com/crl",
+	}}))
+	test.AssertDeepEquals(t, cert.OCSPServer, []string{"http://tenant.not-example.com/ocsp"})
+	test.AssertDeepEquals(t, cert.CRLDistributionPoints, []string{"http://tenant.not-example.com/crl"})
+
+	// Overriding only one URL leaves the other as the profile has it
+	cert = issue(newCA([]Issuer{{
+		Signer:  caKey,
+		Cert:    caCert,
+		OCSPURL: "http://tenant.not-example.com/ocsp",
+	}}))
+	test.AssertDeepEquals(t, cert.OCSPServer, []string{"http://tenant.not-example.com/ocsp"})
+	test.AssertDeepEquals(t, cert.CRLDistributionPoints, []string{"http://not-example.com/crl"})
+
+	// Without overrides, the profile's URLs are used
+	cert = issue(newCA(testCtx.issuers))
+	test.AssertDeepEquals(t, cert.OCSPServer, []string{"http://not-example.com/ocsp"})
+	test.AssertDeepEquals(t, cert.CRLDistributionPoints, []string{"http://not-example.com/crl"})
+}
+
+func TestIssuerKeyMismatch(t *testing.T) {
+	testCtx := setup(t)
+	// test-root.pem has a different key than test-ca.pem, which caKey belongs
+	// to
+	rootCert, err := core.LoadCert("../test/test-root.pem")
+	test.AssertNotError(t, err, "Failed to load root cert")
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		[]Issuer{{Signer: caKey, Cert: rootCert}},
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created a CA with an issuer whose key doesn't match its cert")
+	test.AssertContains(t, err.Error(), "doesn't match")
+
+	// OCSP-only issuers are checked too
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		[]Issuer{
+			{Signer: caKey, Cert: rootCert, OCSPOnly: true},
+			{Signer: caKey, Cert: caCert},
+		},
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created a CA with an OCSP-only issuer whose key doesn't match its cert")
 }
 
 // Test issuing when multiple issuers are present.
@@ -336,17 +860,8 @@ func TestIssueCertificateMultipleIssuers(t *testing.T) {
 			Cert:   caCert,
 		},
 	}
-	ca, err := NewCertificateAuthorityImpl(
-		testCtx.caConfig,
-		testCtx.fc,
-		testCtx.stats,
-		newIssuers,
-		testCtx.keyPolicy,
-		testCtx.logger)
-	test.AssertNotError(t, err, "Failed to remake CA")
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
-	ca.SA = &mockSA{}
+	testCtx.issuers = newIssuers
+	ca := testCtx.newCA(t)
 
 	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
 	issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
@@ -359,19 +874,62 @@ func TestIssueCertificateMultipleIssuers(t *testing.T) {
 	test.AssertNotError(t, err, "Certificate failed signature validation")
 }
 
-func TestOCSP(t *testing.T) {
+func TestIssuerActiveFrom(t *testing.T) {
 	testCtx := setup(t)
-	ca, err := NewCertificateAuthorityImpl(
+	newIssuerCert, err := core.LoadCert("../test/test-ca2.pem")
+	test.AssertNotError(t, err, "Failed to load new cert")
+	cutover := testCtx.fc.Now().Add(24 * time.Hour)
+	newIssuers := []Issuer{
+		{
+			Signer: caKey,
+			Cert:   newIssuerCert,
+			// ECDSA issuance moves to the new issuer immediately, RSA at the
+			// cutover.
+			ActiveFrom: map[string]time.Time{
+				"ECDSA": {},
+				"RSA":   cutover,
+			},
+		}, {
+			Signer: caKey,
+			Cert:   caCert,
+		},
+	}
+	testCtx.issuers = newIssuers
+	ca := testCtx.newCA(t)
+
+	// Both issuers share a key, so compare issuer names rather than checking
+	// signatures.
+	issuerOf := func(csrDER []byte) string {
+		csr, _ := x509.ParseCertificateRequest(csrDER)
+		issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to sign certificate")
+		cert, err := x509.ParseCertificate(issuedCert.DER)
+		test.AssertNotError(t, err, "Certificate failed to parse")
+		return cert.Issuer.CommonName
+	}
+
+	test.AssertEquals(t, issuerOf(CNandSANCSR), caCert.Subject.CommonName)
+	test.AssertEquals(t, issuerOf(ECDSACSR), newIssuerCert.Subject.CommonName)
+
+	testCtx.fc.Set(cutover)
+	test.AssertEquals(t, issuerOf(CNandSANCSR), newIssuerCert.Subject.CommonName)
+	test.AssertEquals(t, issuerOf(ECDSACSR), newIssuerCert.Subject.CommonName)
+
+	// Only known key types may be named
+	newIssuers[0].ActiveFrom = map[string]time.Time{"Ed25519": {}}
+	_, err = NewCertificateAuthorityImpl(
 		testCtx.caConfig,
 		testCtx.fc,
 		testCtx.stats,
-		testCtx.issuers,
+		newIssuers,
 		testCtx.keyPolicy,
 		testCtx.logger)
-	test.AssertNotError(t, err, "Failed to create CA")
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
-	ca.SA = &mockSA{}
+	test.AssertError(t, err, "Created CA with an issuer active for an unknown key type")
+}
+
+func TestOCSP(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
 
 	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
 	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
@@ -410,17 +968,8 @@ func TestOCSP(t *testing.T) {
 			Cert:   caCert,
 		},
 	}
-	ca, err = NewCertificateAuthorityImpl(
-		testCtx.caConfig,
-		testCtx.fc,
-		testCtx.stats,
-		newIssuers,
-		testCtx.keyPolicy,
-		testCtx.logger)
-	test.AssertNotError(t, err, "Failed to remake CA")
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
-	ca.SA = &mockSA{}
+	testCtx.issuers = newIssuers
+	ca = testCtx.newCA(t)
 
 	// Now issue a new cert, signed by newIssuerCert
 	newCert, err := ca.IssueCertificate(ctx, *csr, 1001)
@@ -455,60 +1004,139 @@ func TestOCSP(t *testing.T) {
 	test.AssertEquals(t, parsedNewCertOcspResp.SerialNumber.Cmp(parsedNewCert.SerialNumber), 0)
 }
 
-func TestNoHostnames(t *testing.T) {
+func TestDelegatedOCSPResponder(t *testing.T) {
 	testCtx := setup(t)
-	ca, err := NewCertificateAuthorityImpl(
-		testCtx.caConfig,
-		testCtx.fc,
-		testCtx.stats,
-		testCtx.issuers,
-		testCtx.keyPolicy,
-		testCtx.logger)
-	test.AssertNotError(t, err, "Failed to create CA")
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
-	ca.SA = &mockSA{}
+	responderKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate responder key")
+	noCheck := []pkix.Extension{{Id: oidOCSPNoCheck, Value: []byte{0x05, 0x00}}}
+	makeResponderCert := func(parent *x509.Certificate, signer crypto.Signer, ekus []x509.ExtKeyUsage, exts []pkix.Extension) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber:    big.NewInt(2),
+			Subject:         pkix.Name{CommonName: "happy hacker fake OCSP responder"},
+			NotBefore:       caCert.NotBefore,
+			NotAfter:        caCert.NotAfter,
+			KeyUsage:        x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:     ekus,
+			ExtraExtensions: exts,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, responderKey.Public(), signer)
+		test.AssertNotError(t, err, "Failed to create responder certificate")
+		cert, err := x509.ParseCertificate(der)
+		test.AssertNotError(t, err, "Failed to parse responder certificate")
+		return cert
+	}
+	responderCert := makeResponderCert(caCert, caKey, []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}, noCheck)
+	testCtx.issuers = []Issuer{{
+		Signer:              caKey,
+		Cert:                caCert,
+		OCSPResponderSigner: responderKey,
+		OCSPResponderCert:   responderCert,
+	}}
+	ca := testCtx.newCA(t)
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	parsedCert, err := x509.ParseCertificate(cert.DER)
+	test.AssertNotError(t, err, "Failed to parse cert")
+	ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: cert.DER,
+		Status:  string(core.OCSPStatusGood),
+	})
+	test.AssertNotError(t, err, "Failed to generate OCSP")
+	// ParseResponseForCert checks that the embedded responder cert was issued
+	// by caCert and that the response is signed by the responder
+	parsed, err := ocsp.ParseResponseForCert(ocspResp, parsedCert, caCert)
+	test.AssertNotError(t, err, "Failed to parse / validate delegated OCSP")
+	test.AssertEquals(t, parsed.Status, ocsp.Good)
+	test.AssertEquals(t, parsed.SerialNumber.Cmp(parsedCert.SerialNumber), 0)
+	test.Assert(t, parsed.Certificate != nil, "Delegated OCSP response didn't include the responder cert")
+	test.Assert(t, bytes.Equal(parsed.Certificate.Raw, responderCert.Raw), "Wrong responder cert in OCSP response")
+	test.Assert(t, bytes.Equal(parsed.RawResponderName, responderCert.RawSubject), "Wrong responder ID in OCSP response")
+	err = parsed.CheckSignatureFrom(caCert)
+	test.AssertError(t, err, "Delegated OCSP response validated against the issuer's key")
+
+	// A delegated responder must be complete, match its key, be issued by the
+	// issuer, be for OCSP signing, and not need revocation checking itself
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate key")
+	otherIssuer := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      caCert.Subject,
+		PublicKey:    otherKey.Public(),
+	}
+	for _, tc := range []struct {
+		name   string
+		signer crypto.Signer
+		cert   *x509.Certificate
+	}{
+		{"no cert", responderKey, nil},
+		{"no signer", nil, responderCert},
+		{"mismatched key", otherKey, responderCert},
+		{"wrong issuer", responderKey, makeResponderCert(otherIssuer, otherKey, []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}, noCheck)},
+		{"no OCSP signing EKU", responderKey, makeResponderCert(caCert, caKey, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, noCheck)},
+		{"no ocsp-nocheck", responderKey, makeResponderCert(caCert, caKey, []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}, nil)},
+	} {
+		_, err := NewCertificateAuthorityImpl(
+			testCtx.caConfig,
+			testCtx.fc,
+			testCtx.stats,
+			[]Issuer{{
+				Signer:              caKey,
+				Cert:                caCert,
+				OCSPResponderSigner: tc.signer,
+				OCSPResponderCert:   tc.cert,
+			}},
+			testCtx.keyPolicy,
+			testCtx.logger)
+		test.AssertError(t, err, fmt.Sprintf("Created CA with bad OCSP responder: %s", tc.name))
+	}
+}
+
+func TestNoHostnames(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
 
 	csr, _ := x509.ParseCertificateRequest(NoNamesCSR)
-	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	_, err := ca.IssueCertificate(ctx, *csr, 1001)
 	test.AssertError(t, err, "Issued certificate with no names")
 	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRNoNames)
 }
 
 func TestRejectTooManyNames(t *testing.T) {
 	testCtx := setup(t)
-	ca, err := NewCertificateAuthorityImpl(
-		testCtx.caConfig,
-		testCtx.fc,
-		testCtx.stats,
-		testCtx.issuers,
-		testCtx.keyPolicy,
-		testCtx.logger)
-	test.AssertNotError(t, err, "Failed to create CA")
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
-	ca.SA = &mockSA{}
+	ca := testCtx.newCA(t)
 
 	// Test that the CA rejects a CSR with too many names
 	csr, _ := x509.ParseCertificateRequest(TooManyNameCSR)
-	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	_, err := ca.IssueCertificate(ctx, *csr, 1001)
 	test.AssertError(t, err, "Issued certificate with too many names")
 	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRTooManyNames)
+}
+
+func TestRejectForbiddenSAN(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+	sa := &mockSA{}
+	ca.SA = sa
+
+	// Every SAN is checked against the policy authority, not just the CN, and
+	// a single forbidden name rejects the whole request before signing.
+	csr, _ := x509.ParseCertificateRequest(ForbiddenSANCSR)
+	_, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued certificate with a forbidden SAN")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRForbiddenNames)
+	test.AssertContains(t, err.Error(), `"exactblacklist.letsencrypt.org"`)
+	test.Assert(t, !strings.Contains(err.Error(), `"not-example.com"`), "Error named an allowed SAN")
+	test.AssertEquals(t, len(sa.certificate.DER), 0)
 }
 
 func TestRejectValidityTooLong(t *testing.T) {
 	testCtx := setup(t)
-	ca, err := NewCertificateAuthorityImpl(
-		testCtx.caConfig,
-		testCtx.fc,
-		testCtx.stats,
-		testCtx.issuers,
-		testCtx.keyPolicy,
-		testCtx.logger)
-	test.AssertNotError(t, err, "Failed to create CA")
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
-	ca.SA = &mockSA{}
+	ca := testCtx.newCA(t)
 
 	// This time is a few minutes before the notAfter in testdata/ca_cert.pem
 	future, err := time.Parse(time.RFC3339, "2025-02-10T00:30:00Z")
@@ -522,40 +1150,383 @@ func TestRejectValidityTooLong(t *testing.T) {
 	test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
 }
 
-func TestShortKey(t *testing.T) {
+func TestRejectValidityTooLongMultipleIssuers(t *testing.T) {
 	testCtx := setup(t)
-	ca, err := NewCertificateAuthorityImpl(
+	// test-ca.pem expires in October 2020 and test-ca2.pem in March 2021, so
+	// a one year certificate issued at the start of 2020 only fits within the
+	// latter.
+	now, err := time.Parse(time.RFC3339, "2020-01-01T00:00:00Z")
+	test.AssertNotError(t, err, "Failed to parse time")
+	testCtx.fc.Set(now)
+	newIssuerCert, err := core.LoadCert("../test/test-ca2.pem")
+	test.AssertNotError(t, err, "Failed to load new cert")
+
+	newCA := func(issuers []Issuer) *CertificateAuthorityImpl {
+		c := *testCtx
+		c.issuers = issuers
+		return c.newCA(t)
+	}
+
+	// The default issuer expires too soon, even though the secondary issuer
+	// would not.
+	ca := newCA([]Issuer{{Signer: caKey, Cert: caCert}, {Signer: caKey, Cert: newIssuerCert}})
+	csr, _ := x509.ParseCertificateRequest(NoCNCSR)
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate that expires after the default issuer")
+	test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+
+	// The default issuer outlives the certificate, even though the secondary
+	// issuer does not.
+	ca = newCA([]Issuer{{Signer: caKey, Cert: newIssuerCert}, {Signer: caKey, Cert: caCert}})
+	csr, _ = x509.ParseCertificateRequest(NoCNCSR)
+	issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue a certificate within the default issuer's validity")
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertNotError(t, cert.CheckSignatureFrom(newIssuerCert), "Certificate not signed by the default issuer")
+}
+
+func TestRejectValidityTooShort(t *testing.T) {
+	testCtx := setup(t)
+	newCA := func() (*CertificateAuthorityImpl, *mockSA) {
+		ca := testCtx.newCA(t)
+		sa := &mockSA{}
+		ca.SA = sa
+		return ca, sa
+	}
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+
+	// The RSA profile is backdated by an hour, so a 30 minute expiry would
+	// produce a certificate that had expired before it was issued
+	rsaProfile := testCtx.caConfig.CFSSL.Signing.Profiles[rsaProfileName]
+	rsaProfile.ExpiryString = "30m"
+	ca, sa := newCA()
+	_, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate expiring before its issuance")
+	test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+	test.AssertEquals(t, len(sa.certificate.DER), 0)
+
+	// A profile expiry below the configured minimum is rejected too
+	rsaProfile.ExpiryString = "8760h"
+	testCtx.caConfig.MinCertificateValidity = cmd.ConfigDuration{Duration: 9000 * time.Hour}
+	ca, sa = newCA()
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate shorter than the minimum validity")
+	test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+	test.AssertEquals(t, len(sa.certificate.DER), 0)
+
+	testCtx.caConfig.MinCertificateValidity = cmd.ConfigDuration{Duration: 8760 * time.Hour}
+	ca, _ = newCA()
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue a certificate of the minimum validity")
+}
+
+func TestProfileIssuer(t *testing.T) {
+	testCtx := setup(t)
+	now, err := time.Parse(time.RFC3339, "2019-06-01T00:00:00Z")
+	test.AssertNotError(t, err, "Failed to parse time")
+	testCtx.fc.Set(now)
+	newIssuerCert, err := core.LoadCert("../test/test-ca2.pem")
+	test.AssertNotError(t, err, "Failed to load new cert")
+	testCtx.issuers = []Issuer{{Signer: caKey, Cert: caCert}, {Signer: caKey, Cert: newIssuerCert}}
+
+	testCtx.caConfig.Profiles = map[string]cmd.CAProfileConfig{
+		ecdsaProfileName: {Issuer: "not an issuer"},
+	}
+	_, err = NewCertificateAuthorityImpl(
 		testCtx.caConfig,
 		testCtx.fc,
 		testCtx.stats,
 		testCtx.issuers,
 		testCtx.keyPolicy,
 		testCtx.logger)
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
-	ca.SA = &mockSA{}
+	test.AssertError(t, err, "Created a CA with a profile mapped to an unknown issuer")
 
-	// Test that the CA rejects CSRs that would expire after the intermediate cert
-	csr, _ := x509.ParseCertificateRequest(ShortKeyCSR)
-	_, err = ca.IssueCertificate(ctx, *csr, 1001)
-	test.AssertError(t, err, "Issued a certificate with too short a key.")
+	testCtx.caConfig.Profiles = map[string]cmd.CAProfileConfig{
+		ecdsaProfileName: {Issuer: newIssuerCert.Subject.CommonName},
+	}
+	ca := testCtx.newCA(t)
+
+	for _, tc := range []struct {
+		csr    []byte
+		issuer *x509.Certificate
+	}{
+		// The unmapped RSA profile uses the first issuer
+		{CNandSANCSR, caCert},
+		// The mapped ECDSA profile uses the secondary issuer
+		{ECDSACSR, newIssuerCert},
+	} {
+		csr, _ := x509.ParseCertificateRequest(tc.csr)
+		issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to issue")
+		cert, err := x509.ParseCertificate(issuedCert.DER)
+		test.AssertNotError(t, err, "Certificate failed to parse")
+		test.AssertByteEquals(t, cert.RawIssuer, tc.issuer.RawSubject)
+		test.AssertNotError(t, cert.CheckSignatureFrom(tc.issuer), "Certificate not signed by the expected issuer")
+
+		// OCSP is signed by whichever issuer signed the certificate
+		ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+			CertDER: issuedCert.DER,
+			Status:  string(core.OCSPStatusGood),
+		})
+		test.AssertNotError(t, err, "Failed to generate OCSP")
+		parsed, err := ocsp.ParseResponseForCert(ocspResp, cert, tc.issuer)
+		test.AssertNotError(t, err, "Failed to parse or verify OCSP response")
+		test.AssertEquals(t, parsed.Status, ocsp.Good)
+	}
+}
+
+func TestIssueWithNotBefore(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	now, err := time.Parse(time.RFC3339, "2019-06-01T00:00:00Z")
+	test.AssertNotError(t, err, "Failed to parse time")
+	testCtx.fc.Set(now)
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+
+	// A past NotBefore is used in place of the clock, backdated as usual
+	notBefore := now.AddDate(0, -1, 0)
+	coreCert, err := ca.IssueCertificateWithOptions(ctx, *csr, 1001, IssueOptions{NotBefore: notBefore})
+	test.AssertNotError(t, err, "Failed to issue with a past NotBefore")
+	cert, err := x509.ParseCertificate(coreCert.DER)
+	test.AssertNotError(t, err, "Failed to parse cert")
+	test.AssertEquals(t, cert.NotBefore, notBefore.Add(-time.Hour))
+	test.AssertEquals(t, cert.NotAfter, notBefore.Add(-time.Hour).Add(8760*time.Hour))
+
+	// A future NotBefore is rejected
+	_, err = ca.IssueCertificateWithOptions(ctx, *csr, 1001, IssueOptions{NotBefore: now.Add(time.Minute)})
+	test.AssertError(t, err, "Issued with a future NotBefore")
 	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.InvalidValidity)
+
+	// As is one that would make the certificate outlive the issuer, which
+	// expires in October 2020
+	testCtx.fc.Set(now.AddDate(1, 0, 0))
+	_, err = ca.IssueCertificateWithOptions(ctx, *csr, 1001, IssueOptions{NotBefore: now.AddDate(0, 11, 0)})
+	test.AssertError(t, err, "Issued a certificate that outlives the issuer")
 }
 
-func TestAllowNoCN(t *testing.T) {
+func TestRequestedValidity(t *testing.T) {
 	testCtx := setup(t)
-	ca, err := NewCertificateAuthorityImpl(
+	testCtx.caConfig.MinRequestedValidity = cmd.ConfigDuration{Duration: 24 * time.Hour}
+	ca := testCtx.newCA(t)
+
+	issue := func(validity time.Duration) (*x509.Certificate, error) {
+		csr, _ := x509.ParseCertificateRequest(NoCNCSR)
+		issued, err := ca.IssueCertificateWithValidity(ctx, *csr, 1001, validity)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(issued.DER)
+		test.AssertNotError(t, err, "Certificate failed to parse")
+		return cert, nil
+	}
+
+	// A shorter validity period than the profile's is honored
+	cert, err := issue(7 * 24 * time.Hour)
+	test.AssertNotError(t, err, "Failed to issue a short-lived certificate")
+	test.AssertEquals(t, cert.NotAfter.Sub(cert.NotBefore), 7*24*time.Hour)
+
+	// Validity periods below the minimum are rejected
+	_, err = issue(time.Hour)
+	test.AssertError(t, err, "Issued a certificate shorter than the minimum validity period")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.InvalidValidity)
+
+	// Validity periods above the profile's are cut down to it
+	cert, err = issue(2 * 8760 * time.Hour)
+	test.AssertNotError(t, err, "Failed to issue a certificate with a long requested validity period")
+	test.AssertEquals(t, cert.NotAfter.Sub(cert.NotBefore), 8760*time.Hour)
+
+	// A short certificate may fit within the issuer where a full length one
+	// would not.
+	future, err := time.Parse(time.RFC3339, "2020-10-01T00:00:00Z")
+	test.AssertNotError(t, err, "Failed to parse time")
+	testCtx.fc.Set(future)
+	_, err = issue(0)
+	test.AssertError(t, err, "Issued a certificate that expires after the issuer")
+	test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+	cert, err = issue(7 * 24 * time.Hour)
+	test.AssertNotError(t, err, "Failed to issue a short-lived certificate near the issuer's expiry")
+	test.Assert(t, !cert.NotAfter.After(caCert.NotAfter), "Certificate outlives its issuer")
+	_, err = issue(60 * 24 * time.Hour)
+	test.AssertError(t, err, "Issued a certificate that expires after the issuer")
+	test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+}
+
+func TestMaxIssuancesPerRegistration(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.MaxIssuancesPerRegistration = 2
+	_, err := NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "CA accepted an issuance limit without a window")
+
+	testCtx.caConfig.IssuancesPerRegistrationWindow = cmd.ConfigDuration{Duration: time.Hour}
+	ca := testCtx.newCA(t)
+
+	issue := func(regID int64) error {
+		csr, _ := x509.ParseCertificateRequest(NoCNCSR)
+		_, err := ca.IssueCertificate(ctx, *csr, regID)
+		return err
+	}
+
+	test.AssertNotError(t, issue(1001), "Failed to issue first certificate")
+	testCtx.fc.Add(30 * time.Minute)
+	test.AssertNotError(t, issue(1001), "Failed to issue second certificate")
+	err = issue(1001)
+	test.AssertError(t, err, "Issued more certificates than the limit")
+	test.Assert(t, berrors.Is(err, berrors.RateLimit), "Incorrect error type returned")
+
+	// Other registrations are unaffected
+	test.AssertNotError(t, issue(1002), "Failed to issue for another registration")
+
+	// Once the first issuance leaves the window there's room for one more
+	testCtx.fc.Add(31 * time.Minute)
+	test.AssertNotError(t, issue(1001), "Failed to issue after the window moved on")
+	err = issue(1001)
+	test.Assert(t, berrors.Is(err, berrors.RateLimit), "Issued more certificates than the limit")
+
+	// Issuances that fail don't use up the limit
+	testCtx.issuers = []Issuer{{Signer: failingSigner{caKey}, Cert: caCert}}
+	failingCA := testCtx.newCA(t)
+	failingCA.IssuanceCounter = ca.IssuanceCounter
+	csr, _ := x509.ParseCertificateRequest(NoCNCSR)
+	for i := 0; i < 3; i++ {
+		_, err = failingCA.IssueCertificate(ctx, *csr, 1003)
+		test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+	}
+	test.AssertNotError(t, issue(1003), "Failed to issue after failed issuances")
+	test.AssertNotError(t, issue(1003), "Failed to issue after failed issuances")
+	test.Assert(t, berrors.Is(issue(1003), berrors.RateLimit), "Issued more certificates than the limit")
+}
+
+func TestMemoryIssuanceCounterConcurrency(t *testing.T) {
+	counter := NewMemoryIssuanceCounter()
+	now := time.Now()
+	reserved := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			ok, err := counter.Reserve(ctx, 1001, now.Add(-time.Hour), now, 2)
+			if err != nil {
+				t.Errorf("Failed to reserve issuance: %s", err)
+			}
+			reserved <- ok
+		}()
+	}
+	count := 0
+	for i := 0; i < 10; i++ {
+		if <-reserved {
+			count++
+		}
+	}
+	test.AssertEquals(t, count, 2)
+
+	// Releasing a reservation makes room for another
+	test.AssertNotError(t, counter.Release(ctx, 1001, now), "Failed to release issuance")
+	ok, err := counter.Reserve(ctx, 1001, now.Add(-time.Hour), now, 2)
+	test.AssertNotError(t, err, "Failed to reserve issuance")
+	test.Assert(t, ok, "Released reservation wasn't reusable")
+}
+
+func TestMaxIssuancesPerKey(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.MaxIssuancesPerKey = 2
+	ca := testCtx.newCA(t)
+
+	issue := func(csrDER []byte, regID int64) error {
+		csr, _ := x509.ParseCertificateRequest(csrDER)
+		_, err := ca.IssueCertificate(ctx, *csr, regID)
+		return err
+	}
+
+	// Without a counter the limit can't be enforced, so nothing is issued
+	test.AssertError(t, ca.Health(ctx), "CA with no KeyIssuanceCounter reported healthy")
+	err := issue(NoCNCSR, 1001)
+	test.AssertError(t, err, "Issued a certificate with no KeyIssuanceCounter")
+	test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+
+	ca.KeyIssuanceCounter = NewMemoryKeyIssuanceCounter()
+
+	// Issuances that fail don't use up the limit
+	testCtx.issuers = []Issuer{{Signer: failingSigner{caKey}, Cert: caCert}}
+	failingCA := testCtx.newCA(t)
+	failingCA.KeyIssuanceCounter = ca.KeyIssuanceCounter
+	csr, _ := x509.ParseCertificateRequest(NoCNCSR)
+	for i := 0; i < 3; i++ {
+		_, err = failingCA.IssueCertificate(ctx, *csr, 1001)
+		test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+	}
+
+	// The limit applies across registrations
+	test.AssertNotError(t, issue(NoCNCSR, 1001), "Failed to issue first certificate")
+	test.AssertNotError(t, issue(NoCNCSR, 1002), "Failed to issue second certificate")
+	err = issue(NoCNCSR, 1003)
+	test.AssertError(t, err, "Issued more certificates for a key than the limit")
+	test.Assert(t, berrors.Is(err, berrors.RateLimit), "Incorrect error type returned")
+
+	// Other keys are unaffected
+	test.AssertNotError(t, issue(ECDSACSR, 1001), "Failed to issue for another key")
+
+	testCtx.caConfig.MaxIssuancesPerKey = -1
+	_, err = NewCertificateAuthorityImpl(
 		testCtx.caConfig,
 		testCtx.fc,
 		testCtx.stats,
 		testCtx.issuers,
 		testCtx.keyPolicy,
 		testCtx.logger)
-	test.AssertNotError(t, err, "Couldn't create new CA")
+	test.AssertError(t, err, "CA accepted a negative issuance limit per key")
+}
+
+func TestMemoryKeyIssuanceCounterConcurrency(t *testing.T) {
+	counter := NewMemoryKeyIssuanceCounter()
+	reserved := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			ok, err := counter.Reserve(ctx, "digest", 2)
+			if err != nil {
+				t.Errorf("Failed to reserve issuance: %s", err)
+			}
+			reserved <- ok
+		}()
+	}
+	count := 0
+	for i := 0; i < 10; i++ {
+		if <-reserved {
+			count++
+		}
+	}
+	test.AssertEquals(t, count, 2)
+
+	// Releasing a reservation makes room for another
+	test.AssertNotError(t, counter.Release(ctx, "digest"), "Failed to release issuance")
+	ok, err := counter.Reserve(ctx, "digest", 2)
+	test.AssertNotError(t, err, "Failed to reserve issuance")
+	test.Assert(t, ok, "Released reservation wasn't reusable")
+}
+
+func TestShortKey(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	// Test that the CA rejects CSRs that would expire after the intermediate cert
+	csr, _ := x509.ParseCertificateRequest(ShortKeyCSR)
+	_, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate with too short a key.")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.BadCSRPublicKey)
+}
+
+func TestAllowNoCN(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
 	ca.forceCNFromSAN = false
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
-	ca.SA = &mockSA{}
 
 	csr, err := x509.ParseCertificateRequest(NoCNCSR)
 	test.AssertNotError(t, err, "Couldn't parse CSR")
@@ -584,170 +1555,3296 @@ func TestAllowNoCN(t *testing.T) {
 	test.AssertDeepEquals(t, actual, expected)
 }
 
-func TestLongCommonName(t *testing.T) {
+func TestRejectCNNotInSANs(t *testing.T) {
 	testCtx := setup(t)
-	ca, err := NewCertificateAuthorityImpl(
-		testCtx.caConfig,
-		testCtx.fc,
-		testCtx.stats,
-		testCtx.issuers,
-		testCtx.keyPolicy,
-		testCtx.logger)
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
-	ca.SA = &mockSA{}
+	testCtx.caConfig.MaxNames = 3
+	ca := testCtx.newCA(t)
+	ca.forceCNFromSAN = false
 
-	csr, _ := x509.ParseCertificateRequest(LongCNCSR)
+	csr, err := x509.ParseCertificateRequest(CNNotInSANCSR)
+	test.AssertNotError(t, err, "Couldn't parse CSR")
 	_, err = ca.IssueCertificate(ctx, *csr, 1001)
-	test.AssertError(t, err, "Issued a certificate with a CN over 64 bytes.")
+	test.AssertError(t, err, "Issued a certificate whose CN isn't among its SANs")
 	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRCNNotInSANs)
+
+	// A CN differing from a SAN only in case is fine
+	csr, err = x509.ParseCertificateRequest(CapitalizedCSR)
+	test.AssertNotError(t, err, "Couldn't parse CSR")
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue a certificate whose CN is among its SANs")
+
+	// When the CN is forced from the SANs, the missing CN is added to the
+	// SANs as before
+	ca.forceCNFromSAN = true
+	csr, err = x509.ParseCertificateRequest(CNNotInSANCSR)
+	test.AssertNotError(t, err, "Couldn't parse CSR")
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue a certificate when forcing the CN from the SANs")
 }
 
-func TestWrongSignature(t *testing.T) {
+func TestCNOnlyCSR(t *testing.T) {
 	testCtx := setup(t)
-	testCtx.caConfig.MaxNames = 3
-	ca, err := NewCertificateAuthorityImpl(
-		testCtx.caConfig,
-		testCtx.fc,
-		testCtx.stats,
-		testCtx.issuers,
-		testCtx.keyPolicy,
-		testCtx.logger)
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
-	ca.SA = &mockSA{}
+	ca := testCtx.newCA(t)
+	csr, err := x509.ParseCertificateRequest(NoSANCSR)
+	test.AssertNotError(t, err, "Couldn't parse CSR")
 
-	// x509.ParseCertificateRequest() does not check for invalid signatures...
-	csr, _ := x509.ParseCertificateRequest(WrongSignatureCSR)
+	// When the CN is forced from the SANs, a CN without any SANs is promoted
+	// into them
+	issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue a certificate for a CN-only CSR")
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertEquals(t, cert.Subject.CommonName, "not-example.com")
+	test.AssertDeepEquals(t, cert.DNSNames, []string{"not-example.com"})
 
-	_, err = ca.IssueCertificate(ctx, *csr, 1001)
-	if err == nil {
-		t.Fatalf("Issued a certificate based on a CSR with an invalid signature.")
+	// Otherwise, or when the CN must already be among the SANs, it's rejected
+	for _, configure := range []func(){
+		func() { ca.forceCNFromSAN = false },
+		func() { ca.forceCNFromSAN, ca.requireCNInSANs = true, true },
+	} {
+		configure()
+		_, err = ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertError(t, err, "Issued a certificate without SANs")
+		test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+		test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRCNNotInSANs)
 	}
 }
 
-func TestProfileSelection(t *testing.T) {
+// nameConstraintsExtensions returns a non-critical name constraints extension
+// permitting and excluding the given DNS subtrees, or nothing if there are
+// none, since crypto/x509 can't marshal excluded ones.
+func nameConstraintsExtensions(t *testing.T, permitted, excluded []string) []pkix.Extension {
+	if len(permitted) == 0 && len(excluded) == 0 {
+		return nil
+	}
+	subtrees := func(names []string) []generalSubtree {
+		var trees []generalSubtree
+		for _, name := range names {
+			trees = append(trees, generalSubtree{Base: asn1.RawValue{
+				Class: asn1.ClassContextSpecific,
+				Tag:   2,
+				Bytes: []byte(name),
+			}})
+		}
+		return trees
+	}
+	value, err := asn1.Marshal(nameConstraints{
+		Permitted: subtrees(permitted),
+		Excluded:  subtrees(excluded),
+	})
+	test.AssertNotError(t, err, "Failed to marshal name constraints")
+	return []pkix.Extension{{Id: oidExtensionNameConstraints, Value: value}}
+}
+
+func TestEnforceIssuerNameConstraints(t *testing.T) {
 	testCtx := setup(t)
-	testCtx.caConfig.MaxNames = 3
-	ca, _ := NewCertificateAuthorityImpl(
-		testCtx.caConfig,
-		testCtx.fc,
-		testCtx.stats,
-		testCtx.issuers,
-		testCtx.keyPolicy,
-		testCtx.logger)
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
-	ca.SA = &mockSA{}
+	testCtx.caConfig.EnforceIssuerNameConstraints = true
+	// CNandSANCSR is for not-example.com and www.not-example.com
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	issue := func(permitted, excluded []string) error {
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               caCert.Subject,
+			SubjectKeyId:          caCert.SubjectKeyId,
+			NotBefore:             testCtx.fc.Now().Add(-time.Hour),
+			NotAfter:              testCtx.fc.Now().Add(2 * 8760 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			ExtraExtensions:       nameConstraintsExtensions(t, permitted, excluded),
+		}
+		issuerDER, err := x509.CreateCertificate(rand.Reader, template, template, caKey.Public(), caKey)
+		test.AssertNotError(t, err, "Failed to create issuer certificate")
+		issuerCert, err := x509.ParseCertificate(issuerDER)
+		test.AssertNotError(t, err, "Failed to parse issuer certificate")
+		testCtx.issuers = []Issuer{{Signer: caKey, Cert: issuerCert}}
+		ca := testCtx.newCA(t)
+		_, err = ca.IssueCertificate(ctx, *csr, 1001)
+		return err
+	}
 
-	testCases := []struct {
-		CSR              []byte
-		ExpectedKeyUsage x509.KeyUsage
+	test.AssertNotError(t, issue(nil, nil), "Failed to issue without name constraints")
+	test.AssertNotError(t, issue([]string{"example.org", "not-example.com"}, nil),
+		"Failed to issue for permitted names")
+	test.AssertNotError(t, issue(nil, []string{"mail.not-example.com"}),
+		"Failed to issue for names that aren't excluded")
+	for _, tc := range []struct {
+		permitted, excluded []string
 	}{
-		{CNandSANCSR, x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment},
-		{ECDSACSR, x509.KeyUsageDigitalSignature},
+		{permitted: []string{"example.org"}},
+		{permitted: []string{".not-example.com"}},
+		{permitted: []string{"www.not-example.com"}},
+		{excluded: []string{"www.not-example.com"}},
+		{permitted: []string{"not-example.com"}, excluded: []string{".not-example.com"}},
+	} {
+		err := issue(tc.permitted, tc.excluded)
+		test.AssertError(t, err, fmt.Sprintf("Issued outside name constraints %+v", tc))
+		test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
 	}
 
-	for _, testCase := range testCases {
-		csr, err := x509.ParseCertificateRequest(testCase.CSR)
-		test.AssertNotError(t, err, "Cannot parse CSR")
+	// A wildcard covers every name it could match
+	issuer := &x509.Certificate{
+		Extensions: nameConstraintsExtensions(t, []string{"not-example.com"}, []string{"secret.not-example.com"}),
+	}
+	test.AssertNotError(t, checkNameConstraints(issuer, []string{"*.www.not-example.com"}),
+		"Rejected a wildcard that can't match an excluded name")
+	test.AssertError(t, checkNameConstraints(issuer, []string{"*.not-example.com"}),
+		"Accepted a wildcard matching an excluded name")
+	issuer.Extensions = nameConstraintsExtensions(t, []string{"www.not-example.com"}, nil)
+	test.AssertError(t, checkNameConstraints(issuer, []string{"*.not-example.com"}),
+		"Accepted a wildcard matching names that aren't permitted")
+}
+
+func TestRequireCNInSANs(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.MaxNames = 3
+	testCtx.caConfig.RequireCNInSANs = true
+	ca := testCtx.newCA(t)
+
+	csr, err := x509.ParseCertificateRequest(CNNotInSANCSR)
+	test.AssertNotError(t, err, "Couldn't parse CSR")
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate whose CN isn't among its SANs")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRCNNotInSANs)
+
+	// The CN and SANs are compared and issued lowercased, and SANs differing
+	// only in case are collapsed into one
+	csr, err = x509.ParseCertificateRequest(CapitalizedCSR)
+	test.AssertNotError(t, err, "Couldn't parse CSR")
+	issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue a certificate whose CN is among its SANs")
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertEquals(t, cert.Subject.CommonName, "capitalizedletters.com")
+	test.AssertDeepEquals(t, cert.DNSNames,
+		[]string{"capitalizedletters.com", "evenmorecaps.com", "morecaps.com"})
+}
+
+func TestFallbackProfile(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.ECDSAProfile = ""
+	_, err := NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created a CA without an ECDSA or fallback profile")
+
+	testCtx.caConfig.FallbackProfile = rsaProfileName
+	ca := testCtx.newCA(t)
+
+	// Keys with a profile configured for their type still use it
+	profile, err := ca.profileForKey(&rsa.PublicKey{})
+	test.AssertNotError(t, err, "Couldn't select a profile for an RSA key")
+	test.AssertEquals(t, profile, rsaProfileName)
+
+	// ECDSA keys fall back to the fallback profile
+	csr, err := x509.ParseCertificateRequest(ECDSACSR)
+	test.AssertNotError(t, err, "Couldn't parse CSR")
+	profile, err = ca.profileForKey(csr.PublicKey)
+	test.AssertNotError(t, err, "Couldn't select a profile for an ECDSA key")
+	test.AssertEquals(t, profile, rsaProfileName)
+
+	// No profile, not even the fallback, can sign a DSA key
+	_, err = ca.profileForKey(&dsa.PublicKey{})
+	test.AssertError(t, err, "Selected a profile for a DSA key")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.BadCSRPublicKey)
+	test.Assert(t, strings.Contains(err.Error(), "dsa.PublicKey"), "Error doesn't name the key type")
+
+	csr.PublicKey = &dsa.PublicKey{}
+	csr.PublicKeyAlgorithm = x509.DSA
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate for a DSA key")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.BadCSRPublicKey)
+	test.Assert(t, strings.Contains(err.Error(), "dsa.PublicKey"), "Error doesn't name the key type")
+}
+
+func TestDefaultProfile(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.ECDSAProfile = ""
+	testCtx.caConfig.UseDefaultProfile = true
+	newCA := func() (*CertificateAuthorityImpl, error) {
+		return NewCertificateAuthorityImpl(
+			testCtx.caConfig,
+			testCtx.fc,
+			testCtx.stats,
+			testCtx.issuers,
+			testCtx.keyPolicy,
+			testCtx.logger)
+	}
+
+	// The test config's default profile doesn't restrict its usages, so it
+	// can't be used to sign keys without a named profile.
+	_, err := newCA()
+	test.AssertError(t, err, "Created a CA using a default profile without extended key usages")
+
+	defaultProfile := &cfsslConfig.SigningProfile{
+		Usage:        []string{"digital signature", "any"},
+		OCSP:         "http://not-example.com/default-ocsp",
+		ExpiryString: "2160h",
+		CSRWhitelist: &cfsslConfig.CSRWhitelist{
+			PublicKeyAlgorithm: true,
+			PublicKey:          true,
+			SignatureAlgorithm: true,
+		},
+		ClientProvidesSerialNumbers: true,
+	}
+	testCtx.caConfig.CFSSL.Signing.Default = defaultProfile
+	_, err = newCA()
+	test.AssertError(t, err, "Created a CA using a default profile with anyExtendedKeyUsage")
+
+	defaultProfile.Usage = []string{"digital signature", "server auth"}
+	ca := testCtx.newCA(t)
+
+	// RSA keys still use their own profile
+	profile, err := ca.profileForKey(&rsa.PublicKey{})
+	test.AssertNotError(t, err, "Couldn't select a profile for an RSA key")
+	test.AssertEquals(t, profile, rsaProfileName)
+
+	// ECDSA keys are signed with the default profile
+	csr, err := x509.ParseCertificateRequest(ECDSACSR)
+	test.AssertNotError(t, err, "Couldn't parse CSR")
+	issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue with the default profile")
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertEquals(t, cert.KeyUsage, x509.KeyUsageDigitalSignature)
+	test.AssertDeepEquals(t, cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+	test.AssertEquals(t, cert.IsCA, false)
+	test.AssertDeepEquals(t, cert.OCSPServer, []string{"http://not-example.com/default-ocsp"})
+	test.AssertEquals(t, cert.NotAfter.Sub(cert.NotBefore), 2160*time.Hour)
+	test.AssertEquals(t, cert.SerialNumber.Bytes()[0], byte(testCtx.caConfig.SerialPrefix))
+
+	// Per-request changes apply to the default profile too
+	issuedCert, err = ca.IssueCertificateWithOptions(ctx, *csr, 1001, IssueOptions{Validity: 24 * time.Hour})
+	test.AssertNotError(t, err, "Failed to issue a shortened certificate with the default profile")
+	cert, err = x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertEquals(t, cert.NotAfter.Sub(cert.NotBefore), 24*time.Hour)
+	test.AssertDeepEquals(t, cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+	test.AssertDeepEquals(t, cert.OCSPServer, []string{"http://not-example.com/default-ocsp"})
+}
+
+func TestRejectDisallowedSubjectAttributes(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.PermittedSubjectAttributes = []string{"Organization"}
+	_, err := NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created a CA permitting an unknown subject attribute")
+
+	testCtx.caConfig.PermittedSubjectAttributes = []string{"OU"}
+	testCtx.caConfig.RejectDisallowedSubjectAttributes = true
+	ca := testCtx.newCA(t)
+
+	csr, err := x509.ParseCertificateRequest(OrganizationCSR)
+	test.AssertNotError(t, err, "Couldn't parse CSR")
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate for a CSR requesting a disallowed subject attribute")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRSubjectAttribute)
+	test.Assert(t, strings.Contains(err.Error(), "subject attribute O"), "Error doesn't name the attribute")
+
+	// Once permitted, the Organization is accepted but still left out of the
+	// certificate
+	ca.permittedSubjectAttributes["O"] = true
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue a certificate requesting a permitted subject attribute")
+	parsed, err := x509.ParseCertificate(cert.DER)
+	test.AssertNotError(t, err, "Couldn't parse certificate")
+	test.AssertEquals(t, len(parsed.Subject.Organization), 0)
+
+	// Without strict mode, the Organization is silently stripped
+	ca.rejectDisallowedSubjectAttributes = false
+	delete(ca.permittedSubjectAttributes, "O")
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue a certificate outside of strict mode")
+}
+
+func TestMaxCSRSize(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.MaxCSRBytes = 2048
+	testCtx.caConfig.MaxCSRExtensions = 4
+	ca := testCtx.newCA(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Couldn't generate key")
+	makeCSR := func(exts []pkix.Extension) *x509.CertificateRequest {
+		csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:         pkix.Name{CommonName: "not-example.com"},
+			DNSNames:        []string{"not-example.com"},
+			ExtraExtensions: exts,
+		}, key)
+		test.AssertNotError(t, err, "Couldn't create CSR")
+		csr, err := x509.ParseCertificateRequest(csrDER)
+		test.AssertNotError(t, err, "Couldn't parse CSR")
+		return csr
+	}
+
+	// A CSR within both limits is issued
+	_, err = ca.IssueCertificate(ctx, *makeCSR(nil), 1001)
+	test.AssertNotError(t, err, "Failed to issue a certificate for a small CSR")
+
+	// A single giant extension takes the CSR over the byte limit
+	csr := makeCSR([]pkix.Extension{{
+		Id:    asn1.ObjectIdentifier{1, 2, 3, 4},
+		Value: make([]byte, 4096),
+	}})
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate for an oversized CSR")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRTooLarge)
+	test.AssertContains(t, err.Error(), "bytes")
+
+	// As do too many small ones, counting the SAN extension
+	var exts []pkix.Extension
+	for i := 0; i < 4; i++ {
+		exts = append(exts, pkix.Extension{
+			Id:    asn1.ObjectIdentifier{1, 2, 3, 4, i},
+			Value: []byte{0x05, 0x00},
+		})
+	}
+	csr = makeCSR(exts)
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate for a CSR with too many extensions")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRTooLarge)
+	test.AssertContains(t, err.Error(), "extensions")
+}
+
+func TestIDNNormalization(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	// The PA only accepts punycode labels when IDNA support is enabled
+	_ = features.Set(map[string]bool{"IDNASupport": true})
+	defer features.Reset()
+
+	csr, err := x509.ParseCertificateRequest(NoCNCSR)
+	test.AssertNotError(t, err, "Couldn't parse CSR")
+	csr.DNSNames = []string{"Bücher.not-example.com", "www.not-example.com"}
+	issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to sign certificate")
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertEquals(t, cert.Subject.CommonName, "xn--bcher-kva.not-example.com")
+	sort.Strings(cert.DNSNames)
+	test.AssertDeepEquals(t, cert.DNSNames, []string{"www.not-example.com", "xn--bcher-kva.not-example.com"})
+
+	csr, err = x509.ParseCertificateRequest(NoCNCSR)
+	test.AssertNotError(t, err, "Couldn't parse CSR")
+	csr.DNSNames = []string{strings.Repeat("a", 2100) + "\U0010ffff.not-example.com"}
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate for a name that can't be converted to an A-label")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRInvalidName)
+}
+
+// allowListPA is a PolicyAuthority only willing to issue for the names in
+// allowed.
+type allowListPA struct {
+	core.PolicyAuthority
+	allowed map[string]bool
+}
+
+func (pa allowListPA) WillingToIssue(id core.AcmeIdentifier) error {
+	if !pa.allowed[id.Value] {
+		return fmt.Errorf("not willing to issue for %q", id.Value)
+	}
+	return nil
+}
+
+func TestNameTransform(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+	ca.PA = allowListPA{PolicyAuthority: testCtx.pa, allowed: map[string]bool{"real.example": true}}
+
+	issueFor := func(name string) (core.Certificate, error) {
+		csr, err := x509.ParseCertificateRequest(NoCNCSR)
+		test.AssertNotError(t, err, "Couldn't parse CSR")
+		csr.DNSNames = []string{name}
+		return ca.IssueCertificate(ctx, *csr, 1001)
+	}
+	issue := func() (core.Certificate, error) {
+		return issueFor("alias.example")
+	}
+
+	// By default names are checked and issued for as requested
+	_, err := issue()
+	test.AssertError(t, err, "Issued a certificate for a name the PA forbids")
+
+	ca.NameTransform = func(name string) (string, error) {
+		if name == "alias.example" {
+			return "real.example", nil
+		}
+		return name, nil
+	}
+	issuedCert, err := issue()
+	test.AssertNotError(t, err, "Failed to issue for a transformed name")
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertEquals(t, cert.Subject.CommonName, "real.example")
+	test.AssertDeepEquals(t, cert.DNSNames, []string{"real.example"})
+
+	// Names are normalized before they're transformed, so the transform
+	// needn't handle every case variation
+	issuedCert, err = issueFor("Alias.EXAMPLE")
+	test.AssertNotError(t, err, "Failed to issue for a mixed-case transformed name")
+	cert, err = x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertDeepEquals(t, cert.DNSNames, []string{"real.example"})
+
+	ca.NameTransform = func(name string) (string, error) {
+		return "", fmt.Errorf("unknown alias %q", name)
+	}
+	_, err = issue()
+	test.AssertError(t, err, "Issued a certificate for a name that couldn't be transformed")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+}
+
+func TestLongCommonName(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	csr, _ := x509.ParseCertificateRequest(LongCNCSR)
+	_, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate with a CN over 64 bytes.")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRLongCN)
+}
+
+func TestOmitLongCN(t *testing.T) {
+	testCtx := setup(t)
+
+	// By default a SAN too long to be the CN can't be issued for on its own
+	csr, _ := x509.ParseCertificateRequest(LongSANCSR)
+	_, err := testCtx.newCA(t).IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate with a CN over 64 bytes.")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRLongCN)
+
+	testCtx.caConfig.OmitLongCN = true
+	csr, _ = x509.ParseCertificateRequest(LongSANCSR)
+	issuedCert, err := testCtx.newCA(t).IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to sign certificate")
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertEquals(t, cert.Subject.CommonName, "")
+	test.AssertDeepEquals(t, cert.DNSNames, []string{"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa.not-example.com"})
+
+	// A CN in the CSR is still length checked
+	csr, _ = x509.ParseCertificateRequest(LongCNCSR)
+	_, err = testCtx.newCA(t).IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate with a CN over 64 bytes.")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRLongCN)
+}
+
+func TestWrongSignature(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.MaxNames = 3
+	ca := testCtx.newCA(t)
+
+	// x509.ParseCertificateRequest() does not check for invalid signatures...
+	csr, _ := x509.ParseCertificateRequest(WrongSignatureCSR)
+
+	_, err := ca.IssueCertificate(ctx, *csr, 1001)
+	if err == nil {
+		t.Fatalf("Issued a certificate based on a CSR with an invalid signature.")
+	}
+}
+
+func TestSHA1Signature(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	csr, err := x509.ParseCertificateRequest(SHA1SignatureCSR)
+	test.AssertNotError(t, err, "Cannot parse CSR")
+	test.AssertEquals(t, csr.SignatureAlgorithm, x509.SHA1WithRSA)
+
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate based on a CSR with a SHA-1 signature")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.BadCSRSignatureAlgorithm)
+}
+
+func TestRejectCSRBasicConstraints(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.RejectCSRBasicConstraints = true
+	ca := testCtx.newCA(t)
+
+	csr, err := x509.ParseCertificateRequest(PathLenCSR)
+	test.AssertNotError(t, err, "Cannot parse CSR")
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate based on a CSR requesting a pathLenConstraint")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRExtension)
+
+	csr, err = x509.ParseCertificateRequest(CNandSANCSR)
+	test.AssertNotError(t, err, "Cannot parse CSR")
+	issued, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue certificate")
+	cert, err := x509.ParseCertificate(issued.DER)
+	test.AssertNotError(t, err, "Failed to parse certificate")
+	test.Assert(t, cert.BasicConstraintsValid, "Leaf is missing basicConstraints")
+	test.Assert(t, !cert.IsCA, "Leaf has cA set")
+	test.Assert(t, cert.MaxPathLen <= 0 && !cert.MaxPathLenZero, "Leaf has a pathLenConstraint")
+}
+
+func TestRejectCSRRequestingCA(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	// Rejected even without RejectCSRBasicConstraints
+	mockLog := testCtx.logger.(*blog.Mock)
+	mockLog.Clear()
+	csr, err := x509.ParseCertificateRequest(CATrueCSR)
+	test.AssertNotError(t, err, "Cannot parse CSR")
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate based on a CSR requesting cA")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRExtension)
+	test.AssertEquals(t, len(mockLog.GetAllMatching(
+		fmt.Sprintf(`^ERR: \[AUDIT\] Possible attack: CSR requests a CA certificate: csr=\[%s\]$`,
+			core.Fingerprint256(CATrueCSR)))), 1)
+}
+
+func TestProfileSelection(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.MaxNames = 3
+	ca := testCtx.newCA(t)
+
+	mockLog := testCtx.logger.(*blog.Mock)
+
+	testCases := []struct {
+		CSR              []byte
+		ExpectedKeyUsage x509.KeyUsage
+		ExpectedProfile  string
+	}{
+		{CNandSANCSR, x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment, rsaProfileName},
+		{ECDSACSR, x509.KeyUsageDigitalSignature, ecdsaProfileName},
+	}
+
+	for _, testCase := range testCases {
+		csr, err := x509.ParseCertificateRequest(testCase.CSR)
+		test.AssertNotError(t, err, "Cannot parse CSR")
 
 		// Sign CSR
+		mockLog.Clear()
+		issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to sign certificate")
+
+		// Verify cert contents
+		cert, err := x509.ParseCertificate(issuedCert.DER)
+		test.AssertNotError(t, err, "Certificate failed to parse")
+
+		t.Logf("expected key usage %v, got %v", testCase.ExpectedKeyUsage, cert.KeyUsage)
+		test.AssertEquals(t, cert.KeyUsage, testCase.ExpectedKeyUsage)
+
+		// The profile used is recorded in the issuance audit log
+		lines := mockLog.GetAllMatching(`Certificate issuance - issued JSON=`)
+		test.AssertEquals(t, len(lines), 1)
+		var event issuanceEvent
+		err = json.Unmarshal([]byte(lines[0][strings.Index(lines[0], "JSON=")+5:]), &event)
+		test.AssertNotError(t, err, "Failed to unmarshal issuance event")
+		test.AssertEquals(t, event.Profile, testCase.ExpectedProfile)
+	}
+}
+
+func TestIssueCertificateWithProfile(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	testCases := []struct {
+		name             string
+		CSR              []byte
+		profile          string
+		ExpectedKeyUsage x509.KeyUsage
+		expectedErr      bool
+	}{
+		{"RSA key, RSA profile", CNandSANCSR, rsaProfileName, x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment, false},
+		{"ECDSA key, ECDSA profile", ECDSACSR, ecdsaProfileName, x509.KeyUsageDigitalSignature, false},
+		{"RSA key, ECDSA profile", CNandSANCSR, ecdsaProfileName, 0, true},
+		{"ECDSA key, RSA profile", ECDSACSR, rsaProfileName, 0, true},
+		{"Unknown profile", CNandSANCSR, "unknown", 0, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			csr, err := x509.ParseCertificateRequest(tc.CSR)
+			test.AssertNotError(t, err, "Cannot parse CSR")
+			issuedCert, err := ca.IssueCertificateWithProfile(ctx, *csr, 1001, tc.profile)
+			if tc.expectedErr {
+				test.AssertError(t, err, "Issued with a profile incompatible with the key type")
+				test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+				test.AssertEquals(t, berrors.ReasonOf(err), berrors.IncompatibleProfile)
+				return
+			}
+			test.AssertNotError(t, err, "Failed to sign certificate")
+			cert, err := x509.ParseCertificate(issuedCert.DER)
+			test.AssertNotError(t, err, "Certificate failed to parse")
+			test.AssertEquals(t, cert.KeyUsage, tc.ExpectedKeyUsage)
+		})
+	}
+}
+
+func TestEmailProfile(t *testing.T) {
+	testCtx := setup(t)
+	const emailProfileName = "emailEE"
+	emailProfile := *testCtx.caConfig.CFSSL.Signing.Profiles[rsaProfileName]
+	emailProfile.Usage = []string{"digital signature", "key encipherment", "client auth", "email protection"}
+	testCtx.caConfig.CFSSL.Signing.Profiles[emailProfileName] = &emailProfile
+	testCtx.caConfig.Profiles = map[string]cmd.CAProfileConfig{
+		emailProfileName: {EmailProfile: true},
+	}
+	testCtx.caConfig.MaxNames = 1
+	ca := testCtx.newCA(t)
+	publisher := &mocks.Publisher{}
+	ca.Publisher = publisher
+
+	csr, _ := x509.ParseCertificateRequest(EmailCSR)
+	issuedCert, err := ca.IssueCertificateWithProfile(ctx, *csr, 1001, emailProfileName)
+	test.AssertNotError(t, err, "Failed to sign certificate")
+	// Certificates that can't be used by TLS servers aren't submitted to CT
+	ca.inFlight.Wait()
+	test.AssertEquals(t, len(publisher.Submitted), 0)
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertDeepEquals(t, cert.EmailAddresses, []string{"alice@not-example.com"})
+	test.AssertEquals(t, len(cert.DNSNames), 0)
+	test.AssertEquals(t, cert.Subject.CommonName, "alice@not-example.com")
+	emailProtection := false
+	for _, eku := range cert.ExtKeyUsage {
+		emailProtection = emailProtection || eku == x509.ExtKeyUsageEmailProtection
+	}
+	test.Assert(t, emailProtection, "Certificate doesn't have the emailProtection EKU")
+
+	// Certificates from other profiles still are
+	csr, _ = x509.ParseCertificateRequest(NoCNCSR)
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to sign certificate")
+	ca.inFlight.Wait()
+	test.AssertEquals(t, len(publisher.Submitted), 1)
+
+	// Email CSRs aren't accepted without the email profile
+	csr, _ = x509.ParseCertificateRequest(EmailCSR)
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued for an email address without the email profile")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+
+	// DNS names aren't accepted with it
+	csr, _ = x509.ParseCertificateRequest(CNandSANCSR)
+	_, err = ca.IssueCertificateWithProfile(ctx, *csr, 1001, emailProfileName)
+	test.AssertError(t, err, "Issued for a DNS name with the email profile")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRDNSName)
+
+	// Email addresses count towards MaxNames
+	csr, _ = x509.ParseCertificateRequest(TwoEmailsCSR)
+	_, err = ca.IssueCertificateWithProfile(ctx, *csr, 1001, emailProfileName)
+	test.AssertError(t, err, "Issued for more email addresses than MaxNames")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRTooManyNames)
+
+	// An email profile can't be selected by key type
+	testCtx.caConfig.RSAProfile = emailProfileName
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created a CA that selects an email profile by key type")
+}
+
+func benchmarkIssuance(b *testing.B, issue func(*CertificateAuthorityImpl, x509.CertificateRequest) (core.Certificate, error)) {
+	testCtx := setup(b)
+	ca := testCtx.newCA(b)
+	csr, err := x509.ParseCertificateRequest(CNandSANCSR)
+	if err != nil {
+		b.Fatalf("Cannot parse CSR: %s", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := issue(ca, *csr); err != nil {
+			b.Fatalf("Failed to sign certificate: %s", err)
+		}
+	}
+}
+
+func BenchmarkIssueCertificate(b *testing.B) {
+	benchmarkIssuance(b, func(ca *CertificateAuthorityImpl, csr x509.CertificateRequest) (core.Certificate, error) {
+		return ca.IssueCertificate(ctx, csr, 1001)
+	})
+}
+
+func BenchmarkIssueCertificateWithProfile(b *testing.B) {
+	benchmarkIssuance(b, func(ca *CertificateAuthorityImpl, csr x509.CertificateRequest) (core.Certificate, error) {
+		return ca.IssueCertificateWithProfile(ctx, csr, 1001, rsaProfileName)
+	})
+}
+
+func TestProfileUsages(t *testing.T) {
+	testCtx := setup(t)
+	rsaProfile := testCtx.caConfig.CFSSL.Signing.Profiles[rsaProfileName]
+	rsaProfile.Usage = append(rsaProfile.Usage, "client auth")
+
+	newCA := func(issuers []Issuer) *CertificateAuthorityImpl {
+		c := *testCtx
+		c.issuers = issuers
+		return c.newCA(t)
+	}
+
+	// An issuer without EKU restrictions can issue from a profile that adds
+	// client auth
+	ca := newCA(testCtx.issuers)
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to sign certificate")
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertDeepEquals(t, cert.ExtKeyUsage,
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth})
+
+	// An issuer restricted to server auth can't
+	template := *caCert
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	restrictedDER, err := x509.CreateCertificate(rand.Reader, &template, &template, caKey.Public(), caKey)
+	test.AssertNotError(t, err, "Failed to create restricted issuer certificate")
+	restrictedCert, err := x509.ParseCertificate(restrictedDER)
+	test.AssertNotError(t, err, "Failed to parse restricted issuer certificate")
+	ca = newCA([]Issuer{{Signer: caKey, Cert: restrictedCert}})
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a client auth certificate from a server auth issuer")
+	test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+
+	// ECDSA certificates only request server auth, which it permits
+	csr, _ = x509.ParseCertificateRequest(ECDSACSR)
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to sign certificate from a restricted issuer")
+}
+
+func TestOCSPNoCheck(t *testing.T) {
+	hasNoCheck := func(cert *x509.Certificate) bool {
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(oidOCSPNoCheck) {
+				return true
+			}
+		}
+		return false
+	}
+
+	testCases := []struct {
+		name      string
+		usages    []string
+		noCheck   bool
+		expectErr bool
+	}{
+		{"server", []string{"digital signature", "key encipherment", "server auth"}, false, false},
+		{"server with no check", []string{"digital signature", "key encipherment", "server auth"}, true, true},
+		{"OCSP responder", []string{"digital signature", "ocsp signing"}, true, false},
+		{"OCSP responder without no check", []string{"digital signature", "ocsp signing"}, false, true},
+		{"OCSP responder and server", []string{"digital signature", "ocsp signing", "server auth"}, true, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testCtx := setup(t)
+			rsaProfile := testCtx.caConfig.CFSSL.Signing.Profiles[rsaProfileName]
+			rsaProfile.Usage = tc.usages
+			rsaProfile.OCSPNoCheck = tc.noCheck
+			ca := testCtx.newCA(t)
+
+			csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+			issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+			if tc.expectErr {
+				test.AssertError(t, err, "Issued a certificate from a misconfigured profile")
+				test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+				return
+			}
+			test.AssertNotError(t, err, "Failed to sign certificate")
+			cert, err := x509.ParseCertificate(issuedCert.DER)
+			test.AssertNotError(t, err, "Certificate failed to parse")
+			test.AssertEquals(t, hasNoCheck(cert), tc.noCheck)
+		})
+	}
+}
+
+func TestKeyUsageCompatibility(t *testing.T) {
+	testCases := []struct {
+		name      string
+		csr       []byte
+		profile   string
+		usage     string
+		checked   bool
+		expectErr bool
+	}{
+		{"ECDSA key agreement", ECDSACSR, ecdsaProfileName, "key agreement", true, false},
+		{"ECDSA key encipherment", ECDSACSR, ecdsaProfileName, "key encipherment", true, true},
+		{"RSA key encipherment", CNandSANCSR, rsaProfileName, "key encipherment", true, false},
+		{"RSA key agreement", CNandSANCSR, rsaProfileName, "key agreement", true, true},
+		{"RSA key agreement unchecked", CNandSANCSR, rsaProfileName, "key agreement", false, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testCtx := setup(t)
+			profile := testCtx.caConfig.CFSSL.Signing.Profiles[tc.profile]
+			profile.Usage = []string{"digital signature", tc.usage, "server auth"}
+			testCtx.caConfig.Profiles = map[string]cmd.CAProfileConfig{
+				tc.profile: {CheckKeyUsageCompatibility: tc.checked},
+			}
+			ca := testCtx.newCA(t)
+
+			csr, _ := x509.ParseCertificateRequest(tc.csr)
+			issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+			if tc.expectErr {
+				test.AssertError(t, err, "Issued a certificate with usages its key can't perform")
+				test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+				return
+			}
+			test.AssertNotError(t, err, "Failed to sign certificate")
+			cert, err := x509.ParseCertificate(issuedCert.DER)
+			test.AssertNotError(t, err, "Certificate failed to parse")
+			test.Assert(t, cert.KeyUsage&cfsslConfig.KeyUsage[tc.usage] != 0, "Certificate is missing the profile's key usage")
+		})
+	}
+}
+
+func TestProfilePolicies(t *testing.T) {
+	testCtx := setup(t)
+	rsaProfile := testCtx.caConfig.CFSSL.Signing.Profiles[rsaProfileName]
+	dvOID := asn1.ObjectIdentifier{2, 23, 140, 1, 2, 1}
+	cpsOID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 44947, 1, 1, 1}
+	cpsURI := "http://cps.not-example.com/"
+
+	rsaProfile.Policies = []cfsslConfig.CertificatePolicy{
+		{ID: cfsslConfig.OID(dvOID)},
+		{
+			ID: cfsslConfig.OID(cpsOID),
+			Qualifiers: []cfsslConfig.CertificatePolicyQualifier{
+				{Type: "id-qt-cps", Value: cpsURI},
+			},
+		},
+	}
+	ca := testCtx.newCA(t)
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to sign certificate")
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+
+	type policyQualifier struct {
+		ID    asn1.ObjectIdentifier
+		Value string `asn1:"ia5"`
+	}
+	type policyInformation struct {
+		ID         asn1.ObjectIdentifier
+		Qualifiers []policyQualifier `asn1:"optional"`
+	}
+	var policies []policyInformation
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidCertificatePolicies) {
+			_, err = asn1.Unmarshal(ext.Value, &policies)
+			test.AssertNotError(t, err, "Failed to parse certificate policies")
+		}
+	}
+	test.AssertEquals(t, len(policies), 2)
+	test.Assert(t, policies[0].ID.Equal(dvOID), "Wrong first policy OID")
+	test.AssertEquals(t, len(policies[0].Qualifiers), 0)
+	test.Assert(t, policies[1].ID.Equal(cpsOID), "Wrong second policy OID")
+	test.AssertEquals(t, len(policies[1].Qualifiers), 1)
+	test.Assert(t, policies[1].Qualifiers[0].ID.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}),
+		"Wrong policy qualifier type")
+	test.AssertEquals(t, policies[1].Qualifiers[0].Value, cpsURI)
+
+	for _, policy := range []cfsslConfig.CertificatePolicy{
+		{ID: cfsslConfig.OID(asn1.ObjectIdentifier{1, 50})},
+		{ID: cfsslConfig.OID(asn1.ObjectIdentifier{3, 1})},
+		{
+			ID: cfsslConfig.OID(cpsOID),
+			Qualifiers: []cfsslConfig.CertificatePolicyQualifier{
+				{Type: "id-qt-cps", Value: "not a URI"},
+			},
+		},
+		{
+			ID: cfsslConfig.OID(cpsOID),
+			Qualifiers: []cfsslConfig.CertificatePolicyQualifier{
+				{Type: "id-qt-cps", Value: "http://cps.exämple.com/"},
+			},
+		},
+	} {
+		rsaProfile.Policies = []cfsslConfig.CertificatePolicy{policy}
+		_, err = NewCertificateAuthorityImpl(
+			testCtx.caConfig,
+			testCtx.fc,
+			testCtx.stats,
+			testCtx.issuers,
+			testCtx.keyPolicy,
+			testCtx.logger)
+		test.AssertError(t, err, fmt.Sprintf("Created a CA with invalid policy %+v", policy))
+		test.Assert(t, strings.Contains(err.Error(), rsaProfileName), "Error doesn't name the bad profile")
+	}
+}
+
+func TestValidateProfiles(t *testing.T) {
+	testCases := []struct {
+		name        string
+		modify      func(*cmd.CAConfig)
+		expectedErr string
+	}{
+		{
+			name:        "nonexistent RSA profile",
+			modify:      func(c *cmd.CAConfig) { c.RSAProfile = "nonexistent" },
+			expectedErr: `profile "nonexistent" is selected by key type but isn't a CFSSL signing profile`,
+		},
+		{
+			name: "missing expiry",
+			modify: func(c *cmd.CAConfig) {
+				c.CFSSL.Signing.Profiles[rsaProfileName].ExpiryString = ""
+			},
+			expectedErr: `profile "rsaEE" has no expiry`,
+		},
+		{
+			name: "invalid OCSP URL",
+			modify: func(c *cmd.CAConfig) {
+				c.CFSSL.Signing.Profiles[rsaProfileName].OCSP = "ocsp.example.com"
+			},
+			expectedErr: `profile "rsaEE" has invalid URL "ocsp.example.com"`,
+		},
+		{
+			name: "invalid issuer URL",
+			modify: func(c *cmd.CAConfig) {
+				c.CFSSL.Signing.Profiles[ecdsaProfileName].IssuerURL = []string{"ftp://example.com/issuer"}
+			},
+			expectedErr: `profile "ecdsaEE" has invalid URL "ftp://example.com/issuer"`,
+		},
+		{
+			name: "validity longer than issuer's",
+			modify: func(c *cmd.CAConfig) {
+				c.CFSSL.Signing.Profiles[rsaProfileName].ExpiryString = "87600h"
+			},
+			expectedErr: `profile "rsaEE" has a validity period of 87600h0m0s, longer than that of issuer "happy hacker fake CA"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testCtx := setup(t)
+			tc.modify(&testCtx.caConfig)
+			_, err := NewCertificateAuthorityImpl(
+				testCtx.caConfig,
+				testCtx.fc,
+				testCtx.stats,
+				testCtx.issuers,
+				testCtx.keyPolicy,
+				testCtx.logger)
+			test.AssertError(t, err, "Created a CA with a misconfigured profile")
+			test.AssertEquals(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func countMustStaple(t *testing.T, cert *x509.Certificate) (count int) {
+	oidTLSFeature := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidTLSFeature) {
+			test.Assert(t, !ext.Critical, "Extension was marked critical")
+			test.AssertByteEquals(t, ext.Value, mustStapleFeatureValue)
+			count++
+		}
+	}
+	return count
+}
+
+func TestExtensions(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.MaxNames = 3
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stats := mock_metrics.NewMockScope(ctrl)
+
+	testCtx.stats = stats
+	ca := testCtx.newCA(t)
+
+	mustStapleCSR, err := x509.ParseCertificateRequest(MustStapleCSR)
+	test.AssertNotError(t, err, "Error parsing MustStapleCSR")
+
+	duplicateMustStapleCSR, err := x509.ParseCertificateRequest(DuplicateMustStapleCSR)
+	test.AssertNotError(t, err, "Error parsing DuplicateMustStapleCSR")
+
+	tlsFeatureUnknownCSR, err := x509.ParseCertificateRequest(TLSFeatureUnknownCSR)
+	test.AssertNotError(t, err, "Error parsing TLSFeatureUnknownCSR")
+
+	unsupportedExtensionCSR, err := x509.ParseCertificateRequest(UnsupportedExtensionCSR)
+	test.AssertNotError(t, err, "Error parsing UnsupportedExtensionCSR")
+
+	sign := func(csr *x509.CertificateRequest) *x509.Certificate {
+		coreCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to issue")
+		cert, err := x509.ParseCertificate(coreCert.DER)
+		test.AssertNotError(t, err, "Error parsing certificate produced by CA")
+		return cert
+	}
+
+	// With ca.enableMustStaple = false, should issue successfully and not add
+	// Must Staple.
+	stats.EXPECT().Inc(metricCSRExtensionTLSFeature, int64(1)).Return(nil)
+	stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesRSA, int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesExplicitCN, int64(1)).Return(nil)
+	noStapleCert := sign(mustStapleCSR)
+	test.AssertEquals(t, countMustStaple(t, noStapleCert), 0)
+
+	// With ca.enableMustStaple = true, a TLS feature extension should put a must-staple
+	// extension into the cert
+	ca.enableMustStaple = true
+	stats.EXPECT().Inc(metricCSRExtensionTLSFeature, int64(1)).Return(nil)
+	stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesRSA, int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesExplicitCN, int64(1)).Return(nil)
+	singleStapleCert := sign(mustStapleCSR)
+	test.AssertEquals(t, countMustStaple(t, singleStapleCert), 1)
+
+	// Even if there are multiple TLS Feature extensions, only one extension should be included
+	stats.EXPECT().Inc(metricCSRExtensionTLSFeature, int64(1)).Return(nil)
+	stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesRSA, int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesExplicitCN, int64(1)).Return(nil)
+	duplicateMustStapleCert := sign(duplicateMustStapleCSR)
+	test.AssertEquals(t, countMustStaple(t, duplicateMustStapleCert), 1)
+
+	// ... but if it doesn't ask for stapling, there should be an error
+	stats.EXPECT().Inc(metricCSRExtensionTLSFeature, int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricCSRExtensionTLSFeatureInvalid, int64(1)).Return(nil)
+	_, err = ca.IssueCertificate(ctx, *tlsFeatureUnknownCSR, 1001)
+	test.AssertError(t, err, "Allowed a CSR with an empty TLS feature extension")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Wrong error type when rejecting a CSR with empty TLS feature extension")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRExtension)
+
+	// Copies of an extension with different values should be rejected
+	// outright, before either copy is considered
+	conflictingTLSFeatureCSR, err := x509.ParseCertificateRequest(ConflictingTLSFeatureCSR)
+	test.AssertNotError(t, err, "Error parsing ConflictingTLSFeatureCSR")
+	_, err = ca.IssueCertificate(ctx, *conflictingTLSFeatureCSR, 1001)
+	test.AssertError(t, err, "Allowed a CSR with conflicting TLS feature extensions")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Wrong error type when rejecting a CSR with conflicting TLS feature extensions")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRExtension)
+
+	// Unsupported extensions should be silently ignored, having the same
+	// extensions as the TLS Feature cert above, minus the TLS Feature Extension
+	stats.EXPECT().Inc(metricCSRExtensionOther, int64(1)).Return(nil)
+	stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesRSA, int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesExplicitCN, int64(1)).Return(nil)
+	unsupportedExtensionCert := sign(unsupportedExtensionCSR)
+	test.AssertEquals(t, len(unsupportedExtensionCert.Extensions), len(singleStapleCert.Extensions)-1)
+}
+
+// secp256k1 has parameters crypto/elliptic can't do arithmetic with, which
+// is fine since keys on it only need to be rejected.
+var secp256k1 = &elliptic.CurveParams{
+	P:       mustBigInt("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F"),
+	N:       mustBigInt("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141"),
+	B:       big.NewInt(7),
+	Gx:      mustBigInt("79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798"),
+	Gy:      mustBigInt("483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8"),
+	BitSize: 256,
+	Name:    "secp256k1",
+}
+
+func mustBigInt(hex string) *big.Int {
+	i, ok := new(big.Int).SetString(hex, 16)
+	if !ok {
+		panic(fmt.Sprintf("invalid hex integer %q", hex))
+	}
+	return i
+}
+
+func TestRejectedCurveMetric(t *testing.T) {
+	testCtx := setup(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stats := mock_metrics.NewMockScope(ctrl)
+	testCtx.stats = stats
+	ca := testCtx.newCA(t)
+
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	test.AssertNotError(t, err, "Couldn't generate P-224 key")
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "not-example.com"},
+		DNSNames: []string{"not-example.com"},
+	}, p224Key)
+	test.AssertNotError(t, err, "Couldn't create P-224 CSR")
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	test.AssertNotError(t, err, "Couldn't parse CSR")
+	stats.EXPECT().Inc(metricCSRKeyCurveRejected+".P-224", int64(1)).Return(nil)
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate for a P-224 key")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.BadCSRPublicKey)
+
+	// crypto/x509 can't produce or parse a CSR for a secp256k1 key, so the
+	// CA is handed one directly. The key is rejected before the signature is
+	// checked.
+	csr = &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "not-example.com"},
+		DNSNames: []string{"not-example.com"},
+		PublicKey: &ecdsa.PublicKey{
+			Curve: secp256k1,
+			X:     secp256k1.Gx,
+			Y:     secp256k1.Gy,
+		},
+	}
+	stats.EXPECT().Inc(metricCSRKeyCurveRejected+".secp256k1", int64(1)).Return(nil)
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate for a secp256k1 key")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.BadCSRPublicKey)
+}
+
+func TestLogDroppedCSRExtensions(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.LogDroppedCSRExtensions = true
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stats := mock_metrics.NewMockScope(ctrl)
+
+	testCtx.stats = stats
+	ca := testCtx.newCA(t)
+	mockLog := testCtx.logger.(*blog.Mock)
+	mockLog.Clear()
+
+	csr, err := x509.ParseCertificateRequest(UnsupportedExtensionCSR)
+	test.AssertNotError(t, err, "Error parsing UnsupportedExtensionCSR")
+
+	stats.EXPECT().Inc(metricCSRExtensionOther, int64(1)).Return(nil)
+	stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesRSA, int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesExplicitCN, int64(1)).Return(nil)
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue a certificate for a CSR with an unsupported extension")
+
+	// The CT poison extension is the unsupported extension requested
+	lines := mockLog.GetAllMatching(`^DEBUG: Dropped unsupported CSR extensions: 1\.3\.6\.1\.4\.1\.11129\.2\.4\.3$`)
+	test.AssertEquals(t, len(lines), 1)
+}
+
+func TestRejectUnknownExtensions(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.RejectUnknownExtensions = true
+
+	csr, err := x509.ParseCertificateRequest(UnsupportedExtensionCSR)
+	test.AssertNotError(t, err, "Error parsing UnsupportedExtensionCSR")
+
+	// The CT poison extension is the unsupported extension requested
+	_, err = testCtx.newCA(t).IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate for a CSR with an unsupported extension")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Wrong error type")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRExtension)
+	test.AssertContains(t, err.Error(), "1.3.6.1.4.1.11129.2.4.3")
+
+	// Supported extensions are still accepted
+	mustStapleCSR, err := x509.ParseCertificateRequest(MustStapleCSR)
+	test.AssertNotError(t, err, "Error parsing MustStapleCSR")
+	_, err = testCtx.newCA(t).IssueCertificate(ctx, *mustStapleCSR, 1001)
+	test.AssertNotError(t, err, "Failed to issue a certificate for a CSR with a supported extension")
+
+	// As are unsupported ones the profile allows, which are still dropped
+	profile := testCtx.caConfig.CFSSL.Signing.Profiles[rsaProfileName]
+	profile.AllowedExtensions = append(profile.AllowedExtensions,
+		cfsslConfig.OID(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}))
+	issued, err := testCtx.newCA(t).IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue a certificate for a CSR with an allowed extension")
+	cert, err := x509.ParseCertificate(issued.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	for _, ext := range cert.Extensions {
+		test.Assert(t, ext.Id.String() != "1.3.6.1.4.1.11129.2.4.3", "Allowed extension was copied into the certificate")
+	}
+}
+
+func TestIssuanceMetrics(t *testing.T) {
+	testCtx := setup(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stats := mock_metrics.NewMockScope(ctrl)
+	testCtx.stats = stats
+	ca := testCtx.newCA(t)
+
+	issue := func(csrDER []byte) {
+		csr, err := x509.ParseCertificateRequest(csrDER)
+		test.AssertNotError(t, err, "Couldn't parse CSR")
+		_, err = ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to issue")
+	}
+
+	// An RSA key with a CN in the CSR
+	stats.EXPECT().Inc(metricCSRExtensionBasic, int64(1)).Return(nil)
+	stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesRSA, int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesExplicitCN, int64(1)).Return(nil)
+	issue(CNandSANCSR)
+
+	// An ECDSA key with the CN taken from a SAN
+	stats.EXPECT().Inc(metricCSRExtensionBasic, int64(1)).Return(nil)
+	stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesECDSA, int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesForcedCNFromSAN, int64(1)).Return(nil)
+	issue(ECDSACSR)
+
+	// Without a CN neither CN counter is incremented
+	ca.forceCNFromSAN = false
+	stats.EXPECT().Inc(metricCSRExtensionBasic, int64(1)).Return(nil)
+	stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
+	stats.EXPECT().Inc(metricIssuancesRSA, int64(1)).Return(nil)
+	issue(NoCNCSR)
+}
+
+func TestTLSFeatureCombinations(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.Profiles = map[string]cmd.CAProfileConfig{
+		rsaProfileName: {EnableMustStaple: true},
+	}
+	ca := testCtx.newCA(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "Failed to generate key")
+	issue := func(features []int) (*x509.Certificate, error) {
+		value, err := asn1.Marshal(features)
+		test.AssertNotError(t, err, "Failed to encode TLS features")
+		csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			DNSNames:           []string{"not-example.com"},
+			SignatureAlgorithm: x509.SHA256WithRSA,
+			ExtraExtensions:    []pkix.Extension{{Id: oidTLSFeature, Value: value}},
+		}, key)
+		test.AssertNotError(t, err, "Failed to create CSR")
+		csr, err := x509.ParseCertificateRequest(csrDER)
+		test.AssertNotError(t, err, "Failed to parse CSR")
+		issued, err := ca.IssueCertificate(ctx, *csr, 1001)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(issued.DER)
+		test.AssertNotError(t, err, "Failed to parse certificate")
+		return cert, nil
+	}
+
+	for _, features := range [][]int{
+		{tlsFeatureStatusRequest},
+		{tlsFeatureStatusRequest, tlsFeatureStatusRequestV2},
+		{tlsFeatureStatusRequestV2, tlsFeatureStatusRequest},
+	} {
+		cert, err := issue(features)
+		test.AssertNotError(t, err, fmt.Sprintf("Failed to issue with TLS features %v", features))
+		expected, _ := asn1.Marshal(features)
+		var found bool
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(oidTLSFeature) {
+				test.AssertByteEquals(t, ext.Value, expected)
+				found = true
+			}
+		}
+		test.Assert(t, found, fmt.Sprintf("Certificate missing TLS features %v", features))
+	}
+
+	for _, features := range [][]int{
+		{},
+		{tlsFeatureStatusRequestV2},
+		{tlsFeatureStatusRequest, tlsFeatureStatusRequest},
+		{tlsFeatureStatusRequest, 18},
+	} {
+		_, err := issue(features)
+		test.AssertError(t, err, fmt.Sprintf("Issued with TLS features %v", features))
+		test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+		test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRExtension)
+	}
+}
+
+func TestMaxConcurrentSignings(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.MaxConcurrentSignings = 1
+	ca := testCtx.newCA(t)
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue with a free signing slot")
+
+	// Occupy the only signing slot so that further signing operations block
+	// until their context expires.
+	test.AssertNotError(t, ca.acquireSigningSlot(ctx), "Failed to acquire signing slot")
+	defer ca.releaseSigningSlot()
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	csr, _ = x509.ParseCertificateRequest(CNandSANCSR)
+	_, err = ca.IssueCertificate(timeoutCtx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate without a signing slot")
+	test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+
+	_, err = ca.GenerateOCSP(timeoutCtx, core.OCSPSigningRequest{
+		CertDER: cert.DER,
+		Status:  string(core.OCSPStatusGood),
+	})
+	test.AssertError(t, err, "Generated OCSP without a signing slot")
+	test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+
+	testCtx.caConfig.MaxConcurrentSignings = -1
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "CA should have failed with a negative MaxConcurrentSignings")
+}
+
+func TestDeterministicIssuance(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.DeterministicIssuance = true
+	ca := testCtx.newCA(t)
+
+	// The serial starts with the CA's serial prefix
+	serial := big.NewInt(0x114242)
+	issue := func() []byte {
+		csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+		cert, err := ca.IssueCertificateWithOptions(ctx, *csr, 1001, IssueOptions{Serial: serial})
+		test.AssertNotError(t, err, "Failed to issue")
+		return cert.DER
+	}
+
+	first := issue()
+	second := issue()
+	test.AssertByteEquals(t, first, second)
+
+	// Validity must come from the CA's clock rather than the wall clock
+	cert, err := x509.ParseCertificate(first)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertEquals(t, cert.SerialNumber.Cmp(serial), 0)
+	test.AssertEquals(t, cert.NotBefore, testCtx.fc.Now().UTC().Add(-time.Hour))
+	test.AssertEquals(t, cert.NotAfter, cert.NotBefore.Add(8760*time.Hour))
+
+	// Advancing the CA's clock changes the certificate
+	testCtx.fc.Add(time.Minute)
+	test.Assert(t, !bytes.Equal(first, issue()), "Certificate didn't change when the clock did")
+
+	// Serials must be positive, fit in 20 octets, and start with the CA's
+	// serial prefix
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	for _, bad := range []*big.Int{
+		big.NewInt(0),
+		big.NewInt(-1),
+		new(big.Int).Lsh(big.NewInt(0x11), 155),
+		big.NewInt(0x124242),
+	} {
+		_, err = ca.IssueCertificateWithOptions(ctx, *csr, 1001, IssueOptions{Serial: bad})
+		test.AssertError(t, err, "Issued a certificate with an invalid serial")
+		test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	}
+
+	// Serials can't be requested from a CA that doesn't issue deterministically
+	testCtx.caConfig.DeterministicIssuance = false
+	ca = testCtx.newCA(t)
+	_, err = ca.IssueCertificateWithOptions(ctx, *csr, 1001, IssueOptions{Serial: serial})
+	test.AssertError(t, err, "Issued a certificate with a requested serial")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+
+	// ECDSA issuers' signatures are randomized, so they're rejected unless
+	// they only sign OCSP responses
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate key")
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ecdsa issuer"},
+		NotBefore:             testCtx.fc.Now().Add(-time.Hour),
+		NotAfter:              testCtx.fc.Now().Add(10 * 8760 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	ecdsaCertDER, err := x509.CreateCertificate(rand.Reader, template, template, ecdsaKey.Public(), ecdsaKey)
+	test.AssertNotError(t, err, "Failed to create issuer certificate")
+	ecdsaCert, err := x509.ParseCertificate(ecdsaCertDER)
+	test.AssertNotError(t, err, "Failed to parse issuer certificate")
+	testCtx.caConfig.DeterministicIssuance = true
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		[]Issuer{{Signer: ecdsaKey, Cert: ecdsaCert}},
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created a deterministic CA with an ECDSA issuer")
+	testCtx.issuers = []Issuer{
+		{Signer: caKey, Cert: caCert},
+		{Signer: ecdsaKey, Cert: ecdsaCert, OCSPOnly: true},
+	}
+	testCtx.newCA(t)
+}
+
+func TestPreviewCertificate(t *testing.T) {
+	testCtx := setup(t)
+	// cfssl takes the validity from the wall clock otherwise
+	testCtx.caConfig.DeterministicIssuance = true
+	testCtx.caConfig.Profiles = map[string]cmd.CAProfileConfig{
+		rsaProfileName: {IncludeRegistrationID: true},
+	}
+	newCA := func(issuers []Issuer) (*CertificateAuthorityImpl, *mockSA) {
+		c := *testCtx
+		c.issuers = issuers
+		ca := c.newCA(t)
+		sa := &mockSA{}
+		ca.SA = sa
+		return ca, sa
+	}
+	// Previews mustn't need the issuer's key
+	previewCA, previewSA := newCA([]Issuer{{Signer: failingSigner{caKey}, Cert: caCert}})
+	ca, _ := newCA(testCtx.issuers)
+
+	for _, csrDER := range [][]byte{CNandSANCSR, ECDSACSR} {
+		csr, _ := x509.ParseCertificateRequest(csrDER)
+
+		// Use a fixed serial source so that both get the same serial
+		previewCA.serialRand = bytes.NewReader(bytes.Repeat([]byte{0x42}, 32))
+		preview, err := previewCA.PreviewCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to preview certificate")
+		test.AssertEquals(t, len(previewSA.certificate.DER), 0)
+
+		ca.serialRand = bytes.NewReader(bytes.Repeat([]byte{0x42}, 32))
+		issued, err := ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to issue")
+		cert, err := x509.ParseCertificate(issued.DER)
+		test.AssertNotError(t, err, "Certificate failed to parse")
+		test.AssertEquals(t, preview, describeCertificate(cert))
+	}
+
+	// Every preview reuses the same stand-in key
+	standIn := previewCA.defaultIssuer.previewKey
+	test.Assert(t, standIn != nil, "No stand-in key was generated")
+	previewCA.serialRand = rand.Reader
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	_, err := previewCA.PreviewCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to preview certificate")
+	test.Assert(t, previewCA.defaultIssuer.previewKey == standIn, "Stand-in key was regenerated")
+
+	// The preview is subject to the same checks as issuance
+	csr, _ = x509.ParseCertificateRequest(NoNameCSR)
+	_, err = previewCA.PreviewCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Previewed a certificate for a CSR with no names")
+}
+
+func TestRejectIssuerKey(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+	sa := &mockSA{}
+	ca.SA = sa
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "not-example.com"},
+		DNSNames: []string{"not-example.com"},
+	}, caKey)
+	test.AssertNotError(t, err, "Failed to create CSR")
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	test.AssertNotError(t, err, "Failed to parse CSR")
+
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate for an issuer's key")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Wrong error type")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.BadCSRPublicKey)
+	test.AssertContains(t, err.Error(), caCert.Subject.CommonName)
+	test.AssertEquals(t, len(sa.certificate.DER), 0)
+}
+
+func TestCrossSign(t *testing.T) {
+	testCtx := setup(t)
+	rootCert, err := core.LoadCert("../test/test-root.pem")
+	test.AssertNotError(t, err, "Failed to load root cert")
+	rootKey, err := helpers.ParsePrivateKeyPEM(mustRead("../test/test-root.key"))
+	test.AssertNotError(t, err, "Failed to load root key")
+	testCtx.issuers = []Issuer{
+		{Signer: caKey, Cert: caCert},
+		{Signer: rootKey, Cert: rootCert},
+	}
+
+	// Cross-sign test-ca2.pem, which shares its key with test-ca.pem
+	newIssuerCert, err := core.LoadCert("../test/test-ca2.pem")
+	test.AssertNotError(t, err, "Failed to load new cert")
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		RawSubject: newIssuerCert.RawSubject,
+	}, caKey)
+	test.AssertNotError(t, err, "Failed to create CSR")
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	test.AssertNotError(t, err, "Failed to parse CSR")
+
+	_, err = testCtx.newCA(t).CrossSign(ctx, *csr, rootCert.Subject.CommonName, rootCert.SubjectKeyId, 0)
+	test.AssertError(t, err, "Cross-signed without cross-signing enabled")
+	test.Assert(t, berrors.Is(err, berrors.NotSupported), "Wrong error type")
+
+	testCtx.caConfig.CrossSigning = true
+	testCtx.caConfig.CrossSignValidity = cmd.ConfigDuration{Duration: 365 * 24 * time.Hour}
+	ca := testCtx.newCA(t)
+	crossCert, err := ca.CrossSign(ctx, *csr, rootCert.Subject.CommonName, rootCert.SubjectKeyId, 0)
+	test.AssertNotError(t, err, "Failed to cross-sign")
+	cert, err := x509.ParseCertificate(crossCert.DER)
+	test.AssertNotError(t, err, "Cross-certificate failed to parse")
+	test.AssertNotError(t, cert.CheckSignatureFrom(rootCert), "Cross-certificate not signed by the root")
+	test.AssertByteEquals(t, cert.RawSubject, newIssuerCert.RawSubject)
+	test.AssertByteEquals(t, cert.RawSubjectPublicKeyInfo, newIssuerCert.RawSubjectPublicKeyInfo)
+	test.AssertByteEquals(t, cert.SubjectKeyId, newIssuerCert.SubjectKeyId)
+	test.AssertByteEquals(t, cert.AuthorityKeyId, rootCert.SubjectKeyId)
+	test.Assert(t, cert.BasicConstraintsValid && cert.IsCA, "Cross-certificate isn't a CA certificate")
+	test.Assert(t, cert.MaxPathLen == 0 && cert.MaxPathLenZero, "Cross-certificate has the wrong pathlen")
+	test.AssertEquals(t, cert.KeyUsage, x509.KeyUsageCertSign|x509.KeyUsageCRLSign)
+	test.AssertEquals(t, crossCert.Digest, core.Fingerprint256(crossCert.DER))
+
+	// test-ca.pem has a pathlen of 0
+	_, err = ca.CrossSign(ctx, *csr, caCert.Subject.CommonName, caCert.SubjectKeyId, 0)
+	test.AssertError(t, err, "Cross-signed with an issuer whose pathlen forbids it")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Wrong error type")
+
+	_, err = ca.CrossSign(ctx, *csr, "not an issuer", rootCert.SubjectKeyId, 0)
+	test.AssertError(t, err, "Cross-signed with an unknown issuer")
+
+	limitedIssuer := func(maxPathLen int) *x509.Certificate {
+		return &x509.Certificate{
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			MaxPathLen:            maxPathLen,
+			MaxPathLenZero:        maxPathLen == 0,
+		}
+	}
+	testCases := []struct {
+		issuer  *x509.Certificate
+		pathLen int
+		ok      bool
+	}{
+		{&x509.Certificate{}, 0, false},
+		{limitedIssuer(-1), -1, true},
+		{limitedIssuer(-1), 5, true},
+		{limitedIssuer(0), 0, false},
+		{limitedIssuer(2), 1, true},
+		{limitedIssuer(2), 2, false},
+		{limitedIssuer(2), -1, false},
+	}
+	for _, tc := range testCases {
+		err := checkCrossSignPathLen(tc.issuer, tc.pathLen)
+		test.AssertEquals(t, err == nil, tc.ok)
+	}
+}
+
+func TestNotAfterBoundary(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.Profiles = map[string]cmd.CAProfileConfig{
+		rsaProfileName: {
+			NotAfterBoundary: cmd.ConfigDuration{Duration: 24 * time.Hour},
+		},
+	}
+	ca := testCtx.newCA(t)
+
+	issue := func(now string) *x509.Certificate {
+		nowTime, err := time.Parse(time.RFC3339, now)
+		test.AssertNotError(t, err, "Failed to parse time")
+		testCtx.fc.Set(nowTime)
+		csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+		issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to issue")
+		cert, err := x509.ParseCertificate(issuedCert.DER)
+		test.AssertNotError(t, err, "Certificate failed to parse")
+		return cert
+	}
+
+	// A one year certificate would expire at 12:37:21, so it is cut short to
+	// the preceding midnight.
+	cert := issue("2019-01-01T13:37:21Z")
+	expected, _ := time.Parse(time.RFC3339, "2020-01-01T00:00:00Z")
+	test.AssertEquals(t, cert.NotAfter, expected)
+	test.Assert(t, cert.NotAfter.Sub(cert.NotBefore) <= 8760*time.Hour, "Validity exceeds the profile's")
+
+	// Here a one year certificate would outlive the issuer, which expires on
+	// 2020-10-19, so it expires at the last midnight before then instead.
+	cert = issue("2020-01-01T13:37:21Z")
+	test.AssertEquals(t, cert.NotAfter, caCert.NotAfter.Truncate(24*time.Hour))
+	test.Assert(t, !cert.NotAfter.After(caCert.NotAfter), "Certificate outlives its issuer")
+}
+
+func TestIssuerExpiryWarning(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.Profiles = map[string]cmd.CAProfileConfig{
+		rsaProfileName: {
+			NotAfterBoundary: cmd.ConfigDuration{Duration: 24 * time.Hour},
+		},
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stats := mock_metrics.NewMockScope(ctrl)
+	testCtx.stats = stats
+	ca := testCtx.newCA(t)
+	mockLog := testCtx.logger.(*blog.Mock)
+
+	issue := func(now string) {
+		nowTime, err := time.Parse(time.RFC3339, now)
+		test.AssertNotError(t, err, "Failed to parse time")
+		testCtx.fc.Set(nowTime)
+		stats.EXPECT().Inc(metricCSRExtensionBasic, int64(1)).Return(nil)
+		stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
+		stats.EXPECT().Inc(metricIssuancesRSA, int64(1)).Return(nil)
+		stats.EXPECT().Inc(metricIssuancesExplicitCN, int64(1)).Return(nil)
+		csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+		_, err = ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to issue")
+	}
+	warnings := func() int {
+		return len(mockLog.GetAllMatching(`^WARNING: Issuer .* expires at .*, too soon to issue full-length certificates`))
+	}
+
+	// A one year certificate fits within the issuer, which expires on
+	// 2020-10-19, so nothing is counted or logged.
+	issue("2019-01-01T13:37:21Z")
+	test.AssertEquals(t, warnings(), 0)
+
+	// Later on it has to be cut short, which is counted every time but only
+	// logged once an hour.
+	stats.EXPECT().Inc(metricIssuancesClampedByIssuer, int64(1)).Return(nil)
+	issue("2020-01-01T13:37:21Z")
+	test.AssertEquals(t, warnings(), 1)
+	stats.EXPECT().Inc(metricIssuancesClampedByIssuer, int64(1)).Return(nil)
+	issue("2020-01-01T14:00:00Z")
+	test.AssertEquals(t, warnings(), 1)
+	stats.EXPECT().Inc(metricIssuancesClampedByIssuer, int64(1)).Return(nil)
+	issue("2020-01-01T15:00:00Z")
+	test.AssertEquals(t, warnings(), 2)
+}
+
+func TestIssuerExpiryWarningWithoutBoundary(t *testing.T) {
+	testCtx := setup(t)
+	rootCert, err := core.LoadCert("../test/test-root.pem")
+	test.AssertNotError(t, err, "Failed to load root cert")
+	rootKey, err := helpers.ParsePrivateKeyPEM(mustRead("../test/test-root.key"))
+	test.AssertNotError(t, err, "Failed to load root key")
+	testCtx.issuers = []Issuer{
+		{Signer: caKey, Cert: caCert},
+		{Signer: rootKey, Cert: rootCert},
+	}
+	ca := testCtx.newCA(t)
+	mockLog := testCtx.logger.(*blog.Mock)
+	warnings := func(cn string) int {
+		return len(mockLog.GetAllMatching(`^WARNING: Issuer "` + cn + `" expires at .*, too soon to issue full-length certificates`))
+	}
+
+	// Without a NotAfter boundary, a certificate that would outlive the
+	// issuer, which expires on 2020-10-19, isn't issued at all, but the
+	// issuer is still warned about.
+	testCtx.fc.Set(time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC))
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate that outlives its issuer")
+	test.AssertEquals(t, warnings(caCert.Subject.CommonName), 1)
+
+	// Each issuer's warnings are throttled separately
+	now := testCtx.fc.Now()
+	for _, issuer := range ca.allIssuers {
+		ca.warnIssuerExpiry(issuer, rsaProfileName, now)
+	}
+	test.AssertEquals(t, warnings(caCert.Subject.CommonName), 1)
+	test.AssertEquals(t, warnings(rootCert.Subject.CommonName), 1)
+	for _, issuer := range ca.allIssuers {
+		ca.warnIssuerExpiry(issuer, rsaProfileName, now.Add(issuerExpiryWarningInterval))
+	}
+	test.AssertEquals(t, warnings(caCert.Subject.CommonName), 2)
+	test.AssertEquals(t, warnings(rootCert.Subject.CommonName), 2)
+}
+
+// recordingObserver is an IssuanceObserver that records every certificate
+// it's notified of, and then returns err.
+type recordingObserver struct {
+	issued []core.Certificate
+	err    error
+}
+
+func (o *recordingObserver) OnIssued(cert core.Certificate) error {
+	o.issued = append(o.issued, cert)
+	return o.err
+}
+
+func TestIssuanceObserver(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+
+	// Without an observer, issuance is unaffected
+	_, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue without an observer")
+
+	observer := &recordingObserver{}
+	ca.IssuanceObserver = observer
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue with an observer")
+	test.AssertEquals(t, len(observer.issued), 1)
+	test.AssertDeepEquals(t, observer.issued[0], cert)
+
+	// An observer failing doesn't fail the issuance
+	observer.err = errors.New("event bus unavailable")
+	mockLog := testCtx.logger.(*blog.Mock)
+	mockLog.Clear()
+	cert, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue with a failing observer")
+	test.AssertEquals(t, len(observer.issued), 2)
+	test.AssertEquals(t, observer.issued[1].Serial, cert.Serial)
+	test.AssertEquals(t, len(mockLog.GetAllMatching("^WARNING: Failed to notify issuance observer")), 1)
+}
+
+func TestOmitRevocationPointersForShortLived(t *testing.T) {
+	issue := func(expiry string) *x509.Certificate {
+		testCtx := setup(t)
+		testCtx.caConfig.Expiry = expiry
+		testCtx.caConfig.CFSSL.Signing.Profiles[rsaProfileName].ExpiryString = expiry
+		testCtx.caConfig.Profiles = map[string]cmd.CAProfileConfig{
+			rsaProfileName: {
+				OmitRevocationPointersBelow: cmd.ConfigDuration{Duration: 7 * 24 * time.Hour},
+			},
+		}
+		ca := testCtx.newCA(t)
+
+		csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+		issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to issue")
+		cert, err := x509.ParseCertificate(issuedCert.DER)
+		test.AssertNotError(t, err, "Certificate failed to parse")
+		return cert
+	}
+
+	shortLived := issue("72h")
+	test.AssertEquals(t, len(shortLived.OCSPServer), 0)
+	test.AssertEquals(t, len(shortLived.CRLDistributionPoints), 0)
+	test.AssertDeepEquals(t, shortLived.IssuingCertificateURL, []string{"http://not-example.com/issuer-url"})
+
+	normal := issue("8760h")
+	test.AssertDeepEquals(t, normal.OCSPServer, []string{"http://not-example.com/ocsp"})
+	test.AssertDeepEquals(t, normal.CRLDistributionPoints, []string{"http://not-example.com/crl"})
+}
+
+func TestIssuerFromPKCS12(t *testing.T) {
+	pfxData := mustRead("./testdata/test-ca.p12")
+
+	_, err := NewIssuerFromPKCS12(pfxData, "not the password")
+	test.AssertError(t, err, "Loaded a PKCS#12 bundle with the wrong password")
+	test.AssertEquals(t, err.Error(), "incorrect password for PKCS#12 issuer bundle")
+
+	issuer, err := NewIssuerFromPKCS12(pfxData, "boulder")
+	test.AssertNotError(t, err, "Failed to load PKCS#12 bundle")
+	test.AssertByteEquals(t, issuer.Cert.Raw, caCert.Raw)
+
+	testCtx := setup(t)
+	testCtx.issuers = []Issuer{issuer}
+	ca := testCtx.newCA(t)
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertNotError(t, cert.CheckSignatureFrom(caCert), "Certificate not signed by the PKCS#12 issuer")
+}
+
+func TestIssuerFromEncryptedKey(t *testing.T) {
+	keyPEM := mustRead("./testdata/test-ca-encrypted.key")
+
+	_, err := ParseEncryptedKeyPEM(keyPEM, "not the password")
+	test.AssertError(t, err, "Loaded an encrypted key with the wrong password")
+	test.AssertEquals(t, err.Error(), "incorrect password for encrypted issuer key")
+
+	_, err = ParseEncryptedKeyPEM(mustRead(caKeyFile), "boulder")
+	test.AssertError(t, err, "Loaded an unencrypted key as an encrypted one")
+
+	signer, err := ParseEncryptedKeyPEM(keyPEM, "boulder")
+	test.AssertNotError(t, err, "Failed to load encrypted key")
+	test.Assert(t, core.KeyDigestEquals(signer.Public(), caCert.PublicKey), "Decrypted key doesn't match the issuer certificate")
+
+	testCtx := setup(t)
+	testCtx.issuers = []Issuer{{Signer: signer, Cert: caCert}}
+	ca := testCtx.newCA(t)
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	test.AssertNotError(t, cert.CheckSignatureFrom(caCert), "Certificate not signed by the decrypted issuer key")
+}
+
+func TestAuditLogIssuanceAndOCSP(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+	mockLog := testCtx.logger.(*blog.Mock)
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	cert, err := x509.ParseCertificate(issuedCert.DER)
+	test.AssertNotError(t, err, "Certificate failed to parse")
+	serial := core.SerialToString(cert.SerialNumber)
+
+	lines := mockLog.GetAllMatching(`Certificate issuance - issued JSON=`)
+	test.AssertEquals(t, len(lines), 1)
+	test.Assert(t, !strings.Contains(lines[0], hex.EncodeToString(issuedCert.DER)), "Audit log contains the full certificate")
+	var event issuanceEvent
+	err = json.Unmarshal([]byte(lines[0][strings.Index(lines[0], "JSON=")+5:]), &event)
+	test.AssertNotError(t, err, "Failed to unmarshal issuance event")
+	test.AssertDeepEquals(t, event, issuanceEvent{
+		SerialNumber: serial,
+		Issuer:       caCert.Subject.CommonName,
+		Requester:    1001,
+		Names:        []string{"not-example.com", "www.not-example.com"},
+		Profile:      rsaProfileName,
+		CSRDigest:    core.Fingerprint256(csr.Raw),
+		CertDigest:   core.Fingerprint256(issuedCert.DER),
+	})
+
+	csr, _ = x509.ParseCertificateRequest(NoNamesCSR)
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued certificate with no names")
+	test.AssertEquals(t, len(mockLog.GetAllMatching(`Certificate issuance - error JSON=.*"Requester":1001`)), 1)
+
+	mockLog.Clear()
+	_, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER:   issuedCert.DER,
+		Status:    string(core.OCSPStatusRevoked),
+		Reason:    revocation.KeyCompromise,
+		RevokedAt: testCtx.fc.Now(),
+	})
+	test.AssertNotError(t, err, "Failed to generate OCSP")
+	lines = mockLog.GetAllMatching(`OCSP signing - signed JSON=`)
+	test.AssertEquals(t, len(lines), 1)
+	var ocspEvent ocspSigningEvent
+	err = json.Unmarshal([]byte(lines[0][strings.Index(lines[0], "JSON=")+5:]), &ocspEvent)
+	test.AssertNotError(t, err, "Failed to unmarshal OCSP event")
+	test.AssertDeepEquals(t, ocspEvent, ocspSigningEvent{
+		SerialNumber: serial,
+		Issuer:       caCert.Subject.CommonName,
+		Status:       string(core.OCSPStatusRevoked),
+		Reason:       revocation.KeyCompromise,
+	})
+}
+
+func TestOCSPSerialPrefixes(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	// Issue a certificate with a prefix that belongs to some other CA
+	ca.prefix = 99
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	foreignCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	ca.prefix = testCtx.caConfig.SerialPrefix
+	csr, _ = x509.ParseCertificateRequest(CNandSANCSR)
+	ownCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+
+	// Without a prefix list, any serial is accepted
+	_, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: foreignCert.DER,
+		Status:  string(core.OCSPStatusGood),
+	})
+	test.AssertNotError(t, err, "Failed to generate OCSP without a prefix list")
+
+	ca.ocspPrefixes = map[byte]bool{byte(testCtx.caConfig.SerialPrefix): true, 42: true}
+	_, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: foreignCert.DER,
+		Status:  string(core.OCSPStatusGood),
+	})
+	test.AssertError(t, err, "Generated OCSP for a foreign serial prefix")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+
+	_, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: ownCert.DER,
+		Status:  string(core.OCSPStatusGood),
+	})
+	test.AssertNotError(t, err, "Failed to generate OCSP for our own serial prefix")
+
+	testCtx.caConfig.OCSPSerialPrefixes = []int{256}
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "CA should have failed with an out of range OCSP serial prefix")
+}
+
+func TestOCSPRevocationReasons(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+
+	for _, reason := range []revocation.Reason{
+		revocation.KeyCompromise,
+		revocation.Superseded,
+		revocation.CessationOfOperation,
+	} {
+		ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+			CertDER:   cert.DER,
+			Status:    string(core.OCSPStatusRevoked),
+			Reason:    reason,
+			RevokedAt: testCtx.fc.Now(),
+		})
+		test.AssertNotError(t, err, "Failed to generate revoked OCSP")
+		parsed, err := ocsp.ParseResponse(ocspResp, caCert)
+		test.AssertNotError(t, err, "Failed to parse / validate OCSP")
+		test.AssertEquals(t, parsed.Status, ocsp.Revoked)
+		test.AssertEquals(t, parsed.RevocationReason, int(reason))
+	}
+
+	// Reasons the BRs don't permit for subscriber certificates are rejected
+	for _, reason := range []revocation.Reason{
+		revocation.CACompromise,
+		revocation.CertificateHold,
+		revocation.RemoveFromCRL,
+		revocation.Reason(7),
+	} {
+		_, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+			CertDER:   cert.DER,
+			Status:    string(core.OCSPStatusRevoked),
+			Reason:    reason,
+			RevokedAt: testCtx.fc.Now(),
+		})
+		test.AssertError(t, err, fmt.Sprintf("Generated OCSP with revocation reason %d", reason))
+		test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	}
+
+	// A good response can't carry a revocation reason
+	_, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: cert.DER,
+		Status:  string(core.OCSPStatusGood),
+		Reason:  revocation.KeyCompromise,
+	})
+	test.AssertError(t, err, "Generated a good OCSP response with a revocation reason")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+}
+
+// ocspNonce returns the nonce in the responseExtensions of an OCSP response,
+// or nil if there isn't one.
+func ocspNonce(t *testing.T, response []byte) []byte {
+	var outer rawOCSPResponse
+	_, err := asn1.Unmarshal(response, &outer)
+	test.AssertNotError(t, err, "Failed to parse OCSP response")
+	var basic rawBasicOCSPResponse
+	_, err = asn1.Unmarshal(outer.ResponseBytes.Response, &basic)
+	test.AssertNotError(t, err, "Failed to parse basic OCSP response")
+	var tbs rawOCSPResponseData
+	_, err = asn1.Unmarshal(basic.TBSResponseData.FullBytes, &tbs)
+	test.AssertNotError(t, err, "Failed to parse OCSP response data")
+	for _, ext := range tbs.ResponseExtensions {
+		if ext.Id.Equal(oidOCSPNonce) {
+			var nonce []byte
+			_, err = asn1.Unmarshal(ext.Value, &nonce)
+			test.AssertNotError(t, err, "Failed to parse OCSP nonce")
+			return nonce
+		}
+	}
+	return nil
+}
+
+func TestOCSPNonce(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+
+	// Without a nonce there are no response extensions
+	ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: cert.DER,
+		Status:  string(core.OCSPStatusGood),
+	})
+	test.AssertNotError(t, err, "Failed to generate OCSP")
+	test.Assert(t, ocspNonce(t, ocspResp) == nil, "OCSP response has a nonce that wasn't requested")
+
+	for _, nonce := range [][]byte{{0x01}, bytes.Repeat([]byte{0xAB}, maxOCSPNonceLength)} {
+		ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+			CertDER: cert.DER,
+			Status:  string(core.OCSPStatusGood),
+			Nonce:   nonce,
+		})
+		test.AssertNotError(t, err, "Failed to generate OCSP with a nonce")
+		parsed, err := ocsp.ParseResponse(ocspResp, caCert)
+		test.AssertNotError(t, err, "Failed to parse or verify OCSP response with a nonce")
+		test.AssertEquals(t, parsed.Status, ocsp.Good)
+		test.AssertByteEquals(t, ocspNonce(t, ocspResp), nonce)
+	}
+
+	for _, nonce := range [][]byte{{}, make([]byte, maxOCSPNonceLength+1)} {
+		_, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+			CertDER: cert.DER,
+			Status:  string(core.OCSPStatusGood),
+			Nonce:   nonce,
+		})
+		test.AssertError(t, err, fmt.Sprintf("Generated OCSP with a %d byte nonce", len(nonce)))
+		test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	}
+}
+
+func TestOCSPProducedAt(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	testCtx.fc.Add(48 * time.Hour)
+
+	// A past producedAt is used as is, and thisUpdate and nextUpdate follow
+	// from it rather than from the clock
+	producedAt := testCtx.fc.Now().Add(-30*time.Hour + 17*time.Minute + 5*time.Second).UTC()
+	for _, status := range []core.OCSPStatus{core.OCSPStatusGood, core.OCSPStatusUnknown} {
+		ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+			CertDER:    cert.DER,
+			Status:     string(status),
+			ProducedAt: producedAt,
+			Nonce:      []byte{0x01},
+		})
+		test.AssertNotError(t, err, "Failed to generate OCSP with a producedAt")
+		parsed, err := ocsp.ParseResponse(ocspResp, caCert)
+		test.AssertNotError(t, err, "Failed to parse or verify OCSP response")
+		test.AssertEquals(t, parsed.ProducedAt, producedAt)
+		test.AssertEquals(t, parsed.ThisUpdate, producedAt.Truncate(time.Hour))
+		test.AssertEquals(t, parsed.NextUpdate, parsed.ThisUpdate.Add(testCtx.caConfig.LifespanOCSP.Duration))
+		test.AssertByteEquals(t, ocspNonce(t, ocspResp), []byte{0x01})
+	}
+
+	_, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER:    cert.DER,
+		Status:     string(core.OCSPStatusGood),
+		ProducedAt: testCtx.fc.Now().Add(time.Minute),
+	})
+	test.AssertError(t, err, "Generated OCSP produced in the future")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+}
+
+func TestOCSPCache(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.OCSPCacheSize = 10
+	testCtx.caConfig.OCSPCacheFreshness = cmd.ConfigDuration{Duration: time.Hour}
+	ca := testCtx.newCA(t)
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stats := mock_metrics.NewMockScope(ctrl)
+	ca.stats = stats
+	generate := func(nonce []byte) []byte {
+		ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+			CertDER: cert.DER,
+			Status:  string(core.OCSPStatusGood),
+			Nonce:   nonce,
+		})
+		test.AssertNotError(t, err, "Failed to generate OCSP")
+		return ocspResp
+	}
+
+	stats.EXPECT().Inc(metricOCSPCacheMisses, int64(1)).Return(nil)
+	stats.EXPECT().Inc("Signatures.OCSP", int64(1)).Return(nil)
+	first := generate(nil)
+	stats.EXPECT().Inc(metricOCSPCacheHits, int64(1)).Return(nil)
+	test.AssertByteEquals(t, generate(nil), first)
+
+	// Requests with a nonce are always signed
+	stats.EXPECT().Inc("Signatures.OCSP", int64(1)).Return(nil)
+	test.AssertByteEquals(t, ocspNonce(t, generate([]byte{0x01})), []byte{0x01})
+
+	// Once the cached response is no longer fresh, it's signed again
+	testCtx.fc.Add(time.Hour)
+	stats.EXPECT().Inc(metricOCSPCacheMisses, int64(1)).Return(nil)
+	stats.EXPECT().Inc("Signatures.OCSP", int64(1)).Return(nil)
+	parsed, err := ocsp.ParseResponse(generate(nil), caCert)
+	test.AssertNotError(t, err, "Failed to parse or verify OCSP response")
+	test.AssertEquals(t, parsed.ThisUpdate, testCtx.fc.Now().Truncate(time.Hour))
+}
+
+func TestOCSPCacheIssuersSharingName(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.OCSPCacheSize = 10
+	testCtx.caConfig.OCSPCacheFreshness = cmd.ConfigDuration{Duration: time.Hour}
+	newKey, newIssuerCert := issuerSharingName(t)
+	newCA := func(issuers []Issuer) *CertificateAuthorityImpl {
+		c := *testCtx
+		c.issuers = issuers
+		ca := c.newCA(t)
+		// Give every certificate the same serial
+		ca.serialRand = bytes.NewReader(bytes.Repeat([]byte{0x42}, 64))
+		return ca
+	}
+
+	// Both keys issue a certificate with the same serial under the same name
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	oldCert, err := newCA(testCtx.issuers).IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	ca := newCA([]Issuer{
+		{Signer: caKey, Cert: caCert, OCSPOnly: true},
+		{Signer: newKey, Cert: newIssuerCert},
+	})
+	newCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	test.AssertEquals(t, newCert.Serial, oldCert.Serial)
+
+	// Their responses are cached separately, so each is signed by its own
+	// issuer
+	for _, tc := range []struct {
+		cert   core.Certificate
+		issuer *x509.Certificate
+	}{
+		{oldCert, caCert},
+		{newCert, newIssuerCert},
+		{oldCert, caCert},
+		{newCert, newIssuerCert},
+	} {
+		ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+			CertDER: tc.cert.DER,
+			Status:  string(core.OCSPStatusGood),
+		})
+		test.AssertNotError(t, err, "Failed to generate OCSP")
+		_, err = ocsp.ParseResponse(ocspResp, tc.issuer)
+		test.AssertNotError(t, err, "OCSP response wasn't signed by the certificate's issuer")
+	}
+	test.AssertEquals(t, len(ca.ocspCache.entries), 2)
+}
+
+func TestUnknownOCSP(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	// A certificate from another CA is answered for, not rejected
+	foreignCert, err := core.LoadCert("../test/wfe.pem")
+	test.AssertNotError(t, err, "Failed to load foreign cert")
+	ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: foreignCert.Raw,
+		Status:  string(core.OCSPStatusUnknown),
+	})
+	test.AssertNotError(t, err, "Failed to generate unknown OCSP for a foreign cert")
+	parsed, err := ocsp.ParseResponse(ocspResp, caCert)
+	test.AssertNotError(t, err, "Failed to parse / validate OCSP")
+	test.AssertEquals(t, parsed.Status, ocsp.Unknown)
+	test.AssertEquals(t, parsed.SerialNumber.Cmp(foreignCert.SerialNumber), 0)
+	test.AssertEquals(t, parsed.NextUpdate, parsed.ThisUpdate.Add(testCtx.caConfig.LifespanOCSP.Duration))
+
+	// As is a bare serial
+	serial := big.NewInt(0xdecafbad)
+	ocspResp, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		Status: string(core.OCSPStatusUnknown),
+		Serial: serial,
+	})
+	test.AssertNotError(t, err, "Failed to generate unknown OCSP for a serial")
+	parsed, err = ocsp.ParseResponse(ocspResp, caCert)
+	test.AssertNotError(t, err, "Failed to parse / validate OCSP")
+	test.AssertEquals(t, parsed.Status, ocsp.Unknown)
+	test.AssertEquals(t, parsed.SerialNumber.Cmp(serial), 0)
+
+	_, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		Status: string(core.OCSPStatusUnknown),
+	})
+	test.AssertError(t, err, "Generated unknown OCSP without a cert or serial")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Wrong error type")
+
+	_, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		Status: string(core.OCSPStatusUnknown),
+		Serial: serial,
+		Reason: revocation.KeyCompromise,
+	})
+	test.AssertError(t, err, "Generated unknown OCSP with a revocation reason")
+}
+
+func TestOCSPNextUpdate(t *testing.T) {
+	testCtx := setup(t)
+	newCA := func(config cmd.CAConfig) *CertificateAuthorityImpl {
+		c := *testCtx
+		c.caConfig = config
+		return c.newCA(t)
+	}
+	generateOCSP := func(ca *CertificateAuthorityImpl, der []byte) *ocsp.Response {
+		ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+			CertDER: der,
+			Status:  string(core.OCSPStatusGood),
+		})
+		test.AssertNotError(t, err, "Failed to generate OCSP")
+		parsed, err := ocsp.ParseResponse(ocspResp, caCert)
+		test.AssertNotError(t, err, "Failed to parse validate OCSP")
+		return parsed
+	}
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := newCA(testCtx.caConfig).IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	parsedCert, err := x509.ParseCertificate(cert.DER)
+	test.AssertNotError(t, err, "Failed to parse cert")
+
+	// By default responses are valid for LifespanOCSP, from the CA's clock
+	parsed := generateOCSP(newCA(testCtx.caConfig), cert.DER)
+	test.AssertEquals(t, parsed.ThisUpdate, testCtx.fc.Now().Truncate(time.Hour))
+	test.AssertEquals(t, parsed.NextUpdate.Sub(parsed.ThisUpdate), testCtx.caConfig.LifespanOCSP.Duration)
+
+	fractionalConfig := testCtx.caConfig
+	fractionalConfig.OCSPNextUpdateStrategy = "fractional"
+	fractionalConfig.OCSPNextUpdateFraction = 0.5
+	fractionalConfig.LifespanOCSP = cmd.ConfigDuration{Duration: 96 * time.Hour}
+	ca := newCA(fractionalConfig)
+
+	// Far from expiry, half the remaining lifetime is capped at LifespanOCSP
+	parsed = generateOCSP(ca, cert.DER)
+	test.AssertEquals(t, parsed.NextUpdate.Sub(parsed.ThisUpdate), 96*time.Hour)
+
+	// Close to expiry, responses last half the remaining lifetime
+	testCtx.fc.Set(parsedCert.NotAfter.Add(-48 * time.Hour))
+	parsed = generateOCSP(ca, cert.DER)
+	test.AssertEquals(t, parsed.ThisUpdate, testCtx.fc.Now().Truncate(time.Hour))
+	test.AssertEquals(t, parsed.NextUpdate, parsed.ThisUpdate.Add(parsedCert.NotAfter.Sub(parsed.ThisUpdate)/2))
+	test.Assert(t, !parsed.NextUpdate.After(parsedCert.NotAfter), "OCSP response outlives the certificate")
+
+	// Once the certificate has expired, responses aren't valid past thisUpdate
+	testCtx.fc.Set(parsedCert.NotAfter.Add(2 * time.Hour))
+	parsed = generateOCSP(ca, cert.DER)
+	test.AssertEquals(t, parsed.NextUpdate, parsed.ThisUpdate)
+
+	// The fixed lifespan is cut short by the certificate's expiry too
+	fixedConfig := testCtx.caConfig
+	fixedConfig.LifespanOCSP = cmd.ConfigDuration{Duration: 96 * time.Hour}
+	fixedCA := newCA(fixedConfig)
+	testCtx.fc.Set(parsedCert.NotAfter.Add(-time.Hour))
+	parsed = generateOCSP(fixedCA, cert.DER)
+	test.AssertEquals(t, parsed.NextUpdate, parsedCert.NotAfter)
+	testCtx.fc.Set(parsedCert.NotAfter.Add(2 * time.Hour))
+	parsed = generateOCSP(fixedCA, cert.DER)
+	test.AssertEquals(t, parsed.NextUpdate, parsed.ThisUpdate)
+
+	for _, tc := range []struct {
+		strategy string
+		fraction float64
+	}{
+		{"fractional", 0},
+		{"fractional", 1.5},
+		{"random", 0.5},
+	} {
+		config := testCtx.caConfig
+		config.OCSPNextUpdateStrategy = tc.strategy
+		config.OCSPNextUpdateFraction = tc.fraction
+		_, err := NewCertificateAuthorityImpl(
+			config,
+			testCtx.fc,
+			testCtx.stats,
+			testCtx.issuers,
+			testCtx.keyPolicy,
+			testCtx.logger)
+		test.AssertError(t, err, fmt.Sprintf("Created CA with OCSP nextUpdate strategy %q and fraction %g", tc.strategy, tc.fraction))
+	}
+}
+
+func TestOCSPStatusFromSA(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.OCSPStatusFromSA = true
+	ca := testCtx.newCA(t)
+	sa := &mockSA{statuses: make(map[string]core.CertificateStatus)}
+	ca.SA = sa
+
+	// The SA has no record of the certificate during issuance, so the initial
+	// OCSP response is signed with the requested status.
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+
+	ocspResp, err := ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: cert.DER,
+		Status:  string(core.OCSPStatusGood),
+	})
+	test.AssertNotError(t, err, "Failed to generate OCSP")
+	parsed, err := ocsp.ParseResponse(ocspResp, caCert)
+	test.AssertNotError(t, err, "Failed to parse / validate OCSP")
+	test.AssertEquals(t, parsed.Status, ocsp.Good)
+
+	// Once the SA has the certificate as revoked, a request to sign it as good
+	// gets a revoked response carrying the SA's reason.
+	parsedCert, err := x509.ParseCertificate(cert.DER)
+	test.AssertNotError(t, err, "Failed to parse certificate")
+	serial := core.SerialToString(parsedCert.SerialNumber)
+	revokedAt := testCtx.fc.Now().Add(-time.Hour).Truncate(time.Second)
+	sa.statuses[serial] = core.CertificateStatus{
+		Serial:        serial,
+		Status:        core.OCSPStatusRevoked,
+		RevokedDate:   revokedAt,
+		RevokedReason: revocation.KeyCompromise,
+	}
+	ocspResp, err = ca.GenerateOCSP(ctx, core.OCSPSigningRequest{
+		CertDER: cert.DER,
+		Status:  string(core.OCSPStatusGood),
+	})
+	test.AssertNotError(t, err, "Failed to generate OCSP")
+	parsed, err = ocsp.ParseResponse(ocspResp, caCert)
+	test.AssertNotError(t, err, "Failed to parse / validate OCSP")
+	test.AssertEquals(t, parsed.Status, ocsp.Revoked)
+	test.AssertEquals(t, parsed.RevocationReason, int(revocation.KeyCompromise))
+	test.Assert(t, parsed.RevokedAt.Equal(revokedAt), "Wrong revocation time in OCSP response")
+}
+
+// failingSigner is a crypto.Signer whose Sign method always fails, standing in
+// for an HSM whose session has gone away.
+type failingSigner struct {
+	crypto.Signer
+}
+
+func (failingSigner) Sign(_ io.Reader, _ []byte, _ crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("session closed")
+}
+
+// failingLogger is a logger whose checked audit messages start failing after
+// the first successes, standing in for a log pipeline that has gone down.
+type failingLogger struct {
+	*blog.Mock
+	successes int
+}
+
+func (l *failingLogger) CheckedAuditInfo(msg string) error {
+	if l.successes <= 0 {
+		return errors.New("log pipeline down")
+	}
+	l.successes--
+	return l.Mock.CheckedAuditInfo(msg)
+}
+
+func TestRequireAuditLog(t *testing.T) {
+	for _, successes := range []int{0, 1} {
+		testCtx := setup(t)
+		testCtx.caConfig.RequireAuditLog = true
+		logger := &failingLogger{Mock: blog.NewMock(), successes: successes}
+		testCtx.logger = logger
+		ca := testCtx.newCA(t)
+		sa := &mockSA{}
+		ca.SA = sa
+
+		csr, _ := x509.ParseCertificateRequest(NoCNCSR)
+		cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertError(t, err, "Issued a certificate that couldn't be logged")
+		test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+		test.AssertEquals(t, len(cert.DER), 0)
+		test.AssertEquals(t, len(sa.certificate.DER), 0)
+	}
+
+	// With enough working log messages, issuance succeeds
+	testCtx := setup(t)
+	testCtx.caConfig.RequireAuditLog = true
+	testCtx.logger = &failingLogger{Mock: blog.NewMock(), successes: 2}
+	ca := testCtx.newCA(t)
+	csr, _ := x509.ParseCertificateRequest(NoCNCSR)
+	_, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue certificate")
+}
+
+func TestHealth(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+	ca.SA = nil
+
+	err := ca.Health(ctx)
+	test.AssertError(t, err, "Health check passed without an SA")
+	test.AssertContains(t, err.Error(), "SA")
+
+	ca.SA = &mockSA{}
+	test.AssertNotError(t, ca.Health(ctx), "Health check failed for a working CA")
+
+	issuer := ca.issuers[caCert.Subject.CommonName]
+	issuer.key = failingSigner{issuer.key}
+	err = ca.Health(ctx)
+	test.AssertError(t, err, "Health check passed with a failing issuer key")
+	test.AssertContains(t, err.Error(), caCert.Subject.CommonName)
+}
+
+// wrongKeySigner is a crypto.Signer that reports one public key but signs
+// with a different private key, standing in for an issuer whose HSM holds a
+// key other than the one its certificate was issued for.
+type wrongKeySigner struct {
+	crypto.Signer
+	signingKey crypto.Signer
+}
+
+func (s wrongKeySigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.signingKey.Sign(rand, digest, opts)
+}
+
+func TestStartupSelfTest(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.StartupSelfTest = true
+	testCtx.newCA(t)
+
+	newIssuerCert, err := core.LoadCert("../test/test-ca2.pem")
+	test.AssertNotError(t, err, "Failed to load new cert")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "Failed to generate key")
+	badIssuers := []Issuer{
+		testCtx.issuers[0],
+		{Signer: wrongKeySigner{caKey, otherKey}, Cert: newIssuerCert},
+	}
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		badIssuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Self-test passed with an issuer signing with the wrong key")
+	test.AssertContains(t, err.Error(), newIssuerCert.Subject.CommonName)
+
+	testCtx.caConfig.StartupSelfTest = false
+	testCtx.issuers = badIssuers
+	_ = testCtx.newCA(t)
+}
+
+// parseSCTList returns the serialized SCTs in a TLS encoded
+// SignedCertificateTimestampList.
+func parseSCTList(t *testing.T, list []byte) [][]byte {
+	test.Assert(t, len(list) >= 2, "SCT list too short for its length")
+	test.AssertEquals(t, int(binary.BigEndian.Uint16(list)), len(list)-2)
+	var scts [][]byte
+	for rest := list[2:]; len(rest) > 0; {
+		test.Assert(t, len(rest) >= 2, "SCT too short for its length")
+		length := int(binary.BigEndian.Uint16(rest))
+		test.Assert(t, len(rest) >= 2+length, "SCT truncated")
+		scts = append(scts, rest[2:2+length])
+		rest = rest[2+length:]
+	}
+	return scts
+}
+
+func TestSCTListExtension(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	// Stand-ins for SCTs from different logs, whose contents the CA doesn't
+	// interpret
+	allSCTs := [][]byte{
+		bytes.Repeat([]byte{0x01}, 119),
+		bytes.Repeat([]byte{0x02}, 120),
+		bytes.Repeat([]byte{0x03}, 300),
+	}
+	for _, n := range []int{0, 1, 3} {
+		scts := allSCTs[:n]
+		ext, err := ca.sctListExtension(scts)
+		test.AssertNotError(t, err, fmt.Sprintf("Failed to build extension for %d SCTs", n))
+		if n == 0 {
+			test.Assert(t, ext == nil, "Built an extension for no SCTs")
+			continue
+		}
+		test.AssertEquals(t, asn1.ObjectIdentifier(ext.ID).String(), oidSCTList.String())
+		test.Assert(t, !ext.Critical, "SCT list extension was marked critical")
+		value, err := hex.DecodeString(ext.Value)
+		test.AssertNotError(t, err, "Extension value isn't hex")
+		var list []byte
+		rest, err := asn1.Unmarshal(value, &list)
+		test.AssertNotError(t, err, "Extension value isn't an OCTET STRING")
+		test.AssertEquals(t, len(rest), 0)
+		test.AssertDeepEquals(t, parseSCTList(t, list), scts)
+	}
+
+	_, err := ca.sctListExtension([][]byte{allSCTs[0], {}})
+	test.AssertError(t, err, "Built an extension containing an empty SCT")
+
+	ca.minSCTs = 2
+	for _, n := range []int{0, 1} {
+		_, err = ca.sctListExtension(allSCTs[:n])
+		test.AssertError(t, err, fmt.Sprintf("Built an extension with %d SCTs, below the minimum", n))
+		test.Assert(t, berrors.Is(err, berrors.Malformed), "Wrong error type")
+	}
+	_, err = ca.sctListExtension(allSCTs)
+	test.AssertNotError(t, err, "Failed to build extension with enough SCTs")
+}
+
+func TestIssueWithSCTs(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.MinSCTs = 2
+	ca := testCtx.newCA(t)
+	sa := &mockSA{}
+	ca.SA = sa
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+
+	sctList := func(der []byte) []byte {
+		cert, err := x509.ParseCertificate(der)
+		test.AssertNotError(t, err, "Failed to parse cert")
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(oidSCTList) {
+				test.Assert(t, !ext.Critical, "SCT list extension was marked critical")
+				var list []byte
+				_, err := asn1.Unmarshal(ext.Value, &list)
+				test.AssertNotError(t, err, "Extension value isn't an OCTET STRING")
+				return list
+			}
+		}
+		return nil
+	}
+
+	// Certificates that aren't for a precertificate carry no SCTs
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	test.Assert(t, sctList(cert.DER) == nil, "Certificate without SCTs has an SCT list")
+
+	scts := [][]byte{
+		bytes.Repeat([]byte{0x01}, 119),
+		bytes.Repeat([]byte{0x02}, 120),
+	}
+	cert, err = ca.IssueCertificateWithOptions(ctx, *csr, 1001, IssueOptions{SCTs: scts})
+	test.AssertNotError(t, err, "Failed to issue with SCTs")
+	test.AssertDeepEquals(t, parseSCTList(t, sctList(cert.DER)), scts)
+
+	// Final certificates with too few SCTs aren't issued
+	sa.certificate = core.Certificate{}
+	for _, n := range []int{0, 1} {
+		_, err = ca.IssueCertificateWithOptions(ctx, *csr, 1001, IssueOptions{SCTs: scts[:n]})
+		test.AssertError(t, err, fmt.Sprintf("Issued a final certificate with %d SCTs, below the minimum", n))
+		test.Assert(t, berrors.Is(err, berrors.Malformed), "Wrong error type")
+	}
+	test.AssertEquals(t, len(sa.certificate.DER), 0)
+}
+
+func TestPublishWorkers(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.PublisherWorkers = 2
+	testCtx.caConfig.PublisherQueueSize = 10
+	testCtx.caConfig.PublisherMaxAttempts = 3
+	ca := testCtx.newCA(t)
+	// The certificate only gets in on its last attempt
+	publisher := &mocks.Publisher{Failures: 2}
+	ca.Publisher = publisher
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	test.AssertNotError(t, ca.DrainPublisher(ctx), "Failed to drain publisher")
+	test.AssertEquals(t, len(publisher.Submitted), 1)
+	test.AssertByteEquals(t, publisher.Submitted[0], cert.DER)
+
+	// Once drained, certificates are still issued but no longer submitted
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue after draining")
+	test.AssertNotError(t, ca.DrainPublisher(ctx), "Failed to drain publisher")
+	test.AssertEquals(t, len(publisher.Submitted), 1)
+
+	// Submissions that run out of attempts are given up on
+	ca.startPublishWorkers(1, 1, 3, 0, 0, 0)
+	publisher.Failures = 3
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	test.AssertNotError(t, ca.DrainPublisher(ctx), "Failed to drain publisher")
+	test.AssertEquals(t, len(publisher.Submitted), 1)
+	test.AssertEquals(t, publisher.Failures, 0)
+}
+
+// hangingPublisher is a mocks.Publisher whose SubmitToCT signals on started,
+// and then hangs until its context is done.
+type hangingPublisher struct {
+	mocks.Publisher
+	started chan struct{}
+}
+
+func (p *hangingPublisher) SubmitToCT(ctx context.Context, der []byte) error {
+	p.started <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestPublishTimeouts(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+	publisher := &hangingPublisher{started: make(chan struct{}, 10)}
+	ca.Publisher = publisher
+	mockLog := testCtx.logger.(*blog.Mock)
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+
+	// Each attempt times out on its own
+	ca.startPublishWorkers(1, 1, 2, 0, 0, 10*time.Millisecond)
+	_, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	test.AssertNotError(t, ca.DrainPublisher(ctx), "Failed to drain publisher")
+	test.AssertEquals(t, len(publisher.started), 2)
+	test.AssertEquals(t, len(mockLog.GetAllMatching(`Failed to submit certificate to CT after 2 attempts`)), 1)
+
+	// Once draining times out, the submission in progress is canceled and it
+	// and the queued certificate are abandoned without further attempts
+	publisher.started = make(chan struct{}, 10)
+	ca.startPublishWorkers(1, 2, 3, 0, 0, time.Hour)
+	for i := 0; i < 2; i++ {
+		_, err = ca.IssueCertificate(ctx, *csr, 1001)
+		test.AssertNotError(t, err, "Failed to issue")
+	}
+	<-publisher.started
+	drainCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	test.AssertEquals(t, ca.DrainPublisher(drainCtx), context.DeadlineExceeded)
+	test.AssertEquals(t, len(publisher.started), 0)
+	test.AssertEquals(t, len(mockLog.GetAllMatching(`Abandoned CT submission of certificate after 1 attempts`)), 1)
+	test.AssertEquals(t, len(mockLog.GetAllMatching(`Abandoned CT submission of certificate after 0 attempts`)), 1)
+
+	// A certificate waiting to be retried is abandoned straight away rather
+	// than once its backoff is over
+	mockLog.Clear()
+	failing := &mocks.Publisher{Failures: 1}
+	ca.Publisher = failing
+	ca.startPublishWorkers(1, 1, 3, time.Hour, 0, 0)
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	for failed := false; !failed; {
+		failing.Lock()
+		failed = failing.Failures == 0
+		failing.Unlock()
+	}
+	drainCtx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	test.AssertEquals(t, ca.DrainPublisher(drainCtx), context.DeadlineExceeded)
+	test.Assert(t, time.Since(started) < time.Second, "Draining waited for the retry backoff")
+	test.AssertEquals(t, len(mockLog.GetAllMatching(`Abandoned CT submission of certificate after 1 attempts`)), 1)
+}
+
+// blockingSA is a mockSA whose AddCertificate signals on stored, and then
+// waits for release before storing the certificate.
+type blockingSA struct {
+	mockSA
+	stored  chan struct{}
+	release chan struct{}
+}
+
+func (sa *blockingSA) AddCertificate(ctx context.Context, der []byte, regID int64, ocspResp []byte) (string, error) {
+	sa.stored <- struct{}{}
+	<-sa.release
+	return sa.mockSA.AddCertificate(ctx, der, regID, ocspResp)
+}
+
+func TestShutdown(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+	publisher := &mocks.Publisher{}
+	ca.Publisher = publisher
+	sa := &blockingSA{stored: make(chan struct{}), release: make(chan struct{})}
+	ca.SA = sa
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	issued := make(chan error)
+	go func() {
+		_, err := ca.IssueCertificate(ctx, *csr, 1001)
+		issued <- err
+	}()
+	<-sa.stored
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- ca.Shutdown(ctx)
+	}()
+	// Shutdown rejects new issuances, but waits for the one in flight
+	for shuttingDown := false; !shuttingDown; {
+		ca.shutdownMu.Lock()
+		shuttingDown = ca.shuttingDown
+		ca.shutdownMu.Unlock()
+	}
+	_, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate while shutting down")
+	test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown returned with an issuance in flight")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(sa.release)
+	test.AssertNotError(t, <-issued, "In-flight issuance failed")
+	test.AssertNotError(t, <-shutdown, "Shutdown failed")
+	test.AssertEquals(t, len(publisher.Submitted), 1)
+
+	// Shutdown gives up waiting once its context is done
+	ca = testCtx.newCA(t)
+	sa = &blockingSA{stored: make(chan struct{}), release: make(chan struct{})}
+	ca.SA = sa
+	go func() {
+		_, err := ca.IssueCertificate(ctx, *csr, 1001)
+		issued <- err
+	}()
+	<-sa.stored
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	test.AssertEquals(t, ca.Shutdown(timeoutCtx), context.DeadlineExceeded)
+	close(sa.release)
+	test.AssertNotError(t, <-issued, "In-flight issuance failed")
+}
+
+func TestRegistrationIDExtension(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.Profiles = map[string]cmd.CAProfileConfig{
+		rsaProfileName: {IncludeRegistrationID: true},
+	}
+	ca := testCtx.newCA(t)
+
+	findRegID := func(csrDER []byte) *pkix.Extension {
+		csr, err := x509.ParseCertificateRequest(csrDER)
+		test.AssertNotError(t, err, "Cannot parse CSR")
 		issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
 		test.AssertNotError(t, err, "Failed to sign certificate")
-
-		// Verify cert contents
 		cert, err := x509.ParseCertificate(issuedCert.DER)
 		test.AssertNotError(t, err, "Certificate failed to parse")
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(oidRegistrationID) {
+				return &ext
+			}
+		}
+		return nil
+	}
 
-		t.Logf("expected key usage %v, got %v", testCase.ExpectedKeyUsage, cert.KeyUsage)
-		test.AssertEquals(t, cert.KeyUsage, testCase.ExpectedKeyUsage)
+	// The RSA profile is configured to carry the regID
+	ext := findRegID(CNandSANCSR)
+	test.Assert(t, ext != nil, "Certificate is missing the registration ID extension")
+	test.Assert(t, !ext.Critical, "Registration ID extension was marked critical")
+	var regID int64
+	rest, err := asn1.Unmarshal(ext.Value, &regID)
+	test.AssertNotError(t, err, "Failed to parse registration ID extension")
+	test.AssertEquals(t, len(rest), 0)
+	test.AssertEquals(t, regID, int64(1001))
+
+	// The ECDSA profile isn't, so its certificates must not
+	ext = findRegID(ECDSACSR)
+	test.Assert(t, ext == nil, "Certificate from a public profile has a registration ID extension")
+}
+
+func TestValidateCSR(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+	sa := &mockSA{}
+	ca.SA = sa
+
+	// Validation must not sign anything or consume a serial, so make any
+	// attempt to do so fail.
+	ca.defaultIssuer.key = failingSigner{ca.defaultIssuer.key}
+	ca.defaultIssuer.eeSigner = nil
+	ca.serialRand = &bytes.Buffer{}
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	test.AssertNotError(t, ca.ValidateCSR(ctx, *csr, 1001), "Failed to validate a good CSR")
+	test.AssertEquals(t, len(sa.certificate.DER), 0)
+
+	for _, tc := range []struct {
+		csrDER []byte
+		reason berrors.Reason
+	}{
+		{TooManyNameCSR, berrors.CSRTooManyNames},
+		{ShortKeyCSR, berrors.BadCSRPublicKey},
+		{SHA1SignatureCSR, berrors.BadCSRSignatureAlgorithm},
+	} {
+		csr, _ := x509.ParseCertificateRequest(tc.csrDER)
+		err := ca.ValidateCSR(ctx, *csr, 1001)
+		test.AssertError(t, err, "Validated a bad CSR")
+		test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+		test.AssertEquals(t, berrors.ReasonOf(err), tc.reason)
 	}
+
+	// Validity checks are included too
+	testCtx.fc.Add(time.Hour * 24 * 365 * 50)
+	err := ca.ValidateCSR(ctx, *csr, 1001)
+	test.AssertError(t, err, "Validated a CSR whose certificate would outlive the issuer")
+	test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
 }
 
-func countMustStaple(t *testing.T, cert *x509.Certificate) (count int) {
-	oidTLSFeature := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
-	for _, ext := range cert.Extensions {
-		if ext.Id.Equal(oidTLSFeature) {
-			test.Assert(t, !ext.Critical, "Extension was marked critical")
-			test.AssertByteEquals(t, ext.Value, mustStapleFeatureValue)
-			count++
-		}
+func TestRegenerateOCSPBatch(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+	sa := &mockSA{}
+	ca.SA = sa
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	good, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	revoked, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	revokedAt := testCtx.fc.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	sa.statuses = map[string]core.CertificateStatus{
+		revoked.Serial: {
+			Status:        core.OCSPStatusRevoked,
+			RevokedReason: revocation.Reason(1),
+			RevokedDate:   revokedAt,
+		},
 	}
-	return count
+
+	missing := "ff" + good.Serial[2:]
+	responses := make(map[string][]byte)
+	notFound, err := ca.RegenerateOCSPBatch(ctx, []string{good.Serial, missing, revoked.Serial},
+		func(serial string, response []byte) error {
+			responses[serial] = response
+			return nil
+		})
+	test.AssertNotError(t, err, "Failed to regenerate OCSP")
+	test.AssertDeepEquals(t, notFound, []string{missing})
+	test.AssertEquals(t, len(responses), 2)
+
+	goodCert, err := x509.ParseCertificate(good.DER)
+	test.AssertNotError(t, err, "Failed to parse certificate")
+	parsed, err := ocsp.ParseResponseForCert(responses[good.Serial], goodCert, caCert)
+	test.AssertNotError(t, err, "Failed to parse or verify OCSP response")
+	test.AssertEquals(t, parsed.Status, ocsp.Good)
+
+	revokedCert, err := x509.ParseCertificate(revoked.DER)
+	test.AssertNotError(t, err, "Failed to parse certificate")
+	parsed, err = ocsp.ParseResponseForCert(responses[revoked.Serial], revokedCert, caCert)
+	test.AssertNotError(t, err, "Failed to parse or verify OCSP response")
+	test.AssertEquals(t, parsed.Status, ocsp.Revoked)
+	test.AssertEquals(t, parsed.RevocationReason, 1)
+	test.Assert(t, parsed.RevokedAt.Equal(revokedAt), "Wrong revocation time")
+
+	// An error from emit stops the batch
+	emitErr := errors.New("write failed")
+	_, err = ca.RegenerateOCSPBatch(ctx, []string{good.Serial, revoked.Serial},
+		func(string, []byte) error { return emitErr })
+	test.AssertEquals(t, err, emitErr)
 }
 
-func TestExtensions(t *testing.T) {
+func TestGenerateRevokedOCSPBatch(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+
+	serials := []*big.Int{big.NewInt(0x110001), big.NewInt(0x110002), big.NewInt(0x110003)}
+	revokedAt := testCtx.fc.Now().Add(-time.Hour).Truncate(time.Second)
+	responses := make(map[string][]byte)
+	err := ca.GenerateRevokedOCSPBatch(ctx, caCert.Subject.CommonName, caCert.SubjectKeyId, serials,
+		revocation.KeyCompromise, revokedAt, func(serial *big.Int, response []byte) error {
+			responses[core.SerialToString(serial)] = response
+			return nil
+		})
+	test.AssertNotError(t, err, "Failed to generate revoked OCSP batch")
+	test.AssertEquals(t, len(responses), len(serials))
+	for _, serial := range serials {
+		response, ok := responses[core.SerialToString(serial)]
+		test.Assert(t, ok, fmt.Sprintf("No response for serial %s", serial))
+		parsed, err := ocsp.ParseResponse(response, caCert)
+		test.AssertNotError(t, err, "Failed to parse / validate OCSP")
+		test.AssertEquals(t, parsed.SerialNumber.Cmp(serial), 0)
+		test.AssertEquals(t, parsed.Status, ocsp.Revoked)
+		test.AssertEquals(t, parsed.RevocationReason, int(revocation.KeyCompromise))
+		test.Assert(t, parsed.RevokedAt.Equal(revokedAt), "Wrong revocation time in OCSP response")
+	}
+
+	// An error from emit stops the batch
+	emitted := 0
+	emitErr := errors.New("write failed")
+	err = ca.GenerateRevokedOCSPBatch(ctx, caCert.Subject.CommonName, caCert.SubjectKeyId, serials,
+		revocation.KeyCompromise, revokedAt, func(*big.Int, []byte) error {
+			emitted++
+			return emitErr
+		})
+	test.AssertEquals(t, err, emitErr)
+	test.AssertEquals(t, emitted, 1)
+
+	// Disallowed reasons and unknown issuers are rejected up front
+	noEmit := func(*big.Int, []byte) error {
+		t.Fatal("Response emitted for a rejected batch")
+		return nil
+	}
+	err = ca.GenerateRevokedOCSPBatch(ctx, caCert.Subject.CommonName, caCert.SubjectKeyId, serials,
+		revocation.CertificateHold, revokedAt, noEmit)
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	err = ca.GenerateRevokedOCSPBatch(ctx, "not an issuer", caCert.SubjectKeyId, serials,
+		revocation.KeyCompromise, revokedAt, noEmit)
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+}
+
+func TestExtKeyUsageAllowlist(t *testing.T) {
+	testCases := []struct {
+		name      string
+		allowed   []string
+		strip     bool
+		expectErr bool
+		expected  []x509.ExtKeyUsage
+	}{
+		{"no allowlist", nil, false, false,
+			[]x509.ExtKeyUsage{x509.ExtKeyUsageAny, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}},
+		{"any stripped", []string{"server auth", "client auth"}, true, false,
+			[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}},
+		{"any not stripped", []string{"server auth", "client auth"}, false, true, nil},
+		{"client auth not allowed", []string{"server auth"}, true, true, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testCtx := setup(t)
+			rsaProfile := testCtx.caConfig.CFSSL.Signing.Profiles[rsaProfileName]
+			rsaProfile.Usage = []string{"digital signature", "key encipherment", "any", "server auth", "client auth"}
+			testCtx.caConfig.Profiles = map[string]cmd.CAProfileConfig{
+				rsaProfileName: {
+					AllowedExtKeyUsages: tc.allowed,
+					StripAnyExtKeyUsage: tc.strip,
+				},
+			}
+			ca := testCtx.newCA(t)
+
+			csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+			issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+			if tc.expectErr {
+				test.AssertError(t, err, "Issued a certificate with a disallowed extended key usage")
+				test.Assert(t, berrors.Is(err, berrors.InternalServer), "Incorrect error type returned")
+				return
+			}
+			test.AssertNotError(t, err, "Failed to sign certificate")
+			cert, err := x509.ParseCertificate(issuedCert.DER)
+			test.AssertNotError(t, err, "Certificate failed to parse")
+			test.AssertDeepEquals(t, cert.ExtKeyUsage, tc.expected)
+		})
+	}
+
+	testCtx := setup(t)
+	testCtx.caConfig.Profiles = map[string]cmd.CAProfileConfig{
+		rsaProfileName: {AllowedExtKeyUsages: []string{"server auth", "any"}},
+	}
+	_, err := NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created a CA whose profile allows anyExtendedKeyUsage")
+}
+
+func TestRejectMalformedWildcards(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.RejectMalformedWildcards = true
+	ca := testCtx.newCA(t)
+	sa := &mockSA{}
+	ca.SA = sa
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate key")
+	testCases := []struct {
+		name   string
+		cn     string
+		names  []string
+		reason berrors.Reason
+	}{
+		{"double wildcard", "", []string{"*.*.example.com"}, berrors.CSRInvalidName},
+		{"partial label wildcard", "", []string{"a*.example.com"}, berrors.CSRInvalidName},
+		{"CN-only wildcard", "*.example.com", []string{"example.com"}, berrors.CSRCNNotInSANs},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: tc.cn},
+				DNSNames: tc.names,
+			}, key)
+			test.AssertNotError(t, err, "Failed to create CSR")
+			csr, err := x509.ParseCertificateRequest(csrDER)
+			test.AssertNotError(t, err, "Failed to parse CSR")
+
+			_, err = ca.IssueCertificate(ctx, *csr, 1001)
+			test.AssertError(t, err, "Issued a certificate with a malformed wildcard")
+			test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+			test.AssertEquals(t, berrors.ReasonOf(err), tc.reason)
+			test.AssertEquals(t, len(sa.certificate.DER), 0)
+		})
+	}
+}
+
+func TestNativeSigner(t *testing.T) {
 	testCtx := setup(t)
+	// cfssl takes the validity from the wall clock otherwise
+	testCtx.caConfig.DeterministicIssuance = true
+	testCtx.caConfig.EnableMustStaple = true
 	testCtx.caConfig.MaxNames = 3
+	rsaProfile := testCtx.caConfig.CFSSL.Signing.Profiles[rsaProfileName]
+	rsaProfile.Policies = append(rsaProfile.Policies, cfsslConfig.CertificatePolicy{
+		ID: cfsslConfig.OID(asn1.ObjectIdentifier{1, 2, 3, 4}),
+		Qualifiers: []cfsslConfig.CertificatePolicyQualifier{
+			{Type: "id-qt-cps", Value: "http://not-example.com/cps"},
+			{Type: "id-qt-unotice", Value: "Do What Thou Wilt"},
+		},
+	})
+	newCA := func(backend string) *CertificateAuthorityImpl {
+		testCtx.caConfig.SignerBackend = backend
+		return testCtx.newCA(t)
+	}
+	cfsslCA := newCA("cfssl")
+	nativeCA := newCA("native")
 
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
-	stats := mock_metrics.NewMockScope(ctrl)
+	// Both backends sign identical certificates, including when the profile is
+	// adjusted for the request
+	for _, opts := range []IssueOptions{{}, {Validity: 24 * time.Hour}} {
+		for _, csrDER := range [][]byte{CNandSANCSR, NoCNCSR, ECDSACSR, MustStapleCSR, UnsupportedExtensionCSR} {
+			csr, err := x509.ParseCertificateRequest(csrDER)
+			test.AssertNotError(t, err, "Failed to parse CSR")
 
-	ca, err := NewCertificateAuthorityImpl(
+			cfsslCA.serialRand = bytes.NewReader(bytes.Repeat([]byte{0x42}, 32))
+			cfsslCert, err := cfsslCA.IssueCertificateWithOptions(ctx, *csr, 1001, opts)
+			test.AssertNotError(t, err, "Failed to issue with cfssl")
+			nativeCA.serialRand = bytes.NewReader(bytes.Repeat([]byte{0x42}, 32))
+			nativeCert, err := nativeCA.IssueCertificateWithOptions(ctx, *csr, 1001, opts)
+			test.AssertNotError(t, err, "Failed to issue natively")
+			test.AssertByteEquals(t, nativeCert.DER, cfsslCert.DER)
+		}
+	}
+
+	testCtx.caConfig.SignerBackend = "openssl"
+	_, err := NewCertificateAuthorityImpl(
 		testCtx.caConfig,
 		testCtx.fc,
-		stats,
+		testCtx.stats,
 		testCtx.issuers,
 		testCtx.keyPolicy,
 		testCtx.logger)
-	ca.Publisher = &mocks.Publisher{}
-	ca.PA = testCtx.pa
-	ca.SA = &mockSA{}
+	test.AssertError(t, err, "Created a CA with an unknown signer backend")
+}
 
-	mustStapleCSR, err := x509.ParseCertificateRequest(MustStapleCSR)
-	test.AssertNotError(t, err, "Error parsing MustStapleCSR")
+func TestSubjectKeyIDMethod(t *testing.T) {
+	csr, err := x509.ParseCertificateRequest(CNandSANCSR)
+	test.AssertNotError(t, err, "Failed to parse CSR")
+	for _, tc := range []struct {
+		method      string
+		backend     string
+		expectedSKI string
+	}{
+		{"", "cfssl", "70f06bab472310d1a412a231582bd0e37497d18d"},
+		{"sha1", "native", "70f06bab472310d1a412a231582bd0e37497d18d"},
+		{"truncated-sha256", "cfssl", "f762961ea9849cd89c0d06459ea8cde6c5142031"},
+		{"truncated-sha256", "native", "f762961ea9849cd89c0d06459ea8cde6c5142031"},
+	} {
+		t.Run(tc.method+"/"+tc.backend, func(t *testing.T) {
+			testCtx := setup(t)
+			testCtx.caConfig.SubjectKeyIDMethod = tc.method
+			testCtx.caConfig.SignerBackend = tc.backend
+			ca := testCtx.newCA(t)
 
-	duplicateMustStapleCSR, err := x509.ParseCertificateRequest(DuplicateMustStapleCSR)
-	test.AssertNotError(t, err, "Error parsing DuplicateMustStapleCSR")
+			issuedCert, err := ca.IssueCertificate(ctx, *csr, 1001)
+			test.AssertNotError(t, err, "Failed to issue")
+			cert, err := x509.ParseCertificate(issuedCert.DER)
+			test.AssertNotError(t, err, "Certificate failed to parse")
+			test.AssertEquals(t, hex.EncodeToString(cert.SubjectKeyId), tc.expectedSKI)
+			// The AKI is always the issuer's own SKI
+			test.AssertByteEquals(t, cert.AuthorityKeyId, caCert.SubjectKeyId)
+			var skiCount int
+			for _, ext := range cert.Extensions {
+				if ext.Id.Equal(oidSubjectKeyIdentifier) {
+					skiCount++
+				}
+			}
+			test.AssertEquals(t, skiCount, 1)
+		})
+	}
 
-	tlsFeatureUnknownCSR, err := x509.ParseCertificateRequest(TLSFeatureUnknownCSR)
-	test.AssertNotError(t, err, "Error parsing TLSFeatureUnknownCSR")
+	testCtx := setup(t)
+	testCtx.caConfig.SubjectKeyIDMethod = "md5"
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created a CA with an unknown subject key identifier method")
+}
 
-	unsupportedExtensionCSR, err := x509.ParseCertificateRequest(UnsupportedExtensionCSR)
-	test.AssertNotError(t, err, "Error parsing UnsupportedExtensionCSR")
+func TestCertificatePEM(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
 
-	sign := func(csr *x509.CertificateRequest) *x509.Certificate {
-		coreCert, err := ca.IssueCertificate(ctx, *csr, 1001)
-		test.AssertNotError(t, err, "Failed to issue")
-		cert, err := x509.ParseCertificate(coreCert.DER)
-		test.AssertNotError(t, err, "Error parsing certificate produced by CA")
-		return cert
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+
+	block, rest := pem.Decode(CertificatePEM(cert))
+	test.Assert(t, block != nil, "Failed to decode certificate PEM")
+	test.AssertEquals(t, block.Type, "CERTIFICATE")
+	test.AssertEquals(t, len(rest), 0)
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	test.AssertNotError(t, err, "Failed to parse certificate from PEM")
+	test.Assert(t, bytes.Equal(parsed.Raw, cert.DER), "Certificate PEM doesn't round-trip to the issued DER")
+
+	chain, err := ca.CertificateChainPEM(cert)
+	test.AssertNotError(t, err, "Failed to get chain PEM")
+	var chainDER [][]byte
+	for block, rest = pem.Decode(chain); block != nil; block, rest = pem.Decode(rest) {
+		test.AssertEquals(t, block.Type, "CERTIFICATE")
+		parsed, err := x509.ParseCertificate(block.Bytes)
+		test.AssertNotError(t, err, "Failed to parse certificate from chain PEM")
+		chainDER = append(chainDER, parsed.Raw)
 	}
+	test.AssertEquals(t, len(rest), 0)
+	test.AssertEquals(t, len(chainDER), 2)
+	test.Assert(t, bytes.Equal(chainDER[0], cert.DER), "Chain PEM doesn't start with the issued certificate")
+	test.Assert(t, bytes.Equal(chainDER[1], caCert.Raw), "Chain PEM doesn't end with the issuer certificate")
 
-	// With ca.enableMustStaple = false, should issue successfully and not add
-	// Must Staple.
-	stats.EXPECT().Inc(metricCSRExtensionTLSFeature, int64(1)).Return(nil)
-	stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
-	noStapleCert := sign(mustStapleCSR)
-	test.AssertEquals(t, countMustStaple(t, noStapleCert), 0)
+	// A certificate from another CA has no chain
+	_, err = ca.CertificateChainPEM(core.Certificate{DER: caCert.Raw})
+	test.AssertError(t, err, "Got a chain for a certificate this CA didn't issue")
+	_, err = ca.CertificateChainPEM(core.Certificate{DER: []byte{1, 2, 3}})
+	test.AssertError(t, err, "Got a chain for a malformed certificate")
+}
 
-	// With ca.enableMustStaple = true, a TLS feature extension should put a must-staple
-	// extension into the cert
-	ca.enableMustStaple = true
-	stats.EXPECT().Inc(metricCSRExtensionTLSFeature, int64(1)).Return(nil)
-	stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
-	singleStapleCert := sign(mustStapleCSR)
-	test.AssertEquals(t, countMustStaple(t, singleStapleCert), 1)
+func TestAdditionalExtensions(t *testing.T) {
+	testCtx := setup(t)
+	envOID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 44947, 3, 1}
+	otherOID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 44947, 3, 2}
+	testCtx.caConfig.AdditionalExtensions = []cmd.CAExtensionConfig{
+		{OID: envOID.String(), UTF8String: "staging"},
+		{OID: otherOID.String(), Value: "0500"},
+	}
+	ca := testCtx.newCA(t)
 
-	// Even if there are multiple TLS Feature extensions, only one extension should be included
-	stats.EXPECT().Inc(metricCSRExtensionTLSFeature, int64(1)).Return(nil)
-	stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
-	duplicateMustStapleCert := sign(duplicateMustStapleCSR)
-	test.AssertEquals(t, countMustStaple(t, duplicateMustStapleCert), 1)
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	parsedCert, err := x509.ParseCertificate(cert.DER)
+	test.AssertNotError(t, err, "Failed to parse cert")
+	want := map[string][]byte{
+		// UTF8String "staging"
+		envOID.String(): {0x0c, 0x07, 's', 't', 'a', 'g', 'i', 'n', 'g'},
+		// NULL
+		otherOID.String(): {0x05, 0x00},
+	}
+	for _, ext := range parsedCert.Extensions {
+		value, ok := want[ext.Id.String()]
+		if !ok {
+			continue
+		}
+		test.Assert(t, !ext.Critical, fmt.Sprintf("Additional extension %s is critical", ext.Id))
+		test.Assert(t, bytes.Equal(ext.Value, value), fmt.Sprintf("Wrong value for additional extension %s: %x", ext.Id, ext.Value))
+		delete(want, ext.Id.String())
+	}
+	test.AssertEquals(t, len(want), 0)
 
-	// ... but if it doesn't ask for stapling, there should be an error
-	stats.EXPECT().Inc(metricCSRExtensionTLSFeature, int64(1)).Return(nil)
-	stats.EXPECT().Inc(metricCSRExtensionTLSFeatureInvalid, int64(1)).Return(nil)
-	_, err = ca.IssueCertificate(ctx, *tlsFeatureUnknownCSR, 1001)
-	test.AssertError(t, err, "Allowed a CSR with an empty TLS feature extension")
-	test.Assert(t, berrors.Is(err, berrors.Malformed), "Wrong error type when rejecting a CSR with empty TLS feature extension")
+	for _, tc := range []struct {
+		name   string
+		config cmd.CAExtensionConfig
+	}{
+		{"critical", cmd.CAExtensionConfig{OID: envOID.String(), UTF8String: "staging", Critical: true}},
+		{"standard OID", cmd.CAExtensionConfig{OID: "2.5.29.17", Value: "0500"}},
+		{"PKIX OID", cmd.CAExtensionConfig{OID: "1.3.6.1.5.5.7.1.1", Value: "0500"}},
+		{"CT OID", cmd.CAExtensionConfig{OID: "1.3.6.1.4.1.11129.2.4.2", Value: "0500"}},
+		{"registration ID OID", cmd.CAExtensionConfig{OID: oidRegistrationID.String(), Value: "0500"}},
+		{"invalid OID", cmd.CAExtensionConfig{OID: "1.3.x", Value: "0500"}},
+		{"no value", cmd.CAExtensionConfig{OID: envOID.String()}},
+		{"both values", cmd.CAExtensionConfig{OID: envOID.String(), Value: "0500", UTF8String: "staging"}},
+		{"invalid DER", cmd.CAExtensionConfig{OID: envOID.String(), Value: "050000"}},
+	} {
+		testCtx.caConfig.AdditionalExtensions = []cmd.CAExtensionConfig{tc.config}
+		_, err := NewCertificateAuthorityImpl(
+			testCtx.caConfig,
+			testCtx.fc,
+			testCtx.stats,
+			testCtx.issuers,
+			testCtx.keyPolicy,
+			testCtx.logger)
+		test.AssertError(t, err, fmt.Sprintf("Created CA with bad additional extension: %s", tc.name))
+	}
 
-	// Unsupported extensions should be silently ignored, having the same
-	// extensions as the TLS Feature cert above, minus the TLS Feature Extension
-	stats.EXPECT().Inc(metricCSRExtensionOther, int64(1)).Return(nil)
-	stats.EXPECT().Inc("Signatures.Certificate", int64(1)).Return(nil)
-	unsupportedExtensionCert := sign(unsupportedExtensionCSR)
-	test.AssertEquals(t, len(unsupportedExtensionCert.Extensions), len(singleStapleCert.Extensions)-1)
+	testCtx.caConfig.AdditionalExtensions = []cmd.CAExtensionConfig{
+		{OID: envOID.String(), UTF8String: "staging"},
+		{OID: envOID.String(), UTF8String: "production"},
+	}
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created CA with a duplicated additional extension")
+}
+
+// panickingScope is a metrics.Scope that panics when stat is incremented, to
+// simulate a bug in CSR processing.
+type panickingScope struct {
+	*mock_metrics.MockScope
+	stat string
+}
+
+func (s panickingScope) Inc(stat string, value int64) error {
+	if stat == s.stat {
+		panic(fmt.Sprintf("incremented %s", stat))
+	}
+	return s.MockScope.Inc(stat, value)
+}
+
+func TestCSRPanicRecovery(t *testing.T) {
+	testCtx := setup(t)
+	ca := testCtx.newCA(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	stats := mock_metrics.NewMockScope(ctrl)
+	ca.stats = panickingScope{MockScope: stats, stat: metricCSRExtensionTLSFeature}
+	log := testCtx.logger.(*blog.Mock)
+
+	// The TLS Feature extension requested by this CSR trips the panic while
+	// its extensions are processed
+	stats.EXPECT().Inc(metricCSRPanics, int64(1)).Return(nil)
+	log.Clear()
+	csr, _ := x509.ParseCertificateRequest(MustStapleCSR)
+	_, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertError(t, err, "Issued a certificate despite a panic")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+	test.AssertEquals(t, len(log.GetAllMatching(`^ERR: \[AUDIT\] Panic while processing CSR`)), 1)
+	test.AssertEquals(t, len(log.GetAllMatching(`^INFO: \[AUDIT\] Certificate issuance - error`)), 1)
+
+	// The CA carries on issuing for other CSRs
+	stats.EXPECT().Inc(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	stats.EXPECT().TimingDuration(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	csr, _ = x509.ParseCertificateRequest(CNandSANCSR)
+	_, err = ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue after recovering from a panic")
+}
+
+func TestOCSPSignatureHash(t *testing.T) {
+	testCtx := setup(t)
+	testCtx.caConfig.OCSPSignatureHash = "SHA384"
+	ca := testCtx.newCA(t)
+
+	csr, _ := x509.ParseCertificateRequest(CNandSANCSR)
+	cert, err := ca.IssueCertificate(ctx, *csr, 1001)
+	test.AssertNotError(t, err, "Failed to issue")
+	for _, req := range []core.OCSPSigningRequest{
+		{CertDER: cert.DER, Status: string(core.OCSPStatusGood)},
+		// A nonce makes the response be re-signed after it's amended
+		{CertDER: cert.DER, Status: string(core.OCSPStatusGood), Nonce: []byte{1, 2, 3, 4}},
+	} {
+		ocspResp, err := ca.GenerateOCSP(ctx, req)
+		test.AssertNotError(t, err, "Failed to generate OCSP")
+		parsed, err := ocsp.ParseResponse(ocspResp, caCert)
+		test.AssertNotError(t, err, "Failed to parse / validate OCSP")
+		test.AssertEquals(t, parsed.SignatureAlgorithm, x509.SHA384WithRSA)
+	}
+
+	// The hash must be known and usable by every issuer's OCSP signing key
+	testCtx.caConfig.OCSPSignatureHash = "SHA1"
+	_, err = NewCertificateAuthorityImpl(
+		testCtx.caConfig,
+		testCtx.fc,
+		testCtx.stats,
+		testCtx.issuers,
+		testCtx.keyPolicy,
+		testCtx.logger)
+	test.AssertError(t, err, "Created CA with an unsupported OCSP signature hash")
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "Failed to generate key")
+	alg, err := ocspSignatureAlgorithm(ecdsaKey.Public(), crypto.SHA512)
+	test.AssertNotError(t, err, "Failed to get OCSP signature algorithm for ECDSA key")
+	test.AssertEquals(t, alg, x509.ECDSAWithSHA512)
+	alg, err = ocspSignatureAlgorithm(ecdsaKey.Public(), 0)
+	test.AssertNotError(t, err, "Failed to get default OCSP signature algorithm")
+	test.AssertEquals(t, alg, x509.UnknownSignatureAlgorithm)
+	// A 512 bit RSA key can't hold a PKCS #1 v1.5 encoded SHA-512 hash
+	tinyKey := &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 511), E: 65537}
+	_, err = ocspSignatureAlgorithm(tinyKey, crypto.SHA512)
+	test.AssertError(t, err, "Got an OCSP signature algorithm for a key too small for its hash")
+	test.AssertContains(t, err.Error(), "SHA512")
+	_, err = ocspSignatureAlgorithm(&dsa.PublicKey{}, crypto.SHA256)
+	test.AssertError(t, err, "Got an OCSP signature algorithm for a DSA key")
 }
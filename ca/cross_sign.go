@@ -0,0 +1,142 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	"github.com/letsencrypt/boulder/core"
+	berrors "github.com/letsencrypt/boulder/errors"
+)
+
+// CrossSign issues a CA certificate cross-signing the key and subject of
+// another CA's CSR with the issuer with the given common name and subject key
+// ID, limiting the number of further intermediates below it to pathLen, or
+// not at all if pathLen is negative. It's only available when cross-signing
+// is enabled in the CA's configuration, which should only be the case for
+// operator tooling, and is never exposed over gRPC. The certificate isn't
+// stored or published.
+func (ca *CertificateAuthorityImpl) CrossSign(ctx context.Context, csr x509.CertificateRequest, issuerName string, issuerKeyID []byte, pathLen int) (cert core.Certificate, err error) {
+	logEvent := issuanceEvent{
+		Issuer:    issuerName,
+		CSRDigest: core.Fingerprint256(csr.Raw),
+	}
+	// No matter what, log the decision
+	defer func() {
+		result := "signed"
+		if err != nil {
+			result = "error"
+			logEvent.Error = err.Error()
+		}
+		ca.log.AuditObject(fmt.Sprintf("Cross-signing - %s", result), logEvent)
+	}()
+
+	if !ca.crossSigning {
+		return core.Certificate{}, berrors.NotSupportedError("cross-signing is not enabled for this CA")
+	}
+	if err := ca.beginIssuance(); err != nil {
+		return core.Certificate{}, err
+	}
+	defer ca.endIssuance()
+	issuer, err := ca.issuerByID(issuerName, issuerKeyID)
+	if err != nil {
+		return core.Certificate{}, err
+	}
+	if issuer.ocspOnly {
+		return core.Certificate{}, berrors.MalformedError("issuer %q is OCSP-only", issuerName)
+	}
+	if err := checkCrossSignPathLen(issuer.cert, pathLen); err != nil {
+		return core.Certificate{}, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return core.Certificate{}, berrors.MalformedError("invalid signature on CSR: %s", err)
+	}
+	if err := ca.keyPolicy.GoodKey(csr.PublicKey); err != nil {
+		return core.Certificate{}, berrors.MalformedError("invalid public key in CSR: %s", err)
+	}
+
+	issuedAt := ca.clk.Now()
+	if err := ca.checkIssuerValidity(issuer, issuedAt, ca.crossSignValidity); err != nil {
+		return core.Certificate{}, err
+	}
+	serial, err := ca.generateSerial()
+	if err != nil {
+		return core.Certificate{}, err
+	}
+	serialHex := core.SerialToString(serial)
+	logEvent.SerialNumber = serialHex
+
+	// Go's x509 package doesn't add a subject key ID, which CA certificates
+	// must have. Use the RFC 5280 method, which is what the CA's own
+	// certificate will most likely have used, so that both certificates
+	// identify the key the same way.
+	ski, err := subjectKeyID(subjectKeyIDSHA1, csr.PublicKey)
+	if err != nil {
+		return core.Certificate{}, berrors.MalformedError("failed to compute subject key ID for CSR: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		RawSubject:            csr.RawSubject,
+		SubjectKeyId:          ski,
+		NotBefore:             issuedAt,
+		NotAfter:              issuedAt.Add(ca.crossSignValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            pathLen,
+		MaxPathLenZero:        pathLen == 0,
+	}
+	if pathLen < 0 {
+		template.MaxPathLen = -1
+	}
+
+	err = ca.auditInfoRequired(fmt.Sprintf("Cross-signing: serial=[%s] issuer=[%s] pathLen=[%d] csr=[%s]",
+		serialHex, issuerName, pathLen, hex.EncodeToString(csr.Raw)))
+	if err != nil {
+		return core.Certificate{}, err
+	}
+
+	if err := ca.acquireSigningSlot(ctx); err != nil {
+		return core.Certificate{}, err
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, issuer.cert, csr.PublicKey, issuer.key)
+	ca.releaseSigningSlot()
+	ca.noteSignError(err)
+	if err != nil {
+		return core.Certificate{}, berrors.InternalServerError("failed to sign cross-certificate: %s", err)
+	}
+	ca.stats.Inc(metricSignaturesCrossCertificate, 1)
+
+	cert = core.Certificate{
+		DER:    certDER,
+		Digest: core.Fingerprint256(certDER),
+	}
+	logEvent.CertDigest = cert.Digest
+	return cert, nil
+}
+
+// checkCrossSignPathLen returns an error unless issuer's basic constraints
+// permit it to issue a CA certificate with the given pathLen, where a negative
+// pathLen means no limit.
+func checkCrossSignPathLen(issuer *x509.Certificate, pathLen int) error {
+	cn := issuer.Subject.CommonName
+	if !issuer.BasicConstraintsValid || !issuer.IsCA {
+		return berrors.MalformedError("issuer %q is not a CA", cn)
+	}
+	if issuer.MaxPathLen < 0 || (issuer.MaxPathLen == 0 && !issuer.MaxPathLenZero) {
+		return nil
+	}
+	if issuer.MaxPathLen == 0 {
+		return berrors.MalformedError("issuer %q has a pathlen of 0, so can't issue CA certificates", cn)
+	}
+	if pathLen < 0 || pathLen >= issuer.MaxPathLen {
+		return berrors.MalformedError(
+			"issuer %q has a pathlen of %d, so can only issue CA certificates with a pathlen below that",
+			cn, issuer.MaxPathLen)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// errIncorrectKeyPassword is returned when an encrypted key can't be
+// decrypted with the password given.
+var errIncorrectKeyPassword = errors.New("incorrect password for encrypted issuer key")
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+)
+
+// pbkdf2PRFs maps the PRFs PBKDF2 may use [RFC8018 B.1] to their hashes. An
+// absent PRF means hmacWithSHA1.
+var pbkdf2PRFs = map[string]func() hash.Hash{
+	"1.2.840.113549.2.7":  sha1.New,   // hmacWithSHA1
+	"1.2.840.113549.2.9":  sha256.New, // hmacWithSHA256
+	"1.2.840.113549.2.10": sha512.New384,
+	"1.2.840.113549.2.11": sha512.New,
+}
+
+// pbes2Ciphers maps the AES-CBC encryption schemes PBES2 may use [RFC8018
+// B.2.5] to their key lengths.
+var pbes2Ciphers = map[string]int{
+	"2.16.840.1.101.3.4.1.2":  16, // aes128-CBC-Pad
+	"2.16.840.1.101.3.4.1.22": 24, // aes192-CBC-Pad
+	"2.16.840.1.101.3.4.1.42": 32, // aes256-CBC-Pad
+}
+
+// The structures below mirror an EncryptedPrivateKeyInfo [RFC5958 3] using
+// PBES2 with PBKDF2 [RFC8018 A.2, A.4].
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// ParseEncryptedKeyPEM decrypts a PEM encoded, passphrase-encrypted PKCS#8
+// private key, as produced by `openssl pkcs8 -topk8 -v2 aes-256-cbc`. Only
+// PBES2 with PBKDF2 and AES-CBC is supported.
+func ParseEncryptedKeyPEM(keyPEM []byte, password string) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		return nil, errors.New("no ENCRYPTED PRIVATE KEY PEM block found")
+	}
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted private key: %s", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption algorithm %s", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse PBES2 parameters: %s", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %s", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, fmt.Errorf("failed to parse PBKDF2 parameters: %s", err)
+	}
+	prf := sha1.New
+	if len(kdfParams.PRF.Algorithm) > 0 {
+		var ok bool
+		if prf, ok = pbkdf2PRFs[kdfParams.PRF.Algorithm.String()]; !ok {
+			return nil, fmt.Errorf("unsupported PBKDF2 PRF %s", kdfParams.PRF.Algorithm)
+		}
+	}
+	keyLength, ok := pbes2Ciphers[params.EncryptionScheme.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key cipher %s", params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New("invalid private key cipher IV")
+	}
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted private key isn't a whole number of blocks")
+	}
+
+	if kdfParams.IterationCount < 1 {
+		return nil, fmt.Errorf("invalid PBKDF2 iteration count %d", kdfParams.IterationCount)
+	}
+
+	key := pbkdf2.Key([]byte(password), kdfParams.Salt, kdfParams.IterationCount, keyLength, prf)
+	blockCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(blockCipher, iv).CryptBlocks(plaintext, info.EncryptedData)
+
+	// A wrong password shows up as bad padding or, rarely, as padding that
+	// happens to be valid in front of garbage that won't parse.
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errIncorrectKeyPassword
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(plaintext[:len(plaintext)-padding])
+	if err != nil {
+		return nil, errIncorrectKeyPassword
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+	return signer, nil
+}
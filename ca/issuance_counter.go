@@ -0,0 +1,131 @@
+package ca
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// IssuanceCounter records certificate issuances per registration, so that the
+// CA can cap how many certificates a single registration is issued within a
+// window even if the RA's rate limits fail.
+type IssuanceCounter interface {
+	// Reserve records an issuance for regID at the given time and returns
+	// true, unless limit issuances are already recorded for regID at or after
+	// since, in which case it records nothing and returns false. The check
+	// and the record must be atomic, so that concurrent issuances can't
+	// together exceed limit.
+	Reserve(ctx context.Context, regID int64, since, at time.Time, limit int) (bool, error)
+	// Release removes an issuance recorded by Reserve for regID at the given
+	// time, for an issuance that then failed.
+	Release(ctx context.Context, regID int64, at time.Time) error
+}
+
+// memoryIssuanceCounter is an IssuanceCounter that keeps issuance times in
+// memory. Counts are local to a single CA instance and lost on restart.
+type memoryIssuanceCounter struct {
+	mu     sync.Mutex
+	issued map[int64][]time.Time
+}
+
+// NewMemoryIssuanceCounter returns an IssuanceCounter that keeps its counts in
+// memory.
+func NewMemoryIssuanceCounter() IssuanceCounter {
+	return &memoryIssuanceCounter{
+		issued: make(map[int64][]time.Time),
+	}
+}
+
+// Reserve implements IssuanceCounter. Issuances before since are forgotten,
+// so callers must not later ask for an earlier since for the same regID.
+func (c *memoryIssuanceCounter) Reserve(_ context.Context, regID int64, since, at time.Time, limit int) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var times []time.Time
+	for _, issuedAt := range c.issued[regID] {
+		if !issuedAt.Before(since) {
+			times = append(times, issuedAt)
+		}
+	}
+	if len(times) >= limit {
+		c.issued[regID] = times
+		return false, nil
+	}
+	c.issued[regID] = append(times, at)
+	return true, nil
+}
+
+// Release implements IssuanceCounter.
+func (c *memoryIssuanceCounter) Release(_ context.Context, regID int64, at time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	times := c.issued[regID]
+	for i, issuedAt := range times {
+		if issuedAt.Equal(at) {
+			times = append(times[:i], times[i+1:]...)
+			break
+		}
+	}
+	if len(times) == 0 {
+		delete(c.issued, regID)
+	} else {
+		c.issued[regID] = times
+	}
+	return nil
+}
+
+// KeyIssuanceCounter records certificate issuances per subject public key, so
+// that the CA can cap how many certificates share one key. Keys are
+// identified by core.KeyDigest. It must be shared by every CA instance and
+// survive restarts, or each instance could issue up to the cap on its own.
+type KeyIssuanceCounter interface {
+	// Reserve records an issuance for keyDigest and returns true, unless
+	// limit issuances are already recorded for it, in which case it records
+	// nothing and returns false. The check and the record must be atomic, so
+	// that concurrent issuances can't together exceed limit.
+	Reserve(ctx context.Context, keyDigest string, limit int) (bool, error)
+	// Release removes an issuance recorded by Reserve for keyDigest, for an
+	// issuance that then failed.
+	Release(ctx context.Context, keyDigest string) error
+}
+
+// memoryKeyIssuanceCounter is a KeyIssuanceCounter that keeps its counts in
+// memory. Counts are local to a single CA instance and lost on restart, so
+// it's only suitable for tests and single-instance deployments.
+type memoryKeyIssuanceCounter struct {
+	mu     sync.Mutex
+	issued map[string]int
+}
+
+// NewMemoryKeyIssuanceCounter returns a KeyIssuanceCounter that keeps its
+// counts in memory.
+func NewMemoryKeyIssuanceCounter() KeyIssuanceCounter {
+	return &memoryKeyIssuanceCounter{
+		issued: make(map[string]int),
+	}
+}
+
+// Reserve implements KeyIssuanceCounter.
+func (c *memoryKeyIssuanceCounter) Reserve(_ context.Context, keyDigest string, limit int) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.issued[keyDigest] >= limit {
+		return false, nil
+	}
+	c.issued[keyDigest]++
+	return true, nil
+}
+
+// Release implements KeyIssuanceCounter.
+func (c *memoryKeyIssuanceCounter) Release(_ context.Context, keyDigest string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.issued[keyDigest] > 0 {
+		c.issued[keyDigest]--
+	}
+	if c.issued[keyDigest] == 0 {
+		delete(c.issued, keyDigest)
+	}
+	return nil
+}
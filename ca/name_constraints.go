@@ -0,0 +1,122 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"strings"
+
+	berrors "github.com/letsencrypt/boulder/errors"
+)
+
+var oidExtensionNameConstraints = asn1.ObjectIdentifier{2, 5, 29, 30}
+
+// The structures below mirror the NameConstraints extension [RFC5280
+// 4.2.1.10]. crypto/x509 only exposes its permitted DNS names, so the
+// extension is parsed here to get at the excluded ones too.
+type nameConstraints struct {
+	Permitted []generalSubtree `asn1:"optional,tag:0"`
+	Excluded  []generalSubtree `asn1:"optional,tag:1"`
+}
+
+type generalSubtree struct {
+	Base    asn1.RawValue
+	Minimum int `asn1:"optional,tag:0,default:0"`
+	Maximum int `asn1:"optional,tag:1"`
+}
+
+// dnsNameConstraints returns the DNS names in the permitted and excluded
+// subtrees of issuer's name constraints, if it has any. Subtrees of other
+// name types don't constrain DNS names, and are left out.
+func dnsNameConstraints(issuer *x509.Certificate) (permitted, excluded []string, err error) {
+	for _, ext := range issuer.Extensions {
+		if !ext.Id.Equal(oidExtensionNameConstraints) {
+			continue
+		}
+		var constraints nameConstraints
+		rest, err := asn1.Unmarshal(ext.Value, &constraints)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) > 0 {
+			return nil, nil, asn1.SyntaxError{Msg: "trailing data after name constraints"}
+		}
+		return subtreeDNSNames(constraints.Permitted), subtreeDNSNames(constraints.Excluded), nil
+	}
+	return nil, nil, nil
+}
+
+// subtreeDNSNames returns the dNSName [RFC5280 4.2.1.6] bases of subtrees.
+func subtreeDNSNames(subtrees []generalSubtree) []string {
+	var names []string
+	for _, subtree := range subtrees {
+		if subtree.Base.Class == asn1.ClassContextSpecific && subtree.Base.Tag == 2 {
+			names = append(names, string(subtree.Base.Bytes))
+		}
+	}
+	return names
+}
+
+// checkNameConstraints returns an error if any of the DNS names falls outside
+// the DNS name constraints [RFC5280 4.2.1.10] of issuer: if the issuer has
+// permitted subtrees and the name is in none of them, or if the name is in
+// one of its excluded subtrees. A wildcard name is treated as every name it
+// could match.
+func checkNameConstraints(issuer *x509.Certificate, names []string) error {
+	permittedDomains, excludedDomains, err := dnsNameConstraints(issuer)
+	if err != nil {
+		return berrors.InternalServerError("failed to parse name constraints of issuer %q: %s",
+			issuer.Subject.CommonName, err)
+	}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if len(permittedDomains) > 0 {
+			permitted := false
+			for _, constraint := range permittedDomains {
+				if inDNSSubtree(name, constraint) {
+					permitted = true
+					break
+				}
+			}
+			if !permitted {
+				return berrors.WithReason(berrors.MalformedError(
+					"name %q is not permitted by the name constraints of issuer %q",
+					name, issuer.Subject.CommonName), berrors.CSRForbiddenNames)
+			}
+		}
+		for _, constraint := range excludedDomains {
+			if inDNSSubtree(name, constraint) || wildcardMatches(name, constraint) {
+				return berrors.WithReason(berrors.MalformedError(
+					"name %q is excluded by the name constraints of issuer %q",
+					name, issuer.Subject.CommonName), berrors.CSRForbiddenNames)
+			}
+		}
+	}
+	return nil
+}
+
+// inDNSSubtree returns true if name is in the DNS subtree given by constraint:
+// a constraint of "example.com" includes it and all its subdomains, and one
+// of ".example.com" only its subdomains. A wildcard label is compared like
+// any other.
+func inDNSSubtree(name, constraint string) bool {
+	constraint = strings.ToLower(constraint)
+	if constraint == "" {
+		return true
+	}
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(name, constraint)
+	}
+	return name == constraint || strings.HasSuffix(name, "."+constraint)
+}
+
+// wildcardMatches returns true if name is a wildcard that matches the domain
+// named by constraint, so that some of the names it covers are in the
+// constraint's subtree even though the wildcard itself isn't.
+func wildcardMatches(name, constraint string) bool {
+	constraint = strings.ToLower(constraint)
+	if !strings.HasPrefix(name, "*.") || strings.HasPrefix(constraint, ".") {
+		return false
+	}
+	base := name[1:]
+	return strings.HasSuffix(constraint, base) && !strings.Contains(strings.TrimSuffix(constraint, base), ".")
+}
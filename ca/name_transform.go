@@ -0,0 +1,52 @@
+package ca
+
+import (
+	"crypto/x509"
+
+	csrlib "github.com/letsencrypt/boulder/csr"
+	berrors "github.com/letsencrypt/boulder/errors"
+)
+
+// NameTransform maps a name requested in a CSR to the canonical name to
+// issue for, e.g. an alias of a tenant's name in a multi-tenant setup. It
+// returns an error if the name can't be issued for.
+type NameTransform func(name string) (string, error)
+
+// transformNames replaces the DNS names and CN of csr with their canonical
+// names, if the CA has a NameTransform. Names are lowercased and converted to
+// their A-label form first, as the policy checks would, so that the transform
+// only has to handle names in that form. It runs before the policy checks, so
+// the canonical names are the ones checked and issued for.
+func (ca *CertificateAuthorityImpl) transformNames(csr *x509.CertificateRequest) error {
+	if ca.NameTransform == nil {
+		return nil
+	}
+	transform := func(name string) (string, error) {
+		normalized, err := csrlib.ToALabel(name)
+		if err != nil {
+			return "", err
+		}
+		canonical, err := ca.NameTransform(normalized)
+		if err != nil {
+			return "", berrors.MalformedError("unable to transform name %q: %s", name, err)
+		}
+		return canonical, nil
+	}
+	names := make([]string, len(csr.DNSNames))
+	for i, name := range csr.DNSNames {
+		canonical, err := transform(name)
+		if err != nil {
+			return err
+		}
+		names[i] = canonical
+	}
+	csr.DNSNames = names
+	if cn := csr.Subject.CommonName; cn != "" {
+		canonical, err := transform(cn)
+		if err != nil {
+			return err
+		}
+		csr.Subject.CommonName = canonical
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package ca
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/boulder/revocation"
+)
+
+// ocspCacheKey identifies OCSP responses that are interchangeable. Responses
+// with a nonce are never cached, so it has no part in the key.
+type ocspCacheKey struct {
+	issuer    issuerID
+	serial    string
+	status    string
+	reason    revocation.Reason
+	revokedAt time.Time
+}
+
+type ocspCacheEntry struct {
+	key      ocspCacheKey
+	response []byte
+	expires  time.Time
+}
+
+// ocspCache is a fixed size LRU cache of signed OCSP responses, each of which
+// is served for a fixed time after it was signed.
+type ocspCache struct {
+	mu        sync.Mutex
+	size      int
+	freshness time.Duration
+	// lru holds *ocspCacheEntry, most recently used first.
+	lru     *list.List
+	entries map[ocspCacheKey]*list.Element
+}
+
+func newOCSPCache(size int, freshness time.Duration) *ocspCache {
+	return &ocspCache{
+		size:      size,
+		freshness: freshness,
+		lru:       list.New(),
+		entries:   make(map[ocspCacheKey]*list.Element),
+	}
+}
+
+// get returns the response cached for key, or nil if there is none that's
+// still fresh at now.
+func (c *ocspCache) get(key ocspCacheKey, now time.Time) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem := c.entries[key]
+	if elem == nil {
+		return nil
+	}
+	entry := elem.Value.(*ocspCacheEntry)
+	if !now.Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return entry.response
+}
+
+// add caches response for key as signed at now, evicting the least recently
+// used response if the cache is full.
+func (c *ocspCache) add(key ocspCacheKey, response []byte, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem := c.entries[key]; elem != nil {
+		c.lru.Remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&ocspCacheEntry{
+		key:      key,
+		response: response,
+		expires:  now.Add(c.freshness),
+	})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*ocspCacheEntry).key)
+	}
+}
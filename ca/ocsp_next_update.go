@@ -0,0 +1,55 @@
+package ca
+
+import (
+	"fmt"
+	"time"
+)
+
+// ocspNextUpdateStrategy determines how long after its thisUpdate an OCSP
+// response's nextUpdate is.
+type ocspNextUpdateStrategy string
+
+const (
+	// ocspNextUpdateFixed puts nextUpdate a fixed lifespan after thisUpdate.
+	ocspNextUpdateFixed ocspNextUpdateStrategy = "fixed"
+	// ocspNextUpdateFractional puts nextUpdate a fraction of the certificate's
+	// remaining lifetime after thisUpdate, capped at the fixed lifespan, so
+	// responses for certificates nearing expiry are refreshed at staggered
+	// times and never outlive the certificate.
+	ocspNextUpdateFractional ocspNextUpdateStrategy = "fractional"
+)
+
+// parseOCSPNextUpdateStrategy returns the ocspNextUpdateStrategy named by s.
+// The empty string selects ocspNextUpdateFixed.
+func parseOCSPNextUpdateStrategy(s string) (ocspNextUpdateStrategy, error) {
+	switch strategy := ocspNextUpdateStrategy(s); strategy {
+	case "":
+		return ocspNextUpdateFixed, nil
+	case ocspNextUpdateFixed, ocspNextUpdateFractional:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown OCSP nextUpdate strategy %q", s)
+	}
+}
+
+// ocspNextUpdate returns the nextUpdate of an OCSP response produced at
+// thisUpdate for a certificate expiring at notAfter. Whatever the strategy,
+// it's never after notAfter, so a certificate that had already expired by
+// thisUpdate gets a nextUpdate equal to thisUpdate.
+func (ca *CertificateAuthorityImpl) ocspNextUpdate(thisUpdate, notAfter time.Time) time.Time {
+	remaining := notAfter.Sub(thisUpdate)
+	if remaining < 0 {
+		remaining = 0
+	}
+	interval := ca.lifespanOCSP
+	if ca.ocspNextUpdateStrategy == ocspNextUpdateFractional {
+		interval = time.Duration(float64(remaining) * ca.ocspNextUpdateFraction)
+		if interval > ca.lifespanOCSP {
+			interval = ca.lifespanOCSP
+		}
+	}
+	if interval > remaining {
+		interval = remaining
+	}
+	return thisUpdate.Add(interval)
+}
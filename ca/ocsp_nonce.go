@@ -0,0 +1,120 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// oidOCSPNonce identifies the OCSP nonce extension [RFC6960 4.4.1].
+var oidOCSPNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+// maxOCSPNonceLength is the longest nonce the CA will echo [RFC8954].
+const maxOCSPNonceLength = 32
+
+// ocspSignatureHashes maps the signature algorithms used for OCSP responses to
+// the hash each signs over.
+var ocspSignatureHashes = map[string]crypto.Hash{
+	"1.2.840.113549.1.1.5":  crypto.SHA1,   // sha1WithRSAEncryption
+	"1.2.840.113549.1.1.11": crypto.SHA256, // sha256WithRSAEncryption
+	"1.2.840.113549.1.1.12": crypto.SHA384, // sha384WithRSAEncryption
+	"1.2.840.113549.1.1.13": crypto.SHA512, // sha512WithRSAEncryption
+	"1.2.840.10045.4.1":     crypto.SHA1,   // ecdsa-with-SHA1
+	"1.2.840.10045.4.3.2":   crypto.SHA256, // ecdsa-with-SHA256
+	"1.2.840.10045.4.3.3":   crypto.SHA384, // ecdsa-with-SHA384
+	"1.2.840.10045.4.3.4":   crypto.SHA512, // ecdsa-with-SHA512
+}
+
+// The structures below mirror an OCSP response [RFC6960 4.2.1] closely enough
+// to add responseExtensions or set producedAt, which golang.org/x/crypto/ocsp
+// can't do, while leaving every other field byte for byte as it was signed.
+type rawOCSPResponse struct {
+	Status        asn1.Enumerated
+	ResponseBytes rawOCSPResponseBytes `asn1:"explicit,tag:0"`
+}
+
+type rawOCSPResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type rawBasicOCSPResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type rawOCSPResponseData struct {
+	Version            int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID        asn1.RawValue
+	ProducedAt         asn1.RawValue
+	Responses          asn1.RawValue
+	ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// amendOCSPResponse returns the DER encoded OCSP response with its producedAt
+// replaced by producedAt, unless that is zero, and with nonce added to its
+// responseExtensions, unless that is nil, re-signed with key. Errors from key
+// are returned unwrapped so that signing failures can be classified by the
+// caller.
+func amendOCSPResponse(response []byte, producedAt time.Time, nonce []byte, key crypto.Signer) ([]byte, error) {
+	var outer rawOCSPResponse
+	if rest, err := asn1.Unmarshal(response, &outer); err != nil {
+		return nil, fmt.Errorf("failed to parse OCSP response: %s", err)
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data after OCSP response")
+	}
+	var basic rawBasicOCSPResponse
+	if _, err := asn1.Unmarshal(outer.ResponseBytes.Response, &basic); err != nil {
+		return nil, fmt.Errorf("failed to parse basic OCSP response: %s", err)
+	}
+	var tbs rawOCSPResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &tbs); err != nil {
+		return nil, fmt.Errorf("failed to parse OCSP response data: %s", err)
+	}
+
+	if !producedAt.IsZero() {
+		tbs.ProducedAt = asn1.RawValue{
+			Tag:   asn1.TagGeneralizedTime,
+			Bytes: []byte(producedAt.UTC().Format("20060102150405Z")),
+		}
+	}
+	if nonce != nil {
+		nonceValue, err := asn1.Marshal(nonce)
+		if err != nil {
+			return nil, err
+		}
+		tbs.ResponseExtensions = append(tbs.ResponseExtensions, pkix.Extension{
+			Id:    oidOCSPNonce,
+			Value: nonceValue,
+		})
+	}
+	tbsDER, err := asn1.Marshal(tbs)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, ok := ocspSignatureHashes[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported OCSP signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	h := hash.New()
+	h.Write(tbsDER)
+	signature, err := key.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, err
+	}
+
+	basic.TBSResponseData = asn1.RawValue{FullBytes: tbsDER}
+	basic.Signature = asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)}
+	outer.ResponseBytes.Response, err = asn1.Marshal(basic)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(outer)
+}
@@ -0,0 +1,82 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// ocspSignatureHashNames maps the names of the hashes OCSP responses may be
+// signed over to the hashes themselves.
+var ocspSignatureHashNames = map[string]crypto.Hash{
+	"SHA256": crypto.SHA256,
+	"SHA384": crypto.SHA384,
+	"SHA512": crypto.SHA512,
+}
+
+// parseOCSPSignatureHash returns the hash named by s. The empty string selects
+// the zero hash, for the default of the signing key's type.
+func parseOCSPSignatureHash(s string) (crypto.Hash, error) {
+	if s == "" {
+		return 0, nil
+	}
+	hash, ok := ocspSignatureHashNames[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown OCSP signature hash %q", s)
+	}
+	return hash, nil
+}
+
+// ocspSignatureHashName returns the configuration name of hash, since
+// crypto.Hash has no String method.
+func ocspSignatureHashName(hash crypto.Hash) string {
+	for name, h := range ocspSignatureHashNames {
+		if h == hash {
+			return name
+		}
+	}
+	return fmt.Sprintf("hash %d", hash)
+}
+
+// pkcs1DigestInfoLength is the length of the DigestInfo prefix [RFC8017 9.2]
+// PKCS #1 v1.5 adds to SHA-2 hashes before signing them.
+const pkcs1DigestInfoLength = 19
+
+// ocspSignatureAlgorithm returns the algorithm for signing OCSP responses over
+// hash with the private key for pub. The zero hash returns the zero algorithm,
+// for the default of the key's type. It returns an error if the key can't
+// sign over hash, including if an RSA key is too small to hold its PKCS #1
+// v1.5 encoding.
+func ocspSignatureAlgorithm(pub crypto.PublicKey, hash crypto.Hash) (x509.SignatureAlgorithm, error) {
+	if hash == 0 {
+		return x509.UnknownSignatureAlgorithm, nil
+	}
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if minSize := hash.Size() + pkcs1DigestInfoLength + 11; (key.N.BitLen()+7)/8 < minSize {
+			return 0, fmt.Errorf("%d bit RSA key is too small to sign over %s", key.N.BitLen(), ocspSignatureHashName(hash))
+		}
+		switch hash {
+		case crypto.SHA256:
+			return x509.SHA256WithRSA, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSA, nil
+		case crypto.SHA512:
+			return x509.SHA512WithRSA, nil
+		}
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			return x509.ECDSAWithSHA256, nil
+		case crypto.SHA384:
+			return x509.ECDSAWithSHA384, nil
+		case crypto.SHA512:
+			return x509.ECDSAWithSHA512, nil
+		}
+	default:
+		return 0, fmt.Errorf("OCSP signature hash can't be set for %T keys", pub)
+	}
+	return 0, fmt.Errorf("unsupported OCSP signature hash %s", ocspSignatureHashName(hash))
+}
@@ -0,0 +1,37 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/letsencrypt/boulder/core"
+	berrors "github.com/letsencrypt/boulder/errors"
+)
+
+// CertificatePEM returns the PEM encoding of cert as a single CERTIFICATE
+// block.
+func CertificatePEM(cert core.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.DER})
+}
+
+// CertificateChainPEM returns the PEM encoding of cert followed by that of the
+// issuer certificate it was signed by, found by its authority key ID. It
+// returns an error if cert wasn't issued by one of this CA's issuers.
+func (ca *CertificateAuthorityImpl) CertificateChainPEM(cert core.Certificate) ([]byte, error) {
+	parsed, err := x509.ParseCertificate(cert.DER)
+	if err != nil {
+		return nil, berrors.MalformedError("unable to parse certificate: %s", err)
+	}
+	cn := parsed.Issuer.CommonName
+	issuer := ca.issuerFor(parsed)
+	if issuer == nil {
+		return nil, berrors.NotFoundError("no issuer with CommonName %q and subject key ID %x", cn, parsed.AuthorityKeyId)
+	}
+	if err := parsed.CheckSignatureFrom(issuer.cert); err != nil {
+		return nil, berrors.MalformedError(
+			"certificate %s wasn't issued by issuer %q: %s",
+			core.SerialToString(parsed.SerialNumber), cn, err)
+	}
+	chain := CertificatePEM(cert)
+	return append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.cert.Raw})...), nil
+}
@@ -0,0 +1,189 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cfsslConfig "github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/signer"
+	"golang.org/x/net/context"
+
+	"github.com/letsencrypt/boulder/core"
+	berrors "github.com/letsencrypt/boulder/errors"
+)
+
+// PreviewCertificate returns a description of the certificate IssueCertificate
+// would issue for csr, made the same way but without the issuer's key, so
+// that the effect of configuration changes can be reviewed. The certificate
+// is given a freshly generated serial, but nothing is signed, stored or
+// published, and the request doesn't count towards the registration's
+// issuance limit.
+func (ca *CertificateAuthorityImpl) PreviewCertificate(ctx context.Context, csr x509.CertificateRequest, regID int64) (string, error) {
+	plan, err := ca.planIssuance(&csr, regID, IssueOptions{})
+	if err != nil {
+		return "", err
+	}
+	serial, err := ca.generateSerial()
+	if err != nil {
+		return "", err
+	}
+	req, adjust, err := ca.signRequest(&csr, regID, plan, serial)
+	if err != nil {
+		return "", err
+	}
+	cert, err := plan.issuer.previewCertificate(req, adjust)
+	if err != nil {
+		return "", berrors.InternalServerError("failed to preview certificate: %s", err)
+	}
+	return describeCertificate(cert), nil
+}
+
+// previewCertificate returns the certificate this issuer would sign for req,
+// with the signing profile passed through adjust if it isn't nil. It is signed
+// by a throwaway key of the same type and size as the issuer's, standing in
+// for it in a copy of the issuer certificate, so everything but the signature
+// is exactly as the issuer would sign it.
+func (ii *internalIssuer) previewCertificate(req signer.SignRequest, adjust func(*cfsslConfig.SigningProfile)) (*x509.Certificate, error) {
+	key, err := ii.standInKey()
+	if err != nil {
+		return nil, err
+	}
+	standIn := *ii.cert
+	standIn.PublicKey = key.Public()
+
+	policy := ii.policy
+	if adjust != nil {
+		policy = ii.policyWithProfile(req.Profile, adjust)
+	}
+	previewSigner, err := ii.newSigner(key, &standIn, policy)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, err := previewSigner.Sign(req)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("invalid certificate value returned")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// standInKey returns the throwaway key previewCertificate signs with,
+// generating it on first use.
+func (ii *internalIssuer) standInKey() (crypto.Signer, error) {
+	ii.previewMu.Lock()
+	defer ii.previewMu.Unlock()
+	if ii.previewKey != nil {
+		return ii.previewKey, nil
+	}
+	var key crypto.Signer
+	var err error
+	switch pub := ii.cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		key, err = rsa.GenerateKey(rand.Reader, pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		key, err = ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	default:
+		err = fmt.Errorf("unsupported issuer key type %T", ii.cert.PublicKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ii.previewKey = key
+	return key, nil
+}
+
+// keyUsageNames names the bits of x509.KeyUsage, in order.
+var keyUsageNames = []string{
+	"digital signature",
+	"content commitment",
+	"key encipherment",
+	"data encipherment",
+	"key agreement",
+	"cert sign",
+	"crl sign",
+	"encipher only",
+	"decipher only",
+}
+
+// extKeyUsageNames names the x509.ExtKeyUsages the CA's profiles can set.
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "server auth",
+	x509.ExtKeyUsageClientAuth:      "client auth",
+	x509.ExtKeyUsageCodeSigning:     "code signing",
+	x509.ExtKeyUsageEmailProtection: "email protection",
+	x509.ExtKeyUsageTimeStamping:    "timestamping",
+	x509.ExtKeyUsageOCSPSigning:     "ocsp signing",
+}
+
+// describeCertificate returns a human-readable description of every field of
+// cert apart from its signature. Extensions are listed with their values in
+// hex, in the order they appear in the certificate.
+func describeCertificate(cert *x509.Certificate) string {
+	var b bytes.Buffer
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\n", args...)
+	}
+	line("Serial Number: %s", core.SerialToString(cert.SerialNumber))
+	line("Signature Algorithm: %s", cert.SignatureAlgorithm)
+	line("Issuer: %s", cert.Issuer)
+	line("Not Before: %s", cert.NotBefore.UTC().Format(time.RFC3339))
+	line("Not After: %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	line("Subject: %s", cert.Subject)
+	line("Public Key Algorithm: %s", cert.PublicKeyAlgorithm)
+
+	var sans []string
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	line("Subject Alternative Names: %s", strings.Join(sans, ", "))
+
+	var usages []string
+	for i, name := range keyUsageNames {
+		if cert.KeyUsage&(1<<uint(i)) != 0 {
+			usages = append(usages, name)
+		}
+	}
+	line("Key Usage: %s", strings.Join(usages, ", "))
+	var extUsages []string
+	for _, usage := range cert.ExtKeyUsage {
+		name, ok := extKeyUsageNames[usage]
+		if !ok {
+			name = fmt.Sprintf("unknown (%d)", usage)
+		}
+		extUsages = append(extUsages, name)
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		extUsages = append(extUsages, oid.String())
+	}
+	line("Extended Key Usage: %s", strings.Join(extUsages, ", "))
+
+	line("Extensions:")
+	for _, ext := range cert.Extensions {
+		critical := ""
+		if ext.Critical {
+			critical = " (critical)"
+		}
+		line("    %s%s: %s", ext.Id, critical, hex.EncodeToString(ext.Value))
+	}
+	return b.String()
+}
@@ -12,6 +12,9 @@ It has these top-level messages:
 	IssueCertificateRequest
 	GenerateOCSPRequest
 	OCSPResponse
+	RegenerateOCSPBatchRequest
+	RegenerateOCSPBatchResponse
+	SerialOCSPResponse
 */
 package proto
 
@@ -66,6 +69,9 @@ type GenerateOCSPRequest struct {
 	Status           *string `protobuf:"bytes,2,opt,name=status" json:"status,omitempty"`
 	Reason           *int32  `protobuf:"varint,3,opt,name=reason" json:"reason,omitempty"`
 	RevokedAt        *int64  `protobuf:"varint,4,opt,name=revokedAt" json:"revokedAt,omitempty"`
+	Serial           *string `protobuf:"bytes,5,opt,name=serial" json:"serial,omitempty"`
+	ProducedAt       *int64  `protobuf:"varint,6,opt,name=producedAt" json:"producedAt,omitempty"`
+	Nonce            []byte  `protobuf:"bytes,7,opt,name=nonce" json:"nonce,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -102,6 +108,27 @@ func (m *GenerateOCSPRequest) GetRevokedAt() int64 {
 	return 0
 }
 
+func (m *GenerateOCSPRequest) GetSerial() string {
+	if m != nil && m.Serial != nil {
+		return *m.Serial
+	}
+	return ""
+}
+
+func (m *GenerateOCSPRequest) GetProducedAt() int64 {
+	if m != nil && m.ProducedAt != nil {
+		return *m.ProducedAt
+	}
+	return 0
+}
+
+func (m *GenerateOCSPRequest) GetNonce() []byte {
+	if m != nil {
+		return m.Nonce
+	}
+	return nil
+}
+
 type OCSPResponse struct {
 	Response         []byte `protobuf:"bytes,1,opt,name=response" json:"response,omitempty"`
 	XXX_unrecognized []byte `json:"-"`
@@ -119,10 +146,80 @@ func (m *OCSPResponse) GetResponse() []byte {
 	return nil
 }
 
+type RegenerateOCSPBatchRequest struct {
+	Serials          []string `protobuf:"bytes,1,rep,name=serials" json:"serials,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *RegenerateOCSPBatchRequest) Reset()                    { *m = RegenerateOCSPBatchRequest{} }
+func (m *RegenerateOCSPBatchRequest) String() string            { return proto1.CompactTextString(m) }
+func (*RegenerateOCSPBatchRequest) ProtoMessage()               {}
+func (*RegenerateOCSPBatchRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *RegenerateOCSPBatchRequest) GetSerials() []string {
+	if m != nil {
+		return m.Serials
+	}
+	return nil
+}
+
+type RegenerateOCSPBatchResponse struct {
+	Responses        []*SerialOCSPResponse `protobuf:"bytes,1,rep,name=responses" json:"responses,omitempty"`
+	NotFound         []string              `protobuf:"bytes,2,rep,name=notFound" json:"notFound,omitempty"`
+	XXX_unrecognized []byte                `json:"-"`
+}
+
+func (m *RegenerateOCSPBatchResponse) Reset()                    { *m = RegenerateOCSPBatchResponse{} }
+func (m *RegenerateOCSPBatchResponse) String() string            { return proto1.CompactTextString(m) }
+func (*RegenerateOCSPBatchResponse) ProtoMessage()               {}
+func (*RegenerateOCSPBatchResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *RegenerateOCSPBatchResponse) GetResponses() []*SerialOCSPResponse {
+	if m != nil {
+		return m.Responses
+	}
+	return nil
+}
+
+func (m *RegenerateOCSPBatchResponse) GetNotFound() []string {
+	if m != nil {
+		return m.NotFound
+	}
+	return nil
+}
+
+type SerialOCSPResponse struct {
+	Serial           *string `protobuf:"bytes,1,opt,name=serial" json:"serial,omitempty"`
+	Response         []byte  `protobuf:"bytes,2,opt,name=response" json:"response,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *SerialOCSPResponse) Reset()                    { *m = SerialOCSPResponse{} }
+func (m *SerialOCSPResponse) String() string            { return proto1.CompactTextString(m) }
+func (*SerialOCSPResponse) ProtoMessage()               {}
+func (*SerialOCSPResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *SerialOCSPResponse) GetSerial() string {
+	if m != nil && m.Serial != nil {
+		return *m.Serial
+	}
+	return ""
+}
+
+func (m *SerialOCSPResponse) GetResponse() []byte {
+	if m != nil {
+		return m.Response
+	}
+	return nil
+}
+
 func init() {
 	proto1.RegisterType((*IssueCertificateRequest)(nil), "ca.IssueCertificateRequest")
 	proto1.RegisterType((*GenerateOCSPRequest)(nil), "ca.GenerateOCSPRequest")
 	proto1.RegisterType((*OCSPResponse)(nil), "ca.OCSPResponse")
+	proto1.RegisterType((*RegenerateOCSPBatchRequest)(nil), "ca.RegenerateOCSPBatchRequest")
+	proto1.RegisterType((*RegenerateOCSPBatchResponse)(nil), "ca.RegenerateOCSPBatchResponse")
+	proto1.RegisterType((*SerialOCSPResponse)(nil), "ca.SerialOCSPResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -201,6 +298,7 @@ var _CertificateAuthority_serviceDesc = grpc.ServiceDesc{
 
 type OCSPGeneratorClient interface {
 	GenerateOCSP(ctx context.Context, in *GenerateOCSPRequest, opts ...grpc.CallOption) (*OCSPResponse, error)
+	RegenerateOCSPBatch(ctx context.Context, in *RegenerateOCSPBatchRequest, opts ...grpc.CallOption) (*RegenerateOCSPBatchResponse, error)
 }
 
 type oCSPGeneratorClient struct {
@@ -220,10 +318,20 @@ func (c *oCSPGeneratorClient) GenerateOCSP(ctx context.Context, in *GenerateOCSP
 	return out, nil
 }
 
+func (c *oCSPGeneratorClient) RegenerateOCSPBatch(ctx context.Context, in *RegenerateOCSPBatchRequest, opts ...grpc.CallOption) (*RegenerateOCSPBatchResponse, error) {
+	out := new(RegenerateOCSPBatchResponse)
+	err := grpc.Invoke(ctx, "/ca.OCSPGenerator/RegenerateOCSPBatch", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for OCSPGenerator service
 
 type OCSPGeneratorServer interface {
 	GenerateOCSP(context.Context, *GenerateOCSPRequest) (*OCSPResponse, error)
+	RegenerateOCSPBatch(context.Context, *RegenerateOCSPBatchRequest) (*RegenerateOCSPBatchResponse, error)
 }
 
 func RegisterOCSPGeneratorServer(s *grpc.Server, srv OCSPGeneratorServer) {
@@ -248,6 +356,24 @@ func _OCSPGenerator_GenerateOCSP_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _OCSPGenerator_RegenerateOCSPBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegenerateOCSPBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OCSPGeneratorServer).RegenerateOCSPBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ca.OCSPGenerator/RegenerateOCSPBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OCSPGeneratorServer).RegenerateOCSPBatch(ctx, req.(*RegenerateOCSPBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _OCSPGenerator_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "ca.OCSPGenerator",
 	HandlerType: (*OCSPGeneratorServer)(nil),
@@ -256,6 +382,10 @@ var _OCSPGenerator_serviceDesc = grpc.ServiceDesc{
 			MethodName: "GenerateOCSP",
 			Handler:    _OCSPGenerator_GenerateOCSP_Handler,
 		},
+		{
+			MethodName: "RegenerateOCSPBatch",
+			Handler:    _OCSPGenerator_RegenerateOCSPBatch_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "ca/proto/ca.proto",
@@ -264,23 +394,30 @@ var _OCSPGenerator_serviceDesc = grpc.ServiceDesc{
 func init() { proto1.RegisterFile("ca/proto/ca.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 276 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x90, 0xcd, 0x4b, 0xf3, 0x40,
-	0x10, 0xc6, 0x9b, 0xe6, 0xed, 0x5b, 0x3b, 0xc6, 0x9a, 0xac, 0x1f, 0x0d, 0xf1, 0x12, 0x72, 0xca,
-	0x29, 0x85, 0x5e, 0x05, 0xa1, 0x36, 0x22, 0x05, 0x41, 0xa9, 0x27, 0xc5, 0xcb, 0xb2, 0x8e, 0x1a,
-	0x84, 0x6c, 0x9d, 0x99, 0x08, 0xfe, 0xf7, 0x92, 0x8f, 0x42, 0x10, 0xbd, 0xcd, 0xf2, 0xf0, 0xfc,
-	0x76, 0x7e, 0x03, 0x81, 0xd1, 0xf3, 0x2d, 0x59, 0xb1, 0x73, 0xa3, 0xb3, 0x66, 0x50, 0x43, 0xa3,
-	0xa3, 0x13, 0x63, 0x09, 0x77, 0x81, 0x25, 0x6c, 0xa3, 0xe4, 0x02, 0x66, 0x6b, 0xe6, 0x0a, 0x57,
-	0x48, 0x52, 0xbc, 0x14, 0x46, 0x0b, 0x6e, 0xf0, 0xa3, 0x42, 0x16, 0xb5, 0x0f, 0xae, 0x61, 0x0a,
-	0x9d, 0xd8, 0x49, 0x3d, 0x75, 0x0a, 0x53, 0xc2, 0xd7, 0x82, 0x85, 0xb4, 0x14, 0xb6, 0x5c, 0xe7,
-	0xe1, 0x30, 0x76, 0x52, 0x37, 0x79, 0x80, 0xa3, 0x6b, 0x2c, 0x91, 0xb4, 0xe0, 0xed, 0xea, 0xfe,
-	0x6e, 0xd7, 0x3d, 0x84, 0xb1, 0x41, 0x92, 0xfc, 0x6a, 0xd3, 0xf5, 0xa7, 0xf0, 0x9f, 0x45, 0x4b,
-	0xc5, 0x4d, 0x6f, 0x52, 0xbf, 0x09, 0x35, 0xdb, 0x32, 0x74, 0x63, 0x27, 0x1d, 0xa9, 0x00, 0x26,
-	0x84, 0x9f, 0xf6, 0x1d, 0x9f, 0x97, 0x12, 0xfe, 0x6b, 0xd0, 0x31, 0x78, 0x2d, 0x92, 0xb7, 0xb6,
-	0x64, 0x54, 0x3e, 0xec, 0x51, 0x37, 0xb7, 0xd0, 0xc5, 0x13, 0x1c, 0xf7, 0xf6, 0x5e, 0x56, 0xf2,
-	0x66, 0xa9, 0x90, 0x2f, 0x95, 0x83, 0xff, 0x53, 0x4a, 0x9d, 0x65, 0x46, 0x67, 0x7f, 0xa8, 0x46,
-	0x41, 0xd6, 0x9c, 0xa4, 0x97, 0x24, 0x83, 0xc5, 0x0d, 0x1c, 0xd4, 0xff, 0x77, 0x7a, 0x96, 0xd4,
-	0x39, 0x78, 0x7d, 0x57, 0x35, 0xab, 0x91, 0xbf, 0xd8, 0x47, 0x7e, 0x1d, 0xf4, 0x77, 0x4f, 0x06,
-	0x97, 0xe3, 0xc7, 0x51, 0x73, 0xf1, 0xef, 0x00, 0x00, 0x00, 0xff, 0xff, 0xd4, 0x60, 0xcf, 0xa9,
-	0xa0, 0x01, 0x00, 0x00,
+	// 391 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x75, 0x52, 0x4d, 0x4f, 0x02, 0x31,
+	0x10, 0x65, 0x59, 0x11, 0x19, 0x3e, 0x84, 0xa2, 0xb0, 0x59, 0x12, 0x25, 0x7b, 0xc2, 0x83, 0x90,
+	0x70, 0xf0, 0x62, 0x62, 0xc2, 0x87, 0x1a, 0x4e, 0x1a, 0xb8, 0x18, 0xe2, 0xa5, 0x29, 0x23, 0x6c,
+	0x34, 0x5b, 0x6c, 0xbb, 0x26, 0xfe, 0x07, 0x7f, 0x87, 0xbf, 0xd3, 0xb6, 0x0b, 0x71, 0x43, 0xe0,
+	0x36, 0xed, 0x7b, 0xf3, 0xfa, 0x66, 0x5e, 0xa1, 0xc6, 0x68, 0x6f, 0x2d, 0xb8, 0xe2, 0x3d, 0x46,
+	0xbb, 0xb6, 0x20, 0x59, 0x46, 0xfd, 0x73, 0xc6, 0x05, 0x6e, 0x01, 0x5d, 0x26, 0x50, 0x70, 0x07,
+	0xcd, 0x89, 0x94, 0x31, 0x8e, 0x50, 0xa8, 0xf0, 0x2d, 0x64, 0x54, 0xe1, 0x14, 0x3f, 0x63, 0x94,
+	0x8a, 0x14, 0xc1, 0x65, 0x52, 0x78, 0x4e, 0xdb, 0xe9, 0x94, 0x48, 0x03, 0x2a, 0x02, 0x97, 0xa1,
+	0x54, 0x82, 0xaa, 0x90, 0x47, 0x93, 0xb1, 0x97, 0xd5, 0xf7, 0x6e, 0xf0, 0xe3, 0x40, 0xfd, 0x11,
+	0x23, 0xd4, 0xd7, 0xf8, 0x34, 0x9a, 0x3d, 0x6f, 0x9b, 0x4f, 0x21, 0xcf, 0xb4, 0xe4, 0xf8, 0x7e,
+	0xba, 0x11, 0xa8, 0xc0, 0xb1, 0x54, 0x54, 0xc5, 0xd2, 0x36, 0x16, 0xcc, 0x59, 0x20, 0x95, 0x3c,
+	0xf2, 0x5c, 0x7d, 0xce, 0x91, 0x1a, 0x14, 0x04, 0x7e, 0xf1, 0x77, 0x5c, 0x0c, 0x94, 0x77, 0x64,
+	0xb4, 0x6d, 0x0b, 0x8a, 0x90, 0x7e, 0x78, 0x39, 0xdb, 0x42, 0x00, 0xb4, 0xe9, 0x45, 0xcc, 0x2c,
+	0xe7, 0xd8, 0x72, 0xca, 0x90, 0x8b, 0x78, 0xc4, 0xd0, 0xcb, 0x9b, 0x57, 0x82, 0x36, 0x94, 0x12,
+	0x17, 0x72, 0xcd, 0x23, 0x89, 0xa4, 0x0a, 0x27, 0x62, 0x53, 0x27, 0x3e, 0x82, 0x6b, 0xf0, 0xa7,
+	0xb8, 0x4c, 0x39, 0x1e, 0x52, 0xc5, 0x56, 0x29, 0xdb, 0xc9, 0x93, 0x52, 0xd3, 0xdd, 0x4e, 0x21,
+	0x98, 0x43, 0x6b, 0x2f, 0x7d, 0xa3, 0x7f, 0x65, 0x5c, 0x27, 0x75, 0xd2, 0x51, 0xec, 0x37, 0xba,
+	0x7a, 0xef, 0x33, 0x2b, 0xb2, 0x6b, 0x25, 0xe2, 0xea, 0x81, 0xc7, 0xd1, 0x42, 0xaf, 0xc0, 0x68,
+	0xdf, 0x00, 0xd9, 0xc3, 0xfb, 0x9f, 0xda, 0xb1, 0x53, 0xa7, 0x47, 0x30, 0xab, 0x2b, 0xf5, 0x5f,
+	0xe1, 0x2c, 0x15, 0xd7, 0x20, 0x56, 0x2b, 0x2e, 0x42, 0xf5, 0x4d, 0xc6, 0x50, 0xdd, 0xcd, 0x92,
+	0xb4, 0x8c, 0x9b, 0x03, 0x09, 0xfb, 0xb5, 0xae, 0xfd, 0x09, 0x29, 0x24, 0xc8, 0xf4, 0x7f, 0x1d,
+	0x28, 0x1b, 0x43, 0x9b, 0x54, 0xb9, 0x20, 0xb7, 0x50, 0x4a, 0x47, 0x4c, 0x9a, 0x46, 0x73, 0x4f,
+	0xe8, 0x7e, 0xd5, 0x00, 0xe9, 0x61, 0x82, 0x0c, 0x79, 0x81, 0xfa, 0x9e, 0x05, 0x92, 0x0b, 0x43,
+	0x3d, 0x1c, 0x84, 0x7f, 0x79, 0x10, 0xdf, 0x2a, 0x0f, 0xf3, 0xf3, 0x9c, 0xfd, 0xc3, 0x7f, 0x2b,
+	0xc5, 0x2d, 0xbc, 0xf2, 0x02, 0x00, 0x00,
 }
@@ -0,0 +1,208 @@
+package ca
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// publishQueue holds issued certificates waiting to be submitted to the
+// Publisher by a bounded pool of workers.
+type publishQueue struct {
+	certs chan []byte
+	// maxAttempts is how many times each certificate is submitted before
+	// giving up, and backoff how long to wait before the first retry. The
+	// wait doubles for every retry after that, up to maxBackoff.
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	// attemptTimeout bounds each submission.
+	attemptTimeout time.Duration
+
+	// ctx is canceled once DrainPublisher stops waiting, after which
+	// submissions in progress are canceled and nothing more is retried or
+	// submitted.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	workers sync.WaitGroup
+	// mu guards closed, and is held for reading while sending on certs so
+	// that drain can't close it underneath a sender.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// Defaults for the publish workers' maximum retry backoff and submission
+// timeout, used if they aren't configured.
+const (
+	defaultPublishMaxBackoff     = time.Minute
+	defaultPublishAttemptTimeout = 30 * time.Second
+)
+
+// startPublishWorkers starts workers goroutines that submit certificates
+// queued by publish, of which up to queueSize may wait at once. Zero
+// maxBackoff and attemptTimeout select their defaults.
+func (ca *CertificateAuthorityImpl) startPublishWorkers(workers, queueSize, maxAttempts int, backoff, maxBackoff, attemptTimeout time.Duration) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultPublishMaxBackoff
+	}
+	if attemptTimeout <= 0 {
+		attemptTimeout = defaultPublishAttemptTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ca.publishQueue = &publishQueue{
+		certs:          make(chan []byte, queueSize),
+		maxAttempts:    maxAttempts,
+		backoff:        backoff,
+		maxBackoff:     maxBackoff,
+		attemptTimeout: attemptTimeout,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+	for i := 0; i < workers; i++ {
+		ca.publishQueue.workers.Add(1)
+		go func() {
+			defer ca.publishQueue.workers.Done()
+			for certDER := range ca.publishQueue.certs {
+				ca.submitWithRetries(certDER)
+			}
+		}()
+	}
+}
+
+// serverAuthCertificate returns true if the certificate certDER can be used
+// for TLS server authentication, and so must be submitted to CT logs. That
+// includes certificates with no extended key usages, which can be used for
+// anything, and certificates that fail to parse, to be on the safe side.
+func serverAuthCertificate(certDER []byte) bool {
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil || len(cert.ExtKeyUsage) == 0 {
+		return true
+	}
+	for _, eku := range cert.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageServerAuth || eku == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+// publish submits certDER to any configured CT logs without waiting for the
+// submission to finish. Without publish workers each submission gets its own
+// goroutine and is attempted once. With them, certificates are queued, and
+// are dropped if the queue is full or has been drained.
+func (ca *CertificateAuthorityImpl) publish(certDER []byte) {
+	if ca.Publisher == nil {
+		return
+	}
+	q := ca.publishQueue
+	if q == nil {
+		// Only called while an issuance is in flight, so Shutdown can't have
+		// stopped waiting yet
+		ca.inFlight.Add(1)
+		go func() {
+			defer ca.inFlight.Done()
+			// since we don't want this method to be canceled if the parent context
+			// expires pass a background context to it
+			_ = ca.Publisher.SubmitToCT(context.Background(), certDER)
+		}()
+		return
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if !q.closed {
+		select {
+		case q.certs <- certDER:
+			return
+		default:
+		}
+	}
+	ca.stats.Inc(metricPublishDropped, 1)
+	ca.log.AuditErr(fmt.Sprintf("Dropped certificate from CT submission queue: digest=[%s]",
+		core.Fingerprint256(certDER)))
+}
+
+// submitWithRetries submits certDER to the Publisher, retrying failures with
+// exponential backoff until the queue's maximum number of attempts is used
+// up. Once the queue's context is canceled the certificate is abandoned
+// instead.
+func (ca *CertificateAuthorityImpl) submitWithRetries(certDER []byte) {
+	q := ca.publishQueue
+	wait := q.backoff
+	var err error
+	for attempt := 1; attempt <= q.maxAttempts; attempt++ {
+		if attempt > 1 {
+			ca.stats.Inc(metricPublishRetries, 1)
+			if wait > 0 {
+				select {
+				case <-q.ctx.Done():
+				case <-ca.clk.After(wait):
+				}
+			}
+			wait *= 2
+			if wait > q.maxBackoff {
+				wait = q.maxBackoff
+			}
+		}
+		if q.ctx.Err() != nil {
+			ca.stats.Inc(metricPublishAbandoned, 1)
+			ca.log.AuditErr(fmt.Sprintf("Abandoned CT submission of certificate after %d attempts: digest=[%s]",
+				attempt-1, core.Fingerprint256(certDER)))
+			return
+		}
+		ctx, cancel := context.WithTimeout(q.ctx, q.attemptTimeout)
+		err = ca.Publisher.SubmitToCT(ctx, certDER)
+		cancel()
+		if err == nil {
+			ca.stats.Inc(metricPublishSubmitted, 1)
+			return
+		}
+	}
+	ca.stats.Inc(metricPublishFailed, 1)
+	ca.log.AuditErr(fmt.Sprintf("Failed to submit certificate to CT after %d attempts: digest=[%s] err=[%v]",
+		q.maxAttempts, core.Fingerprint256(certDER), err))
+}
+
+// DrainPublisher stops accepting certificates for CT submission and waits
+// for those already queued, including their retries, to be submitted. It
+// should be called on shutdown once no more certificates are being issued.
+// If ctx is done first, submissions in progress are canceled and the
+// remaining certificates are abandoned without further attempts, including
+// those waiting to be retried, and DrainPublisher returns ctx's error once the
+// workers have stopped. It does
+// nothing unless publish workers are configured.
+func (ca *CertificateAuthorityImpl) DrainPublisher(ctx context.Context) error {
+	q := ca.publishQueue
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.certs)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,63 @@
+package ca
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	cfsslConfig "github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/signer"
+
+	berrors "github.com/letsencrypt/boulder/errors"
+)
+
+// oidSCTList identifies the SignedCertificateTimestampList extension
+// [RFC6962 3.3].
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// maxSCTListLength is the longest a SignedCertificateTimestampList, and each
+// SerializedSCT within it, may be, since each has a two byte length prefix.
+const maxSCTListLength = 1<<16 - 1
+
+// sctListExtension returns the SignedCertificateTimestampList extension
+// carrying scts, each a serialized SCT as returned by a CT log, for inclusion
+// in a final certificate. It returns an error if fewer than the configured
+// minimum number of SCTs are given, and a nil extension if there are none.
+func (ca *CertificateAuthorityImpl) sctListExtension(scts [][]byte) (*signer.Extension, error) {
+	if len(scts) < ca.minSCTs {
+		return nil, berrors.MalformedError("%d SCTs given, but at least %d are required", len(scts), ca.minSCTs)
+	}
+	if len(scts) == 0 {
+		return nil, nil
+	}
+	value, err := marshalSCTList(scts)
+	if err != nil {
+		return nil, berrors.MalformedError("%s", err)
+	}
+	return &signer.Extension{
+		ID:       cfsslConfig.OID(oidSCTList),
+		Critical: false,
+		Value:    hex.EncodeToString(value),
+	}, nil
+}
+
+// marshalSCTList returns the DER encoded extension value for scts: an OCTET
+// STRING holding their TLS encoded SignedCertificateTimestampList.
+func marshalSCTList(scts [][]byte) ([]byte, error) {
+	var list bytes.Buffer
+	for i, sct := range scts {
+		if len(sct) == 0 || len(sct) > maxSCTListLength {
+			return nil, fmt.Errorf("SCT %d is %d bytes, not between 1 and %d", i, len(sct), maxSCTListLength)
+		}
+		binary.Write(&list, binary.BigEndian, uint16(len(sct)))
+		list.Write(sct)
+	}
+	if list.Len() > maxSCTListLength {
+		return nil, fmt.Errorf("SCT list is %d bytes, more than the maximum of %d", list.Len(), maxSCTListLength)
+	}
+	encoded := make([]byte, 2, 2+list.Len())
+	binary.BigEndian.PutUint16(encoded, uint16(list.Len()))
+	return asn1.Marshal(append(encoded, list.Bytes()...))
+}
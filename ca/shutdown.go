@@ -0,0 +1,51 @@
+package ca
+
+import (
+	"golang.org/x/net/context"
+
+	berrors "github.com/letsencrypt/boulder/errors"
+)
+
+// beginIssuance registers an issuance as in flight, so that Shutdown waits
+// for it, or returns an error if the CA is shutting down. Callers must call
+// endIssuance once the issuance has finished if it returns no error.
+func (ca *CertificateAuthorityImpl) beginIssuance() error {
+	ca.shutdownMu.Lock()
+	defer ca.shutdownMu.Unlock()
+	if ca.shuttingDown {
+		return berrors.InternalServerError("shutting down")
+	}
+	ca.inFlight.Add(1)
+	return nil
+}
+
+// endIssuance marks an issuance registered by beginIssuance as finished.
+func (ca *CertificateAuthorityImpl) endIssuance() {
+	ca.inFlight.Done()
+}
+
+// Shutdown stops the CA accepting new issuances, which are rejected from then
+// on, and waits for those in flight to be signed and stored and for their CT
+// submissions to finish, including any already queued for the publish
+// workers. If ctx is done first, Shutdown returns its error without waiting
+// any longer, and the CT submissions still queued are abandoned.
+func (ca *CertificateAuthorityImpl) Shutdown(ctx context.Context) error {
+	ca.shutdownMu.Lock()
+	ca.shuttingDown = true
+	ca.shutdownMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		// Issuances in flight may still be queueing certificates, so the
+		// publish workers are only drained once they've finished
+		ca.inFlight.Wait()
+		_ = ca.DrainPublisher(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
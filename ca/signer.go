@@ -0,0 +1,367 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/mail"
+	"time"
+
+	cfsslConfig "github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
+)
+
+// certSigner signs end-entity certificates for an issuer, returning them PEM
+// encoded. It is satisfied both by cfssl's local signer and by nativeSigner.
+type certSigner interface {
+	Sign(req signer.SignRequest) (cert []byte, err error)
+}
+
+// certSignerFactory returns a certSigner that signs with key, as the issuer
+// of cert, according to policy.
+type certSignerFactory func(key crypto.Signer, cert *x509.Certificate, policy *cfsslConfig.Signing) (certSigner, error)
+
+// parseSignerBackend returns the certSignerFactory named by s: "cfssl" for
+// cfssl's local signer, or "native" for nativeSigner. The empty string
+// selects cfssl.
+func parseSignerBackend(s string) (certSignerFactory, error) {
+	switch s {
+	case "", "cfssl":
+		return newCFSSLSigner, nil
+	case "native":
+		return newNativeSigner, nil
+	default:
+		return nil, fmt.Errorf("unknown signer backend %q", s)
+	}
+}
+
+// newCFSSLSigner returns cfssl's local signer.
+func newCFSSLSigner(key crypto.Signer, cert *x509.Certificate, policy *cfsslConfig.Signing) (certSigner, error) {
+	s, err := local.NewSigner(key, cert, x509.SHA256WithRSA, policy)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// nativeSigner signs certificates with x509.CreateCertificate, building them
+// from the same signing profiles cfssl's local signer uses and producing the
+// same certificates it would. It only supports what Boulder uses of cfssl:
+// profiles may not submit precertificates to CT logs, and CSRs requesting CA
+// certificates are never honoured.
+type nativeSigner struct {
+	key    crypto.Signer
+	cert   *x509.Certificate
+	policy *cfsslConfig.Signing
+}
+
+// newNativeSigner returns a nativeSigner, or an error if any of policy's
+// profiles use a cfssl feature it doesn't support.
+func newNativeSigner(key crypto.Signer, cert *x509.Certificate, policy *cfsslConfig.Signing) (certSigner, error) {
+	if policy == nil || policy.Default == nil {
+		return nil, errors.New("native signer requires a default signing profile")
+	}
+	for name, profile := range policy.Profiles {
+		if len(profile.CTLogServers) > 0 {
+			return nil, fmt.Errorf("native signer doesn't support CT log servers in profile %q", name)
+		}
+	}
+	if len(policy.Default.CTLogServers) > 0 {
+		return nil, errors.New("native signer doesn't support CT log servers in the default profile")
+	}
+	return &nativeSigner{key: key, cert: cert, policy: policy}, nil
+}
+
+// profile returns the named profile, or the default profile for the empty
+// name or an unknown one, as cfssl does.
+func (s *nativeSigner) profile(name string) *cfsslConfig.SigningProfile {
+	if p := s.policy.Profiles[name]; name != "" && p != nil {
+		return p
+	}
+	return s.policy.Default
+}
+
+// Sign signs the certificate requested by req.
+func (s *nativeSigner) Sign(req signer.SignRequest) ([]byte, error) {
+	profile := s.profile(req.Profile)
+
+	block, _ := pem.Decode([]byte(req.Request))
+	if block == nil || (block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST") {
+		return nil, errors.New("no certificate request PEM block found")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate request: %s", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %s", err)
+	}
+
+	// Copy out only the fields of the CSR the profile allows
+	var template x509.Certificate
+	whitelist := profile.CSRWhitelist
+	if whitelist == nil || whitelist.Subject {
+		template.Subject = csr.Subject
+	}
+	if whitelist == nil || whitelist.PublicKey {
+		template.PublicKey = csr.PublicKey
+	}
+	if whitelist == nil || whitelist.DNSNames {
+		template.DNSNames = csr.DNSNames
+	}
+	if whitelist == nil || whitelist.IPAddresses {
+		template.IPAddresses = csr.IPAddresses
+	}
+	if whitelist == nil || whitelist.EmailAddresses {
+		template.EmailAddresses = csr.EmailAddresses
+	}
+	if req.CRLOverride != "" {
+		template.CRLDistributionPoints = []string{req.CRLOverride}
+	}
+
+	if req.Hosts != nil {
+		template.DNSNames, template.IPAddresses, template.EmailAddresses = splitHosts(req.Hosts)
+	}
+	template.Subject = requestSubject(req.Subject, template.Subject)
+
+	if profile.NameWhitelist != nil {
+		names := append([]string{template.Subject.CommonName}, template.DNSNames...)
+		names = append(names, template.EmailAddresses...)
+		for i, name := range names {
+			if (i > 0 || name != "") && profile.NameWhitelist.Find([]byte(name)) == nil {
+				return nil, fmt.Errorf("name %q doesn't match the profile's name whitelist", name)
+			}
+		}
+	}
+
+	if profile.ClientProvidesSerialNumbers {
+		if req.Serial == nil {
+			return nil, errors.New("no serial number provided")
+		}
+		template.SerialNumber = req.Serial
+	} else {
+		// As many octets as RFC 5280 4.1.2.2 allows, and never negative
+		serial := make([]byte, 20)
+		if _, err := io.ReadFull(rand.Reader, serial); err != nil {
+			return nil, err
+		}
+		serial[0] &= 0x7F
+		template.SerialNumber = new(big.Int).SetBytes(serial)
+	}
+
+	for _, ext := range req.Extensions {
+		oid := asn1.ObjectIdentifier(ext.ID)
+		if !profile.ExtensionWhitelist[oid.String()] {
+			return nil, fmt.Errorf("extension %s isn't allowed by the profile", oid)
+		}
+		value, err := hex.DecodeString(ext.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for extension %s: %s", oid, err)
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+			Id:       oid,
+			Critical: ext.Critical,
+			Value:    value,
+		})
+	}
+
+	if err := s.applyProfile(&template, profile); err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, s.cert, template.PublicKey, s.key)
+	if err != nil {
+		// Returned unwrapped so that HSM errors can be recognised
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// applyProfile sets template's validity, usages, basic constraints, subject
+// key ID, revocation and issuer URLs, policies and OCSP no check extension
+// from profile, with the default profile supplying whatever profile leaves
+// out, the same way cfssl's signer.FillTemplate does.
+func (s *nativeSigner) applyProfile(template *x509.Certificate, profile *cfsslConfig.SigningProfile) error {
+	defaultProfile := s.policy.Default
+	ku, ekus, _ := profile.Usages()
+	if ku == 0 && len(ekus) == 0 {
+		return errors.New("profile has no key usages")
+	}
+
+	expiry := profile.Expiry
+	if expiry == 0 {
+		expiry = defaultProfile.Expiry
+	}
+	backdate := profile.Backdate
+	if backdate == 0 {
+		backdate = 5 * time.Minute
+	}
+	template.NotBefore = time.Now().Round(time.Minute).Add(-backdate).UTC()
+	if !profile.NotBefore.IsZero() {
+		template.NotBefore = profile.NotBefore.UTC()
+	}
+	template.NotAfter = template.NotBefore.Add(expiry).UTC()
+	if !profile.NotAfter.IsZero() {
+		template.NotAfter = profile.NotAfter.UTC()
+	}
+
+	template.KeyUsage = ku
+	template.ExtKeyUsage = ekus
+	template.BasicConstraintsValid = true
+	template.IsCA = profile.CAConstraint.IsCA
+	if template.IsCA {
+		template.MaxPathLen = profile.CAConstraint.MaxPathLen
+		template.MaxPathLenZero = template.MaxPathLen == 0 && profile.CAConstraint.MaxPathLenZero
+		template.DNSNames = nil
+		template.EmailAddresses = nil
+	}
+
+	ski, err := subjectKeyID(subjectKeyIDSHA1, template.PublicKey)
+	if err != nil {
+		return err
+	}
+	template.SubjectKeyId = ski
+
+	ocspURL, crlURL, issuerURLs := profile.OCSP, profile.CRL, profile.IssuerURL
+	if ocspURL == "" {
+		ocspURL = defaultProfile.OCSP
+	}
+	if crlURL == "" {
+		crlURL = defaultProfile.CRL
+	}
+	if issuerURLs == nil {
+		issuerURLs = defaultProfile.IssuerURL
+	}
+	if ocspURL != "" {
+		template.OCSPServer = []string{ocspURL}
+	}
+	// A CRL override from the request takes precedence
+	if crlURL != "" && len(template.CRLDistributionPoints) == 0 {
+		template.CRLDistributionPoints = []string{crlURL}
+	}
+	if len(issuerURLs) > 0 {
+		template.IssuingCertificateURL = issuerURLs
+	}
+
+	if len(profile.Policies) > 0 {
+		policies, err := marshalCertificatePolicies(profile.Policies)
+		if err != nil {
+			return err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+			Id:    asn1.ObjectIdentifier{2, 5, 29, 32},
+			Value: policies,
+		})
+	}
+	if profile.OCSPNoCheck {
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+			Id:    oidOCSPNoCheck,
+			Value: []byte{0x05, 0x00},
+		})
+	}
+	return nil
+}
+
+// splitHosts sorts hosts into DNS names, IP addresses and email addresses.
+func splitHosts(hosts []string) (dnsNames []string, ips []net.IP, emails []string) {
+	dnsNames, ips, emails = []string{}, []net.IP{}, []string{}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		} else if email, err := mail.ParseAddress(host); err == nil && email != nil {
+			emails = append(emails, email.Address)
+		} else {
+			dnsNames = append(dnsNames, host)
+		}
+	}
+	return dnsNames, ips, emails
+}
+
+// requestSubject returns the subject requested by subject, with any fields it
+// leaves empty taken from csrSubject.
+func requestSubject(subject *signer.Subject, csrSubject pkix.Name) pkix.Name {
+	if subject == nil {
+		return csrSubject
+	}
+	name := subject.Name()
+	if name.CommonName == "" {
+		name.CommonName = csrSubject.CommonName
+	}
+	for _, field := range []struct{ requested, csr *[]string }{
+		{&name.Country, &csrSubject.Country},
+		{&name.Province, &csrSubject.Province},
+		{&name.Locality, &csrSubject.Locality},
+		{&name.Organization, &csrSubject.Organization},
+		{&name.OrganizationalUnit, &csrSubject.OrganizationalUnit},
+	} {
+		if len(*field.requested) == 0 {
+			*field.requested = *field.csr
+		}
+	}
+	if name.SerialNumber == "" {
+		name.SerialNumber = csrSubject.SerialNumber
+	}
+	return name
+}
+
+// The structures below mirror the certificatePolicies extension [RFC5280
+// 4.2.1.4] as cfssl encodes it.
+type policyInformation struct {
+	PolicyIdentifier asn1.ObjectIdentifier
+	Qualifiers       []interface{} `asn1:"tag:optional,omitempty"`
+}
+
+type cpsPolicyQualifier struct {
+	PolicyQualifierID asn1.ObjectIdentifier
+	Qualifier         string `asn1:"tag:optional,ia5"`
+}
+
+type userNotice struct {
+	ExplicitText string `asn1:"tag:optional,utf8"`
+}
+
+type userNoticePolicyQualifier struct {
+	PolicyQualifierID asn1.ObjectIdentifier
+	Qualifier         userNotice
+}
+
+var (
+	oidCPSQualifier        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+	oidUserNoticeQualifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 2}
+)
+
+// marshalCertificatePolicies returns the DER encoded value of a
+// certificatePolicies extension listing policies.
+func marshalCertificatePolicies(policies []cfsslConfig.CertificatePolicy) ([]byte, error) {
+	infos := []policyInformation{}
+	for _, policy := range policies {
+		info := policyInformation{PolicyIdentifier: asn1.ObjectIdentifier(policy.ID)}
+		for _, qualifier := range policy.Qualifiers {
+			switch qualifier.Type {
+			case "id-qt-cps":
+				info.Qualifiers = append(info.Qualifiers, cpsPolicyQualifier{
+					PolicyQualifierID: oidCPSQualifier,
+					Qualifier:         qualifier.Value,
+				})
+			case "id-qt-unotice":
+				info.Qualifiers = append(info.Qualifiers, userNoticePolicyQualifier{
+					PolicyQualifierID: oidUserNoticeQualifier,
+					Qualifier:         userNotice{ExplicitText: qualifier.Value},
+				})
+			default:
+				return nil, fmt.Errorf("unknown policy qualifier type %q", qualifier.Type)
+			}
+		}
+		infos = append(infos, info)
+	}
+	return asn1.Marshal(infos)
+}
@@ -0,0 +1,59 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// subjectKeyIDMethod determines how the subject key identifiers of issued
+// certificates are derived from their public keys.
+type subjectKeyIDMethod string
+
+const (
+	// subjectKeyIDSHA1 is the SHA-1 hash of the subjectPublicKey [RFC5280
+	// 4.2.1.2], as cfssl computes it.
+	subjectKeyIDSHA1 subjectKeyIDMethod = "sha1"
+	// subjectKeyIDTruncatedSHA256 is the leftmost 160 bits of the SHA-256
+	// hash of the subjectPublicKey [RFC7093 2 method 1].
+	subjectKeyIDTruncatedSHA256 subjectKeyIDMethod = "truncated-sha256"
+)
+
+// parseSubjectKeyIDMethod returns the subjectKeyIDMethod named by s. The
+// empty string selects subjectKeyIDSHA1.
+func parseSubjectKeyIDMethod(s string) (subjectKeyIDMethod, error) {
+	switch method := subjectKeyIDMethod(s); method {
+	case "":
+		return subjectKeyIDSHA1, nil
+	case subjectKeyIDSHA1, subjectKeyIDTruncatedSHA256:
+		return method, nil
+	default:
+		return "", fmt.Errorf("unknown subject key identifier method %q", s)
+	}
+}
+
+// subjectKeyID returns the subject key identifier of pub derived using
+// method.
+func subjectKeyID(method subjectKeyIDMethod, pub crypto.PublicKey) ([]byte, error) {
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	var parsedSPKI struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(spki, &parsedSPKI); err != nil {
+		return nil, err
+	}
+	if method == subjectKeyIDTruncatedSHA256 {
+		hash := sha256.Sum256(parsedSPKI.SubjectPublicKey.Bytes)
+		return hash[:sha1.Size], nil
+	}
+	hash := sha1.Sum(parsedSPKI.SubjectPublicKey.Bytes)
+	return hash[:], nil
+}
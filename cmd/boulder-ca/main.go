@@ -5,14 +5,19 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/cloudflare/cfssl/helpers"
 	"github.com/jmhodges/clock"
 	"github.com/letsencrypt/pkcs11key"
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 
 	"github.com/letsencrypt/boulder/ca"
@@ -49,24 +54,57 @@ func loadIssuers(c config) ([]ca.Issuer, error) {
 		issuerConfig := *c.CA.Key
 		issuerConfig.CertFile = c.Common.IssuerCert
 		priv, cert, err := loadIssuer(issuerConfig)
-		return []ca.Issuer{{
+		if err != nil {
+			return nil, err
+		}
+		issuer := ca.Issuer{
 			Signer: priv,
 			Cert:   cert,
-		}}, err
+		}
+		err = loadOCSPResponder(&issuer, issuerConfig.OCSPResponder)
+		return []ca.Issuer{issuer}, err
 	}
 	var issuers []ca.Issuer
 	for _, issuerConfig := range c.CA.Issuers {
 		priv, cert, err := loadIssuer(issuerConfig)
 		cmd.FailOnError(err, "Couldn't load private key")
-		issuers = append(issuers, ca.Issuer{
-			Signer: priv,
-			Cert:   cert,
-		})
+		issuer := ca.Issuer{
+			Signer:   priv,
+			Cert:     cert,
+			OCSPOnly: issuerConfig.OCSPOnly,
+			OCSPURL:  issuerConfig.OCSPURL,
+			CRLURL:   issuerConfig.CRLURL,
+
+			ActiveFrom: issuerConfig.ActiveFrom,
+		}
+		err = loadOCSPResponder(&issuer, issuerConfig.OCSPResponder)
+		cmd.FailOnError(err, "Couldn't load OCSP responder")
+		issuers = append(issuers, issuer)
 	}
 	return issuers, nil
 }
 
+// loadOCSPResponder sets issuer's delegated OCSP responder from
+// responderConfig, if it isn't nil. NewCertificateAuthorityImpl checks that
+// issuer issued it for OCSP signing.
+func loadOCSPResponder(issuer *ca.Issuer, responderConfig *cmd.IssuerConfig) error {
+	if responderConfig == nil {
+		return nil
+	}
+	signer, cert, err := loadIssuer(*responderConfig)
+	if err != nil {
+		return err
+	}
+	issuer.OCSPResponderSigner = signer
+	issuer.OCSPResponderCert = cert
+	return nil
+}
+
 func loadIssuer(issuerConfig cmd.IssuerConfig) (crypto.Signer, *x509.Certificate, error) {
+	if issuerConfig.PKCS12File != "" {
+		return loadPKCS12Issuer(issuerConfig)
+	}
+
 	cert, err := core.LoadCert(issuerConfig.CertFile)
 	if err != nil {
 		return nil, nil, err
@@ -83,6 +121,22 @@ func loadIssuer(issuerConfig cmd.IssuerConfig) (crypto.Signer, *x509.Certificate
 	return signer, cert, err
 }
 
+func loadPKCS12Issuer(issuerConfig cmd.IssuerConfig) (crypto.Signer, *x509.Certificate, error) {
+	pfxData, err := ioutil.ReadFile(issuerConfig.PKCS12File)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not read PKCS#12 file %s", issuerConfig.PKCS12File)
+	}
+	password, err := issuerConfig.PKCS12Password.Pass()
+	if err != nil {
+		return nil, nil, err
+	}
+	issuer, err := ca.NewIssuerFromPKCS12(pfxData, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", issuerConfig.PKCS12File, err)
+	}
+	return issuer.Signer, issuer.Cert, nil
+}
+
 func loadSigner(issuerConfig cmd.IssuerConfig) (crypto.Signer, error) {
 	if issuerConfig.File != "" {
 		keyBytes, err := ioutil.ReadFile(issuerConfig.File)
@@ -90,6 +144,18 @@ func loadSigner(issuerConfig cmd.IssuerConfig) (crypto.Signer, error) {
 			return nil, fmt.Errorf("Could not read key file %s", issuerConfig.File)
 		}
 
+		if block, _ := pem.Decode(keyBytes); block != nil && block.Type == "ENCRYPTED PRIVATE KEY" {
+			password, err := issuerConfig.KeyPassword.Pass()
+			if err != nil {
+				return nil, err
+			}
+			signer, err := ca.ParseEncryptedKeyPEM(keyBytes, password)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", issuerConfig.File, err)
+			}
+			return signer, nil
+		}
+
 		signer, err := helpers.ParsePrivateKeyPEM(keyBytes)
 		if err != nil {
 			return nil, err
@@ -111,11 +177,25 @@ func loadSigner(issuerConfig cmd.IssuerConfig) (crypto.Signer, error) {
 	} else {
 		pkcs11Config = issuerConfig.PKCS11
 	}
-	if pkcs11Config.Module == "" ||
-		pkcs11Config.TokenLabel == "" ||
-		pkcs11Config.PIN == "" ||
-		pkcs11Config.PrivateKeyLabel == "" {
-		return nil, fmt.Errorf("Missing a field in pkcs11Config %#v", pkcs11Config)
+	if pkcs11Config == nil {
+		return nil, fmt.Errorf("Issuer %s has neither a key file nor a PKCS#11 config", issuerConfig.CertFile)
+	}
+	// Don't include the config itself in the error, since it contains the PIN.
+	var missing []string
+	if pkcs11Config.Module == "" {
+		missing = append(missing, "Module")
+	}
+	if pkcs11Config.TokenLabel == "" {
+		missing = append(missing, "TokenLabel")
+	}
+	if pkcs11Config.PIN == "" {
+		missing = append(missing, "PIN")
+	}
+	if pkcs11Config.PrivateKeyLabel == "" {
+		missing = append(missing, "PrivateKeyLabel")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("Missing fields in pkcs11Config: %s", strings.Join(missing, ", "))
 	}
 	numSessions := issuerConfig.NumSessions
 	if numSessions <= 0 {
@@ -159,15 +239,24 @@ func main() {
 	issuers, err := loadIssuers(c)
 	cmd.FailOnError(err, "Couldn't load issuers")
 
+	keyPolicy := goodkey.NewKeyPolicy()
+	if c.CA.SharedFactorKeyWindow > 0 {
+		keyPolicy.SharedFactors = goodkey.NewSharedFactorChecker(c.CA.SharedFactorKeyWindow)
+	}
+
 	cai, err := ca.NewCertificateAuthorityImpl(
 		c.CA,
 		clock.Default(),
 		scope,
 		issuers,
-		goodkey.NewKeyPolicy(),
+		keyPolicy,
 		logger)
 	cmd.FailOnError(err, "Failed to create CA impl")
 	cai.PA = pa
+	if c.CA.MaxIssuancesPerKey > 0 && cai.KeyIssuanceCounter == nil {
+		cmd.FailOnError(errors.New("no issuance counter shared between CA instances is available"),
+			"MaxIssuancesPerKey can't be enforced")
+	}
 
 	var tls *tls.Config
 	if c.CA.TLS.CertFile != nil {
@@ -183,7 +272,7 @@ func main() {
 	if c.CA.GRPCCA != nil {
 		s, l, err := bgrpc.NewServer(c.CA.GRPCCA, tls, scope)
 		cmd.FailOnError(err, "Unable to setup CA gRPC server")
-		caWrapper := bgrpc.NewCertificateAuthorityServer(cai)
+		caWrapper := bgrpc.NewCertificateAuthorityServer(cai, c.CA.MaxCSRBytes)
 		caPB.RegisterCertificateAuthorityServer(s, caWrapper)
 		go func() {
 			err = s.Serve(l)
@@ -195,7 +284,7 @@ func main() {
 	if c.CA.GRPCOCSPGenerator != nil {
 		s, l, err := bgrpc.NewServer(c.CA.GRPCOCSPGenerator, tls, scope)
 		cmd.FailOnError(err, "Unable to setup CA gRPC server")
-		caWrapper := bgrpc.NewCertificateAuthorityServer(cai)
+		caWrapper := bgrpc.NewCertificateAuthorityServer(cai, c.CA.MaxCSRBytes)
 		caPB.RegisterOCSPGeneratorServer(s, caWrapper)
 		go func() {
 			err = s.Serve(l)
@@ -211,6 +300,15 @@ func main() {
 		if ocspSrv != nil {
 			ocspSrv.GracefulStop()
 		}
+		drainTimeout := c.CA.PublisherDrainTimeout.Duration
+		if drainTimeout <= 0 {
+			drainTimeout = time.Minute
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		if err := cai.DrainPublisher(ctx); err != nil {
+			logger.AuditErr(fmt.Sprintf("Abandoned CT submissions still queued on shutdown: %s", err))
+		}
 	})
 
 	go cmd.DebugServer(c.CA.DebugAddr)
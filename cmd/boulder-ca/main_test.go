@@ -1,8 +1,12 @@
 package main
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/letsencrypt/pkcs11key"
+
+	"github.com/letsencrypt/boulder/ca"
 	"github.com/letsencrypt/boulder/cmd"
 )
 
@@ -41,3 +45,100 @@ func TestLoadIssuerBadCert(t *testing.T) {
 		t.Fatal("loadIssuer succeeded when loading key from /dev/null")
 	}
 }
+
+func TestLoadSignerNoKey(t *testing.T) {
+	_, err := loadSigner(cmd.IssuerConfig{
+		CertFile: "../../test/test-ca2.pem",
+	})
+	if err == nil {
+		t.Fatal("loadSigner succeeded with neither a key file nor a PKCS#11 config")
+	}
+}
+
+func TestLoadSignerIncompletePKCS11(t *testing.T) {
+	_, err := loadSigner(cmd.IssuerConfig{
+		PKCS11: &pkcs11key.Config{
+			Module: "/usr/lib/softhsm/libsofthsm2.so",
+			PIN:    "hunter2",
+		},
+		CertFile: "../../test/test-ca2.pem",
+	})
+	if err == nil {
+		t.Fatal("loadSigner succeeded with an incomplete PKCS#11 config")
+	}
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("loadSigner error included the PKCS#11 PIN: %s", err)
+	}
+}
+
+func TestLoadIssuerPKCS12(t *testing.T) {
+	signer, cert, err := loadIssuer(cmd.IssuerConfig{
+		PKCS12File:     "../../ca/testdata/test-ca.p12",
+		PKCS12Password: cmd.PasswordConfig{Password: "boulder"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer == nil || cert == nil {
+		t.Fatal("loadIssuer returned nil signer or cert")
+	}
+
+	_, _, err = loadIssuer(cmd.IssuerConfig{
+		PKCS12File:     "../../ca/testdata/test-ca.p12",
+		PKCS12Password: cmd.PasswordConfig{Password: "wrong"},
+	})
+	if err == nil {
+		t.Fatal("loadIssuer succeeded with the wrong PKCS#12 password")
+	}
+}
+
+func TestLoadIssuerEncryptedKey(t *testing.T) {
+	signer, cert, err := loadIssuer(cmd.IssuerConfig{
+		File:        "../../ca/testdata/test-ca-encrypted.key",
+		KeyPassword: cmd.PasswordConfig{Password: "boulder"},
+		CertFile:    "../../test/test-ca.pem",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer == nil || cert == nil {
+		t.Fatal("loadIssuer returned nil signer or cert")
+	}
+
+	_, _, err = loadIssuer(cmd.IssuerConfig{
+		File:        "../../ca/testdata/test-ca-encrypted.key",
+		KeyPassword: cmd.PasswordConfig{Password: "wrong"},
+		CertFile:    "../../test/test-ca.pem",
+	})
+	if err == nil {
+		t.Fatal("loadIssuer succeeded with the wrong key password")
+	}
+}
+
+func TestLoadOCSPResponder(t *testing.T) {
+	signer, cert, err := loadIssuer(cmd.IssuerConfig{
+		File:     "../../test/test-ca.key",
+		CertFile: "../../test/test-ca.pem",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := ca.Issuer{Signer: signer, Cert: cert}
+	if err := loadOCSPResponder(&issuer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if issuer.OCSPResponderSigner != nil || issuer.OCSPResponderCert != nil {
+		t.Fatal("loadOCSPResponder set a responder without a config")
+	}
+
+	responderConfig := &cmd.IssuerConfig{
+		File:     "../../ca/testdata/ocsp-responder.key",
+		CertFile: "../../ca/testdata/ocsp-responder.pem",
+	}
+	if err := loadOCSPResponder(&issuer, responderConfig); err != nil {
+		t.Fatal(err)
+	}
+	if issuer.OCSPResponderSigner == nil || issuer.OCSPResponderCert == nil {
+		t.Fatal("loadOCSPResponder didn't set the responder")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"strings"
 	"time"
 
@@ -16,15 +17,18 @@ import (
 	"github.com/letsencrypt/boulder/core"
 )
 
-// PasswordConfig either contains a password or the path to a file
-// containing a password
+// PasswordConfig either contains a password, the path to a file
+// containing a password, or the name of an environment variable
+// holding a password
 type PasswordConfig struct {
 	Password     string
 	PasswordFile string
+	PasswordEnv  string
 }
 
 // Pass returns a password, either directly from the configuration
-// struct or by reading from a specified file
+// struct, by reading from a specified file, or from a specified
+// environment variable, which must be set
 func (pc *PasswordConfig) Pass() (string, error) {
 	if pc.PasswordFile != "" {
 		contents, err := ioutil.ReadFile(pc.PasswordFile)
@@ -33,6 +37,13 @@ func (pc *PasswordConfig) Pass() (string, error) {
 		}
 		return strings.TrimRight(string(contents), "\n"), nil
 	}
+	if pc.PasswordEnv != "" {
+		password, ok := os.LookupEnv(pc.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("password environment variable %s is not set", pc.PasswordEnv)
+		}
+		return password, nil
+	}
 	return pc.Password, nil
 }
 
@@ -88,6 +99,21 @@ type CAConfig struct {
 	ECDSAProfile string
 	TestMode     bool
 	SerialPrefix int
+	// AllowedSerialPrefixes, if non-empty, lists the serial prefixes reserved
+	// for this CA instance, one of which SerialPrefix must be. Giving each
+	// instance its own set guards against two instances being configured
+	// with the same prefix, and so issuing colliding serials.
+	AllowedSerialPrefixes []int
+	// OCSPSerialPrefixes, if non-empty, restricts OCSP signing to certificates
+	// whose serial begins with SerialPrefix or one of these prefixes. Serials
+	// with any other prefix were likely issued by a different CA.
+	OCSPSerialPrefixes []int
+	// OCSPStatusFromSA makes the CA look up a certificate's status in the SA
+	// when generating OCSP, rather than trusting the status it was asked to
+	// sign. A certificate the SA has on record as revoked is always signed as
+	// revoked. Certificates the SA doesn't know about yet (e.g. during
+	// issuance) use the requested status.
+	OCSPStatusFromSA bool
 	// TODO(jsha): Remove Key field once we've migrated to Issuers
 	Key *IssuerConfig
 	// Issuers contains configuration information for each issuer cert and key
@@ -96,12 +122,44 @@ type CAConfig struct {
 	// LifespanOCSP is how long OCSP responses are valid for; It should be longer
 	// than the minTimeToExpiry field for the OCSP Updater.
 	LifespanOCSP ConfigDuration
+	// OCSPNextUpdateStrategy selects how long each OCSP response is valid
+	// for: "fixed" (the default), for LifespanOCSP, or "fractional", for
+	// OCSPNextUpdateFraction of the certificate's remaining lifetime but no
+	// longer than LifespanOCSP. Fractional responses never outlive the
+	// certificate, and spread the refreshes of responses for certificates
+	// nearing expiry.
+	OCSPNextUpdateStrategy string
+	// OCSPNextUpdateFraction is the fraction of a certificate's remaining
+	// lifetime, greater than 0 and at most 1, used by the "fractional"
+	// OCSPNextUpdateStrategy.
+	OCSPNextUpdateFraction float64
+	// OCSPCacheSize, if positive, is how many signed OCSP responses the CA
+	// keeps so that identical requests for them within OCSPCacheFreshness of
+	// their signing get the same response without signing it again. Requests
+	// with a nonce or an explicit producedAt are never served from the cache.
+	OCSPCacheSize      int
+	OCSPCacheFreshness ConfigDuration
+	// OCSPSignatureHash, if set, is the hash OCSP responses are signed over:
+	// "SHA256", "SHA384" or "SHA512". By default it depends on the type of
+	// the signing key. Every issuer's OCSP signing key must be able to use it.
+	OCSPSignatureHash string
 	// How long issued certificates are valid for, should match expiry field
 	// in cfssl config.
 	Expiry string
 	// The maximum number of subjectAltNames in a single certificate
 	MaxNames int
 	CFSSL    cfsslConfig.Config
+	// SignerBackend selects what signs certificates using the CFSSL signing
+	// profiles: "cfssl" (the default) for CFSSL's own signer, or "native" to
+	// build them with Go's x509 package directly. The native signer doesn't
+	// support CT log servers in profiles.
+	SignerBackend string
+
+	// SubjectKeyIDMethod selects how the subject key identifiers of issued
+	// certificates are derived from their public keys: "sha1" (the default),
+	// for the SHA-1 hash of the key, or "truncated-sha256", for the leftmost
+	// 160 bits of its SHA-256 hash as in RFC 7093.
+	SubjectKeyIDMethod string
 
 	// DoNotForceCN is a temporary config setting. It controls whether
 	// to add a certificate's serial to its Subject, and whether to
@@ -112,11 +170,227 @@ type CAConfig struct {
 	// triggers issuance of certificates with Must Staple.
 	EnableMustStaple bool
 
+	// MaxConcurrentSignings limits the number of certificate and OCSP signing
+	// operations that may be in flight at once, since most HSMs have limited
+	// session throughput. Zero means no limit.
+	MaxConcurrentSignings int
+
+	// DeterministicIssuance makes issued certificates reproducible given a
+	// fixed CSR, serial, and clock: validity is computed from the CA's clock
+	// without CFSSL's rounding to the nearest minute, and callers may supply
+	// the serial. Intended for CT cross-checking and testing. ECDSA
+	// signatures are randomized, so it can't be used with ECDSA issuers.
+	DeterministicIssuance bool
+
+	// Profiles contains Boulder-specific settings for the CFSSL signing
+	// profiles, keyed by profile name.
+	Profiles map[string]CAProfileConfig
+
+	// MaxProfiles, if non-zero, is the maximum number of CFSSL signing
+	// profiles the CA will load. It guards against accidentally deploying an
+	// oversized signing config.
+	MaxProfiles int
+
+	// SharedFactorKeyWindow, if non-zero, is the number of recent RSA moduli
+	// that each new key's modulus is checked against for a shared prime factor,
+	// which indicates keys generated with a weak random number generator.
+	SharedFactorKeyWindow int
+
+	// RejectCSRBasicConstraints causes the CA to reject CSRs that request a
+	// basicConstraints extension with cA set or with a pathLenConstraint, and
+	// to refuse to emit any leaf certificate carrying either.
+	RejectCSRBasicConstraints bool
+
+	// MinRequestedValidity is the shortest validity period a caller may
+	// request for an individual certificate. Requested validity periods
+	// longer than the profile's expiry are cut down to it.
+	MinRequestedValidity ConfigDuration
+
+	// MinCertificateValidity is the shortest validity period, from notBefore
+	// to notAfter, of any certificate the CA will issue, after backdating and
+	// any shortening to fit within the issuer. Certificates that would expire
+	// at or before the time they are issued are always refused.
+	MinCertificateValidity ConfigDuration
+
+	// CNStrategy selects which DNS name becomes the subject CN of a
+	// certificate whose CSR has none: "first" (the default), "shortest", or
+	// "registered-domain".
+	CNStrategy string
+	// OmitLongCN causes certificates to be issued without a subject CN when
+	// a CN would be taken from the DNS names but every one of them is longer
+	// than the 64 bytes a CN may hold, instead of the CSR being rejected.
+	// Names too long to be the CN chosen by CNStrategy are otherwise passed
+	// over in favor of the shortest.
+	OmitLongCN bool
+
+	// MaxIssuancesPerRegistration, if non-zero, is the most certificates the
+	// CA will issue to a single registration within
+	// IssuancesPerRegistrationWindow. It's a last resort in case the RA's rate
+	// limits fail.
+	MaxIssuancesPerRegistration    int
+	IssuancesPerRegistrationWindow ConfigDuration
+
+	// MaxIssuancesPerKey, if non-zero, is the most certificates the CA will
+	// issue for a single subject public key, to limit how widely one key is
+	// reused. It needs a count shared by every CA instance, which boulder-ca
+	// can't provide yet, so boulder-ca refuses to start with it set.
+	MaxIssuancesPerKey int
+
+	// RequireAuditLog makes the CA refuse to issue a certificate unless its
+	// audit log entries for the issuance are successfully written.
+	RequireAuditLog bool
+
+	// StartupSelfTest causes the CA to issue a throwaway certificate from
+	// each issuer when it starts, and to refuse to start unless every one of
+	// them verifies against its issuer certificate.
+	StartupSelfTest bool
+
+	// MinSCTs is the fewest SCTs, from different CT logs, that a final
+	// certificate issued for a precertificate must embed.
+	MinSCTs int
+
+	// CrossSigning enables issuing CA certificates that cross-sign other CAs'
+	// keys, valid for CrossSignValidity. It must only be set in configuration
+	// used by operator tooling, never for a CA serving requests.
+	CrossSigning      bool
+	CrossSignValidity ConfigDuration
+
+	// PublisherWorkers, if non-zero, is the number of workers submitting
+	// issued certificates to CT, instead of a new goroutine for each one. Up
+	// to PublisherQueueSize certificates can wait for a worker, after which
+	// they are dropped. Each is submitted up to PublisherMaxAttempts times,
+	// waiting PublisherRetryBackoff before the first retry and twice as long
+	// before each one after that, up to PublisherMaxRetryBackoff (default one
+	// minute). Each submission times out after PublisherAttemptTimeout
+	// (default 30 seconds). On shutdown, certificates not submitted within
+	// PublisherDrainTimeout (default one minute) are abandoned.
+	PublisherWorkers         int
+	PublisherQueueSize       int
+	PublisherMaxAttempts     int
+	PublisherRetryBackoff    ConfigDuration
+	PublisherMaxRetryBackoff ConfigDuration
+	PublisherAttemptTimeout  ConfigDuration
+	PublisherDrainTimeout    ConfigDuration
+
+	// LogDroppedCSRExtensions causes the OIDs of unsupported extensions
+	// requested in CSRs, which are left out of the certificate, to be logged at
+	// debug level.
+	LogDroppedCSRExtensions bool
+	// RejectUnknownExtensions causes CSRs requesting an extension the CA
+	// doesn't support, and which isn't in the signing profile's
+	// AllowedExtensions, to be rejected instead of having it dropped.
+	RejectUnknownExtensions bool
+	// FallbackProfile is the CFSSL profile used to sign certificates for keys
+	// whose type-specific profile (RSAProfile or ECDSAProfile) is unset. If it
+	// is set, either of those may be left empty.
+	FallbackProfile string
+	// UseDefaultProfile causes keys with neither a type-specific profile nor
+	// a FallbackProfile to be signed with the CFSSL config's Default signing
+	// profile. That profile must not be a CA profile, must have the client
+	// provide serial numbers and restrict what is copied from the CSR, and
+	// must list at least one extended key usage, none of them "any", and no
+	// cert sign or CRL sign key usage.
+	UseDefaultProfile bool
+	// RejectDisallowedSubjectAttributes causes CSRs requesting any subject
+	// attribute other than the CN and those in PermittedSubjectAttributes to
+	// be rejected. Otherwise, and for permitted attributes, everything except
+	// the CN is silently left out of the certificate.
+	RejectDisallowedSubjectAttributes bool
+	// RejectMalformedWildcards causes CSRs to be rejected if any of their
+	// names is a wildcard other than a single "*" leftmost label, or if their
+	// CN is a wildcard that isn't also among their DNS names.
+	RejectMalformedWildcards bool
+	// RequireCNInSANs causes CSRs with a CN that isn't also among their DNS
+	// names, ignoring case, to be rejected even when DoNotForceCN isn't set.
+	// Otherwise such a CN is added to the certificate's SANs.
+	RequireCNInSANs bool
+	// EnforceIssuerNameConstraints causes CSRs to be rejected if any of their
+	// DNS names falls outside the name constraints of the issuer certificate
+	// that would sign them, rather than issuing certificates that relying
+	// parties enforcing the constraints would reject.
+	EnforceIssuerNameConstraints bool
+	// PermittedSubjectAttributes lists the subject attributes, by short name
+	// (O, OU, L, ST, C or serialNumber), a CSR may request without being
+	// rejected.
+	PermittedSubjectAttributes []string
+	// MaxCSRBytes and MaxCSRExtensions, if non-zero, cause CSRs larger than
+	// this many bytes of DER, or requesting more than this many extensions, to
+	// be rejected before any other checks are made on them.
+	MaxCSRBytes      int
+	MaxCSRExtensions int
+	// AdditionalExtensions are included in every certificate the CA issues,
+	// e.g. to mark the environment it was issued in. They must not be
+	// critical or use the OID of a standard extension.
+	AdditionalExtensions []CAExtensionConfig
+
 	SAService *GRPCClientConfig
 
 	Features map[string]bool
 }
 
+// CAProfileConfig contains Boulder-specific settings for a single CFSSL signing
+// profile, supplementing what CFSSL's own profile configuration supports.
+type CAProfileConfig struct {
+	// OmitRevocationPointersBelow, if non-zero, causes certificates whose
+	// validity period is shorter than this duration to be issued without an
+	// OCSP URL or CRL distribution point, even if the profile configures them.
+	OmitRevocationPointersBelow ConfigDuration
+	// IncludeRegistrationID causes certificates issued with this profile to
+	// carry the requester's registration ID in a private, non-critical
+	// extension. It is intended for internal PKI only and must never be set
+	// for profiles used to issue publicly trusted certificates.
+	IncludeRegistrationID bool
+	// NotAfterBoundary, if non-zero, causes certificates issued with this
+	// profile to expire exactly on a multiple of this duration, by shortening
+	// their validity period as needed. Durations that evenly divide 24 hours
+	// fall on the corresponding UTC wall-clock times, e.g. 24h for midnight.
+	// Certificates that would outlive the issuer instead expire on the last
+	// boundary before it.
+	NotAfterBoundary ConfigDuration
+	// CheckKeyUsageCompatibility causes issuance with this profile to fail if
+	// any of its key usages can't be performed with the CSR's key type, e.g.
+	// key agreement with an RSA key or key encipherment with an ECDSA key.
+	CheckKeyUsageCompatibility bool
+	// EnableMustStaple causes TLS Feature extensions requested in CSRs to be
+	// included in certificates issued with this profile, even if the CA-wide
+	// EnableMustStaple is not set.
+	EnableMustStaple bool
+	// EmailProfile marks a profile for S/MIME or client authentication
+	// certificates, issued to the email addresses in a CSR rather than its DNS
+	// names. Such CSRs are validated by email address syntax, not hostname
+	// policy. An email profile is only used when a caller names it, and can't
+	// be the RSA, ECDSA or fallback profile.
+	EmailProfile bool
+	// AllowedExtKeyUsages, if non-empty, lists the CFSSL names (e.g. "server
+	// auth", "client auth") of the only extended key usages certificates
+	// issued with this profile may carry. Issuance fails if the profile
+	// requests any other, or none at all. "any" may not be listed.
+	AllowedExtKeyUsages []string
+	// Issuer, if set, is the common name of the issuer that signs every
+	// certificate issued with this profile, instead of the first issuer
+	// active for the key's type. It must not be OCSP-only.
+	Issuer string
+	// StripAnyExtKeyUsage causes anyExtendedKeyUsage to be dropped from
+	// certificates issued with this profile even if the CFSSL profile's usages
+	// include "any". Without it, a profile with an AllowedExtKeyUsages list
+	// that requests anyExtendedKeyUsage is rejected.
+	StripAnyExtKeyUsage bool
+}
+
+// CAExtensionConfig describes a static, non-critical extension included in
+// every certificate the CA issues.
+type CAExtensionConfig struct {
+	// OID is the extension's object identifier in dotted decimal form.
+	OID string
+	// Value is the hex-encoded DER of the extension's value. For a value that
+	// is a single UTF8String, UTF8String may be set instead.
+	Value      string
+	UTF8String string
+	// Critical must not be set. It's only here so that a configuration
+	// marking the extension critical is rejected rather than ignored.
+	Critical bool
+}
+
 // PAConfig specifies how a policy authority should connect to its
 // database, what policies it should enforce, and what challenges
 // it should offer.
@@ -148,16 +422,41 @@ func (pc PAConfig) CheckChallenges() error {
 
 // IssuerConfig contains info about an issuer: private key and issuer cert.
 // It should contain either a File path to a PEM-format private key,
-// or a PKCS11Config defining how to load a module for an HSM.
+// optionally encrypted with KeyPassword, a PKCS11Config defining how to load a module for an HSM, or a PKCS12File
+// containing both the private key and issuer cert.
 type IssuerConfig struct {
 	// A file from which a pkcs11key.Config will be read and parsed, if present
 	ConfigFile string
 	File       string
-	PKCS11     *pkcs11key.Config
-	CertFile   string
+	// KeyPassword decrypts File when it holds an ENCRYPTED PRIVATE KEY
+	// (passphrase-encrypted PKCS#8).
+	KeyPassword PasswordConfig
+	PKCS11      *pkcs11key.Config
+	CertFile    string
 	// Number of sessions to open with the HSM. For maximum performance,
 	// this should be equal to the number of cores in the HSM. Defaults to 1.
 	NumSessions int
+	// A PKCS#12 bundle containing both the private key and issuer cert,
+	// encrypted with PKCS12Password. If present, the other fields are ignored.
+	PKCS12File     string
+	PKCS12Password PasswordConfig
+	// OCSPOnly marks a retired issuer that is loaded only to sign OCSP
+	// responses for the certificates it issued, and never for new issuance.
+	OCSPOnly bool
+	// OCSPURL and CRLURL, if set, replace the OCSP responder and CRL URLs of
+	// the signing profiles in certificates issued by this issuer.
+	OCSPURL string
+	CRLURL  string
+	// ActiveFrom, if non-empty, restricts this issuer to issuing certificates
+	// for the listed key types ("RSA" or "ECDSA"), each from the given time.
+	// Issuance uses the first issuer in Issuers that is active for the key
+	// type, so a new issuer can be rotated in one key type at a time.
+	ActiveFrom map[string]time.Time
+	// OCSPResponder, if set, is a delegated OCSP responder that signs this
+	// issuer's OCSP responses instead of its own key. Its key and cert are
+	// loaded like the issuer's, and its other fields are ignored. The cert
+	// must be issued by this issuer and be for OCSP signing.
+	OCSPResponder *IssuerConfig
 }
 
 // TLSConfig represents certificates and a key for authenticated TLS.
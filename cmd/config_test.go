@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"os"
 	"regexp"
 	"strings"
 	"testing"
@@ -41,13 +42,20 @@ func TestPasswordConfig(t *testing.T) {
 		{pc: PasswordConfig{Password: "config"}, expected: "config"},
 		{pc: PasswordConfig{Password: "config", PasswordFile: "testdata/test_secret"}, expected: "secret"},
 		{pc: PasswordConfig{PasswordFile: "testdata/test_secret"}, expected: "secret"},
+		{pc: PasswordConfig{Password: "config", PasswordEnv: "BOULDER_TEST_SECRET"}, expected: "env secret"},
 	}
+	os.Setenv("BOULDER_TEST_SECRET", "env secret")
+	defer os.Unsetenv("BOULDER_TEST_SECRET")
 
 	for _, tc := range tests {
 		password, err := tc.pc.Pass()
 		test.AssertNotError(t, err, "Failed to retrieve password")
 		test.AssertEquals(t, password, tc.expected)
 	}
+
+	pc := PasswordConfig{PasswordEnv: "BOULDER_TEST_UNSET_SECRET"}
+	_, err := pc.Pass()
+	test.AssertError(t, err, "Pass succeeded with an unset environment variable")
 }
 
 func TestTLSConfigLoad(t *testing.T) {
@@ -44,6 +44,10 @@ func (ca *mockCA) GenerateOCSP(_ context.Context, xferObj core.OCSPSigningReques
 	return
 }
 
+func (ca *mockCA) RegenerateOCSPBatch(_ context.Context, serials []string, emit func(serial string, response []byte) error) (notFound []string, err error) {
+	return
+}
+
 type mockPub struct {
 	sa   core.StorageAuthority
 	logs []cmd.LogDescription
@@ -84,6 +84,8 @@ type CertificateAuthority interface {
 	// [RegistrationAuthority]
 	IssueCertificate(ctx context.Context, csr x509.CertificateRequest, regID int64) (Certificate, error)
 	GenerateOCSP(ctx context.Context, ocspReq OCSPSigningRequest) ([]byte, error)
+	// [OCSPUpdater]
+	RegenerateOCSPBatch(ctx context.Context, serials []string, emit func(serial string, response []byte) error) (notFound []string, err error)
 }
 
 // PolicyAuthority defines the public interface for the Boulder PA
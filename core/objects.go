@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"net"
 	"strings"
 	"time"
@@ -64,6 +65,7 @@ const (
 const (
 	OCSPStatusGood    = OCSPStatus("good")
 	OCSPStatusRevoked = OCSPStatus("revoked")
+	OCSPStatusUnknown = OCSPStatus("unknown")
 )
 
 // These types are the available challenges
@@ -524,6 +526,15 @@ type OCSPSigningRequest struct {
 	Status    string
 	Reason    revocation.Reason
 	RevokedAt time.Time
+	// Nonce, if non-nil, is echoed in the response's nonce extension.
+	Nonce []byte
+	// Serial identifies the certificate when Status is "unknown" and CertDER
+	// is empty, as when asked about a serial the CA didn't issue.
+	Serial *big.Int
+	// ProducedAt, if non-zero, is used as the response's producedAt, and to
+	// compute its thisUpdate and nextUpdate, instead of the current time. It
+	// must not be in the future.
+	ProducedAt time.Time
 }
 
 // SignedCertificateTimestamp is the internal representation of ct.SignedCertificateTimestamp
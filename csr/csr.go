@@ -3,11 +3,15 @@ package csr
 import (
 	"crypto"
 	"crypto/x509"
-	"errors"
 	"fmt"
+	"net/mail"
 	"strings"
 
+	"github.com/weppos/publicsuffix-go/publicsuffix"
+	"golang.org/x/net/idna"
+
 	"github.com/letsencrypt/boulder/core"
+	berrors "github.com/letsencrypt/boulder/errors"
 	"github.com/letsencrypt/boulder/goodkey"
 )
 
@@ -18,46 +22,74 @@ const maxCNLength = 64
 // are no longer considered sufficiently strong.
 // * No MD2, MD5, or SHA-1
 // * No DSA
-//
-// SHA1WithRSA is allowed because there's still a fair bit of it
-// out there, but we should try to remove it soon.
 var badSignatureAlgorithms = map[x509.SignatureAlgorithm]bool{
 	x509.UnknownSignatureAlgorithm: true,
 	x509.MD2WithRSA:                true,
 	x509.MD5WithRSA:                true,
+	x509.SHA1WithRSA:               true,
 	x509.DSAWithSHA1:               true,
 	x509.DSAWithSHA256:             true,
 	x509.ECDSAWithSHA1:             true,
 }
 
+// CNStrategy determines which DNS name is hoisted into an empty subject CN.
+type CNStrategy string
+
+const (
+	// CNFromFirstSAN hoists the first DNS name in the CSR.
+	CNFromFirstSAN CNStrategy = "first"
+	// CNFromShortestSAN hoists the shortest DNS name in the CSR, preferring the
+	// earliest of equally short names.
+	CNFromShortestSAN CNStrategy = "shortest"
+	// CNFromRegisteredDomain hoists the first DNS name in the CSR which is
+	// itself a registered domain (e.g. example.com rather than
+	// www.example.com), falling back to the first DNS name if there is none.
+	CNFromRegisteredDomain CNStrategy = "registered-domain"
+)
+
+// ParseCNStrategy returns the CNStrategy named by s. The empty string selects
+// CNFromFirstSAN.
+func ParseCNStrategy(s string) (CNStrategy, error) {
+	switch strategy := CNStrategy(s); strategy {
+	case "":
+		return CNFromFirstSAN, nil
+	case CNFromFirstSAN, CNFromShortestSAN, CNFromRegisteredDomain:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown CN strategy %q", s)
+	}
+}
+
+// malformed returns a Malformed error explained by reason, which every error
+// returned by this package is.
+func malformed(reason berrors.Reason, msg string, args ...interface{}) error {
+	return berrors.WithReason(berrors.MalformedError(msg, args...), reason)
+}
+
 var (
-	invalidPubKey       = errors.New("invalid public key in CSR")
-	unsupportedSigAlg   = errors.New("signature algorithm not supported")
-	invalidSig          = errors.New("invalid signature on CSR")
-	invalidEmailPresent = errors.New("CSR contains one or more email address fields")
-	invalidIPPresent    = errors.New("CSR contains one or more IP address fields")
-	invalidNoDNS        = errors.New("at least one DNS name is required")
+	invalidPubKey       = malformed(berrors.BadCSRPublicKey, "invalid public key in CSR")
+	unsupportedSigAlg   = malformed(berrors.BadCSRSignatureAlgorithm, "signature algorithm not supported")
+	invalidSig          = malformed(berrors.BadCSRSignature, "invalid signature on CSR")
+	invalidEmailPresent = malformed(berrors.CSREmailAddress, "CSR contains one or more email address fields")
+	invalidIPPresent    = malformed(berrors.CSRIPAddress, "CSR contains one or more IP address fields")
+	invalidNoDNS        = malformed(berrors.CSRNoNames, "at least one DNS name is required")
+	invalidCNNotInSANs  = malformed(berrors.CSRCNNotInSANs, "CN is not among the CSR's DNS names")
+	invalidNoEmail      = malformed(berrors.CSRNoNames, "at least one email address is required")
+	invalidDNSPresent   = malformed(berrors.CSRDNSName, "CSR contains one or more DNS name fields")
+	invalidCNNotEmail   = malformed(berrors.CSRCNNotInSANs, "CN is not among the CSR's email addresses")
 )
 
 // VerifyCSR checks the validity of a x509.CertificateRequest. Before doing checks it normalizes
-// the CSR which lowers the case of DNS names and subject CN, and if forceCNFromSAN is true it
-// will hoist a DNS name into the CN if it is empty.
-func VerifyCSR(csr *x509.CertificateRequest, maxNames int, keyPolicy *goodkey.KeyPolicy, pa core.PolicyAuthority, forceCNFromSAN bool, regID int64) error {
-	normalizeCSR(csr, forceCNFromSAN)
-	key, ok := csr.PublicKey.(crypto.PublicKey)
-	if !ok {
-		return invalidPubKey
+// the CSR which lowers the case of DNS names and subject CN, converts any internationalized
+// names to their A-label form, and if forceCNFromSAN is true it will hoist a DNS name, chosen
+// according to cnStrategy, into the CN if it is empty. If omitLongCN is true and every DNS name
+// is too long to be a CN, the CN is left empty rather than the CSR being rejected.
+func VerifyCSR(csr *x509.CertificateRequest, maxNames int, keyPolicy *goodkey.KeyPolicy, pa core.PolicyAuthority, forceCNFromSAN bool, cnStrategy CNStrategy, omitLongCN bool, regID int64) error {
+	if err := normalizeCSR(csr, forceCNFromSAN, cnStrategy, omitLongCN); err != nil {
+		return err
 	}
-	if err := keyPolicy.GoodKey(key); err != nil {
-		return fmt.Errorf("invalid public key in CSR: %s", err)
-	}
-	if badSignatureAlgorithms[csr.SignatureAlgorithm] {
-		// go1.6 provides a stringer for x509.SignatureAlgorithm but 1.5.x
-		// does not
-		return unsupportedSigAlg
-	}
-	if err := csr.CheckSignature(); err != nil {
-		return invalidSig
+	if err := verifyKeyAndSignature(csr, keyPolicy); err != nil {
+		return err
 	}
 	if len(csr.EmailAddresses) > 0 {
 		return invalidEmailPresent
@@ -69,10 +101,10 @@ func VerifyCSR(csr *x509.CertificateRequest, maxNames int, keyPolicy *goodkey.Ke
 		return invalidNoDNS
 	}
 	if len(csr.Subject.CommonName) > maxCNLength {
-		return fmt.Errorf("CN was longer than %d bytes", maxCNLength)
+		return malformed(berrors.CSRLongCN, "CN was longer than %d bytes", maxCNLength)
 	}
 	if maxNames > 0 && len(csr.DNSNames) > maxNames {
-		return fmt.Errorf("CSR contains more than %d DNS names", maxNames)
+		return malformed(berrors.CSRTooManyNames, "CSR contains more than %d DNS names", maxNames)
 	}
 	badNames := []string{}
 	for _, name := range csr.DNSNames {
@@ -84,21 +116,224 @@ func VerifyCSR(csr *x509.CertificateRequest, maxNames int, keyPolicy *goodkey.Ke
 		}
 	}
 	if len(badNames) > 0 {
-		return fmt.Errorf("policy forbids issuing for: %s", strings.Join(badNames, ", "))
+		return malformed(berrors.CSRForbiddenNames, "policy forbids issuing for: %s", strings.Join(badNames, ", "))
+	}
+	return nil
+}
+
+// VerifyEmailCSR is VerifyCSR for certificates issued to email addresses rather
+// than DNS names, e.g. for S/MIME or client authentication. The CSR must
+// request between one and maxNames email addresses, which must be bare
+// addresses at valid domain names, and no DNS names or IP addresses. The
+// domains are lowercased and converted to their A-label form, and the
+// subject CN, if any, must be one of the addresses. If forceCNFromSAN is true
+// an empty CN is replaced by the first address. No policy authority is
+// consulted.
+func VerifyEmailCSR(csr *x509.CertificateRequest, maxNames int, keyPolicy *goodkey.KeyPolicy, forceCNFromSAN bool) error {
+	seen := make(map[string]bool, len(csr.EmailAddresses))
+	emails := make([]string, 0, len(csr.EmailAddresses))
+	for _, email := range csr.EmailAddresses {
+		normalized, err := normalizeEmail(email)
+		if err != nil {
+			return err
+		}
+		if !seen[normalized] {
+			seen[normalized] = true
+			emails = append(emails, normalized)
+		}
+	}
+	csr.EmailAddresses = emails
+
+	if err := verifyKeyAndSignature(csr, keyPolicy); err != nil {
+		return err
+	}
+	if len(csr.DNSNames) > 0 {
+		return invalidDNSPresent
+	}
+	if len(csr.IPAddresses) > 0 {
+		return invalidIPPresent
+	}
+	if len(csr.EmailAddresses) == 0 {
+		return invalidNoEmail
+	}
+	if maxNames > 0 && len(csr.EmailAddresses) > maxNames {
+		return malformed(berrors.CSRTooManyNames, "CSR contains more than %d email addresses", maxNames)
+	}
+	if csr.Subject.CommonName == "" {
+		if forceCNFromSAN {
+			csr.Subject.CommonName = csr.EmailAddresses[0]
+		}
+	} else {
+		cn, err := normalizeEmail(csr.Subject.CommonName)
+		if err != nil || !seen[cn] {
+			return invalidCNNotEmail
+		}
+		csr.Subject.CommonName = cn
+	}
+	if len(csr.Subject.CommonName) > maxCNLength {
+		return malformed(berrors.CSRLongCN, "CN was longer than %d bytes", maxCNLength)
+	}
+	return nil
+}
+
+// verifyKeyAndSignature checks csr's public key against keyPolicy, and that
+// it is signed by that key with a sufficiently strong algorithm.
+func verifyKeyAndSignature(csr *x509.CertificateRequest, keyPolicy *goodkey.KeyPolicy) error {
+	key, ok := csr.PublicKey.(crypto.PublicKey)
+	if !ok {
+		return invalidPubKey
+	}
+	if err := keyPolicy.GoodKey(key); err != nil {
+		return malformed(berrors.BadCSRPublicKey, "invalid public key in CSR: %s", err)
+	}
+	if badSignatureAlgorithms[csr.SignatureAlgorithm] {
+		// go1.6 provides a stringer for x509.SignatureAlgorithm but 1.5.x
+		// does not
+		return unsupportedSigAlg
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return invalidSig
+	}
+	return nil
+}
+
+// normalizeEmail returns email with its domain lowercased and in A-label form,
+// or an error if it isn't a bare address at a domain name with at least two
+// labels. The local part is left as it is, since it may be case-sensitive.
+func normalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return "", malformed(berrors.CSRInvalidEmail, "invalid email address %q", email)
+	}
+	at := strings.LastIndex(email, "@")
+	domain, err := ToALabel(email[at+1:])
+	if err != nil {
+		return "", malformed(berrors.CSRInvalidEmail, "invalid email address %q: %s", email, err)
+	}
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, "[") {
+		return "", malformed(berrors.CSRInvalidEmail, "invalid email address %q", email)
+	}
+	return email[:at+1] + domain, nil
+}
+
+// CheckCNInSANs returns an error if csr has a subject CN which isn't also one
+// of its DNS names, comparing them as VerifyCSR would after normalization. It
+// must be called before VerifyCSR, which adds the CN to the DNS names.
+func CheckCNInSANs(csr *x509.CertificateRequest) error {
+	if csr.Subject.CommonName == "" {
+		return nil
+	}
+	cn, err := ToALabel(csr.Subject.CommonName)
+	if err != nil {
+		return err
+	}
+	for _, name := range csr.DNSNames {
+		aLabel, err := ToALabel(name)
+		if err != nil {
+			return err
+		}
+		if aLabel == cn {
+			return nil
+		}
+	}
+	return invalidCNNotInSANs
+}
+
+// CheckWildcards returns an error if any of csr's DNS names or its subject CN
+// is a malformed wildcard, or if its CN is a wildcard which isn't also one of
+// its DNS names. A well-formed wildcard has a single "*" as its leftmost label
+// and nothing else wildcarded. Like CheckCNInSANs, it must be called before
+// VerifyCSR, which adds the CN to the DNS names.
+func CheckWildcards(csr *x509.CertificateRequest) error {
+	for _, name := range append([]string{csr.Subject.CommonName}, csr.DNSNames...) {
+		if strings.Contains(name, "*") &&
+			(!strings.HasPrefix(name, "*.") || strings.Count(name, "*") > 1 || len(name) == len("*.")) {
+			return malformed(berrors.CSRInvalidName, "malformed wildcard name %q", name)
+		}
+	}
+	if !strings.HasPrefix(csr.Subject.CommonName, "*.") {
+		return nil
+	}
+	if err := CheckCNInSANs(csr); err != nil {
+		return malformed(berrors.CSRCNNotInSANs, "wildcard CN %q is not among the CSR's DNS names", csr.Subject.CommonName)
 	}
 	return nil
 }
 
-// normalizeCSR deduplicates and lowers the case of dNSNames and the subject CN.
-// If forceCNFromSAN is true it will also hoist a dNSName into the CN if it is empty.
-func normalizeCSR(csr *x509.CertificateRequest, forceCNFromSAN bool) {
+// normalizeCSR deduplicates and lowers the case of dNSNames and the subject CN,
+// and converts any U-label (Unicode) names to their punycode A-label form.
+// Empty dNSNames are dropped, and the rest are sorted so that CSRs for the same
+// set of names always produce certificates with identically ordered SANs.
+// If forceCNFromSAN is true it will also hoist a dNSName, chosen according to
+// cnStrategy, into the CN if it is empty. If omitLongCN is true and that name
+// is too long to be a CN, the shortest dNSName is hoisted instead, or none at
+// all if it is too long as well.
+func normalizeCSR(csr *x509.CertificateRequest, forceCNFromSAN bool, cnStrategy CNStrategy, omitLongCN bool) error {
+	names := make([]string, 0, len(csr.DNSNames))
+	for _, name := range csr.DNSNames {
+		aLabel, err := ToALabel(name)
+		if err != nil {
+			return err
+		}
+		if aLabel == "" {
+			continue
+		}
+		names = append(names, aLabel)
+	}
+	csr.DNSNames = names
+	cn, err := ToALabel(csr.Subject.CommonName)
+	if err != nil {
+		return err
+	}
+	csr.Subject.CommonName = cn
+
 	if forceCNFromSAN && csr.Subject.CommonName == "" {
 		if len(csr.DNSNames) > 0 {
-			csr.Subject.CommonName = csr.DNSNames[0]
+			cn := selectCN(csr.DNSNames, cnStrategy)
+			if omitLongCN && len(cn) > maxCNLength {
+				cn = selectCN(csr.DNSNames, CNFromShortestSAN)
+				if len(cn) > maxCNLength {
+					cn = ""
+				}
+			}
+			csr.Subject.CommonName = cn
 		}
 	} else if csr.Subject.CommonName != "" {
 		csr.DNSNames = append(csr.DNSNames, csr.Subject.CommonName)
 	}
-	csr.Subject.CommonName = strings.ToLower(csr.Subject.CommonName)
 	csr.DNSNames = core.UniqueLowerNames(csr.DNSNames)
+	return nil
+}
+
+// selectCN returns the name from the non-empty names that cnStrategy chooses
+// as the CN.
+func selectCN(names []string, cnStrategy CNStrategy) string {
+	switch cnStrategy {
+	case CNFromShortestSAN:
+		shortest := names[0]
+		for _, name := range names[1:] {
+			if len(name) < len(shortest) {
+				shortest = name
+			}
+		}
+		return shortest
+	case CNFromRegisteredDomain:
+		for _, name := range names {
+			if domain, err := publicsuffix.Domain(name); err == nil && domain == name {
+				return name
+			}
+		}
+	}
+	return names[0]
+}
+
+// ToALabel lowercases name and converts it to its punycode A-label form, as
+// VerifyCSR normalizes names. Names which are already entirely ASCII are
+// returned lowercased but otherwise unchanged.
+func ToALabel(name string) (string, error) {
+	aLabel, err := idna.ToASCII(strings.ToLower(name))
+	if err != nil {
+		return "", malformed(berrors.CSRInvalidName, "invalid internationalized name %q: %s", name, err)
+	}
+	return aLabel, nil
 }
@@ -6,11 +6,13 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"errors"
+	"fmt"
 	"net"
 	"strings"
 	"testing"
 
 	"github.com/letsencrypt/boulder/core"
+	berrors "github.com/letsencrypt/boulder/errors"
 	"github.com/letsencrypt/boulder/goodkey"
 	"github.com/letsencrypt/boulder/test"
 )
@@ -59,6 +61,9 @@ func TestVerifyCSR(t *testing.T) {
 	signedReqWithIPAddress := new(x509.CertificateRequest)
 	*signedReqWithIPAddress = *signedReq
 	signedReqWithIPAddress.IPAddresses = []net.IP{net.IPv4(1, 2, 3, 4)}
+	signedReqWithEmptyNames := new(x509.CertificateRequest)
+	*signedReqWithEmptyNames = *signedReq
+	signedReqWithEmptyNames.DNSNames = []string{"", ""}
 
 	cases := []struct {
 		csr           *x509.CertificateRequest
@@ -106,7 +111,7 @@ func TestVerifyCSR(t *testing.T) {
 			testingPolicy,
 			&mockPA{},
 			0,
-			errors.New("CN was longer than 64 bytes"),
+			malformed(berrors.CSRLongCN, "CN was longer than 64 bytes"),
 		},
 		{
 			signedReqWithHosts,
@@ -114,7 +119,7 @@ func TestVerifyCSR(t *testing.T) {
 			testingPolicy,
 			&mockPA{},
 			0,
-			errors.New("CSR contains more than 1 DNS names"),
+			malformed(berrors.CSRTooManyNames, "CSR contains more than 1 DNS names"),
 		},
 		{
 			signedReqWithBadNames,
@@ -122,7 +127,7 @@ func TestVerifyCSR(t *testing.T) {
 			testingPolicy,
 			&mockPA{},
 			0,
-			errors.New("policy forbids issuing for: \"bad-name.com\", \"other-bad-name.com\""),
+			malformed(berrors.CSRForbiddenNames, "policy forbids issuing for: \"bad-name.com\", \"other-bad-name.com\""),
 		},
 		{
 			signedReqWithEmailAddress,
@@ -140,11 +145,65 @@ func TestVerifyCSR(t *testing.T) {
 			0,
 			invalidIPPresent,
 		},
+		{
+			signedReqWithEmptyNames,
+			100,
+			testingPolicy,
+			&mockPA{},
+			0,
+			invalidNoDNS,
+		},
+	}
+
+	for _, c := range cases {
+		err := VerifyCSR(c.csr, c.maxNames, c.keyPolicy, c.pa, false, CNFromFirstSAN, false, c.regID)
+		test.AssertDeepEquals(t, c.expectedError, err)
+	}
+}
+
+func TestVerifyEmailCSR(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "error generating test key")
+	signedReqBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{PublicKey: private.PublicKey, SignatureAlgorithm: x509.SHA256WithRSA}, private)
+	test.AssertNotError(t, err, "error generating test CSR")
+	signedReq, err := x509.ParseCertificateRequest(signedReqBytes)
+	test.AssertNotError(t, err, "error parsing test CSR")
+
+	cases := []struct {
+		cn             string
+		emails         []string
+		dnsNames       []string
+		ipAddresses    []net.IP
+		expectedCN     string
+		expectedEmails []string
+		expectedError  error
+	}{
+		{"", []string{"alice@example.com"}, nil, nil, "alice@example.com", []string{"alice@example.com"}, nil},
+		{"Alice@EXAMPLE.com", []string{"Alice@EXAMPLE.com", "Alice@example.com"}, nil, nil, "Alice@example.com", []string{"Alice@example.com"}, nil},
+		{"", []string{"alice@ñ.example.com"}, nil, nil, "alice@xn--ida.example.com", []string{"alice@xn--ida.example.com"}, nil},
+		{"", nil, nil, nil, "", nil, invalidNoEmail},
+		{"", []string{"a@example.com", "b@example.com", "c@example.com"}, nil, nil, "", nil, malformed(berrors.CSRTooManyNames, "CSR contains more than 2 email addresses")},
+		{"", []string{"alice@example.com"}, []string{"example.com"}, nil, "", nil, invalidDNSPresent},
+		{"", []string{"alice@example.com"}, nil, []net.IP{net.IPv4(1, 2, 3, 4)}, "", nil, invalidIPPresent},
+		{"bob@example.com", []string{"alice@example.com"}, nil, nil, "", nil, invalidCNNotEmail},
+		{"example.com", []string{"alice@example.com"}, nil, nil, "", nil, invalidCNNotEmail},
+		{"", []string{"Alice <alice@example.com>"}, nil, nil, "", nil, malformed(berrors.CSRInvalidEmail, "invalid email address %q", "Alice <alice@example.com>")},
+		{"", []string{"alice@localhost"}, nil, nil, "", nil, malformed(berrors.CSRInvalidEmail, "invalid email address %q", "alice@localhost")},
 	}
 
 	for _, c := range cases {
-		err := VerifyCSR(c.csr, c.maxNames, c.keyPolicy, c.pa, false, c.regID)
+		csr := new(x509.CertificateRequest)
+		*csr = *signedReq
+		csr.Subject.CommonName = c.cn
+		csr.EmailAddresses = c.emails
+		csr.DNSNames = c.dnsNames
+		csr.IPAddresses = c.ipAddresses
+		err := VerifyEmailCSR(csr, 2, testingPolicy, true)
 		test.AssertDeepEquals(t, c.expectedError, err)
+		if err == nil {
+			test.AssertEquals(t, csr.Subject.CommonName, c.expectedCN)
+			test.AssertDeepEquals(t, csr.EmailAddresses, c.expectedEmails)
+		}
 	}
 }
 
@@ -185,10 +244,150 @@ func TestNormalizeCSR(t *testing.T) {
 			"a.com",
 			[]string{"a.com", "b.com"},
 		},
+		{
+			&x509.CertificateRequest{DNSNames: []string{"Bücher.com", "xn--bcher-kva.com"}},
+			true,
+			"xn--bcher-kva.com",
+			[]string{"xn--bcher-kva.com"},
+		},
+		{
+			&x509.CertificateRequest{Subject: pkix.Name{CommonName: "bücher.com"}, DNSNames: []string{"a.com"}},
+			false,
+			"xn--bcher-kva.com",
+			[]string{"a.com", "xn--bcher-kva.com"},
+		},
+		{
+			&x509.CertificateRequest{DNSNames: []string{"c.com", "", "B.com", "a.com", "b.com"}},
+			false,
+			"",
+			[]string{"a.com", "b.com", "c.com"},
+		},
+		{
+			&x509.CertificateRequest{DNSNames: []string{""}},
+			true,
+			"",
+			[]string{},
+		},
 	}
 	for _, c := range cases {
-		normalizeCSR(c.csr, c.forceCN)
+		err := normalizeCSR(c.csr, c.forceCN, CNFromFirstSAN, false)
+		test.AssertNotError(t, err, "normalizeCSR failed")
 		test.AssertEquals(t, c.expectedCN, c.csr.Subject.CommonName)
-		test.AssertDeepEquals(t, c.expectedNames, c.expectedNames)
+		test.AssertDeepEquals(t, c.expectedNames, c.csr.DNSNames)
+	}
+
+	// A label which overflows the punycode encoder should be rejected
+	err := normalizeCSR(&x509.CertificateRequest{
+		DNSNames: []string{strings.Repeat("a", 2100) + "\U0010ffff.com"},
+	}, true, CNFromFirstSAN, false)
+	test.AssertError(t, err, "normalizeCSR accepted a name that can't be converted to an A-label")
+}
+
+func TestCNStrategy(t *testing.T) {
+	names := []string{"www.example.com", "a.b.example.org", "example.net", "b.co", "example.com"}
+	for _, c := range []struct {
+		strategy   CNStrategy
+		names      []string
+		expectedCN string
+	}{
+		{CNFromFirstSAN, names, "www.example.com"},
+		{CNFromShortestSAN, names, "b.co"},
+		{CNFromRegisteredDomain, names, "example.net"},
+		{CNFromRegisteredDomain, []string{"www.example.com", "mail.example.com"}, "www.example.com"},
+	} {
+		csr := &x509.CertificateRequest{DNSNames: c.names}
+		err := normalizeCSR(csr, true, c.strategy, false)
+		test.AssertNotError(t, err, "normalizeCSR failed")
+		test.AssertEquals(t, csr.Subject.CommonName, c.expectedCN)
+	}
+
+	// A CN in the CSR is never replaced
+	csr := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "www.example.com"}, DNSNames: names}
+	err := normalizeCSR(csr, true, CNFromShortestSAN, false)
+	test.AssertNotError(t, err, "normalizeCSR failed")
+	test.AssertEquals(t, csr.Subject.CommonName, "www.example.com")
+
+	// With omitLongCN, names too long to be the CN are passed over, and if
+	// they all are the CN is left empty
+	long := strings.Repeat("a", maxCNLength) + ".com"
+	for _, c := range []struct {
+		names      []string
+		expectedCN string
+	}{
+		{[]string{long, "example.com"}, "example.com"},
+		{[]string{long}, ""},
+	} {
+		csr := &x509.CertificateRequest{DNSNames: c.names}
+		err := normalizeCSR(csr, true, CNFromFirstSAN, true)
+		test.AssertNotError(t, err, "normalizeCSR failed")
+		test.AssertEquals(t, csr.Subject.CommonName, c.expectedCN)
+	}
+}
+
+func TestParseCNStrategy(t *testing.T) {
+	strategy, err := ParseCNStrategy("")
+	test.AssertNotError(t, err, "Failed to parse empty CN strategy")
+	test.AssertEquals(t, strategy, CNFromFirstSAN)
+	strategy, err = ParseCNStrategy("registered-domain")
+	test.AssertNotError(t, err, "Failed to parse CN strategy")
+	test.AssertEquals(t, strategy, CNFromRegisteredDomain)
+	_, err = ParseCNStrategy("longest")
+	test.AssertError(t, err, "Parsed an unknown CN strategy")
+}
+
+func TestCheckCNInSANs(t *testing.T) {
+	for _, c := range []struct {
+		cn    string
+		names []string
+		ok    bool
+	}{
+		{"", nil, true},
+		{"", []string{"a.com"}, true},
+		{"a.com", []string{"b.com", "a.com"}, true},
+		{"A.com", []string{"a.COM"}, true},
+		{"bücher.com", []string{"xn--bcher-kva.com"}, true},
+		{"a.com", nil, false},
+		{"a.com", []string{"www.a.com"}, false},
+	} {
+		err := CheckCNInSANs(&x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: c.cn},
+			DNSNames: c.names,
+		})
+		if c.ok {
+			test.AssertNotError(t, err, fmt.Sprintf("CN %q rejected with names %v", c.cn, c.names))
+		} else {
+			test.AssertEquals(t, err, invalidCNNotInSANs)
+		}
+	}
+}
+
+func TestCheckWildcards(t *testing.T) {
+	for _, c := range []struct {
+		cn     string
+		names  []string
+		reason berrors.Reason
+	}{
+		{"", []string{"a.com"}, ""},
+		{"*.a.com", []string{"*.a.com", "a.com"}, ""},
+		{"a.com", []string{"*.a.com", "a.com"}, ""},
+		{"*.A.com", []string{"*.a.COM"}, ""},
+		{"*.a.com", []string{"a.com"}, berrors.CSRCNNotInSANs},
+		{"", []string{"*.*.a.com"}, berrors.CSRInvalidName},
+		{"", []string{"a*.a.com"}, berrors.CSRInvalidName},
+		{"", []string{"www.*.a.com"}, berrors.CSRInvalidName},
+		{"", []string{"*"}, berrors.CSRInvalidName},
+		{"*.", []string{"a.com"}, berrors.CSRInvalidName},
+	} {
+		err := CheckWildcards(&x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: c.cn},
+			DNSNames: c.names,
+		})
+		if c.reason == "" {
+			test.AssertNotError(t, err, fmt.Sprintf("CN %q rejected with names %v", c.cn, c.names))
+			continue
+		}
+		test.AssertError(t, err, fmt.Sprintf("CN %q accepted with names %v", c.cn, c.names))
+		test.Assert(t, berrors.Is(err, berrors.Malformed), "Incorrect error type returned")
+		test.AssertEquals(t, berrors.ReasonOf(err), c.reason)
 	}
 }
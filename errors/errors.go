@@ -20,10 +20,39 @@ const (
 	ConnectionFailure
 )
 
+// Reason refines an ErrorType with a stable, machine-readable explanation of
+// an error, so that callers can tell apart errors of the same type without
+// parsing their Detail. The values are never changed once in use.
+type Reason string
+
+// Reasons the CA rejects a request to issue a certificate
+const (
+	BadCSRPublicKey          Reason = "badCSRPublicKey"
+	BadCSRSignatureAlgorithm Reason = "badCSRSignatureAlgorithm"
+	BadCSRSignature          Reason = "badCSRSignature"
+	CSREmailAddress          Reason = "csrEmailAddress"
+	CSRIPAddress             Reason = "csrIPAddress"
+	CSRNoNames               Reason = "csrNoNames"
+	CSRLongCN                Reason = "csrLongCN"
+	CSRTooManyNames          Reason = "csrTooManyNames"
+	CSRInvalidName           Reason = "csrInvalidName"
+	CSRForbiddenNames        Reason = "csrForbiddenNames"
+	CSRCNNotInSANs           Reason = "csrCNNotInSANs"
+	CSRTooLarge              Reason = "csrTooLarge"
+	CSRSubjectAttribute      Reason = "csrSubjectAttribute"
+	CSRExtension             Reason = "csrExtension"
+	CSRInvalidEmail          Reason = "csrInvalidEmail"
+	CSRDNSName               Reason = "csrDNSName"
+	IncompatibleProfile      Reason = "incompatibleProfile"
+	InvalidValidity          Reason = "invalidValidity"
+)
+
 // BoulderError represents internal Boulder errors
 type BoulderError struct {
 	Type   ErrorType
 	Detail string
+	// Reason, if set, further explains errors of Type
+	Reason Reason
 }
 
 func (be *BoulderError) Error() string {
@@ -47,6 +76,28 @@ func Is(err error, errType ErrorType) bool {
 	return bErr.Type == errType
 }
 
+// WithReason returns a copy of err with its Reason set to reason. Errors that
+// aren't BoulderErrors are returned unchanged.
+func WithReason(err error, reason Reason) error {
+	bErr, ok := err.(*BoulderError)
+	if !ok {
+		return err
+	}
+	withReason := *bErr
+	withReason.Reason = reason
+	return &withReason
+}
+
+// ReasonOf returns the Reason of err, or the empty Reason if err isn't a
+// BoulderError or has none.
+func ReasonOf(err error) Reason {
+	bErr, ok := err.(*BoulderError)
+	if !ok {
+		return ""
+	}
+	return bErr.Reason
+}
+
 func InternalServerError(msg string, args ...interface{}) error {
 	return New(InternalServer, msg, args...)
 }
@@ -40,6 +40,10 @@ type KeyPolicy struct {
 	AllowRSA           bool // Whether RSA keys should be allowed.
 	AllowECDSANISTP256 bool // Whether ECDSA NISTP256 keys should be allowed.
 	AllowECDSANISTP384 bool // Whether ECDSA NISTP384 keys should be allowed.
+
+	// SharedFactors, if non-nil, is used to reject RSA keys whose modulus
+	// shares a prime factor with another recently seen modulus.
+	SharedFactors *SharedFactorChecker
 }
 
 // NewKeyPolicy returns a KeyPolicy that allows RSA, ECDSA256 and ECDSA384.
@@ -169,18 +173,39 @@ func isPointAtInfinityNISTP(x, y *big.Int) bool {
 
 // GoodCurve determines if an elliptic curve meets our requirements.
 func (policy *KeyPolicy) goodCurve(c elliptic.Curve) (err error) {
+	if !policy.AllowedCurve(c) {
+		return berrors.MalformedError("ECDSA curve %v not allowed", CurveName(c))
+	}
+	return nil
+}
+
+// AllowedCurve returns true if the policy allows ECDSA keys on curve c. Only
+// the NIST P-256 and P-384 curves, as implemented by crypto/elliptic, are ever
+// allowed; any other curve, even one with identical parameters, is not.
+func (policy *KeyPolicy) AllowedCurve(c elliptic.Curve) bool {
 	// Simply use a whitelist for now.
+	if c == nil {
+		return false
+	}
 	params := c.Params()
 	switch {
 	case policy.AllowECDSANISTP256 && params == elliptic.P256().Params():
-		return nil
+		return true
 	case policy.AllowECDSANISTP384 && params == elliptic.P384().Params():
-		return nil
+		return true
 	default:
-		return berrors.MalformedError("ECDSA curve %v not allowed", params.Name)
+		return false
 	}
 }
 
+// CurveName returns the name of curve c, or "unknown" if it has none.
+func CurveName(c elliptic.Curve) string {
+	if c == nil || c.Params() == nil || c.Params().Name == "" {
+		return "unknown"
+	}
+	return c.Params().Name
+}
+
 // GoodKeyRSA determines if a RSA pubkey meets our requirements
 func (policy *KeyPolicy) goodKeyRSA(key rsa.PublicKey) (err error) {
 	if !policy.AllowRSA {
@@ -218,6 +243,9 @@ func (policy *KeyPolicy) goodKeyRSA(key rsa.PublicKey) (err error) {
 	if checkSmallPrimes(modulus) {
 		return berrors.MalformedError("key divisible by small prime")
 	}
+	if policy.SharedFactors != nil && policy.SharedFactors.Check(modulus) {
+		return berrors.MalformedError("key shares a prime factor with another key")
+	}
 
 	return nil
 }
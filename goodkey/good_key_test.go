@@ -90,6 +90,41 @@ func TestGoodKey(t *testing.T) {
 	test.AssertNotError(t, testingPolicy.GoodKey(&private.PublicKey), "Should have accepted good key.")
 }
 
+func TestSharedFactors(t *testing.T) {
+	primes := make([]*big.Int, 3)
+	for i := range primes {
+		var err error
+		primes[i], err = rand.Prime(rand.Reader, 1024)
+		test.AssertNotError(t, err, "Error generating prime")
+	}
+	first := &rsa.PublicKey{N: new(big.Int).Mul(primes[0], primes[1]), E: 65537}
+	second := &rsa.PublicKey{N: new(big.Int).Mul(primes[0], primes[2]), E: 65537}
+	unrelated, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.AssertNotError(t, err, "Error generating key")
+
+	policy := &KeyPolicy{
+		AllowRSA:      true,
+		SharedFactors: NewSharedFactorChecker(10),
+	}
+	test.AssertNotError(t, policy.GoodKey(first), "Should have accepted the first key")
+	test.AssertNotError(t, policy.GoodKey(first), "Should have accepted the first key again")
+	test.AssertNotError(t, policy.GoodKey(&unrelated.PublicKey), "Should have accepted an unrelated key")
+	test.AssertError(t, policy.GoodKey(second), "Should have rejected a key sharing a prime")
+	test.AssertError(t, policy.GoodKey(first), "Should have rejected the first key once flagged")
+	test.AssertNotError(t, policy.GoodKey(&unrelated.PublicKey), "Should have accepted an unrelated key")
+}
+
+func TestSharedFactorCheckerWindow(t *testing.T) {
+	// 15 = 3 * 5 and 21 = 3 * 7 share a factor, but 15 has left the window by
+	// the time 21 is checked.
+	checker := NewSharedFactorChecker(2)
+	test.Assert(t, !checker.Check(big.NewInt(15)), "15 flagged")
+	test.Assert(t, !checker.Check(big.NewInt(11*13)), "143 flagged")
+	test.Assert(t, !checker.Check(big.NewInt(17*19)), "323 flagged")
+	test.Assert(t, !checker.Check(big.NewInt(21)), "21 flagged after 15 left the window")
+	test.Assert(t, checker.Check(big.NewInt(7*23)), "161 not flagged despite sharing 7 with 21")
+}
+
 func TestECDSABadCurve(t *testing.T) {
 	for _, curve := range invalidCurves {
 		private, err := ecdsa.GenerateKey(curve, rand.Reader)
@@ -99,6 +134,11 @@ func TestECDSABadCurve(t *testing.T) {
 	}
 }
 
+func TestECDSANilCurve(t *testing.T) {
+	test.AssertError(t, testingPolicy.GoodKey(&ecdsa.PublicKey{}), "Should have rejected key without a curve.")
+	test.AssertEquals(t, CurveName(nil), "unknown")
+}
+
 var invalidCurves = []elliptic.Curve{
 	elliptic.P224(),
 	elliptic.P521(),
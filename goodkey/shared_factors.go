@@ -0,0 +1,75 @@
+package goodkey
+
+import (
+	"math/big"
+	"sync"
+)
+
+var bigOne = big.NewInt(1)
+
+// SharedFactorChecker detects RSA moduli that share a prime factor with
+// another recently seen modulus, which indicates a key generated with a weak
+// random number generator. Both keys can then be factored by anyone with a
+// GCD. It keeps a fixed-size window of the most recent distinct moduli and
+// checks each new modulus against all of them.
+type SharedFactorChecker struct {
+	mu     sync.Mutex
+	recent []sharedFactorEntry
+	next   int
+}
+
+type sharedFactorEntry struct {
+	modulus *big.Int
+	// flagged is set once the modulus has been found to share a factor with
+	// another modulus.
+	flagged bool
+}
+
+// NewSharedFactorChecker returns a SharedFactorChecker that remembers the last
+// size distinct moduli it has seen.
+func NewSharedFactorChecker(size int) *SharedFactorChecker {
+	return &SharedFactorChecker{
+		recent: make([]sharedFactorEntry, 0, size),
+	}
+}
+
+// Check records modulus and returns true if it shares a prime factor with any
+// modulus in the window. Moduli found to share a factor are flagged, so that
+// the earlier of the pair is also rejected if it is seen again while it
+// remains in the window. Resubmitting an identical modulus is not treated as
+// sharing a factor.
+func (c *SharedFactorChecker) Check(modulus *big.Int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := -1
+	shared := false
+	var gcd big.Int
+	for i := range c.recent {
+		entry := &c.recent[i]
+		if entry.modulus.Cmp(modulus) == 0 {
+			seen = i
+			continue
+		}
+		if gcd.GCD(nil, nil, entry.modulus, modulus).Cmp(bigOne) != 0 {
+			entry.flagged = true
+			shared = true
+		}
+	}
+
+	if seen >= 0 {
+		c.recent[seen].flagged = c.recent[seen].flagged || shared
+		return c.recent[seen].flagged
+	}
+	if cap(c.recent) == 0 {
+		return shared
+	}
+	entry := sharedFactorEntry{modulus: new(big.Int).Set(modulus), flagged: shared}
+	if len(c.recent) < cap(c.recent) {
+		c.recent = append(c.recent, entry)
+	} else {
+		c.recent[c.next] = entry
+		c.next = (c.next + 1) % len(c.recent)
+	}
+	return shared
+}
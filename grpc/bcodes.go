@@ -82,7 +82,11 @@ func wrapError(ctx context.Context, err error) error {
 		// Ignoring the error return here is safe because if setting the metadata
 		// fails, we'll still return an error, but it will be interpreted on the
 		// other side as an InternalServerError instead of a more specific one.
-		_ = grpc.SetTrailer(ctx, metadata.Pairs("errortype", strconv.Itoa(int(berr.Type))))
+		pairs := []string{"errortype", strconv.Itoa(int(berr.Type))}
+		if berr.Reason != "" {
+			pairs = append(pairs, "errorreason", string(berr.Reason))
+		}
+		_ = grpc.SetTrailer(ctx, metadata.Pairs(pairs...))
 		return grpc.Errorf(codes.Unknown, err.Error())
 	}
 	// TODO(2589): deprecated, remove once boulder/errors code has been deployed
@@ -106,8 +110,9 @@ func wrapError(ctx context.Context, err error) error {
 // unwrapError unwraps errors returned from gRPC client calls which were wrapped
 // with wrapError to their proper internal error type. If the provided metadata
 // object has an "errortype" field, that will be used to set the type of the
-// error. If the error is a core.XXXError or a probs.ProblemDetails the type
-// is determined using the gRPC error code which has been deprecated (#2507).
+// error, and an "errorreason" field, if present, its reason. If the error is a
+// core.XXXError or a probs.ProblemDetails the type is determined using the gRPC
+// error code which has been deprecated (#2507).
 func unwrapError(err error, md metadata.MD) error {
 	if err == nil {
 		return nil
@@ -128,7 +133,11 @@ func unwrapError(err error, md metadata.MD) error {
 				unwrappedErr,
 			)
 		}
-		return berrors.New(berrors.ErrorType(errType), unwrappedErr)
+		unwrapped := berrors.New(berrors.ErrorType(errType), unwrappedErr)
+		if reasons := md["errorreason"]; len(reasons) == 1 {
+			unwrapped = berrors.WithReason(unwrapped, berrors.Reason(reasons[0]))
+		}
+		return unwrapped
 	}
 	// TODO(2589): deprecated, remove once boulder/errors code has been deployed
 	code := grpc.Code(err)
@@ -16,6 +16,7 @@ import (
 	caPB "github.com/letsencrypt/boulder/ca/proto"
 	"github.com/letsencrypt/boulder/core"
 	corepb "github.com/letsencrypt/boulder/core/proto"
+	berrors "github.com/letsencrypt/boulder/errors"
 	"github.com/letsencrypt/boulder/revocation"
 )
 
@@ -45,6 +46,9 @@ func (cac CertificateAuthorityClientWrapper) IssueCertificate(ctx context.Contex
 	if err != nil {
 		return core.Certificate{}, err
 	}
+	if res == nil || !certificateValid(res) {
+		return core.Certificate{}, errIncompleteResponse
+	}
 	return pbToCert(res), nil
 }
 
@@ -54,31 +58,82 @@ func (cac CertificateAuthorityClientWrapper) GenerateOCSP(ctx context.Context, o
 	}
 	reason := int32(ocspReq.Reason)
 	revokedAt := ocspReq.RevokedAt.UnixNano()
-	res, err := cac.innerOCSP.GenerateOCSP(ctx, &caPB.GenerateOCSPRequest{
+	req := &caPB.GenerateOCSPRequest{
 		CertDER:   ocspReq.CertDER,
 		Status:    &ocspReq.Status,
 		Reason:    &reason,
 		RevokedAt: &revokedAt,
-	})
+		Nonce:     ocspReq.Nonce,
+	}
+	if ocspReq.Serial != nil {
+		serial := core.SerialToString(ocspReq.Serial)
+		req.Serial = &serial
+	}
+	if !ocspReq.ProducedAt.IsZero() {
+		producedAt := ocspReq.ProducedAt.UnixNano()
+		req.ProducedAt = &producedAt
+	}
+	res, err := cac.innerOCSP.GenerateOCSP(ctx, req)
 	if err != nil {
 		return nil, err
 	}
+	if res == nil {
+		return nil, errIncompleteResponse
+	}
 	return res.Response, nil
 }
 
+// RegenerateOCSPBatch has the CA sign the whole batch before any response is
+// passed to emit, so an error from emit can't stop the CA signing the rest.
+func (cac CertificateAuthorityClientWrapper) RegenerateOCSPBatch(ctx context.Context, serials []string, emit func(serial string, response []byte) error) ([]string, error) {
+	if cac.innerOCSP == nil {
+		return nil, errors.New("this CA client does not support generating OCSP")
+	}
+	res, err := cac.innerOCSP.RegenerateOCSPBatch(ctx, &caPB.RegenerateOCSPBatchRequest{
+		Serials: serials,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, errIncompleteResponse
+	}
+	for _, response := range res.Responses {
+		if response == nil || response.Serial == nil {
+			return nil, errIncompleteResponse
+		}
+		if err := emit(*response.Serial, response.Response); err != nil {
+			return res.NotFound, err
+		}
+	}
+	return res.NotFound, nil
+}
+
 // CertificateAuthorityServerWrapper is the gRPC version of a core.CertificateAuthority server
 type CertificateAuthorityServerWrapper struct {
 	inner core.CertificateAuthority
+	// maxCSRBytes, if non-zero, is the largest CSR the wrapper will parse and
+	// pass on to inner.
+	maxCSRBytes int
 }
 
-func NewCertificateAuthorityServer(inner core.CertificateAuthority) *CertificateAuthorityServerWrapper {
-	return &CertificateAuthorityServerWrapper{inner}
+func NewCertificateAuthorityServer(inner core.CertificateAuthority, maxCSRBytes int) *CertificateAuthorityServerWrapper {
+	return &CertificateAuthorityServerWrapper{inner, maxCSRBytes}
 }
 
 func (cas *CertificateAuthorityServerWrapper) IssueCertificate(ctx context.Context, request *caPB.IssueCertificateRequest) (*corepb.Certificate, error) {
+	if request == nil || request.Csr == nil || request.RegistrationID == nil {
+		return nil, errIncompleteRequest
+	}
+	// Check the size before parsing, so that oversized CSRs cost us nothing
+	if cas.maxCSRBytes > 0 && len(request.Csr) > cas.maxCSRBytes {
+		return nil, berrors.WithReason(
+			berrors.MalformedError("CSR is %d bytes, more than the maximum of %d", len(request.Csr), cas.maxCSRBytes),
+			berrors.CSRTooLarge)
+	}
 	csr, err := x509.ParseCertificateRequest(request.Csr)
 	if err != nil {
-		return nil, err
+		return nil, berrors.MalformedError("invalid CSR: %s", err)
 	}
 	cert, err := cas.inner.IssueCertificate(ctx, *csr, *request.RegistrationID)
 	if err != nil {
@@ -88,14 +143,48 @@ func (cas *CertificateAuthorityServerWrapper) IssueCertificate(ctx context.Conte
 }
 
 func (cas *CertificateAuthorityServerWrapper) GenerateOCSP(ctx context.Context, request *caPB.GenerateOCSPRequest) (*caPB.OCSPResponse, error) {
-	res, err := cas.inner.GenerateOCSP(ctx, core.OCSPSigningRequest{
+	if request == nil || request.Status == nil || request.Reason == nil || request.RevokedAt == nil {
+		return nil, errIncompleteRequest
+	}
+	ocspReq := core.OCSPSigningRequest{
 		CertDER:   request.CertDER,
 		Status:    *request.Status,
 		Reason:    revocation.Reason(*request.Reason),
 		RevokedAt: time.Unix(0, *request.RevokedAt),
-	})
+		Nonce:     request.Nonce,
+	}
+	if request.Serial != nil {
+		serial, err := core.StringToSerial(*request.Serial)
+		if err != nil {
+			return nil, berrors.MalformedError("invalid serial: %s", err)
+		}
+		ocspReq.Serial = serial
+	}
+	if request.ProducedAt != nil {
+		ocspReq.ProducedAt = time.Unix(0, *request.ProducedAt)
+	}
+	res, err := cas.inner.GenerateOCSP(ctx, ocspReq)
 	if err != nil {
 		return nil, err
 	}
 	return &caPB.OCSPResponse{Response: res}, nil
 }
+
+func (cas *CertificateAuthorityServerWrapper) RegenerateOCSPBatch(ctx context.Context, request *caPB.RegenerateOCSPBatchRequest) (*caPB.RegenerateOCSPBatchResponse, error) {
+	if request == nil {
+		return nil, errIncompleteRequest
+	}
+	res := &caPB.RegenerateOCSPBatchResponse{}
+	notFound, err := cas.inner.RegenerateOCSPBatch(ctx, request.Serials, func(serial string, response []byte) error {
+		res.Responses = append(res.Responses, &caPB.SerialOCSPResponse{
+			Serial:   &serial,
+			Response: response,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.NotFound = notFound
+	return res, nil
+}
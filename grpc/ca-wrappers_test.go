@@ -0,0 +1,190 @@
+package grpc
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	caPB "github.com/letsencrypt/boulder/ca/proto"
+	"github.com/letsencrypt/boulder/core"
+	berrors "github.com/letsencrypt/boulder/errors"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestCAServerIncompleteRequests(t *testing.T) {
+	cas := NewCertificateAuthorityServer(nil, 0)
+	regID := int64(1)
+
+	for _, req := range []*caPB.IssueCertificateRequest{
+		nil,
+		{RegistrationID: &regID},
+		{Csr: []byte{1, 2, 3}},
+	} {
+		_, err := cas.IssueCertificate(context.Background(), req)
+		test.AssertEquals(t, err, errIncompleteRequest)
+	}
+
+	status := string(core.OCSPStatusGood)
+	reason := int32(0)
+	revokedAt := int64(0)
+	for _, req := range []*caPB.GenerateOCSPRequest{
+		nil,
+		{Reason: &reason, RevokedAt: &revokedAt},
+		{Status: &status, RevokedAt: &revokedAt},
+		{Status: &status, Reason: &reason},
+	} {
+		_, err := cas.GenerateOCSP(context.Background(), req)
+		test.AssertEquals(t, err, errIncompleteRequest)
+	}
+}
+
+func TestCAServerMalformedCSR(t *testing.T) {
+	cas := NewCertificateAuthorityServer(nil, 0)
+	regID := int64(1)
+	_, err := cas.IssueCertificate(context.Background(), &caPB.IssueCertificateRequest{
+		Csr:            []byte{1, 2, 3},
+		RegistrationID: &regID,
+	})
+	test.AssertError(t, err, "IssueCertificate accepted an unparseable CSR")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Wrong error type for unparseable CSR")
+}
+
+func TestCAServerOversizedCSR(t *testing.T) {
+	// The inner CA is nil, so the CSR must be rejected before it's parsed or
+	// passed on
+	cas := NewCertificateAuthorityServer(nil, 10)
+	regID := int64(1)
+	_, err := cas.IssueCertificate(context.Background(), &caPB.IssueCertificateRequest{
+		Csr:            make([]byte, 11),
+		RegistrationID: &regID,
+	})
+	test.AssertError(t, err, "IssueCertificate accepted an oversized CSR")
+	test.Assert(t, berrors.Is(err, berrors.Malformed), "Wrong error type for oversized CSR")
+	test.AssertEquals(t, berrors.ReasonOf(err), berrors.CSRTooLarge)
+}
+
+// recordingCA is a core.CertificateAuthority that records the OCSP requests
+// it's given.
+type recordingCA struct {
+	ocspReq core.OCSPSigningRequest
+}
+
+func (ca *recordingCA) IssueCertificate(context.Context, x509.CertificateRequest, int64) (core.Certificate, error) {
+	return core.Certificate{}, nil
+}
+
+func (ca *recordingCA) GenerateOCSP(_ context.Context, ocspReq core.OCSPSigningRequest) ([]byte, error) {
+	ca.ocspReq = ocspReq
+	return []byte("response"), nil
+}
+
+func (ca *recordingCA) RegenerateOCSPBatch(_ context.Context, serials []string, emit func(string, []byte) error) ([]string, error) {
+	var notFound []string
+	for _, serial := range serials {
+		if serial == "missing" {
+			notFound = append(notFound, serial)
+			continue
+		}
+		if err := emit(serial, []byte("response for "+serial)); err != nil {
+			return notFound, err
+		}
+	}
+	return notFound, nil
+}
+
+// loopbackOCSPGenerator is a caPB.OCSPGeneratorClient that marshals each
+// request and response as they would be on the wire, and passes them to a
+// server wrapper directly.
+type loopbackOCSPGenerator struct {
+	t   *testing.T
+	srv *CertificateAuthorityServerWrapper
+}
+
+func (l loopbackOCSPGenerator) roundTrip(in, out proto.Message) {
+	b, err := proto.Marshal(in)
+	test.AssertNotError(l.t, err, "Failed to marshal message")
+	test.AssertNotError(l.t, proto.Unmarshal(b, out), "Failed to unmarshal message")
+}
+
+func (l loopbackOCSPGenerator) GenerateOCSP(ctx context.Context, in *caPB.GenerateOCSPRequest, _ ...grpc.CallOption) (*caPB.OCSPResponse, error) {
+	req := &caPB.GenerateOCSPRequest{}
+	l.roundTrip(in, req)
+	res, err := l.srv.GenerateOCSP(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	out := &caPB.OCSPResponse{}
+	l.roundTrip(res, out)
+	return out, nil
+}
+
+func (l loopbackOCSPGenerator) RegenerateOCSPBatch(ctx context.Context, in *caPB.RegenerateOCSPBatchRequest, _ ...grpc.CallOption) (*caPB.RegenerateOCSPBatchResponse, error) {
+	req := &caPB.RegenerateOCSPBatchRequest{}
+	l.roundTrip(in, req)
+	res, err := l.srv.RegenerateOCSPBatch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	out := &caPB.RegenerateOCSPBatchResponse{}
+	l.roundTrip(res, out)
+	return out, nil
+}
+
+func TestGenerateOCSPRoundTrip(t *testing.T) {
+	inner := &recordingCA{}
+	cac := NewCertificateAuthorityClient(nil, loopbackOCSPGenerator{t, NewCertificateAuthorityServer(inner, 0)})
+
+	producedAt := time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC)
+	ocspReq := core.OCSPSigningRequest{
+		Status:     string(core.OCSPStatusUnknown),
+		RevokedAt:  time.Unix(0, 0),
+		Nonce:      []byte{1, 2, 3},
+		Serial:     big.NewInt(0x114242),
+		ProducedAt: producedAt,
+	}
+	res, err := cac.GenerateOCSP(context.Background(), ocspReq)
+	test.AssertNotError(t, err, "GenerateOCSP failed")
+	test.AssertByteEquals(t, res, []byte("response"))
+	test.AssertEquals(t, inner.ocspReq.Status, ocspReq.Status)
+	test.AssertByteEquals(t, inner.ocspReq.Nonce, ocspReq.Nonce)
+	test.AssertEquals(t, inner.ocspReq.Serial.Cmp(ocspReq.Serial), 0)
+	test.Assert(t, inner.ocspReq.ProducedAt.Equal(producedAt), "ProducedAt didn't survive the round trip")
+
+	// Unset optional fields stay unset
+	ocspReq = core.OCSPSigningRequest{
+		CertDER:   []byte{4, 5, 6},
+		Status:    string(core.OCSPStatusGood),
+		RevokedAt: time.Unix(0, 0),
+	}
+	_, err = cac.GenerateOCSP(context.Background(), ocspReq)
+	test.AssertNotError(t, err, "GenerateOCSP failed")
+	test.AssertByteEquals(t, inner.ocspReq.CertDER, ocspReq.CertDER)
+	test.Assert(t, inner.ocspReq.Serial == nil, "Serial was set")
+	test.Assert(t, inner.ocspReq.ProducedAt.IsZero(), "ProducedAt was set")
+	test.Assert(t, inner.ocspReq.Nonce == nil, "Nonce was set")
+}
+
+func TestRegenerateOCSPBatchRoundTrip(t *testing.T) {
+	cac := NewCertificateAuthorityClient(nil, loopbackOCSPGenerator{t, NewCertificateAuthorityServer(&recordingCA{}, 0)})
+
+	responses := map[string]string{}
+	notFound, err := cac.RegenerateOCSPBatch(context.Background(), []string{"a", "missing", "b"},
+		func(serial string, response []byte) error {
+			responses[serial] = string(response)
+			return nil
+		})
+	test.AssertNotError(t, err, "RegenerateOCSPBatch failed")
+	test.AssertDeepEquals(t, notFound, []string{"missing"})
+	test.AssertDeepEquals(t, responses, map[string]string{
+		"a": "response for a",
+		"b": "response for b",
+	})
+
+	_, err = NewCertificateAuthorityServer(nil, 0).RegenerateOCSPBatch(context.Background(), nil)
+	test.AssertEquals(t, err, errIncompleteRequest)
+}
@@ -51,6 +51,7 @@ func TestErrorWrapping(t *testing.T) {
 		core.MalformedRequestError("yup"),
 		&probs.ProblemDetails{Type: probs.MalformedProblem, Detail: "yup"},
 		berrors.MalformedError("yup"),
+		berrors.WithReason(berrors.MalformedError("yup"), berrors.CSRNoNames),
 	} {
 		es.err = tc
 		_, err := client.Chill(context.Background(), &testproto.Time{})
@@ -28,6 +28,16 @@ type Logger interface {
 	AuditErr(string)
 }
 
+// A CheckedLogger is a Logger that can report whether an audit message was
+// delivered to the system logger, for callers that must not proceed without an
+// audit trail.
+type CheckedLogger interface {
+	Logger
+	// CheckedAuditInfo is like AuditInfo, but returns an error if the message
+	// could not be written to the system logger.
+	CheckedAuditInfo(string) error
+}
+
 // impl implements Logger.
 type impl struct {
 	w writer
@@ -100,7 +110,7 @@ func Get() Logger {
 }
 
 type writer interface {
-	logAtLevel(syslog.Priority, string)
+	logAtLevel(syslog.Priority, string) error
 }
 
 // bothWriter implements writer and writes to both syslog and stdout.
@@ -112,8 +122,9 @@ type bothWriter struct {
 }
 
 // Log the provided message at the appropriate level, writing to
-// both stdout and the Logger, as well as informing statsd.
-func (w *bothWriter) logAtLevel(level syslog.Priority, msg string) {
+// both stdout and the Logger, as well as informing statsd. It returns any error
+// from writing to syslog.
+func (w *bothWriter) logAtLevel(level syslog.Priority, msg string) error {
 	var prefix string
 	var err error
 
@@ -162,11 +173,12 @@ func (w *bothWriter) logAtLevel(level syslog.Priority, msg string) {
 			msg,
 			reset)
 	}
+	return err
 }
 
-func (log *impl) auditAtLevel(level syslog.Priority, msg string) {
+func (log *impl) auditAtLevel(level syslog.Priority, msg string) error {
 	text := fmt.Sprintf("%s %s", auditTag, msg)
-	log.w.logAtLevel(level, text)
+	return log.w.logAtLevel(level, text)
 }
 
 // Return short format caller info for panic events, skipping to before the
@@ -196,28 +208,34 @@ func (log *impl) AuditPanic() {
 // Err level messages are always marked with the audit tag, for special handling
 // at the upstream system logger.
 func (log *impl) Err(msg string) {
-	log.auditAtLevel(syslog.LOG_ERR, msg)
+	_ = log.auditAtLevel(syslog.LOG_ERR, msg)
 }
 
 // Warning level messages pass through normally.
 func (log *impl) Warning(msg string) {
-	log.w.logAtLevel(syslog.LOG_WARNING, msg)
+	_ = log.w.logAtLevel(syslog.LOG_WARNING, msg)
 }
 
 // Info level messages pass through normally.
 func (log *impl) Info(msg string) {
-	log.w.logAtLevel(syslog.LOG_INFO, msg)
+	_ = log.w.logAtLevel(syslog.LOG_INFO, msg)
 }
 
 // Debug level messages pass through normally.
 func (log *impl) Debug(msg string) {
-	log.w.logAtLevel(syslog.LOG_DEBUG, msg)
+	_ = log.w.logAtLevel(syslog.LOG_DEBUG, msg)
 }
 
 // AuditInfo sends an INFO-severity message that is prefixed with the
 // audit tag, for special handling at the upstream system logger.
 func (log *impl) AuditInfo(msg string) {
-	log.auditAtLevel(syslog.LOG_INFO, msg)
+	_ = log.auditAtLevel(syslog.LOG_INFO, msg)
+}
+
+// CheckedAuditInfo is like AuditInfo, but returns an error if the message
+// could not be written to syslog.
+func (log *impl) CheckedAuditInfo(msg string) error {
+	return log.auditAtLevel(syslog.LOG_INFO, msg)
 }
 
 // AuditObject sends an INFO-severity JSON-serialized object message that is prefixed
@@ -225,14 +243,14 @@ func (log *impl) AuditInfo(msg string) {
 func (log *impl) AuditObject(msg string, obj interface{}) {
 	jsonObj, err := json.Marshal(obj)
 	if err != nil {
-		log.auditAtLevel(syslog.LOG_ERR, fmt.Sprintf("Object could not be serialized to JSON. Raw: %+v", obj))
+		_ = log.auditAtLevel(syslog.LOG_ERR, fmt.Sprintf("Object could not be serialized to JSON. Raw: %+v", obj))
 		return
 	}
 
-	log.auditAtLevel(syslog.LOG_INFO, fmt.Sprintf("%s JSON=%s", msg, jsonObj))
+	_ = log.auditAtLevel(syslog.LOG_INFO, fmt.Sprintf("%s JSON=%s", msg, jsonObj))
 }
 
 // AuditErr can format an error for auditing; it does so at ERR level.
 func (log *impl) AuditErr(msg string) {
-	log.auditAtLevel(syslog.LOG_ERR, msg)
+	_ = log.auditAtLevel(syslog.LOG_ERR, msg)
 }
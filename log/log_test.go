@@ -241,6 +241,20 @@ func TestSyslogLevels(t *testing.T) {
 	}
 }
 
+type failingWriter struct{}
+
+func (failingWriter) logAtLevel(syslog.Priority, string) error {
+	return fmt.Errorf("write failed")
+}
+
+func TestCheckedAuditInfo(t *testing.T) {
+	var logger CheckedLogger = NewMock()
+	test.AssertNotError(t, logger.CheckedAuditInfo("delivered"), "Mock logger failed to log")
+
+	logger = &impl{failingWriter{}}
+	test.AssertError(t, logger.CheckedAuditInfo("undelivered"), "Failed write wasn't reported")
+}
+
 func newUDPListener(addr string) (*net.UDPConn, error) {
 	l, err := net.ListenPacket("udp", addr)
 	if err != nil {
@@ -42,8 +42,9 @@ var levelName = map[syslog.Priority]string{
 	syslog.LOG_DEBUG:   "DEBUG",
 }
 
-func (w *mockWriter) logAtLevel(p syslog.Priority, msg string) {
+func (w *mockWriter) logAtLevel(p syslog.Priority, msg string) error {
 	w.msgChan <- fmt.Sprintf("%s: %s", levelName[p&7], msg)
+	return nil
 }
 
 // newMockWriter returns a new mockWriter
@@ -37,6 +37,11 @@ func (ca *MockCA) GenerateOCSP(ctx context.Context, xferObj core.OCSPSigningRequ
 	return
 }
 
+// RegenerateOCSPBatch is a mock
+func (ca *MockCA) RegenerateOCSPBatch(ctx context.Context, serials []string, emit func(serial string, response []byte) error) (notFound []string, err error) {
+	return
+}
+
 // RevokeCertificate is a mock
 func (ca *MockCA) RevokeCertificate(ctx context.Context, serial string, reasonCode revocation.Reason) (err error) {
 	return
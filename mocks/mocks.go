@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/cactus/go-statsd-client/statsd"
@@ -367,11 +368,23 @@ func (sa *StorageAuthority) DeactivateRegistration(_ context.Context, _ int64) e
 
 // Publisher is a mock
 type Publisher struct {
-	// empty
+	sync.Mutex
+	// Failures is the number of calls to SubmitToCT that fail before they
+	// start succeeding.
+	Failures int
+	// Submitted holds the certificates successfully passed to SubmitToCT.
+	Submitted [][]byte
 }
 
 // SubmitToCT is a mock
-func (*Publisher) SubmitToCT(_ context.Context, der []byte) error {
+func (p *Publisher) SubmitToCT(_ context.Context, der []byte) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.Failures > 0 {
+		p.Failures--
+		return errors.New("mock CT submission failure")
+	}
+	p.Submitted = append(p.Submitted, der)
 	return nil
 }
 
@@ -644,8 +644,8 @@ func (ra *RegistrationAuthorityImpl) NewCertificate(ctx context.Context, req cor
 
 	// Verify the CSR
 	csr := req.CSR
-	if err := csrlib.VerifyCSR(csr, ra.maxNames, &ra.keyPolicy, ra.PA, ra.forceCNFromSAN, regID); err != nil {
-		return emptyCert, berrors.MalformedError(err.Error())
+	if err := csrlib.VerifyCSR(csr, ra.maxNames, &ra.keyPolicy, ra.PA, ra.forceCNFromSAN, csrlib.CNFromFirstSAN, false, regID); err != nil {
+		return emptyCert, berrors.WithReason(berrors.MalformedError("%s", err), berrors.ReasonOf(err))
 	}
 
 	logEvent.CommonName = csr.Subject.CommonName